@@ -0,0 +1,358 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 一个处理阶段，把 In 类型的元素变成零个或多个 Out 类型的元素
+type Stage[In, Out any] struct {
+	name string
+	run  func(in In, emit func(Out)) error
+}
+
+// 名称用于错误和统计
+func (s Stage[In, Out]) Name() string {
+	return s.name
+}
+
+// 类型相同、可能出错的处理函数
+func Apply[T any](name string, fn func(T) (T, error)) Stage[T, T] {
+	return Map(name, fn)
+}
+
+// 不会出错的处理函数，例如 strings.ToUpper
+func Func[T any](name string, fn func(T) T) Stage[T, T] {
+	return Stage[T, T]{name: name, run: func(in T, emit func(T)) error {
+		emit(fn(in))
+		return nil
+	}}
+}
+
+// 转换元素的类型
+func Map[In, Out any](name string, fn func(In) (Out, error)) Stage[In, Out] {
+	return Stage[In, Out]{name: name, run: func(in In, emit func(Out)) error {
+		out, err := fn(in)
+		if err != nil {
+			return err
+		}
+		emit(out)
+		return nil
+	}}
+}
+
+// 只保留 keep 返回 true 的元素
+func Filter[T any](name string, keep func(T) bool) Stage[T, T] {
+	return Stage[T, T]{name: name, run: func(in T, emit func(T)) error {
+		if keep(in) {
+			emit(in)
+		}
+		return nil
+	}}
+}
+
+// 把一个元素展开为多个元素，返回空切片时丢弃这个元素
+func FlatMap[In, Out any](name string, fn func(In) ([]Out, error)) Stage[In, Out] {
+	return Stage[In, Out]{name: name, run: func(in In, emit func(Out)) error {
+		list, err := fn(in)
+		if err != nil {
+			return err
+		}
+		for _, out := range list {
+			emit(out)
+		}
+		return nil
+	}}
+}
+
+// 出错时的处理方式
+type ErrorMode int
+
+const (
+	AbortOnError  ErrorMode = iota // 遇到第一个错误时停止，默认值
+	CollectErrors                  // 丢弃出错的元素，继续处理其余的元素，最后返回所有错误
+)
+
+// 某个元素在某个阶段出错
+type ItemError struct {
+	Index int    // 元素在输入中的序号
+	Stage string // 出错的阶段
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("pipeline: item %d: stage %q: %v", e.Index, e.Stage, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// 阶段的统计
+type StageMetrics struct {
+	Name   string
+	In     int64         // 进入这个阶段的元素
+	Out    int64         // 这个阶段输出的元素
+	Errors int64         // 出错的元素
+	Time   time.Duration // 处理函数的总耗时，并发执行时是所有 worker 的耗时之和
+}
+
+// 统计的计数器
+type counters struct {
+	in, out, errors, nanos atomic.Int64
+}
+
+// 类型擦除后的阶段
+type stage struct {
+	name string
+	run  func(in any, emit func(any)) error
+}
+
+// 流水线的选项
+type Option func(*options)
+
+type options struct {
+	workers int
+	mode    ErrorMode
+	metrics bool
+}
+
+// 同时处理元素的 goroutine 数，默认为 1。输出的顺序总是与输入相同
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		o.workers = max(n, 1)
+	}
+}
+
+// 出错时的处理方式，默认为 AbortOnError
+func WithErrorMode(mode ErrorMode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// 记录每个阶段的统计，计时有少量开销，默认关闭
+func WithMetrics() Option {
+	return func(o *options) {
+		o.metrics = true
+	}
+}
+
+// 由多个阶段组成的流水线，输入 In 类型的元素，输出 Out 类型的元素。
+// 流水线创建后不会被修改，Then 返回新的流水线，可以在多个 goroutine 中同时 Run
+type Pipeline[In, Out any] struct {
+	opts     options
+	stages   []stage
+	counters []*counters
+}
+
+// 创建没有阶段的流水线，输出与输入相同
+func New[T any](opts ...Option) *Pipeline[T, T] {
+	o := options{workers: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Pipeline[T, T]{opts: o}
+}
+
+// 追加一个不改变类型的阶段
+func (p *Pipeline[In, Out]) Then(s Stage[Out, Out]) *Pipeline[In, Out] {
+	return Then(p, s)
+}
+
+// 追加一个阶段，可以改变元素的类型。新的流水线有自己的统计
+func Then[In, Mid, Out any](p *Pipeline[In, Mid], s Stage[Mid, Out]) *Pipeline[In, Out] {
+	stages := append(p.stages[:len(p.stages):len(p.stages)], stage{
+		name: s.name,
+		run: func(in any, emit func(any)) error {
+			return s.run(in.(Mid), func(out Out) { emit(out) })
+		},
+	})
+	next := &Pipeline[In, Out]{opts: p.opts, stages: stages}
+	if p.opts.metrics {
+		next.counters = make([]*counters, len(stages))
+		for i := range next.counters {
+			next.counters[i] = &counters{}
+		}
+	}
+	return next
+}
+
+// 各个阶段的统计，没有使用 WithMetrics 时返回 nil
+func (p *Pipeline[In, Out]) Metrics() []StageMetrics {
+	if p.counters == nil {
+		return nil
+	}
+	metrics := make([]StageMetrics, len(p.stages))
+	for i, c := range p.counters {
+		metrics[i] = StageMetrics{
+			Name:   p.stages[i].name,
+			In:     c.in.Load(),
+			Out:    c.out.Load(),
+			Errors: c.errors.Load(),
+			Time:   time.Duration(c.nanos.Load()),
+		}
+	}
+	return metrics
+}
+
+// 处理所有元素，返回的结果与输入的顺序相同，不修改 items。
+// AbortOnError 模式下返回第一个出错的元素的 *ItemError（并发时是已经发现的错误中序号最小的）；
+// CollectErrors 模式下返回其余元素的结果和按序号排列的所有错误。
+// ctx 结束时停止处理并返回 ctx.Err()
+func (p *Pipeline[In, Out]) Run(ctx context.Context, items []In) ([]Out, error) {
+	results := make([][]Out, len(items))
+	errs := make([]*ItemError, len(items))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var aborted atomic.Bool
+
+	process := func(b *buffers, i int) {
+		out, err := p.process(ctx, b, i, items[i])
+		if err != nil {
+			errs[i] = err
+			if p.opts.mode == AbortOnError {
+				aborted.Store(true)
+				cancel()
+			}
+			return
+		}
+		results[i] = out
+	}
+
+	if p.opts.workers <= 1 || len(items) <= 1 {
+		b := &buffers{}
+		for i := range items {
+			if ctx.Err() != nil {
+				break
+			}
+			process(b, i)
+		}
+	} else {
+		// worker 按序号领取元素，结果写到各自的位置，不需要加锁
+		var next atomic.Int64
+		var wg sync.WaitGroup
+		for w := 0; w < min(p.opts.workers, len(items)); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b := &buffers{}
+				for ctx.Err() == nil {
+					i := int(next.Add(1) - 1)
+					if i >= len(items) {
+						return
+					}
+					process(b, i)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	var collected []error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if aborted.Load() {
+			return nil, err
+		}
+		collected = append(collected, err)
+	}
+	// 外部的 ctx 结束
+	if err := ctx.Err(); err != nil && !aborted.Load() {
+		return nil, err
+	}
+
+	n := 0
+	for _, r := range results {
+		n += len(r)
+	}
+	out := make([]Out, 0, n)
+	for _, r := range results {
+		out = append(out, r...)
+	}
+	return out, errors.Join(collected...)
+}
+
+// 每个 worker 复用的缓冲区，减少每个元素的内存分配
+type buffers struct {
+	current, next []any
+	emit          func(any)
+}
+
+// 让一个元素依次通过所有阶段
+func (p *Pipeline[In, Out]) process(ctx context.Context, b *buffers, index int, item In) ([]Out, *ItemError) {
+	if b.emit == nil {
+		b.emit = func(v any) { b.next = append(b.next, v) }
+	}
+	b.current = append(b.current[:0], item)
+	for si, s := range p.stages {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		var c *counters
+		if p.counters != nil {
+			c = p.counters[si]
+			c.in.Add(int64(len(b.current)))
+		}
+		b.next = b.next[:0]
+		for _, v := range b.current {
+			var start time.Time
+			if c != nil {
+				start = time.Now()
+			}
+			err := s.run(v, b.emit)
+			if c != nil {
+				c.nanos.Add(int64(time.Since(start)))
+			}
+			if err != nil {
+				if c != nil {
+					c.errors.Add(1)
+				}
+				return nil, &ItemError{Index: index, Stage: s.name, Err: err}
+			}
+		}
+		if c != nil {
+			c.out.Add(int64(len(b.next)))
+		}
+		b.current, b.next = b.next, b.current
+		if len(b.current) == 0 {
+			return nil, nil
+		}
+	}
+
+	out := make([]Out, len(b.current))
+	for i, v := range b.current {
+		out[i] = v.(Out)
+	}
+	return out, nil
+}
+
+// 取出 CollectErrors 模式返回的所有 *ItemError，按序号排列
+func ItemErrors(err error) []*ItemError {
+	var list []*ItemError
+	var walk func(error)
+	walk = func(err error) {
+		if ie, ok := err.(*ItemError); ok {
+			list = append(list, ie)
+			return
+		}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				walk(e)
+			}
+		}
+	}
+	if err != nil {
+		walk(err)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Index < list[j].Index })
+	return list
+}
@@ -0,0 +1,337 @@
+package pipeline_test
+
+import (
+	"code-snippet/code/005/pipeline"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 流水线的测试和与 string-chain-processing.go 中顺序循环的对比：
+//
+//	go test -race code-snippet/code/005/pipeline
+//	go test -run '^$' -bench . -benchmem code-snippet/code/005/pipeline
+//
+// 处理链中的函数都很轻，pipeline 的额外开销主要来自类型擦除和每个元素的切片分配，
+// 只有处理函数足够重时并发才能抵消这些开销，所以另外测试一个较重的处理链
+
+// string-chain-processing.go 中的实现
+func StringProcess(list []string, chain []func(string) string) []string {
+	for index, str := range list {
+		result := str
+		for _, function := range chain {
+			result = function(result)
+		}
+		list[index] = result
+	}
+	return list
+}
+
+func removePrefix(s string) string {
+	return strings.TrimPrefix(s, "go")
+}
+
+// 较重的处理函数
+func repeat(in string) string {
+	return strings.ToLower(strings.Repeat(in, 64))[:len(in)]
+}
+
+type chain struct {
+	name  string
+	funcs []func(string) string
+}
+
+var chains = []chain{
+	{"light", []func(string) string{removePrefix, strings.TrimSpace, strings.ToUpper}},
+	{"heavy", []func(string) string{removePrefix, strings.TrimSpace, repeat, strings.ToUpper}},
+}
+
+// 用同样的函数组成流水线
+func build(funcs []func(string) string, opts ...pipeline.Option) *pipeline.Pipeline[string, string] {
+	p := pipeline.New[string](opts...)
+	for i, fn := range funcs {
+		p = p.Then(pipeline.Func(fmt.Sprint("stage ", i), fn))
+	}
+	return p
+}
+
+type variant struct {
+	name string
+	p    *pipeline.Pipeline[string, string]
+}
+
+// 基准测试中对比的几种流水线
+func variants(funcs []func(string) string) []variant {
+	workers := runtime.GOMAXPROCS(0)
+	return []variant{
+		{"pipeline", build(funcs)},
+		{"pipeline+metrics", build(funcs, pipeline.WithMetrics())},
+		{fmt.Sprintf("pipeline_%d_workers", workers), build(funcs, pipeline.WithWorkers(workers))},
+	}
+}
+
+// 基准测试的输入
+func items(n int) []string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf(" go item %d ", i)
+	}
+	return list
+}
+
+func TestStringProcess(t *testing.T) {
+	list := []string{"go scanner", "go parser", " go compiler", "go printer", "go formater", ""}
+	input := append([]string(nil), list...)
+	want := StringProcess(append([]string(nil), list...), chains[0].funcs)
+
+	for _, workers := range []int{1, 3} {
+		p := pipeline.New[string](pipeline.WithWorkers(workers)).
+			Then(pipeline.Func("prefix", removePrefix)).
+			Then(pipeline.Func("trim", strings.TrimSpace)).
+			Then(pipeline.Func("upper", strings.ToUpper))
+		got, err := p.Run(context.Background(), list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("workers %d: got %q, want %q", workers, got, want)
+		}
+	}
+	// 输入不会被修改
+	if !reflect.DeepEqual(list, input) {
+		t.Errorf("input modified: %q", list)
+	}
+}
+
+// 基准测试对比的流水线与顺序循环的输出相同
+func TestBenchmarkChains(t *testing.T) {
+	list := items(1000)
+	for _, c := range chains {
+		want := StringProcess(append([]string(nil), list...), c.funcs)
+		for _, v := range variants(c.funcs) {
+			got, err := v.p.Run(context.Background(), list)
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s: output differs from StringProcess (%v)", c.name, v.name, err)
+			}
+		}
+	}
+}
+
+func TestStages(t *testing.T) {
+	words := pipeline.Then(
+		pipeline.New[string]().Then(pipeline.Filter("non-empty", func(s string) bool { return s != "" })),
+		pipeline.FlatMap("words", func(s string) ([]string, error) { return strings.Fields(s), nil }),
+	)
+	lengths := pipeline.Then(words, pipeline.Map("len", func(s string) (int, error) { return len(s), nil }))
+	got, err := lengths.Run(context.Background(), []string{"a bb", "", "ccc", "   "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// 没有阶段的流水线原样输出
+	same, err := pipeline.New[int]().Run(context.Background(), []int{1, 2})
+	if err != nil || !reflect.DeepEqual(same, []int{1, 2}) {
+		t.Errorf("empty pipeline: %v %v", same, err)
+	}
+	// 空输入得到空的结果
+	none, err := lengths.Run(context.Background(), nil)
+	if err != nil || len(none) != 0 {
+		t.Errorf("empty input: %v %v", none, err)
+	}
+}
+
+// 把字符串解析为整数，负数之后的阶段会记录调用
+func parser(calls *atomic.Int64, opts ...pipeline.Option) *pipeline.Pipeline[string, int] {
+	p := pipeline.Then(pipeline.New[string](opts...), pipeline.Map("atoi", strconv.Atoi))
+	return p.Then(pipeline.Apply("positive", func(n int) (int, error) {
+		calls.Add(1)
+		if n < 0 {
+			return 0, fmt.Errorf("negative %d", n)
+		}
+		return n, nil
+	}))
+}
+
+func TestAbortOnError(t *testing.T) {
+	var calls atomic.Int64
+	_, err := parser(&calls).Run(context.Background(), []string{"1", "x", "2", "-3"})
+	var ie *pipeline.ItemError
+	if !errors.As(err, &ie) || ie.Index != 1 || ie.Stage != "atoi" || !errors.Is(err, strconv.ErrSyntax) {
+		t.Fatalf("first error: %v", err)
+	}
+	// 出错后不再处理后面的元素
+	if calls.Load() != 1 {
+		t.Errorf("%d calls after the error", calls.Load())
+	}
+
+	// 并发时返回已经发现的错误中序号最小的
+	items := make([]string, 100)
+	for i := range items {
+		items[i] = strconv.Itoa(i)
+	}
+	items[40], items[41] = "-1", "-2"
+	_, err = parser(&calls, pipeline.WithWorkers(4)).Run(context.Background(), items)
+	if !errors.As(err, &ie) || (ie.Index != 40 && ie.Index != 41) || ie.Stage != "positive" {
+		t.Errorf("concurrent error: %v", err)
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	var calls atomic.Int64
+	for _, workers := range []int{1, 4} {
+		got, err := parser(&calls, pipeline.WithWorkers(workers), pipeline.WithErrorMode(pipeline.CollectErrors)).
+			Run(context.Background(), []string{"1", "x", "2", "-3", "4"})
+		if want := []int{1, 2, 4}; !reflect.DeepEqual(got, want) {
+			t.Errorf("workers %d: got %v, want %v", workers, got, want)
+		}
+		list := pipeline.ItemErrors(err)
+		if len(list) != 2 || list[0].Index != 1 || list[1].Index != 3 || list[1].Stage != "positive" {
+			t.Errorf("workers %d: errors %v", workers, err)
+		}
+	}
+}
+
+func TestConcurrentOrder(t *testing.T) {
+	// 元素的耗时不同，完成的顺序与输入不同
+	items := make([]int, 200)
+	for i := range items {
+		items[i] = i
+	}
+	p := pipeline.Then(pipeline.New[int](pipeline.WithWorkers(8)), pipeline.FlatMap("pair", func(n int) ([]string, error) {
+		time.Sleep(time.Duration((n*7)%5) * 100 * time.Microsecond)
+		return []string{strconv.Itoa(n), "-"}, nil
+	}))
+	got, err := p.Run(context.Background(), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range items {
+		if got[2*i] != strconv.Itoa(n) || got[2*i+1] != "-" {
+			t.Fatalf("position %d: %q", 2*i, got[2*i])
+		}
+	}
+}
+
+func TestConcurrentSpeedup(t *testing.T) {
+	items := make([]int, 16)
+	sleep := pipeline.New[int](pipeline.WithWorkers(8)).Then(pipeline.Func("sleep", func(n int) int {
+		time.Sleep(20 * time.Millisecond)
+		return n
+	}))
+	start := time.Now()
+	if _, err := sleep.Run(context.Background(), items); err != nil {
+		t.Fatal(err)
+	}
+	// 顺序执行需要 320ms，8 个 worker 大约 40ms
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("took %s", d)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int64
+	p := pipeline.New[int](pipeline.WithWorkers(2)).Then(pipeline.Func("slow", func(n int) int {
+		if calls.Add(1) == 3 {
+			cancel()
+		}
+		time.Sleep(time.Millisecond)
+		return n
+	}))
+	_, err := p.Run(ctx, make([]int, 1000))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+	if n := calls.Load(); n > 10 {
+		t.Errorf("%d items processed after cancel", n)
+	}
+
+	// 已经结束的 ctx 不处理任何元素
+	calls.Store(0)
+	if _, err := p.Run(ctx, make([]int, 10)); !errors.Is(err, context.Canceled) || calls.Load() != 0 {
+		t.Errorf("cancelled before start: %v, %d calls", err, calls.Load())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	var calls atomic.Int64
+	p := parser(&calls, pipeline.WithMetrics(), pipeline.WithWorkers(3), pipeline.WithErrorMode(pipeline.CollectErrors))
+	p = p.Then(pipeline.Filter("even", func(n int) bool { return n%2 == 0 }))
+	for i := 0; i < 2; i++ {
+		p.Run(context.Background(), []string{"1", "x", "2", "-3", "4"})
+	}
+	got := p.Metrics()
+	want := []pipeline.StageMetrics{
+		{Name: "atoi", In: 10, Out: 8, Errors: 2},
+		{Name: "positive", In: 8, Out: 6, Errors: 2},
+		{Name: "even", In: 6, Out: 4},
+	}
+	for i := range got {
+		if got[i].Time <= 0 {
+			t.Errorf("stage %s: no time recorded", got[i].Name)
+		}
+		got[i].Time = 0
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if pipeline.New[int]().Then(pipeline.Func("id", func(n int) int { return n })).Metrics() != nil {
+		t.Error("metrics without WithMetrics")
+	}
+}
+
+func TestBranching(t *testing.T) {
+	// 从同一个流水线追加不同的阶段，互不影响
+	base := pipeline.New[string]().Then(pipeline.Func("trim", strings.TrimSpace))
+	upper := base.Then(pipeline.Func("upper", strings.ToUpper))
+	lower := base.Then(pipeline.Func("lower", strings.ToLower))
+	a, _ := upper.Run(context.Background(), []string{" Go "})
+	b, _ := lower.Run(context.Background(), []string{" Go "})
+	c, _ := base.Run(context.Background(), []string{" Go "})
+	if a[0] != "GO" || b[0] != "go" || c[0] != "Go" {
+		t.Errorf("got %q %q %q", a, b, c)
+	}
+}
+
+// 顺序循环，每个处理链一个子测试
+func BenchmarkStringProcess(b *testing.B) {
+	list := items(10000)
+	for _, c := range chains {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			buf := make([]string, len(list))
+			for i := 0; i < b.N; i++ {
+				// StringProcess 会修改输入，每次先复制
+				copy(buf, list)
+				StringProcess(buf, c.funcs)
+			}
+		})
+	}
+}
+
+// 同样的处理链组成的流水线，按处理链和流水线的选项分成子测试
+func BenchmarkPipeline(b *testing.B) {
+	list := items(10000)
+	for _, c := range chains {
+		for _, v := range variants(c.funcs) {
+			b.Run(c.name+"/"+v.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := v.p.Run(context.Background(), list); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"code-snippet/code/005/pipeline"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 用 pipeline 包改写 string-chain-processing.go 中的处理链
+func main() {
+	// 待处理的字符串列表
+	list := []string{
+		"go scanner",
+		"go parser",
+		"go compiler",
+		"go printer",
+		"go formater",
+	}
+
+	// 函数处理链，处理后的结果是新的切片，list 保持不变
+	chain := pipeline.New[string](pipeline.WithMetrics()).
+		Then(pipeline.Func("remove prefix", removeStringPrefix)).
+		Then(pipeline.Func("trim space", strings.TrimSpace)).
+		Then(pipeline.Func("upper", strings.ToUpper))
+
+	result, err := chain.Run(context.Background(), list)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, str := range result {
+		fmt.Println(str)
+	}
+	fmt.Println(list[0])
+
+	// 过滤、展开和改变类型
+	lengths := pipeline.Then(
+		chain.Then(pipeline.Filter("no printer", func(s string) bool { return s != "PRINTER" })),
+		pipeline.FlatMap("letters", func(s string) ([]string, error) {
+			return []string{s, strings.ToLower(s)}, nil
+		}),
+	)
+	counts, err := pipeline.Then(lengths, pipeline.Map("length", func(s string) (int, error) {
+		return len(s), nil
+	})).Run(context.Background(), list)
+	fmt.Println(counts, err)
+
+	// 四个 goroutine 并发处理，结果的顺序与输入相同；出错的元素被丢弃，错误一起返回
+	checked := pipeline.New[string](pipeline.WithWorkers(4), pipeline.WithErrorMode(pipeline.CollectErrors)).
+		Then(pipeline.Apply("no compiler", func(s string) (string, error) {
+			if strings.Contains(s, "compiler") {
+				return "", errors.New("compiler is not allowed")
+			}
+			return s, nil
+		}))
+	result, err = checked.Run(context.Background(), list)
+	fmt.Println(result)
+	fmt.Println(err)
+
+	// 每个阶段的统计
+	for _, m := range chain.Metrics() {
+		fmt.Printf("%-14s in=%d out=%d errors=%d\n", m.Name, m.In, m.Out, m.Errors)
+	}
+}
+
+// 自定义的移除前缀的处理函数
+func removeStringPrefix(in string) (out string) {
+	return strings.TrimPrefix(in, "go")
+}
@@ -1,32 +1,23 @@
 package main
 
-import "fmt"
+import (
+	"code-snippet/code/006/eventbus"
+	"fmt"
+)
 
-// 实例化一个通过字符串映射函数切片的map
-var eventByName = make(map[string][]func(interface{}))
+// 默认的事件总线
+var bus = eventbus.New(eventbus.WithPanicHandler(func(e *eventbus.PanicError) {
+	fmt.Println("recovered:", e)
+}))
 
 // 注册事件，提供事件名和回调函数
-func RegisterEvent(name string, callback func(interface{})) {
-	// 通过名字查找事件列表
-	list := eventByName[name]
-
-	// 在列表切片中添加函数
-	list = append(list, callback)
-
-	// 将修改的事件列表切片保存回去
-	eventByName[name] = list
+func RegisterEvent(name string, callback func(interface{})) *eventbus.Subscription {
+	return bus.Subscribe(name, callback)
 }
 
 // 调用事件
-func CallEvent(name string, param interface{}) {
-	// 通过名字找到事件列表
-	list := eventByName[name]
-
-	// 遍历这个事件的所有回调
-	for _, callback := range list {
-		// 传入参数调用回调
-		callback(param)
-	}
+func CallEvent(name string, param interface{}) error {
+	return bus.Publish(name, param)
 }
 
 // 声明角色的结构体
@@ -43,15 +34,53 @@ func GlobalEvent(param interface{}) {
 	fmt.Println("global event:", param)
 }
 
+// 技能事件的参数
+type Skill struct {
+	Name   string
+	Damage int
+}
+
+// 带有类型的技能事件
+var onSkill = eventbus.NewTopic[Skill](bus, "OnCastSkill")
+
 func main() {
 	// 实例化一个角色
 	a := new(Actor)
 
 	// 注册名为 OnSkill 的回调
-	RegisterEvent("OnSkill", a.OnEvent)
+	sub := RegisterEvent("OnSkill", a.OnEvent)
 
 	// 再次在 OnSkill 上注册全局事件
 	RegisterEvent("OnSkill", GlobalEvent)
 
 	CallEvent("OnSkill", 100)
+
+	// 取消订阅后角色不再响应
+	sub.Unsubscribe()
+	CallEvent("OnSkill", 200)
+
+	// 处理函数直接接收 Skill，优先级高的先调用，Once 只响应一次
+	onSkill.Subscribe(func(s Skill) {
+		fmt.Println("log skill:", s.Name)
+	})
+	onSkill.Subscribe(func(s Skill) {
+		fmt.Println("first blood:", s.Name)
+	}, eventbus.Once(), eventbus.Priority(10))
+	onSkill.Subscribe(func(s Skill) {
+		if s.Damage < 0 {
+			panic("negative damage")
+		}
+	}, eventbus.Priority(5))
+
+	onSkill.Publish(Skill{"fireball", 30})
+	if err := onSkill.Publish(Skill{"heal", -20}); err != nil {
+		fmt.Println("publish:", err)
+	}
+
+	// 类型不一致的参数不会发送给处理函数
+	fmt.Println(CallEvent("OnCastSkill", "fireball"))
+
+	// 异步发布，等待处理完成
+	<-onSkill.PublishAsync(Skill{"thunder", 50})
+	bus.Close()
 }
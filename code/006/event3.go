@@ -0,0 +1,85 @@
+package main
+
+import (
+	"code-snippet/code/006/eventbus"
+	"code-snippet/code/006/eventbus/eventlog"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 技能事件的参数
+type Skill struct {
+	Name   string
+	Damage int
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "event3")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 打开事件日志，总线上发布的每个事件都先追加到日志中
+	events, err := eventlog.Open(filepath.Join(dir, "events"), eventlog.WithSegmentSize(256))
+	if err != nil {
+		log.Fatal(err)
+	}
+	bus := eventbus.New(eventbus.WithRecorder(events))
+	onSkill := eventbus.NewTopic[Skill](bus, "OnSkill")
+
+	onSkill.Subscribe(func(s Skill) {
+		fmt.Println("live:", s.Name)
+	})
+	onSkill.Publish(Skill{"fireball", 30})
+	onSkill.Publish(Skill{"heal", -20})
+	bus.Publish("OnLevelUp", 2)
+	onSkill.Publish(Skill{"thunder", 50})
+
+	// 新的订阅者从第 2 个事件开始补上之前的事件
+	next, err := events.Replay(2, []string{"OnSkill"}, func(e eventlog.Event) {
+		var s Skill
+		e.Decode(&s)
+		fmt.Printf("replay #%d %s: %s\n", e.Seq, e.Topic, s.Name)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("next offset:", next, "segments:", events.Segments())
+
+	// 持久的订阅者处理两个事件后退出，重启后从上次的位置继续
+	offsets, err := eventlog.OpenOffsets(filepath.Join(dir, "offsets.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	consumer := &eventlog.Consumer{Name: "stats", Log: events, Offsets: offsets}
+	for run := 1; run <= 2; run++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		handled := 0
+		consumer.Run(ctx, func(e eventlog.Event) error {
+			fmt.Printf("consumer run %d: #%d %s %s\n", run, e.Seq, e.Topic, e.Data)
+			if handled++; handled == 2 {
+				cancel()
+			}
+			return nil
+		})
+		cancel()
+	}
+
+	// 订阅者等待新的事件
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go onSkill.Publish(Skill{"meteor", 99})
+	consumer.Run(ctx, func(e eventlog.Event) error {
+		fmt.Printf("consumer tail: #%d %s %s\n", e.Seq, e.Topic, e.Data)
+		cancel()
+		return nil
+	})
+
+	bus.Close()
+	events.Close()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"code-snippet/code/006/eventbus"
+	"code-snippet/code/006/eventbus/broker"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// 声明角色的结构体
+type Actor struct {
+	Name string
+}
+
+// 为角色添加一个事件处理函数
+func (a *Actor) OnEvent(param interface{}) {
+	fmt.Printf("%s event: %v\n", a.Name, param)
+}
+
+// 技能事件的参数
+type Skill struct {
+	Name   string
+	Damage int
+}
+
+// 一个进程：本地总线发布的事件转发给代理，代理上匹配 patterns 的事件分发到本地总线
+func connect(addr, name string, patterns ...string) (*eventbus.Bus, *broker.Client) {
+	client := broker.Dial(addr, broker.WithName(name), broker.WithRetryInterval(50*time.Millisecond))
+	bus := eventbus.New(eventbus.WithRecorder(client))
+	if err := broker.Bridge(bus, client, patterns...); err != nil {
+		log.Fatal(err)
+	}
+	return bus, client
+}
+
+func main() {
+	// 代理通常是单独的进程（brokerd），这里在同一个进程中启动
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b := broker.New()
+	go b.Serve(ln)
+	addr := ln.Addr().String()
+
+	// 游戏服务器订阅所有 On 开头的事件，统计服务器只订阅技能事件
+	game, gameClient := connect(addr, "game", "On*")
+	stats, statsClient := connect(addr, "stats", "OnCastSkill")
+
+	// 与进程内的事件系统一样注册处理函数
+	a := &Actor{Name: "hero"}
+	game.Subscribe("OnSkill", a.OnEvent)
+	game.Subscribe("OnLevelUp", a.OnEvent)
+	eventbus.NewTopic[Skill](game, "OnCastSkill").Subscribe(func(s Skill) {
+		fmt.Println("game skill:", s.Name, s.Damage)
+	})
+
+	done := make(chan struct{})
+	eventbus.NewTopic[Skill](stats, "OnCastSkill").Subscribe(func(s Skill) {
+		fmt.Println("stats skill:", s.Name, s.Damage)
+		close(done)
+	})
+
+	// 等待两个进程都连上代理
+	for !gameClient.Connected() || !statsClient.Connected() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 统计服务器发布的事件由游戏服务器处理
+	stats.Publish("OnSkill", 100)
+
+	// 游戏服务器发布的技能事件同时由本地和统计服务器处理
+	eventbus.NewTopic[Skill](game, "OnCastSkill").Publish(Skill{"fireball", 30})
+	<-done
+
+	// 直接通过客户端发布，等待代理确认
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := statsClient.Publish(ctx, "OnLevelUp", map[string]int{"level": 2}); err != nil {
+		log.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	gameClient.Close()
+	statsClient.Close()
+	fmt.Printf("broker: %+v\n", b.Stats())
+	b.Close()
+}
@@ -0,0 +1,48 @@
+package broker
+
+import (
+	"code-snippet/code/006/eventbus"
+	"encoding/json"
+	"reflect"
+)
+
+// 把代理上匹配 patterns 的事件分发到本地总线，本地的处理函数不需要任何改动。
+// 通过 NewTopic 声明过类型的事件解码为声明的类型，其他事件解码为 interface{}。
+// 本地发布的事件要转发给代理时，在创建总线时使用 eventbus.WithRecorder(client)；
+// 从代理收到的事件通过 Dispatch 分发，不会再转发回代理
+func Bridge(bus *eventbus.Bus, client *Client, patterns ...string) error {
+	for _, pattern := range patterns {
+		_, err := client.Subscribe(pattern, func(e Event) error {
+			param, err := decodeParam(bus.TopicType(e.Topic), e.Data)
+			if err != nil {
+				// 无法解码的事件重发也不会成功，记录后确认
+				client.logf("broker: decode %s: %v", e.Topic, err)
+				return nil
+			}
+			// 处理函数的 panic 已经交给总线的 WithPanicHandler，重发只会再次 panic，所以同样确认
+			bus.Dispatch(e.Topic, param)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按主题声明的类型解码事件参数
+func decodeParam(typ reflect.Type, data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if typ == nil {
+		var v interface{}
+		err := json.Unmarshal(data, &v)
+		return v, err
+	}
+	p := reflect.New(typ)
+	if err := json.Unmarshal(data, p.Interface()); err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
@@ -0,0 +1,411 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 事件代理：客户端通过 TCP 连接，订阅事件名的模式，发布的事件转发给其他所有匹配的订阅者。
+// 未确认的事件在 AckTimeout 后重发，有名字的会话断开后继续保留，重连后补发
+type Broker struct {
+	AckTimeout time.Duration // 投递后等待确认的时间，默认 5 秒
+	MaxPending int           // 每个会话最多保留的未确认事件，超过时丢弃最早的，默认 10000
+	Logf       func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	nextConn uint64
+	listener net.Listener
+	conns    map[*conn]bool
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// 订阅者的会话
+type session struct {
+	id       string
+	named    bool
+	patterns map[string]bool
+	pending  []*delivery // 按投递序号排列的未确认事件
+	nextID   uint64
+	conn     *conn // 断开时为 nil
+	dropped  int
+}
+
+// 等待确认的事件
+type delivery struct {
+	id     uint64
+	topic  string
+	data   json.RawMessage
+	sentAt time.Time // 没有发送过时为零值
+}
+
+// 一个客户端连接
+type conn struct {
+	net.Conn
+	out     chan frame // 写入队列，满时丢弃，由重发机制补上
+	done    chan struct{}
+	session *session
+	once    sync.Once
+}
+
+// 发送消息，不会阻塞
+func (c *conn) send(f frame) bool {
+	select {
+	case <-c.done:
+		return false
+	case c.out <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		c.Conn.Close()
+		close(c.done)
+	})
+}
+
+// 创建代理
+func New() *Broker {
+	return &Broker{
+		AckTimeout: 5 * time.Second,
+		MaxPending: 10000,
+		sessions:   make(map[string]*session),
+		conns:      make(map[*conn]bool),
+		done:       make(chan struct{}),
+	}
+}
+
+// 监听地址并处理连接，直到 Close
+func (b *Broker) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return b.Serve(ln)
+}
+
+// 在 ln 上接受连接，直到 Close
+func (b *Broker) Serve(ln net.Listener) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	b.listener = ln
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go b.redeliver()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-b.done:
+				return nil
+			default:
+			}
+			return err
+		}
+		c := &conn{Conn: nc, out: make(chan frame, 256), done: make(chan struct{})}
+		b.mu.Lock()
+		b.conns[c] = true
+		b.mu.Unlock()
+
+		b.wg.Add(2)
+		go b.write(c)
+		go b.read(c)
+	}
+}
+
+// 监听的地址
+func (b *Broker) Addr() net.Addr {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.listener == nil {
+		return nil
+	}
+	return b.listener.Addr()
+}
+
+// 关闭代理和所有连接
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	var err error
+	if b.listener != nil {
+		err = b.listener.Close()
+	}
+	for c := range b.conns {
+		c.close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return err
+}
+
+func (b *Broker) logf(format string, args ...interface{}) {
+	if b.Logf != nil {
+		b.Logf(format, args...)
+	} else {
+		log.Printf("broker: "+format, args...)
+	}
+}
+
+// 把写入队列中的消息写到连接
+func (b *Broker) write(c *conn) {
+	defer b.wg.Done()
+	w := bufio.NewWriter(c)
+	encoder := json.NewEncoder(w)
+	for {
+		var f frame
+		select {
+		case <-c.done:
+			return
+		case f = <-c.out:
+		}
+		if err := encoder.Encode(f); err != nil {
+			c.close()
+			return
+		}
+		// 队列中没有更多消息时再刷新
+		if len(c.out) == 0 {
+			if err := w.Flush(); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// 读取并处理客户端的消息
+func (b *Broker) read(c *conn) {
+	defer b.wg.Done()
+	defer b.disconnect(c)
+
+	decoder := json.NewDecoder(bufio.NewReader(c))
+	for {
+		var f frame
+		if err := decoder.Decode(&f); err != nil {
+			if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				b.logf("%s: %v", c.RemoteAddr(), err)
+			}
+			return
+		}
+		if err := b.handle(c, f); err != nil {
+			c.send(frame{Op: opError, ID: f.ID, Error: err.Error()})
+		}
+	}
+}
+
+// 处理一条消息
+func (b *Broker) handle(c *conn, f frame) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-c.done:
+		// 连接已经被同名的新连接接管
+		return nil
+	default:
+	}
+	if f.Op == opHello {
+		b.attach(c, f.Name)
+		if f.ID != 0 {
+			c.send(frame{Op: opAck, ID: f.ID})
+		}
+		return nil
+	}
+	if c.session == nil {
+		// 没有 hello 时使用匿名会话
+		b.attach(c, "")
+	}
+	s := c.session
+
+	switch f.Op {
+	case opSub:
+		if err := validPattern(f.Pattern); err != nil {
+			return err
+		}
+		s.patterns[f.Pattern] = true
+		if f.ID != 0 {
+			c.send(frame{Op: opAck, ID: f.ID})
+		}
+	case opUnsub:
+		delete(s.patterns, f.Pattern)
+		// 不再订阅的事件不会被确认，从队列中删除
+		pending := s.pending[:0]
+		for _, d := range s.pending {
+			if s.matches(d.topic) {
+				pending = append(pending, d)
+			}
+		}
+		clear(s.pending[len(pending):])
+		s.pending = pending
+		if f.ID != 0 {
+			c.send(frame{Op: opAck, ID: f.ID})
+		}
+	case opPub:
+		if f.Topic == "" {
+			return errors.New("missing topic")
+		}
+		b.publish(s, f.Topic, f.Data)
+		c.send(frame{Op: opAck, ID: f.ID})
+	case opAck:
+		for i, d := range s.pending {
+			if d.id == f.ID {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+	default:
+		return errors.New("unknown op " + strconv.Quote(f.Op))
+	}
+	return nil
+}
+
+// 把连接关联到会话。同名会话已经有连接时断开旧连接，补发所有未确认的事件。调用时需要持有锁
+func (b *Broker) attach(c *conn, name string) {
+	if c.session != nil {
+		c.session.conn = nil
+		if !c.session.named {
+			delete(b.sessions, c.session.id)
+		}
+	}
+
+	s := b.sessions[name]
+	if name == "" || s == nil {
+		b.nextConn++
+		s = &session{id: name, named: name != "", patterns: map[string]bool{}}
+		if name == "" {
+			s.id = "#" + strconv.FormatUint(b.nextConn, 10)
+		}
+		b.sessions[s.id] = s
+	}
+	if s.conn != nil && s.conn != c {
+		old := s.conn
+		old.session = nil
+		old.close()
+	}
+	s.conn = c
+	c.session = s
+
+	for _, d := range s.pending {
+		b.deliver(s, d)
+	}
+}
+
+// 连接断开，匿名会话随之删除
+func (b *Broker) disconnect(c *conn) {
+	c.close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.conns, c)
+	if s := c.session; s != nil && s.conn == c {
+		s.conn = nil
+		if !s.named {
+			delete(b.sessions, s.id)
+		}
+	}
+}
+
+// 把事件放入其他所有匹配的会话的队列。调用时需要持有锁
+func (b *Broker) publish(from *session, topic string, data json.RawMessage) {
+	for _, s := range b.sessions {
+		if s == from || !s.matches(topic) {
+			continue
+		}
+		s.nextID++
+		d := &delivery{id: s.nextID, topic: topic, data: data}
+		s.pending = append(s.pending, d)
+		if len(s.pending) > b.MaxPending {
+			s.pending = s.pending[1:]
+			s.dropped++
+		}
+		b.deliver(s, d)
+	}
+}
+
+// 会话是否订阅了事件
+func (s *session) matches(topic string) bool {
+	for pattern := range s.patterns {
+		if match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// 发送事件，会话没有连接时等待重连。调用时需要持有锁
+func (b *Broker) deliver(s *session, d *delivery) {
+	if s.conn == nil {
+		return
+	}
+	if s.conn.send(frame{Op: opEvent, ID: d.id, Topic: d.topic, Data: d.data}) {
+		d.sentAt = time.Now()
+	}
+}
+
+// 定期重发超时未确认的事件
+func (b *Broker) redeliver() {
+	defer b.wg.Done()
+	interval := max(b.AckTimeout/2, 10*time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			b.mu.Lock()
+			for _, s := range b.sessions {
+				for _, d := range s.pending {
+					if now.Sub(d.sentAt) >= b.AckTimeout {
+						b.deliver(s, d)
+					}
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// 代理的统计信息
+type Stats struct {
+	Sessions int // 会话数量，包括断开的有名字的会话
+	Pending  int // 所有会话中未确认的事件
+	Dropped  int // 因为超过 MaxPending 丢弃的事件
+}
+
+// 当前的统计信息
+func (b *Broker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var st Stats
+	for _, s := range b.sessions {
+		st.Sessions++
+		st.Pending += len(s.pending)
+		st.Dropped += s.dropped
+	}
+	return st
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"code-snippet/code/006/eventbus/broker"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var (
+	addr       = flag.String("addr", "127.0.0.1:7070", "监听地址")
+	ackTimeout = flag.Duration("ack-timeout", 5*time.Second, "投递后等待确认的时间，超时后重发")
+	maxPending = flag.Int("max-pending", 10000, "每个会话最多保留的未确认事件")
+)
+
+func main() {
+	flag.Parse()
+
+	b := broker.New()
+	b.AckTimeout = *ackTimeout
+	b.MaxPending = *maxPending
+
+	// Ctrl+C 时关闭代理
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		b.Close()
+	}()
+
+	log.Printf("listening on %s", *addr)
+	if err := b.ListenAndServe(*addr); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"code-snippet/code/006/eventbus"
+	"code-snippet/code/006/eventbus/broker"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检查跨进程的事件代理：
+//
+//	go run ./code/006/eventbus/broker/check
+
+var checks = []struct {
+	name string
+	run  func() error
+}{
+	{"wildcard delivery without echo", checkWildcard},
+	{"redeliver after handler error", checkRedeliver},
+	{"queue events for offline named session", checkOffline},
+	{"drop oldest beyond max pending", checkMaxPending},
+	{"publish before broker starts", checkPublishEarly},
+	{"unsubscribe", checkUnsubscribe},
+	{"bad pattern", checkBadPattern},
+	{"subscribe ack timeout", checkSubscribeTimeout},
+	{"bridge local buses", checkBridge},
+}
+
+// 在随机端口上启动代理
+func start() (*broker.Broker, string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	b := broker.New()
+	b.AckTimeout = 100 * time.Millisecond
+	b.Logf = func(string, ...interface{}) {}
+	go b.Serve(ln)
+	return b, ln.Addr().String(), nil
+}
+
+// 连接代理，不输出重连日志
+func dial(addr string, opts ...broker.Option) *broker.Client {
+	opts = append([]broker.Option{
+		broker.WithRetryInterval(20 * time.Millisecond),
+		broker.WithAckTimeout(100 * time.Millisecond),
+		broker.WithLogf(func(string, ...interface{}) {}),
+	}, opts...)
+	return broker.Dial(addr, opts...)
+}
+
+// 等待条件成立
+func waitFor(what string, cond func() bool) error {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return errors.New("timeout waiting for " + what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return nil
+}
+
+func publish(c *broker.Client, topic string, param interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return c.Publish(ctx, topic, param)
+}
+
+// 收到的事件，记录为 "主题=数据"
+type inbox struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (in *inbox) handle(e broker.Event) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.events = append(in.events, e.Topic+"="+string(e.Data))
+	return nil
+}
+
+func (in *inbox) len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.events)
+}
+
+// 排序后的事件列表，投递不保证顺序
+func (in *inbox) String() string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	list := append([]string(nil), in.events...)
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
+
+func expect(what, got, want string) error {
+	if got != want {
+		return fmt.Errorf("%s: got %q, want %q", what, got, want)
+	}
+	return nil
+}
+
+func checkWildcard() error {
+	b, addr, err := start()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	a, c := dial(addr), dial(addr)
+	defer a.Close()
+	defer c.Close()
+	var ina, inc inbox
+	a.Subscribe("On*", ina.handle)
+	c.Subscribe("On*", inc.handle)
+	c.Subscribe("Tick", inc.handle)
+	if err := waitFor("connect", func() bool { return a.Connected() && c.Connected() }); err != nil {
+		return err
+	}
+
+	publish(c, "OnSkill", map[string]int{"damage": 30})
+	publish(c, "Tick", 1)
+	publish(a, "OnLevelUp", 2)
+	publish(a, "Tick", 2)
+	if err := waitFor("events", func() bool { return ina.len() == 1 && inc.len() == 2 }); err != nil {
+		return err
+	}
+	// 发布者不会收到自己发布的事件
+	if err := expect("a", ina.String(), `OnSkill={"damage":30}`); err != nil {
+		return err
+	}
+	if err := expect("c", inc.String(), "OnLevelUp=2 Tick=2"); err != nil {
+		return err
+	}
+	return waitFor("acks", func() bool { return b.Stats().Pending == 0 })
+}
+
+func checkRedeliver() error {
+	b, addr, err := start()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	a, c := dial(addr), dial(addr)
+	defer a.Close()
+	defer c.Close()
+	var mu sync.Mutex
+	calls := 0
+	a.Subscribe("OnSave", func(e broker.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if calls++; calls == 1 {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	if err := waitFor("connect", func() bool { return a.Connected() && c.Connected() }); err != nil {
+		return err
+	}
+
+	if err := publish(c, "OnSave", "game1"); err != nil {
+		return err
+	}
+	if err := waitFor("redelivery", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls == 2
+	}); err != nil {
+		return err
+	}
+	if err := waitFor("ack", func() bool { return b.Stats().Pending == 0 }); err != nil {
+		return err
+	}
+	time.Sleep(250 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		return fmt.Errorf("handler called %d times after ack", calls)
+	}
+	return nil
+}
+
+func checkOffline() error {
+	b, addr, err := start()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	worker := dial(addr, broker.WithName("worker"))
+	worker.Subscribe("Job*", func(broker.Event) error { return nil })
+	if err := waitFor("connect", worker.Connected); err != nil {
+		return err
+	}
+	worker.Close()
+
+	p := dial(addr)
+	defer p.Close()
+	for i := 1; i <= 3; i++ {
+		if err := publish(p, fmt.Sprint("Job", i), i); err != nil {
+			return err
+		}
+	}
+	if st := b.Stats(); st.Pending != 3 {
+		return fmt.Errorf("stats after publish %+v", st)
+	}
+
+	// 同名的会话重连后收到断开期间的事件
+	var in inbox
+	worker = dial(addr, broker.WithName("worker"))
+	defer worker.Close()
+	worker.Subscribe("Job*", in.handle)
+	if err := waitFor("events", func() bool { return in.len() >= 3 }); err != nil {
+		return err
+	}
+	if err := expect("events", in.String(), "Job1=1 Job2=2 Job3=3"); err != nil {
+		return err
+	}
+	return waitFor("acks", func() bool { return b.Stats().Pending == 0 })
+}
+
+func checkMaxPending() error {
+	b, addr, err := start()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	b.MaxPending = 2
+
+	offline := dial(addr, broker.WithName("offline"))
+	offline.Subscribe("*", func(broker.Event) error { return nil })
+	if err := waitFor("connect", offline.Connected); err != nil {
+		return err
+	}
+	offline.Close()
+
+	p := dial(addr)
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		publish(p, "Tick", i)
+	}
+	if st := b.Stats(); st.Pending != 2 || st.Dropped != 1 {
+		return fmt.Errorf("stats %+v", st)
+	}
+
+	var in inbox
+	offline = dial(addr, broker.WithName("offline"))
+	defer offline.Close()
+	offline.Subscribe("*", in.handle)
+	if err := waitFor("events", func() bool { return in.len() >= 2 }); err != nil {
+		return err
+	}
+	return expect("events", in.String(), "Tick=1 Tick=2")
+}
+
+func checkPublishEarly() error {
+	// 先占用一个端口再释放，客户端在代理启动前连接失败
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var in inbox
+	sub := dial(addr)
+	defer sub.Close()
+	sub.Subscribe("On*", in.handle)
+
+	// 发布者重连得慢一些，保证订阅者先连上代理
+	pub := dial(addr, broker.WithRetryInterval(300*time.Millisecond))
+	defer pub.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- publish(pub, "OnStart", "early")
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if pub.Connected() || pub.Pending() != 1 {
+		return fmt.Errorf("connected %v, pending %d before broker starts", pub.Connected(), pub.Pending())
+	}
+	b := broker.New()
+	b.Logf = func(string, ...interface{}) {}
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go b.Serve(ln)
+	defer b.Close()
+
+	if err := <-done; err != nil {
+		return err
+	}
+	if pub.Pending() != 0 {
+		return fmt.Errorf("pending %d after ack", pub.Pending())
+	}
+	if err := waitFor("event", func() bool { return in.len() == 1 }); err != nil {
+		return err
+	}
+	return expect("events", in.String(), `OnStart="early"`)
+}
+
+func checkUnsubscribe() error {
+	b, addr, err := start()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	a, c := dial(addr), dial(addr)
+	defer a.Close()
+	defer c.Close()
+	var ina, inb inbox
+	s, err := a.Subscribe("OnSkill", ina.handle)
+	if err != nil {
+		return err
+	}
+	a.Subscribe("OnLevel*", inb.handle)
+	if err := waitFor("connect", func() bool { return a.Connected() && c.Connected() }); err != nil {
+		return err
+	}
+
+	publish(c, "OnSkill", 1)
+	if err := waitFor("event", func() bool { return ina.len() == 1 }); err != nil {
+		return err
+	}
+	s.Unsubscribe()
+	publish(c, "OnSkill", 2)
+	publish(c, "OnLevelUp", 3)
+	// 代理按顺序转发，收到后一个事件时前一个事件如果会转发也已经到达
+	if err := waitFor("event", func() bool { return inb.len() == 1 }); err != nil {
+		return err
+	}
+	return expect("events", ina.String(), "OnSkill=1")
+}
+
+func checkBadPattern() error {
+	b, addr, err := start()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	c := dial(addr)
+	defer c.Close()
+	if _, err := c.Subscribe("On[", func(broker.Event) error { return nil }); !errors.Is(err, path.ErrBadPattern) {
+		return fmt.Errorf("client subscribe: %v", err)
+	}
+
+	// 代理同样检查模式，返回带有请求 id 的错误
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, `{"op":"sub","id":7,"pattern":"On["}`)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	var reply struct {
+		Op    string
+		ID    int
+		Error string
+	}
+	if err := json.Unmarshal([]byte(line), &reply); err != nil {
+		return err
+	}
+	if reply.Op != "error" || reply.ID != 7 || reply.Error != path.ErrBadPattern.Error() {
+		return fmt.Errorf("reply %s", line)
+	}
+	return nil
+}
+
+func checkSubscribeTimeout() error {
+	// 只确认握手、从不确认订阅的代理
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				decoder := json.NewDecoder(conn)
+				var hello struct{ ID int }
+				if decoder.Decode(&hello) != nil {
+					return
+				}
+				fmt.Fprintf(conn, `{"op":"ack","id":%d}`+"\n", hello.ID)
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	c := dial(ln.Addr().String())
+	defer c.Close()
+	if err := waitFor("connection", c.Connected); err != nil {
+		return err
+	}
+	start := time.Now()
+	s, err := c.Subscribe("OnSkill", func(broker.Event) error { return nil })
+	if !errors.Is(err, broker.ErrAckTimeout) || s != nil {
+		return fmt.Errorf("subscribe without ack: %v, %v", s, err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		return fmt.Errorf("subscribe returned after %s", d)
+	}
+	return nil
+}
+
+// 技能事件的参数
+type Skill struct {
+	Name   string
+	Damage int
+}
+
+func checkBridge() error {
+	b, addr, err := start()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	// 两个进程各有一个本地总线，发布的事件通过代理转发
+	game, stats := dial(addr), dial(addr)
+	defer game.Close()
+	defer stats.Close()
+	gameBus := eventbus.New(eventbus.WithRecorder(game))
+	statsBus := eventbus.New(eventbus.WithRecorder(stats))
+	if err := broker.Bridge(gameBus, game, "On*"); err != nil {
+		return err
+	}
+	if err := broker.Bridge(statsBus, stats, "OnCastSkill"); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var got []string
+	add := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, s)
+	}
+	gameBus.Subscribe("OnSkill", func(param interface{}) {
+		add(fmt.Sprintf("game OnSkill %T %v", param, param))
+	})
+	eventbus.NewTopic[Skill](gameBus, "OnCastSkill").Subscribe(func(s Skill) {
+		add(fmt.Sprintf("game skill %s %d", s.Name, s.Damage))
+	})
+	eventbus.NewTopic[Skill](statsBus, "OnCastSkill").Subscribe(func(s Skill) {
+		add(fmt.Sprintf("stats skill %s %d", s.Name, s.Damage))
+	})
+	if err := waitFor("connect", func() bool { return game.Connected() && stats.Connected() }); err != nil {
+		return err
+	}
+
+	statsBus.Publish("OnSkill", 100)
+	eventbus.NewTopic[Skill](gameBus, "OnCastSkill").Publish(Skill{"fireball", 30})
+	if err := waitFor("events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) >= 3
+	}); err != nil {
+		return err
+	}
+	// 等待可能的回声
+	time.Sleep(250 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(got)
+	want := []string{"game OnSkill float64 100", "game skill fireball 30", "stats skill fireball 30"}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("got %q, want %q", got, want)
+	}
+	if n := game.Pending() + stats.Pending(); n != 0 {
+		return fmt.Errorf("%d publishes not acked", n)
+	}
+	return nil
+}
+
+func main() {
+	failed := 0
+	for _, c := range checks {
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", len(checks))
+}
@@ -0,0 +1,557 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 客户端已经关闭
+var ErrClosed = errors.New("broker: client closed")
+
+// 超过 WithAckTimeout 设置的时间没有收到代理的确认
+var ErrAckTimeout = errors.New("broker: timed out waiting for ack")
+
+// 从代理收到的事件
+type Event struct {
+	Topic string
+	Data  json.RawMessage
+}
+
+// 把事件的参数解码到 v
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// 代理的客户端，在后台连接代理并在断开后自动重连，可以被多个 goroutine 同时使用
+type Client struct {
+	addr       string
+	name       string
+	retry      time.Duration
+	ackTimeout time.Duration
+	logf       func(format string, args ...interface{})
+
+	mu     sync.Mutex
+	link   *link // 未连接时为 nil
+	subs   []*Subscription
+	calls  map[uint64]*call
+	nextID uint64
+	closed bool
+
+	events chan incoming
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// 等待代理确认的请求
+type call struct {
+	f      frame
+	resend bool      // 发布请求在确认前一直重发，订阅请求在连接断开后放弃
+	sentAt time.Time // 没有发送过时为零值
+	done   chan error
+}
+
+// 等待处理的事件
+type incoming struct {
+	Event
+	id   uint64
+	link *link // 收到事件的连接，确认通过同一个连接发送
+}
+
+// 一次连接
+type link struct {
+	conn    net.Conn
+	out     chan frame
+	done    chan struct{}
+	once    sync.Once
+	readyID uint64        // 握手的最后一条消息，代理确认后订阅已经全部生效
+	ready   chan struct{} // 收到 readyID 的确认后关闭
+}
+
+// 发送消息，不会阻塞
+func (l *link) send(f frame) bool {
+	select {
+	case <-l.done:
+		return false
+	case l.out <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *link) close() {
+	l.once.Do(func() {
+		l.conn.Close()
+		close(l.done)
+	})
+}
+
+// 订阅，用于取消订阅
+type Subscription struct {
+	client  *Client
+	pattern string
+	fn      func(Event) error
+}
+
+// 创建客户端的选项
+type Option func(*Client)
+
+// 会话名。有名字的会话断开后代理继续保存发给它的事件，重连后补发。
+// 同一时间只能有一个客户端使用同一个名字，后连接的会断开先连接的
+func WithName(name string) Option {
+	return func(c *Client) {
+		c.name = name
+	}
+}
+
+// 连接失败或断开后重连的间隔，默认 1 秒
+func WithRetryInterval(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.retry = d
+		}
+	}
+}
+
+// 发布后等待确认的时间，超时后重发，默认 5 秒
+func WithAckTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.ackTimeout = d
+		}
+	}
+}
+
+// 记录连接错误，默认使用 log.Printf
+func WithLogf(logf func(format string, args ...interface{})) Option {
+	return func(c *Client) {
+		c.logf = logf
+	}
+}
+
+// 创建客户端，在后台连接 addr，代理不可用时每隔一段时间重试
+func Dial(addr string, opts ...Option) *Client {
+	c := &Client{
+		addr:       addr,
+		retry:      time.Second,
+		ackTimeout: 5 * time.Second,
+		logf:       log.Printf,
+		calls:      make(map[uint64]*call),
+		events:     make(chan incoming, 256),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.wg.Add(3)
+	go c.run()
+	go c.handle()
+	go c.resend()
+	return c
+}
+
+// 订阅匹配 pattern 的事件，模式的语法与 path.Match 相同，例如 On*。
+// fn 在同一个 goroutine 中依次调用，返回 nil 后确认事件，返回错误时代理稍后重发。
+// 已经连接时等待代理确认订阅，未连接时订阅在连接后生效。
+// 代理返回错误或者确认超时（ErrAckTimeout）时取消订阅并返回错误
+func (c *Client) Subscribe(pattern string, fn func(Event) error) (*Subscription, error) {
+	if err := validPattern(pattern); err != nil {
+		return nil, err
+	}
+	s := &Subscription{client: c, pattern: pattern, fn: fn}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	subscribed := c.subscribed(pattern)
+	c.subs = append(c.subs, s)
+	c.mu.Unlock()
+
+	if subscribed {
+		return s, nil
+	}
+	if err := c.request(frame{Op: opSub, Pattern: pattern}); err != nil {
+		s.Unsubscribe()
+		return nil, err
+	}
+	return s, nil
+}
+
+// 取消订阅，同一个模式没有其他订阅时通知代理
+func (s *Subscription) Unsubscribe() {
+	c := s.client
+	c.mu.Lock()
+	for i, sub := range c.subs {
+		if sub == s {
+			c.subs = append(c.subs[:i:i], c.subs[i+1:]...)
+			break
+		}
+	}
+	subscribed := c.subscribed(s.pattern)
+	c.mu.Unlock()
+
+	if !subscribed {
+		c.request(frame{Op: opUnsub, Pattern: s.pattern})
+	}
+}
+
+// 是否已经订阅了 pattern。调用时需要持有锁
+func (c *Client) subscribed(pattern string) bool {
+	for _, s := range c.subs {
+		if s.pattern == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// 发送订阅请求并等待确认，超时返回 ErrAckTimeout。未连接或连接断开时直接返回，重连后会重新订阅
+func (c *Client) request(f frame) error {
+	c.mu.Lock()
+	if c.link == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	call := c.add(f, false)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(c.ackTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-call.done:
+		return err
+	case <-timer.C:
+		c.cancel(call.f.ID)
+		return ErrAckTimeout
+	case <-c.done:
+		return ErrClosed
+	}
+}
+
+// 分配 id，记录并发送请求。调用时需要持有锁
+func (c *Client) add(f frame, resend bool) *call {
+	c.nextID++
+	f.ID = c.nextID
+	call := &call{f: f, resend: resend, done: make(chan error, 1)}
+	c.calls[f.ID] = call
+	if c.link != nil && c.link.send(f) {
+		call.sentAt = time.Now()
+	}
+	return call
+}
+
+// 放弃等待请求的结果
+func (c *Client) cancel(id uint64) {
+	c.mu.Lock()
+	delete(c.calls, id)
+	c.mu.Unlock()
+}
+
+// 发布事件，param 编码为 JSON，等待代理确认。
+// 未连接时事件保存在客户端，连接后发送；ctx 结束后不再重发
+func (c *Client) Publish(ctx context.Context, topic string, param interface{}) error {
+	data, err := json.Marshal(param)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	call := c.add(frame{Op: opPub, Topic: topic, Data: data}, true)
+	c.mu.Unlock()
+
+	select {
+	case err := <-call.done:
+		return err
+	case <-ctx.Done():
+		c.cancel(call.f.ID)
+		return ctx.Err()
+	case <-c.done:
+		return ErrClosed
+	}
+}
+
+// 实现 eventbus.Recorder：把本地总线上发布的事件转发给代理，不等待确认
+func (c *Client) Record(topic string, param interface{}) error {
+	data, err := json.Marshal(param)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.add(frame{Op: opPub, Topic: topic, Data: data}, true)
+	return nil
+}
+
+// 还没有确认的发布请求
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, call := range c.calls {
+		if call.resend {
+			n++
+		}
+	}
+	return n
+}
+
+// 是否已经连接到代理，并且代理已经收到所有订阅
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	l := c.link
+	c.mu.Unlock()
+	if l == nil {
+		return false
+	}
+	select {
+	case <-l.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// 关闭客户端，未确认的发布请求被丢弃
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	if c.link != nil {
+		c.link.close()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	return nil
+}
+
+// 连接代理并读取消息，断开后重连
+func (c *Client) run() {
+	defer c.wg.Done()
+	for {
+		conn, err := net.Dial("tcp", c.addr)
+		if err == nil {
+			err = c.serve(conn)
+		}
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		if err != nil {
+			c.logf("broker: %s: %v", c.addr, err)
+		}
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.retry):
+		}
+	}
+}
+
+// 处理一次连接，返回断开的原因
+func (c *Client) serve(conn net.Conn) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	// 在其他请求之前依次发送会话名、所有订阅和未确认的发布
+	l := &link{
+		conn:  conn,
+		out:   make(chan frame, 256+len(c.subs)+len(c.calls)),
+		done:  make(chan struct{}),
+		ready: make(chan struct{}),
+	}
+	handshake := []frame{{Op: opHello, Name: c.name}}
+	patterns := map[string]bool{}
+	for _, s := range c.subs {
+		if !patterns[s.pattern] {
+			patterns[s.pattern] = true
+			handshake = append(handshake, frame{Op: opSub, Pattern: s.pattern})
+		}
+	}
+	c.nextID++
+	l.readyID = c.nextID
+	handshake[len(handshake)-1].ID = l.readyID
+	for _, f := range handshake {
+		l.out <- f
+	}
+	ids := make([]uint64, 0, len(c.calls))
+	for id, call := range c.calls {
+		if call.resend {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		call := c.calls[id]
+		l.out <- call.f
+		call.sentAt = time.Now()
+	}
+	c.link = l
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go c.write(l)
+	defer func() {
+		l.close()
+		c.mu.Lock()
+		c.link = nil
+		// 连接断开后订阅请求不再等待，重连时会重新订阅
+		for id, call := range c.calls {
+			if !call.resend {
+				delete(c.calls, id)
+				call.done <- nil
+			}
+		}
+		c.mu.Unlock()
+	}()
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var f frame
+		if err := decoder.Decode(&f); err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		switch f.Op {
+		case opEvent:
+			// 处理函数中可以调用 Publish，所以不在读取消息的 goroutine 中调用；
+			// 处理不过来时丢弃，代理稍后重发
+			select {
+			case c.events <- incoming{Event{f.Topic, f.Data}, f.ID, l}:
+			default:
+			}
+		case opAck, opError:
+			if f.ID == l.readyID {
+				close(l.ready)
+				continue
+			}
+			var err error
+			if f.Op == opError {
+				err = errors.New("broker: " + f.Error)
+			}
+			c.mu.Lock()
+			call := c.calls[f.ID]
+			delete(c.calls, f.ID)
+			c.mu.Unlock()
+			if call != nil {
+				call.done <- err
+			} else if err != nil {
+				c.logf("%v", err)
+			}
+		}
+	}
+}
+
+// 把队列中的消息写到连接
+func (c *Client) write(l *link) {
+	defer c.wg.Done()
+	w := bufio.NewWriter(l.conn)
+	encoder := json.NewEncoder(w)
+	for {
+		var f frame
+		select {
+		case <-l.done:
+			return
+		case f = <-l.out:
+		}
+		if err := encoder.Encode(f); err != nil {
+			l.close()
+			return
+		}
+		if len(l.out) == 0 {
+			if err := w.Flush(); err != nil {
+				l.close()
+				return
+			}
+		}
+	}
+}
+
+// 依次调用处理函数，都成功后确认事件
+func (c *Client) handle() {
+	defer c.wg.Done()
+	for {
+		var in incoming
+		select {
+		case <-c.done:
+			return
+		case in = <-c.events:
+		}
+
+		c.mu.Lock()
+		var fns []func(Event) error
+		for _, s := range c.subs {
+			if match(s.pattern, in.Topic) {
+				fns = append(fns, s.fn)
+			}
+		}
+		c.mu.Unlock()
+		if len(fns) == 0 {
+			// 重连后订阅之前收到的事件，不确认，等订阅后由代理重发
+			continue
+		}
+
+		var err error
+		for _, fn := range fns {
+			if e := fn(in.Event); e != nil && err == nil {
+				err = e
+			}
+		}
+		if err != nil {
+			c.logf("broker: handle %s: %v", in.Topic, err)
+			continue
+		}
+		// 连接已经断开时不再确认，代理会在重连后重发
+		in.link.send(frame{Op: opAck, ID: in.id})
+	}
+}
+
+// 定期重发超时未确认的发布请求
+func (c *Client) resend() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(max(c.ackTimeout/2, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			if c.link != nil {
+				for _, call := range c.calls {
+					if call.resend && now.Sub(call.sentAt) >= c.ackTimeout && c.link.send(call.f) {
+						call.sentAt = now
+					}
+				}
+			}
+			c.mu.Unlock()
+		}
+	}
+}
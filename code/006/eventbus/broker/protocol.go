@@ -0,0 +1,48 @@
+package broker
+
+import (
+	"encoding/json"
+	"path"
+)
+
+// 连接上传输的消息，每条消息编码为一行 JSON
+//
+//	客户端 -> 代理  hello{id,name}        声明会话名，同名的会话在重连后保留订阅和未确认的事件，带有 id 时回复 ack{id}
+//	客户端 -> 代理  sub{id,pattern}       订阅匹配模式的事件，带有 id 时回复 ack{id}
+//	客户端 -> 代理  unsub{id,pattern}     取消订阅，带有 id 时回复 ack{id}
+//	客户端 -> 代理  pub{id,topic,data}    发布事件，代理把事件放入所有订阅者的队列后回复 ack{id}
+//	代理 -> 客户端  event{id,topic,data}  投递事件，客户端处理完成后回复 ack{id}
+//	代理 -> 客户端  error{id,error}       请求出错
+//
+// 发布和投递都在超时未确认时重发，所以每个事件至少送达一次，但可能重复
+type frame struct {
+	Op      string          `json:"op"`
+	ID      uint64          `json:"id,omitempty"`
+	Name    string          `json:"name,omitempty"`
+	Pattern string          `json:"pattern,omitempty"`
+	Topic   string          `json:"topic,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+const (
+	opHello = "hello"
+	opSub   = "sub"
+	opUnsub = "unsub"
+	opPub   = "pub"
+	opEvent = "event"
+	opAck   = "ack"
+	opError = "error"
+)
+
+// 检查订阅模式，语法与 path.Match 相同：* 匹配任意字符串，? 匹配单个字符，[...] 匹配字符集
+func validPattern(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// 事件名是否匹配订阅模式
+func match(pattern, topic string) bool {
+	ok, _ := path.Match(pattern, topic)
+	return ok
+}
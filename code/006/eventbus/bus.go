@@ -0,0 +1,373 @@
+package eventbus
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 总线已经关闭
+var ErrClosed = errors.New("eventbus: bus closed")
+
+// 事件处理函数发生 panic，其他处理函数照常调用
+type PanicError struct {
+	Topic string
+	Value interface{} // recover 得到的值
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("eventbus: listener of %q panicked: %v", e.Topic, e.Value)
+}
+
+// 事件参数的类型与主题声明的类型不一致
+type TypeError struct {
+	Topic string
+	Want  reflect.Type
+	Got   reflect.Type
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("eventbus: topic %q expects %s, got %s", e.Topic, e.Want, e.Got)
+}
+
+// 事件总线，可以被多个 goroutine 同时使用
+type Bus struct {
+	mu     sync.RWMutex
+	topics map[string]*topic
+	nextID uint64
+
+	workers   int
+	queueSize int
+	onPanic   func(*PanicError)
+	recorders []Recorder
+
+	poolOnce sync.Once
+	jobs     chan func()
+	poolWG   sync.WaitGroup
+	closeMu  sync.RWMutex // 保护 closed 和 sending.Add
+	closed   bool
+	closing  chan struct{}  // Close 时关闭，让阻塞在队列上的发送返回
+	sending  sync.WaitGroup // 正在向 jobs 发送的 PublishAsync，全部返回后才能关闭 jobs
+}
+
+// 一个主题的处理函数
+type topic struct {
+	typ       reflect.Type // 通过 NewTopic 声明的参数类型，未声明时为 nil
+	listeners []*listener  // 按优先级排序，只整体替换，发布时可以不加锁遍历
+}
+
+// 处理函数
+type listener struct {
+	id       uint64
+	priority int
+	once     bool
+	fired    atomic.Bool // once 的处理函数是否已经调用过
+	fn       func(interface{})
+}
+
+// 创建总线的选项
+type Option func(*Bus)
+
+// 异步发布使用的 goroutine 数量，默认为 GOMAXPROCS
+func WithWorkers(n int) Option {
+	return func(b *Bus) {
+		if n > 0 {
+			b.workers = n
+		}
+	}
+}
+
+// 异步发布的队列长度，队列满时 PublishAsync 阻塞，默认为 workers 的 64 倍
+func WithQueueSize(n int) Option {
+	return func(b *Bus) {
+		if n >= 0 {
+			b.queueSize = n
+		}
+	}
+}
+
+// 处理函数 panic 时调用，可以用来记录日志
+func WithPanicHandler(handler func(*PanicError)) Option {
+	return func(b *Bus) {
+		b.onPanic = handler
+	}
+}
+
+// 事件记录器，例如持久化的事件日志
+type Recorder interface {
+	// 在调用处理函数之前记录事件，返回错误时不再调用处理函数
+	Record(topic string, param interface{}) error
+}
+
+// 发布的每个事件先交给记录器记录，可以指定多个，按顺序调用
+func WithRecorder(r Recorder) Option {
+	return func(b *Bus) {
+		b.recorders = append(b.recorders, r)
+	}
+}
+
+// 创建总线
+func New(opts ...Option) *Bus {
+	b := &Bus{
+		topics:    make(map[string]*topic),
+		workers:   runtime.GOMAXPROCS(0),
+		queueSize: -1,
+		closing:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.queueSize < 0 {
+		b.queueSize = b.workers * 64
+	}
+	return b
+}
+
+// 订阅的选项
+type SubscribeOption func(*listener)
+
+// 优先级，数值大的先调用，相同优先级按订阅顺序调用，默认为 0
+func Priority(n int) SubscribeOption {
+	return func(l *listener) {
+		l.priority = n
+	}
+}
+
+// 只处理一次事件，之后自动取消订阅
+func Once() SubscribeOption {
+	return func(l *listener) {
+		l.once = true
+	}
+}
+
+// 订阅，用于取消订阅
+type Subscription struct {
+	bus   *Bus
+	topic string
+	id    uint64
+}
+
+// 取消订阅，可以多次调用。正在进行的发布仍可能调用处理函数
+func (s *Subscription) Unsubscribe() {
+	s.bus.remove(s.topic, s.id)
+}
+
+// 订阅事件，fn 接收发布时传入的参数
+func (b *Bus) Subscribe(name string, fn func(interface{}), opts ...SubscribeOption) *Subscription {
+	l := &listener{fn: fn}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	l.id = b.nextID
+
+	t := b.topic(name)
+	// 复制一份再插入，正在遍历旧切片的发布不受影响
+	listeners := make([]*listener, 0, len(t.listeners)+1)
+	listeners = append(listeners, t.listeners...)
+	i := sort.Search(len(listeners), func(i int) bool {
+		return listeners[i].priority < l.priority
+	})
+	listeners = append(listeners, nil)
+	copy(listeners[i+1:], listeners[i:])
+	listeners[i] = l
+	t.listeners = listeners
+
+	return &Subscription{bus: b, topic: name, id: l.id}
+}
+
+// 取得主题，不存在时创建。调用时需要持有写锁
+func (b *Bus) topic(name string) *topic {
+	t := b.topics[name]
+	if t == nil {
+		t = new(topic)
+		b.topics[name] = t
+	}
+	return t
+}
+
+// 删除处理函数
+func (b *Bus) remove(name string, id uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topics[name]
+	if t == nil {
+		return
+	}
+	for i, l := range t.listeners {
+		if l.id == id {
+			listeners := make([]*listener, 0, len(t.listeners)-1)
+			listeners = append(listeners, t.listeners[:i]...)
+			t.listeners = append(listeners, t.listeners[i+1:]...)
+			break
+		}
+	}
+	if len(t.listeners) == 0 && t.typ == nil {
+		delete(b.topics, name)
+	}
+}
+
+// 主题当前的处理函数数量
+func (b *Bus) Listeners(name string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if t := b.topics[name]; t != nil {
+		return len(t.listeners)
+	}
+	return 0
+}
+
+// 通过 NewTopic 声明的参数类型，没有声明时返回 nil
+func (b *Bus) TopicType(name string) reflect.Type {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if t := b.topics[name]; t != nil {
+		return t.typ
+	}
+	return nil
+}
+
+// 声明主题的参数类型，与已有的声明不同时 panic
+func (b *Bus) declare(name string, typ reflect.Type) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(name)
+	if t.typ != nil && t.typ != typ {
+		panic(fmt.Sprintf("eventbus: topic %q redeclared as %s, previously %s", name, typ, t.typ))
+	}
+	t.typ = typ
+}
+
+// 同步发布事件：先交给记录器记录，再在当前 goroutine 中按优先级依次调用处理函数。
+// 处理函数的 panic 被恢复为 *PanicError，多个错误合并后返回
+func (b *Bus) Publish(name string, param interface{}) error {
+	return b.publish(name, param, true)
+}
+
+// 与 Publish 相同，但不交给记录器。用于分发从其他地方（例如日志重放、其他进程）收到的事件
+func (b *Bus) Dispatch(name string, param interface{}) error {
+	return b.publish(name, param, false)
+}
+
+func (b *Bus) publish(name string, param interface{}, record bool) error {
+	b.mu.RLock()
+	t := b.topics[name]
+	var listeners []*listener
+	var typ reflect.Type
+	if t != nil {
+		listeners, typ = t.listeners, t.typ
+	}
+	b.mu.RUnlock()
+
+	if typ != nil && param != nil && !reflect.TypeOf(param).AssignableTo(typ) {
+		return &TypeError{Topic: name, Want: typ, Got: reflect.TypeOf(param)}
+	}
+	if record {
+		for _, r := range b.recorders {
+			if err := r.Record(name, param); err != nil {
+				return err
+			}
+		}
+	}
+
+	var errs []error
+	for _, l := range listeners {
+		if l.once {
+			// 并发发布时只有一个能够调用
+			if !l.fired.CompareAndSwap(false, true) {
+				continue
+			}
+			b.remove(name, l.id)
+		}
+		if err := b.call(name, l, param); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 调用处理函数，恢复 panic
+func (b *Bus) call(name string, l *listener, param interface{}) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			e := &PanicError{Topic: name, Value: v, Stack: debug.Stack()}
+			if b.onPanic != nil {
+				b.onPanic(e)
+			}
+			err = e
+		}
+	}()
+	l.fn(param)
+	return nil
+}
+
+// 异步发布事件：交给工作 goroutine 按优先级依次调用处理函数，不同事件之间可能并发处理。
+// 返回的通道在处理完成后收到 Publish 的结果，可以忽略。总线关闭后，
+// 以及队列已满、等待期间总线被关闭时收到 ErrClosed。处理函数中也可以调用
+func (b *Bus) PublishAsync(name string, param interface{}) <-chan error {
+	done := make(chan error, 1)
+	b.poolOnce.Do(b.startWorkers)
+
+	// 只在登记时持有锁，等待队列时不持有，Close 和处理函数中的发布都不会被阻塞
+	b.closeMu.RLock()
+	if b.closed {
+		b.closeMu.RUnlock()
+		done <- ErrClosed
+		return done
+	}
+	b.sending.Add(1)
+	b.closeMu.RUnlock()
+	defer b.sending.Done()
+
+	select {
+	case b.jobs <- func() { done <- b.Publish(name, param) }:
+	case <-b.closing:
+		done <- ErrClosed
+	}
+	return done
+}
+
+// 启动工作 goroutine
+func (b *Bus) startWorkers() {
+	b.jobs = make(chan func(), b.queueSize)
+	for i := 0; i < b.workers; i++ {
+		b.poolWG.Add(1)
+		go func() {
+			defer b.poolWG.Done()
+			for job := range b.jobs {
+				job()
+			}
+		}()
+	}
+}
+
+// 关闭总线：不再接受异步发布，等待已经提交的事件处理完毕。同步发布不受影响
+func (b *Bus) Close() {
+	b.closeMu.Lock()
+	if b.closed {
+		b.closeMu.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.closing)
+	b.closeMu.Unlock()
+
+	// 没有异步发布过时不需要启动工作 goroutine
+	b.poolOnce.Do(func() {})
+	if b.jobs != nil {
+		// 阻塞在队列上的发送收到 closing 后返回，之后不会再有发送
+		b.sending.Wait()
+		close(b.jobs)
+		b.poolWG.Wait()
+	}
+}
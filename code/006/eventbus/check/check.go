@@ -0,0 +1,399 @@
+package main
+
+import (
+	"code-snippet/code/006/eventbus"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 检查事件总线的行为，建议加上 -race 运行：
+//
+//	go run -race ./code/006/eventbus/check
+
+var checks = []struct {
+	name string
+	run  func() error
+}{
+	{"priority order", checkPriority},
+	{"once under concurrent publish", checkOnce},
+	{"unsubscribe during dispatch", checkUnsubscribe},
+	{"subscribe from listener", checkReentrant},
+	{"panic isolation", checkPanic},
+	{"typed topics", checkTyped},
+	{"async dispatch", checkAsync},
+	{"async runs in parallel", checkParallel},
+	{"close", checkClose},
+	{"close with blocked and re-entrant publishers", checkCloseBlocked},
+	{"concurrent use", checkConcurrent},
+}
+
+// 记录调用顺序
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) listener(name string) func(interface{}) {
+	return func(interface{}) {
+		r.mu.Lock()
+		r.calls = append(r.calls, name)
+		r.mu.Unlock()
+	}
+}
+
+func (r *recorder) take() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := strings.Join(r.calls, ",")
+	r.calls = nil
+	return s
+}
+
+func expect(what, got, want string) error {
+	if got != want {
+		return fmt.Errorf("%s: got %q, want %q", what, got, want)
+	}
+	return nil
+}
+
+func checkPriority() error {
+	bus := eventbus.New()
+	var r recorder
+	bus.Subscribe("e", r.listener("a"))
+	bus.Subscribe("e", r.listener("b"), eventbus.Priority(1))
+	bus.Subscribe("e", r.listener("c"))
+	bus.Subscribe("e", r.listener("d"), eventbus.Priority(-1))
+	bus.Subscribe("e", r.listener("e"), eventbus.Priority(1))
+	bus.Subscribe("other", r.listener("x"))
+
+	if err := bus.Publish("e", nil); err != nil {
+		return err
+	}
+	if err := bus.Publish("missing", nil); err != nil {
+		return err
+	}
+	return expect("order", r.take(), "b,e,a,c,d")
+}
+
+func checkOnce() error {
+	bus := eventbus.New()
+	var n int32
+	bus.Subscribe("e", func(interface{}) { atomic.AddInt32(&n, 1) }, eventbus.Once())
+	bus.Subscribe("e", func(interface{}) {})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bus.Publish("e", nil)
+		}()
+	}
+	wg.Wait()
+
+	if n != 1 {
+		return fmt.Errorf("once listener called %d times", n)
+	}
+	if got := bus.Listeners("e"); got != 1 {
+		return fmt.Errorf("%d listeners left, want 1", got)
+	}
+	return nil
+}
+
+func checkUnsubscribe() error {
+	bus := eventbus.New()
+	var r recorder
+	var later *eventbus.Subscription
+	bus.Subscribe("e", func(p interface{}) {
+		r.listener("a")(p)
+		later.Unsubscribe()
+	})
+	later = bus.Subscribe("e", r.listener("b"))
+
+	// 本次发布已经取得处理函数列表，b 仍然被调用
+	bus.Publish("e", nil)
+	bus.Publish("e", nil)
+	later.Unsubscribe()
+	if err := expect("calls", r.take(), "a,b,a"); err != nil {
+		return err
+	}
+	if got := bus.Listeners("e"); got != 1 {
+		return fmt.Errorf("%d listeners left, want 1", got)
+	}
+	return nil
+}
+
+func checkReentrant() error {
+	bus := eventbus.New()
+	var r recorder
+	bus.Subscribe("e", func(p interface{}) {
+		r.listener("a")(p)
+		if p == 1 {
+			bus.Subscribe("e", r.listener("b"))
+			bus.Publish("f", nil)
+		}
+	})
+	bus.Subscribe("f", r.listener("f"))
+
+	done := make(chan struct{})
+	go func() {
+		bus.Publish("e", 1)
+		bus.Publish("e", 2)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		return errors.New("deadlock")
+	}
+	return expect("calls", r.take(), "a,f,a,b")
+}
+
+func checkPanic() error {
+	var handled []*eventbus.PanicError
+	bus := eventbus.New(eventbus.WithPanicHandler(func(e *eventbus.PanicError) {
+		handled = append(handled, e)
+	}))
+	var r recorder
+	bus.Subscribe("e", r.listener("a"))
+	bus.Subscribe("e", func(interface{}) { panic("boom") })
+	bus.Subscribe("e", func(interface{}) { panic(errors.New("bang")) })
+	bus.Subscribe("e", r.listener("d"))
+
+	err := bus.Publish("e", nil)
+	if err := expect("calls", r.take(), "a,d"); err != nil {
+		return err
+	}
+	var pe *eventbus.PanicError
+	if !errors.As(err, &pe) || pe.Value != "boom" || pe.Topic != "e" || len(pe.Stack) == 0 {
+		return fmt.Errorf("error %v, want PanicError for boom", err)
+	}
+	if !strings.Contains(err.Error(), "bang") {
+		return fmt.Errorf("error %q does not mention the second panic", err)
+	}
+	if len(handled) != 2 {
+		return fmt.Errorf("panic handler called %d times, want 2", len(handled))
+	}
+	return nil
+}
+
+type skill struct {
+	Name string
+}
+
+func checkTyped() error {
+	bus := eventbus.New()
+	topic := eventbus.NewTopic[skill](bus, "skill")
+	var got []skill
+	topic.Subscribe(func(s skill) { got = append(got, s) })
+
+	// 同样的类型可以再次声明
+	again := eventbus.NewTopic[skill](bus, "skill")
+	if err := again.Publish(skill{"fire"}); err != nil {
+		return err
+	}
+	if err := bus.Publish("skill", nil); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(got, []skill{{"fire"}, {}}) {
+		return fmt.Errorf("received %v", got)
+	}
+
+	err := bus.Publish("skill", "fire")
+	var te *eventbus.TypeError
+	if !errors.As(err, &te) || te.Got != reflect.TypeOf("") {
+		return fmt.Errorf("error %v, want TypeError", err)
+	}
+	if len(got) != 2 {
+		return errors.New("listener called with wrong type")
+	}
+
+	// 接口类型的主题接收实现了接口的值
+	errs := eventbus.NewTopic[error](bus, "errors")
+	var msg string
+	errs.Subscribe(func(e error) { msg = e.Error() })
+	if err := bus.Publish("errors", errors.New("disk full")); err != nil || msg != "disk full" {
+		return fmt.Errorf("interface topic: %v %q", err, msg)
+	}
+
+	defer func() {
+		recover()
+	}()
+	eventbus.NewTopic[int](bus, "skill")
+	return errors.New("redeclaring a topic with another type did not panic")
+}
+
+func checkAsync() error {
+	bus := eventbus.New(eventbus.WithWorkers(4), eventbus.WithQueueSize(8))
+	var n int64
+	bus.Subscribe("e", func(p interface{}) { atomic.AddInt64(&n, int64(p.(int))) })
+	bus.Subscribe("bad", func(interface{}) { panic("async") })
+
+	var results []<-chan error
+	for i := 1; i <= 1000; i++ {
+		results = append(results, bus.PublishAsync("e", i))
+	}
+	bad := bus.PublishAsync("bad", nil)
+	bus.Close()
+
+	if n != 500500 {
+		return fmt.Errorf("sum %d after close, want 500500", n)
+	}
+	for _, done := range results {
+		if err := <-done; err != nil {
+			return err
+		}
+	}
+	var pe *eventbus.PanicError
+	if err := <-bad; !errors.As(err, &pe) {
+		return fmt.Errorf("async panic result %v", err)
+	}
+	return nil
+}
+
+func checkParallel() error {
+	const workers = 4
+	bus := eventbus.New(eventbus.WithWorkers(workers))
+	defer bus.Close()
+
+	// 每个处理函数都等待其他处理函数开始，串行执行时会超时
+	var started sync.WaitGroup
+	started.Add(workers)
+	bus.Subscribe("e", func(interface{}) {
+		started.Done()
+		started.Wait()
+	})
+	var results []<-chan error
+	for i := 0; i < workers; i++ {
+		results = append(results, bus.PublishAsync("e", nil))
+	}
+	timeout := time.After(5 * time.Second)
+	for _, done := range results {
+		select {
+		case <-done:
+		case <-timeout:
+			return errors.New("events were not dispatched in parallel")
+		}
+	}
+	return nil
+}
+
+func checkClose() error {
+	bus := eventbus.New()
+	bus.Close()
+	bus.Close()
+	if err := <-bus.PublishAsync("e", nil); err != eventbus.ErrClosed {
+		return fmt.Errorf("publish after close: %v", err)
+	}
+	var r recorder
+	bus.Subscribe("e", r.listener("a"))
+	if err := bus.Publish("e", nil); err != nil {
+		return err
+	}
+	return expect("sync publish after close", r.take(), "a")
+}
+
+func checkCloseBlocked() error {
+	bus := eventbus.New(eventbus.WithWorkers(1), eventbus.WithQueueSize(1))
+	release := make(chan struct{})
+	inner := make(chan (<-chan error), 1)
+	bus.Subscribe("slow", func(interface{}) {
+		<-release
+		// 处理函数中再次异步发布，此时 Close 已经开始
+		inner <- bus.PublishAsync("e", nil)
+	})
+	bus.Subscribe("e", func(interface{}) {})
+
+	// 唯一的工作 goroutine 被 slow 占用，queued 占满队列，blocked 阻塞在队列上
+	slow := bus.PublishAsync("slow", nil)
+	time.Sleep(10 * time.Millisecond)
+	queued := bus.PublishAsync("e", nil)
+	blocked := make(chan (<-chan error), 1)
+	go func() { blocked <- bus.PublishAsync("e", nil) }()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		bus.Close()
+		close(closed)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		return errors.New("Close deadlocked with blocked publishers")
+	}
+	results := []struct {
+		name string
+		done <-chan error
+		want error
+	}{
+		{"slow", slow, nil},
+		{"queued", queued, nil},
+		{"blocked", <-blocked, eventbus.ErrClosed},
+		{"re-entrant", <-inner, eventbus.ErrClosed},
+	}
+	for _, r := range results {
+		if err := <-r.done; err != r.want {
+			return fmt.Errorf("%s publish: got %v, want %v", r.name, err, r.want)
+		}
+	}
+	return nil
+}
+
+func checkConcurrent() error {
+	bus := eventbus.New(eventbus.WithWorkers(4))
+	topic := eventbus.NewTopic[int](bus, "n")
+	var total int64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				sub := topic.Subscribe(func(v int) { atomic.AddInt64(&total, int64(v)) }, eventbus.Priority(i%3))
+				topic.Publish(1)
+				topic.PublishAsync(0)
+				sub.Unsubscribe()
+				sub.Unsubscribe()
+			}
+		}()
+	}
+	wg.Wait()
+	bus.Close()
+
+	// 每次同步发布至少调用自己订阅的处理函数
+	if total < 8*200 {
+		return fmt.Errorf("total %d, want at least %d", total, 8*200)
+	}
+	if got := bus.Listeners("n"); got != 0 {
+		return fmt.Errorf("%d listeners left", got)
+	}
+	return nil
+}
+
+func main() {
+	failed := 0
+	for _, c := range checks {
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", len(checks))
+}
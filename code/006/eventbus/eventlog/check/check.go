@@ -0,0 +1,474 @@
+package main
+
+import (
+	"code-snippet/code/006/eventbus"
+	"code-snippet/code/006/eventbus/eventlog"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检查持久化的事件日志：
+//
+//	go run ./code/006/eventbus/eventlog/check
+
+var checks = []struct {
+	name string
+	run  func(dir string) error
+}{
+	{"append and read", checkAppend},
+	{"segment rotation and reopen", checkRotation},
+	{"recover from truncated tail", checkTruncated},
+	{"recover from corrupt tail", checkCorrupt},
+	{"replay from offset", checkReplay},
+	{"record bus events", checkBus},
+	{"consumer resumes after restart", checkConsumer},
+	{"consumer follows new events", checkTail},
+	{"concurrent appends", checkConcurrent},
+}
+
+// 读取全部事件，返回 "序号:主题:数据" 的列表
+func dump(l *eventlog.Log, from uint64) (string, error) {
+	var list []string
+	err := l.Read(from, func(e eventlog.Event) error {
+		list = append(list, fmt.Sprintf("%d:%s:%s", e.Seq, e.Topic, e.Data))
+		return nil
+	})
+	return strings.Join(list, " "), err
+}
+
+func expect(what, got, want string) error {
+	if got != want {
+		return fmt.Errorf("%s: got %q, want %q", what, got, want)
+	}
+	return nil
+}
+
+func checkAppend(dir string) error {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	l, err := eventlog.Open(dir, eventlog.WithClock(func() time.Time { return now }), eventlog.WithSync(true))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	e, err := l.Append("OnSkill", map[string]int{"damage": 30})
+	if err != nil {
+		return err
+	}
+	if e.Seq != 1 || !e.Time.Equal(now) {
+		return fmt.Errorf("first event %+v", e)
+	}
+	l.Append("OnSkill", "heal")
+	l.Append("OnLevel", nil)
+	if _, err := l.Append("bad", func() {}); err == nil {
+		return errors.New("appending an unencodable value succeeded")
+	}
+
+	got, err := dump(l, 0)
+	if err != nil {
+		return err
+	}
+	if err := expect("events", got, `1:OnSkill:{"damage":30} 2:OnSkill:"heal" 3:OnLevel:null`); err != nil {
+		return err
+	}
+
+	var first eventlog.Event
+	l.Read(1, func(e eventlog.Event) error {
+		first = e
+		return errors.New("stop")
+	})
+	var param struct{ Damage int }
+	if err := first.Decode(&param); err != nil || param.Damage != 30 || !first.Time.Equal(now) {
+		return fmt.Errorf("decoded %+v %v (%v)", param, first.Time, err)
+	}
+	if l.NextSeq() != 4 {
+		return fmt.Errorf("next seq %d", l.NextSeq())
+	}
+	return nil
+}
+
+func checkRotation(dir string) error {
+	l, err := eventlog.Open(dir, eventlog.WithSegmentSize(200))
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= 20; i++ {
+		if _, err := l.Append("n", i); err != nil {
+			return err
+		}
+	}
+	if l.Segments() < 5 {
+		return fmt.Errorf("only %d segments", l.Segments())
+	}
+	l.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	sort.Strings(files)
+	if filepath.Base(files[0]) != "00000000000000000001.log" {
+		return fmt.Errorf("first segment %s", files[0])
+	}
+
+	// 重新打开后序号连续，可以跨段读取
+	l, err = eventlog.Open(dir, eventlog.WithSegmentSize(200))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if e, err := l.Append("n", 21); err != nil || e.Seq != 21 {
+		return fmt.Errorf("append after reopen: %+v %v", e, err)
+	}
+	got, err := dump(l, 9)
+	if err != nil {
+		return err
+	}
+	var want []string
+	for i := 9; i <= 21; i++ {
+		want = append(want, fmt.Sprintf("%d:n:%d", i, i))
+	}
+	return expect("events from 9", got, strings.Join(want, " "))
+}
+
+// 在最后一条记录的每个字节处截断文件，重新打开后应该只剩前面完整的记录
+func checkTruncated(dir string) error {
+	l, err := eventlog.Open(dir)
+	if err != nil {
+		return err
+	}
+	l.Append("a", 1)
+	l.Append("b", 2)
+	l.Close()
+
+	path := filepath.Join(dir, "00000000000000000001.log")
+	full, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	first := len(full) - recordSize(full)
+	for cut := first; cut < len(full); cut++ {
+		if err := os.WriteFile(path, full[:cut], 0644); err != nil {
+			return err
+		}
+		l, err := eventlog.Open(dir)
+		if err != nil {
+			return fmt.Errorf("cut at %d: %v", cut, err)
+		}
+		e, err := l.Append("c", 3)
+		if err != nil {
+			return err
+		}
+		got, err := dump(l, 0)
+		l.Close()
+		if err != nil {
+			return fmt.Errorf("cut at %d: %v", cut, err)
+		}
+		if e.Seq != 2 || got != "1:a:1 2:c:3" {
+			return fmt.Errorf("cut at %d: seq %d, events %q", cut, e.Seq, got)
+		}
+	}
+	return nil
+}
+
+// 最后一条记录的长度，记录头部的前 4 个字节为数据长度
+func recordSize(data []byte) int {
+	offset := 0
+	for {
+		n := int(data[offset])<<24 | int(data[offset+1])<<16 | int(data[offset+2])<<8 | int(data[offset+3])
+		if offset+8+n >= len(data) {
+			return len(data) - offset
+		}
+		offset += 8 + n
+	}
+}
+
+func checkCorrupt(dir string) error {
+	l, err := eventlog.Open(dir)
+	if err != nil {
+		return err
+	}
+	l.Append("a", "first")
+	l.Append("b", "second")
+	l.Close()
+
+	// 修改最后一条记录中的一个字节，校验和不再匹配
+	path := filepath.Join(dir, "00000000000000000001.log")
+	data, _ := os.ReadFile(path)
+	data[len(data)-3] ^= 0xff
+	os.WriteFile(path, data, 0644)
+
+	l, err = eventlog.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	got, err := dump(l, 0)
+	if err != nil {
+		return err
+	}
+	return expect("events", got, `1:a:"first"`)
+}
+
+func checkReplay(dir string) error {
+	l, err := eventlog.Open(dir, eventlog.WithSegmentSize(100))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for i := 1; i <= 10; i++ {
+		topic := "odd"
+		if i%2 == 0 {
+			topic = "even"
+		}
+		l.Append(topic, i)
+	}
+
+	var got []string
+	next, err := l.Replay(5, []string{"even"}, func(e eventlog.Event) {
+		got = append(got, string(e.Data))
+	})
+	if err != nil {
+		return err
+	}
+	if next != 11 {
+		return fmt.Errorf("next offset %d, want 11", next)
+	}
+	if err := expect("replayed", strings.Join(got, ","), "6,8,10"); err != nil {
+		return err
+	}
+
+	got = nil
+	next, _ = l.Replay(11, nil, func(e eventlog.Event) { got = append(got, string(e.Data)) })
+	if next != 11 || len(got) != 0 {
+		return fmt.Errorf("replay at the end: next %d, %v", next, got)
+	}
+	return nil
+}
+
+type skill struct {
+	Name string
+}
+
+func checkBus(dir string) error {
+	l, err := eventlog.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	bus := eventbus.New(eventbus.WithRecorder(l))
+	topic := eventbus.NewTopic[skill](bus, "skill")
+	var live []string
+	topic.Subscribe(func(s skill) { live = append(live, s.Name) })
+
+	topic.Publish(skill{"fire"})
+	bus.Publish("skill", "wrong type")
+	if err := bus.Publish("other", func() {}); err == nil {
+		return errors.New("publishing an unencodable value succeeded")
+	}
+	<-topic.PublishAsync(skill{"ice"})
+	bus.Close()
+
+	if err := expect("live", strings.Join(live, ","), "fire,ice"); err != nil {
+		return err
+	}
+	got, err := dump(l, 0)
+	if err != nil {
+		return err
+	}
+	return expect("recorded", got, `1:skill:{"Name":"fire"} 2:skill:{"Name":"ice"}`)
+}
+
+func checkConsumer(dir string) error {
+	l, err := eventlog.Open(filepath.Join(dir, "log"))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for i := 1; i <= 5; i++ {
+		l.Append("n", i)
+	}
+	l.Append("skip", 0)
+	l.Append("n", 6)
+
+	offsetsPath := filepath.Join(dir, "offsets.json")
+	var got []string
+	// 每次处理 limit 个事件后停止，模拟进程重启
+	run := func(limit int) error {
+		offsets, err := eventlog.OpenOffsets(offsetsPath)
+		if err != nil {
+			return err
+		}
+		c := &eventlog.Consumer{Name: "sum", Topics: []string{"n"}, Log: l, Offsets: offsets}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		n := 0
+		err = c.Run(ctx, func(e eventlog.Event) error {
+			got = append(got, string(e.Data))
+			if n++; n == limit {
+				cancel()
+			}
+			return nil
+		})
+		if err != context.Canceled {
+			return fmt.Errorf("run returned %v", err)
+		}
+		return nil
+	}
+	if err := run(2); err != nil {
+		return err
+	}
+	if err := run(4); err != nil {
+		return err
+	}
+	if err := expect("handled", strings.Join(got, ","), "1,2,3,4,5,6"); err != nil {
+		return err
+	}
+
+	// 其他消费者从头开始
+	offsets, _ := eventlog.OpenOffsets(offsetsPath)
+	if offsets.Get("sum") != 8 || offsets.Get("other") != 1 {
+		return fmt.Errorf("offsets sum=%d other=%d", offsets.Get("sum"), offsets.Get("other"))
+	}
+
+	// 处理函数返回错误时停止，出错的事件没有确认，下次重新处理
+	c := &eventlog.Consumer{Name: "fail", Log: l, Offsets: offsets}
+	failure := errors.New("fail")
+	err = c.Run(context.Background(), func(e eventlog.Event) error {
+		if e.Seq == 3 {
+			return failure
+		}
+		return nil
+	})
+	if err != failure || offsets.Get("fail") != 3 {
+		return fmt.Errorf("failing consumer: %v, offset %d", err, offsets.Get("fail"))
+	}
+	return nil
+}
+
+func checkTail(dir string) error {
+	l, err := eventlog.Open(dir)
+	if err != nil {
+		return err
+	}
+	offsets, err := eventlog.OpenOffsets(filepath.Join(dir, "offsets.json"))
+	if err != nil {
+		return err
+	}
+	l.Append("n", 1)
+
+	c := &eventlog.Consumer{Name: "tail", Log: l, Offsets: offsets}
+	received := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(context.Background(), func(e eventlog.Event) error {
+			received <- string(e.Data)
+			return nil
+		})
+	}()
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case v := <-received:
+			if v != fmt.Sprint(i) {
+				return fmt.Errorf("received %s, want %d", v, i)
+			}
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("event %d not delivered", i)
+		}
+		l.Append("n", i+1)
+	}
+
+	// 关闭日志后消费者退出
+	l.Close()
+	select {
+	case err := <-done:
+		if err != eventlog.ErrClosed {
+			return fmt.Errorf("run returned %v after close", err)
+		}
+	case <-time.After(5 * time.Second):
+		return errors.New("consumer did not stop after close")
+	}
+	return nil
+}
+
+func checkConcurrent(dir string) error {
+	l, err := eventlog.Open(dir, eventlog.WithSegmentSize(1000))
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				l.Append(fmt.Sprint("g", g), i)
+				// 同时读取不会读到写了一半的记录
+				if err := l.Read(0, func(eventlog.Event) error { return nil }); err != nil {
+					panic(err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	l.Close()
+
+	l, err = eventlog.Open(dir, eventlog.WithSegmentSize(1000))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	var seqs []uint64
+	count := map[string]int{}
+	l.Read(0, func(e eventlog.Event) error {
+		seqs = append(seqs, e.Seq)
+		count[e.Topic]++
+		return nil
+	})
+	want := make([]uint64, 400)
+	for i := range want {
+		want[i] = uint64(i + 1)
+	}
+	if !reflect.DeepEqual(seqs, want) {
+		return fmt.Errorf("sequence numbers are not contiguous: %v", seqs)
+	}
+	for g := 0; g < 8; g++ {
+		if count[fmt.Sprint("g", g)] != 50 {
+			return fmt.Errorf("topic g%d has %d events", g, count[fmt.Sprint("g", g)])
+		}
+	}
+	return nil
+}
+
+func main() {
+	root, err := os.MkdirTemp("", "eventlog-check")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	defer os.RemoveAll(root)
+
+	failed := 0
+	for i, c := range checks {
+		if err := c.run(filepath.Join(root, fmt.Sprint(i))); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.RemoveAll(root)
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", len(checks))
+}
@@ -0,0 +1,117 @@
+package eventlog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 记录每个消费者下一个要处理的事件序号，保存在 JSON 文件中
+type Offsets struct {
+	path string
+
+	mu      sync.Mutex
+	offsets map[string]uint64
+}
+
+// 打开偏移量文件，文件不存在时所有消费者从头开始
+func OpenOffsets(path string) (*Offsets, error) {
+	o := &Offsets{path: path, offsets: map[string]uint64{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &o.offsets); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// 消费者下一个要处理的事件序号，没有记录时为 1
+func (o *Offsets) Get(name string) uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if next, ok := o.offsets[name]; ok {
+		return next
+	}
+	return 1
+}
+
+// 保存消费者下一个要处理的事件序号。先写临时文件再改名，崩溃时不会留下不完整的文件
+func (o *Offsets) Commit(name string, next uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.offsets[name] == next {
+		return nil
+	}
+	o.offsets[name] = next
+
+	data, err := json.MarshalIndent(o.offsets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), o.path)
+}
+
+// 持久的订阅者：从上次处理到的位置开始读取日志，读完已有的事件后等待新的事件
+type Consumer struct {
+	Name    string
+	Topics  []string // 只处理这些主题，为空时处理所有主题
+	Log     *Log
+	Offsets *Offsets
+}
+
+// 持续处理事件，直到 ctx 取消、日志关闭或者 fn 返回错误。
+// 每处理完一个事件就保存偏移量，重启后从下一个事件继续；
+// 处理完成但偏移量没有保存时会再处理一次，所以 fn 应该能够处理重复的事件
+func (c *Consumer) Run(ctx context.Context, fn func(Event) error) error {
+	next := c.Offsets.Get(c.Name)
+	for {
+		// 先取得通知通道，避免错过读取之后追加的事件
+		changed := c.Log.wait()
+
+		err := c.Log.Read(next, func(e Event) error {
+			if matchTopic(c.Topics, e.Topic) {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			next = e.Seq + 1
+			if err := c.Offsets.Commit(c.Name, next); err != nil {
+				return err
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志已经关闭
+var ErrClosed = errors.New("eventlog: log closed")
+
+// 日志中的一个事件
+type Event struct {
+	Seq   uint64          `json:"seq"` // 序号，从 1 开始连续递增，作为读取的偏移量
+	Time  time.Time       `json:"time"`
+	Topic string          `json:"topic"`
+	Data  json.RawMessage `json:"data"` // 事件参数编码后的 JSON
+}
+
+// 把事件参数解码到 v
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// 日志文件损坏
+type CorruptError struct {
+	File   string
+	Offset int64
+	Msg    string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("eventlog: %s: corrupt record at offset %d: %s", e.File, e.Offset, e.Msg)
+}
+
+// 每条记录的头部：数据长度和 CRC-32C 校验和，都是大端序的 uint32
+const headerSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 段文件的扩展名，文件名为段中第一个事件的序号
+const segmentExt = ".log"
+
+// 持久化的事件日志。事件追加到目录中的段文件，当前段超过大小限制后新建一个段。
+// 实现了 eventbus.Recorder，可以记录总线上发布的所有事件
+type Log struct {
+	dir         string
+	segmentSize int64
+	sync        bool
+	now         func() time.Time
+
+	mu       sync.Mutex
+	segments []segment // 按序号排列，最后一个为正在写入的段
+	file     *os.File  // 正在写入的段
+	nextSeq  uint64
+	changed  chan struct{} // 追加事件时关闭并替换，用于通知等待的消费者
+	closed   bool
+}
+
+// 段文件
+type segment struct {
+	base uint64 // 第一个事件的序号
+	path string
+	size int64
+}
+
+// 打开日志的选项
+type Option func(*Log)
+
+// 段文件的大小限制，默认为 64MB
+func WithSegmentSize(n int64) Option {
+	return func(l *Log) {
+		if n > 0 {
+			l.segmentSize = n
+		}
+	}
+}
+
+// 每次追加后调用 fsync，断电也不会丢失已经返回的事件。默认只在切换段和关闭时同步
+func WithSync(sync bool) Option {
+	return func(l *Log) {
+		l.sync = sync
+	}
+}
+
+// 事件时间的来源，默认为 time.Now
+func WithClock(now func() time.Time) Option {
+	return func(l *Log) {
+		l.now = now
+	}
+}
+
+// 打开目录中的日志，目录不存在时创建。
+// 最后一个段末尾不完整或校验失败的记录（例如写入时进程崩溃）会被截掉
+func Open(dir string, opts ...Option) (*Log, error) {
+	l := &Log{
+		dir:         dir,
+		segmentSize: 64 << 20,
+		now:         time.Now,
+		nextSeq:     1,
+		changed:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := l.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	if len(l.segments) == 0 {
+		if err := l.newSegment(); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+
+	last := &l.segments[len(l.segments)-1]
+	end, next, err := recoverSegment(last.path, last.base)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Truncate(last.path, end); err != nil {
+		return nil, err
+	}
+	last.size = end
+	l.nextSeq = next
+
+	l.file, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// 列出目录中的段文件
+func (l *Log) loadSegments() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, segment{base, filepath.Join(l.dir, name), info.Size()})
+	}
+	sort.Slice(l.segments, func(i, j int) bool {
+		return l.segments[i].base < l.segments[j].base
+	})
+	return nil
+}
+
+// 扫描段文件，返回最后一条完整记录的结束位置和下一个序号
+func recoverSegment(path string, base uint64) (int64, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r := newReader(f, path)
+	next := base
+	for {
+		e, err := r.next()
+		if err == io.EOF {
+			return r.offset, next, nil
+		}
+		var corrupt *CorruptError
+		if errors.As(err, &corrupt) || err == io.ErrUnexpectedEOF {
+			// 写入中断留下的半条记录
+			return r.offset, next, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if e.Seq != next {
+			return r.offset, next, nil
+		}
+		next++
+		r.commit()
+	}
+}
+
+// 新建一个段，第一个事件的序号为 nextSeq。调用时需要持有锁
+func (l *Log) newSegment() error {
+	if l.file != nil {
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+		l.file = nil
+	}
+
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.nextSeq, segmentExt))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	l.segments = append(l.segments, segment{base: l.nextSeq, path: path})
+	return syncDir(l.dir)
+}
+
+// 同步目录，确保新建的文件在断电后仍然存在
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// 追加事件，param 编码为 JSON
+func (l *Log) Append(topic string, param interface{}) (Event, error) {
+	data, err := json.Marshal(param)
+	if err != nil {
+		return Event{}, fmt.Errorf("eventlog: encode %q: %v", topic, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return Event{}, ErrClosed
+	}
+
+	e := Event{Seq: l.nextSeq, Time: l.now(), Topic: topic, Data: data}
+	record, err := json.Marshal(&e)
+	if err != nil {
+		return Event{}, err
+	}
+	buf := make([]byte, headerSize+len(record))
+	binary.BigEndian.PutUint32(buf, uint32(len(record)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(record, crcTable))
+	copy(buf[headerSize:], record)
+
+	active := &l.segments[len(l.segments)-1]
+	if active.size > 0 && active.size+int64(len(buf)) > l.segmentSize {
+		if err := l.newSegment(); err != nil {
+			return Event{}, err
+		}
+		active = &l.segments[len(l.segments)-1]
+	}
+
+	// 一次写入整条记录，失败时截掉可能写入的部分
+	if _, err := l.file.Write(buf); err != nil {
+		l.file.Truncate(active.size)
+		return Event{}, err
+	}
+	if l.sync {
+		if err := l.file.Sync(); err != nil {
+			return Event{}, err
+		}
+	}
+	active.size += int64(len(buf))
+	l.nextSeq++
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return e, nil
+}
+
+// 实现 eventbus.Recorder
+func (l *Log) Record(topic string, param interface{}) error {
+	_, err := l.Append(topic, param)
+	return err
+}
+
+// 下一个事件的序号
+func (l *Log) NextSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextSeq
+}
+
+// 段文件的数量
+func (l *Log) Segments() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.segments)
+}
+
+// 按顺序读取序号不小于 from 的事件，只包含调用时已经追加的事件。fn 返回错误时停止读取并返回该错误
+func (l *Log) Read(from uint64, fn func(Event) error) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	segments := append([]segment(nil), l.segments...)
+	end := l.nextSeq
+	l.mu.Unlock()
+
+	// 从包含 from 的段开始读
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].base > from
+	})
+	if i > 0 {
+		i--
+	}
+	for ; i < len(segments); i++ {
+		if segments[i].base >= end {
+			break
+		}
+		if err := readSegment(segments[i], from, end, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 读取一个段中 [from, end) 范围内的事件
+func readSegment(seg segment, from, end uint64, fn func(Event) error) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// 只读到复制段信息时的大小，不会读到正在写入的记录
+	r := newReader(io.LimitReader(f, seg.size), seg.path)
+	for {
+		e, err := r.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return &CorruptError{seg.path, r.offset, "truncated record"}
+		}
+		if err != nil {
+			return err
+		}
+		r.commit()
+		if e.Seq >= end {
+			return nil
+		}
+		if e.Seq < from {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// 把序号不小于 from 的已有事件依次交给 fn，返回下一个未读取的序号。
+// 用于让新的订阅者补上之前的事件，topics 不为空时只重放这些主题
+func (l *Log) Replay(from uint64, topics []string, fn func(Event)) (uint64, error) {
+	next := from
+	err := l.Read(from, func(e Event) error {
+		if matchTopic(topics, e.Topic) {
+			fn(e)
+		}
+		next = e.Seq + 1
+		return nil
+	})
+	return next, err
+}
+
+func matchTopic(topics []string, topic string) bool {
+	if len(topics) == 0 {
+		return true
+	}
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回一个通道，下一次追加事件或关闭日志时被关闭
+func (l *Log) wait() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+// 关闭日志
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.changed)
+
+	if l.file == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// 逐条读取记录
+type reader struct {
+	r      *bufio.Reader
+	path   string
+	offset int64 // 已经确认的最后一条记录的结束位置
+	length int64 // 当前记录的长度
+}
+
+func newReader(r io.Reader, path string) *reader {
+	return &reader{r: bufio.NewReader(r), path: path}
+}
+
+// 读取下一条记录。文件在记录边界结束时返回 io.EOF，在记录中间结束时返回 io.ErrUnexpectedEOF
+func (r *reader) next() (Event, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return Event{}, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	sum := binary.BigEndian.Uint32(header[4:])
+
+	// 长度字段本身可能已经损坏，先按块读取，避免分配过大的内存
+	record := make([]byte, 0, min(int(n), 1<<20))
+	if _, err := io.CopyN(byteWriter{&record}, r.r, int64(n)); err != nil {
+		if err == io.EOF {
+			return Event{}, io.ErrUnexpectedEOF
+		}
+		return Event{}, err
+	}
+	r.length = headerSize + int64(n)
+
+	if crc32.Checksum(record, crcTable) != sum {
+		return Event{}, &CorruptError{r.path, r.offset, "checksum mismatch"}
+	}
+	var e Event
+	if err := json.Unmarshal(record, &e); err != nil {
+		return Event{}, &CorruptError{r.path, r.offset, err.Error()}
+	}
+	return e, nil
+}
+
+// 确认读取的记录，移动到下一条记录的开头
+func (r *reader) commit() {
+	r.offset += r.length
+}
+
+// 追加到字节切片
+type byteWriter struct {
+	b *[]byte
+}
+
+func (w byteWriter) Write(p []byte) (int, error) {
+	*w.b = append(*w.b, p...)
+	return len(p), nil
+}
@@ -0,0 +1,40 @@
+package eventbus
+
+import "reflect"
+
+// 带有参数类型的主题，处理函数直接接收 T 而不是 interface{}
+type Topic[T any] struct {
+	bus  *Bus
+	name string
+}
+
+// 在总线上声明主题。同一个名字只能声明为一种类型，否则 panic。
+// 声明后通过 Bus.Publish 发布其他类型的参数会返回 *TypeError
+func NewTopic[T any](b *Bus, name string) Topic[T] {
+	b.declare(name, reflect.TypeOf((*T)(nil)).Elem())
+	return Topic[T]{bus: b, name: name}
+}
+
+// 主题名
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// 订阅事件
+func (t Topic[T]) Subscribe(fn func(T), opts ...SubscribeOption) *Subscription {
+	return t.bus.Subscribe(t.name, func(param interface{}) {
+		// 参数为 nil 时得到零值
+		v, _ := param.(T)
+		fn(v)
+	}, opts...)
+}
+
+// 同步发布事件
+func (t Topic[T]) Publish(v T) error {
+	return t.bus.Publish(t.name, v)
+}
+
+// 异步发布事件
+func (t Topic[T]) PublishAsync(v T) <-chan error {
+	return t.bus.PublishAsync(t.name, v)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"code-snippet/code/006/jsoncodec"
+	"code-snippet/code/006/jsoncodec/sample"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// 对比反射编码、jsongen 生成的代码和 encoding/json，先检查输出相同，再运行基准测试：
+//
+//	GOEXPERIMENT=nojsonv2 go run ./code/006/jsoncodec/bench
+//
+// 生成的方法会被反射编码和 encoding/json 调用，所以对照组使用字段和标签相同、但没有方法的镜像类型。
+// 默认启用 jsonv2 实验时跳过与 encoding/json 的对比，只运行基准测试
+
+var benchmark = flag.Bool("bench", true, "运行基准测试")
+
+// 与 sample.Skill 相同但没有方法
+type plainSkill struct {
+	Name  string       `json:"name"`
+	Level sample.Level `json:"level,string"`
+}
+
+// 与 sample.Actor 相同但没有方法
+type plainActor struct {
+	Name string `json:"name"`
+	Age  int    `json:"age,omitempty"`
+
+	Skills []plainSkill `json:"skills"`
+
+	Born     time.Time         `json:"born"`
+	Nickname *string           `json:"nickname"`
+	Extra    map[string]string `json:"extra,omitempty"`
+	Score    float64           `json:"score"`
+	Weapons  [2]string         `json:"weapons"`
+	Avatar   []byte            `json:"avatar,omitempty"`
+	Friends  []*plainActor     `json:"friends,omitempty"`
+}
+
+// 与 sample.Kinds 相同但没有方法
+type plainKinds struct {
+	Int8     int8                   `json:",omitempty"`
+	Uint     uint                   `json:"uint,omitempty"`
+	Float32  float32                `json:"f32"`
+	Bool     bool                   `json:"bool,omitempty"`
+	Rank     sample.Rank            `json:"rank"`
+	Tags     sample.Tags            `json:"tags"`
+	Levels   []sample.Level         `json:"levels"`
+	QuotedP  *float64               `json:"qp,string"`
+	QuotedS  string                 `json:"qs,string"`
+	QuotedB  bool                   `json:"qb,string"`
+	Any      interface{}            `json:"any"`
+	Grade    sample.Grade           `json:"grade"`
+	ByName   map[string]*plainSkill `json:"by_name"`
+	Matrix   [][]int                `json:"matrix"`
+	ByID     map[int]string         `json:"by_id"`
+	Duration time.Duration          `json:"duration"`
+	Dash     string                 `json:"-,"`
+	Skip     string                 `json:"-"`
+	HTML     string                 `json:"<html>"`
+	Title    string
+	Label    string      `json:"Title"`
+	Next     *plainKinds `json:"next,omitempty"`
+}
+
+func plainSkills(skills []sample.Skill) []plainSkill {
+	if skills == nil {
+		return nil
+	}
+	result := make([]plainSkill, len(skills))
+	for i, s := range skills {
+		result[i] = plainSkill(s)
+	}
+	return result
+}
+
+func plainActorOf(a *sample.Actor) *plainActor {
+	if a == nil {
+		return nil
+	}
+	p := &plainActor{
+		Name:     a.Name,
+		Age:      a.Age,
+		Skills:   plainSkills(a.Skills),
+		Born:     a.Born,
+		Nickname: a.Nickname,
+		Extra:    a.Extra,
+		Score:    a.Score,
+		Weapons:  a.Weapons,
+		Avatar:   a.Avatar,
+	}
+	if a.Friends != nil {
+		p.Friends = make([]*plainActor, len(a.Friends))
+		for i, f := range a.Friends {
+			p.Friends[i] = plainActorOf(f)
+		}
+	}
+	return p
+}
+
+func plainKindsOf(k *sample.Kinds) *plainKinds {
+	if k == nil {
+		return nil
+	}
+	p := &plainKinds{
+		Int8: k.Int8, Uint: k.Uint, Float32: k.Float32, Bool: k.Bool, Rank: k.Rank, Tags: k.Tags, Levels: k.Levels,
+		QuotedP: k.QuotedP, QuotedS: k.QuotedS, QuotedB: k.QuotedB, Any: k.Any, Grade: k.Grade,
+		Matrix: k.Matrix, ByID: k.ByID, Duration: k.Duration, Dash: k.Dash, Skip: k.Skip, HTML: k.HTML,
+		Title: k.Title, Label: k.Label, Next: plainKindsOf(k.Next),
+	}
+	if k.ByName != nil {
+		p.ByName = map[string]*plainSkill{}
+		for name, s := range k.ByName {
+			if s != nil {
+				p.ByName[name] = &plainSkill{s.Name, s.Level}
+			} else {
+				p.ByName[name] = nil
+			}
+		}
+	}
+	return p
+}
+
+// 编码测试用例：生成的类型和对应的镜像
+type encodeCase struct {
+	name      string
+	generated json.Marshaler
+	plain     interface{}
+}
+
+// 解码测试用例：同一段输入分别解码到生成的类型和镜像类型
+type decodeCase struct {
+	name  string
+	input string
+}
+
+func main() {
+	flag.Parse()
+
+	// 基于 jsonv2 的实现把非法 UTF-8 直接替换为 U+FFFD 字符，原有实现输出 \ufffd
+	v1, _ := json.Marshal("\xff")
+	compareStd := bytes.Equal(v1, []byte(`"\ufffd"`))
+	if !compareStd {
+		fmt.Println("encoding/json is built with GOEXPERIMENT=jsonv2, skip comparing with it")
+	}
+
+	nick := "cb"
+	half := 0.5
+	actor := newActor()
+	actor.Nickname = &nick
+	actor.Extra = map[string]string{"hat": "yes", "<b>": "a&b", "": "empty"}
+	actor.Avatar = []byte("\x89PNG\r\n")
+	actor.Friends = []*sample.Actor{{Name: "Jet", Skills: []sample.Skill{}}, nil}
+	kinds := &sample.Kinds{
+		Int8: -8, Uint: 8, Float32: 1e-7, Bool: true, Rank: 255,
+		Tags: sample.Tags{"a", "b "}, Levels: []sample.Level{1, -2},
+		QuotedP: &half, QuotedS: `say "hi"`, QuotedB: true,
+		Any:    map[string]interface{}{"x": []interface{}{1, "two", nil}},
+		Grade:  3,
+		ByName: map[string]*sample.Skill{"roll": {Name: "Roll", Level: 1}, "none": nil},
+		Matrix: [][]int{{1, 2}, nil, {}},
+		ByID:   map[int]string{2: "b", 10: "j"},
+
+		Duration: time.Minute, Dash: "-", Skip: "skip", HTML: "<html>", Title: "t", Label: "l",
+		Next: &sample.Kinds{Float32: 1e21, Uint: 1},
+	}
+	invalid := &sample.Kinds{QuotedP: new(float64)}
+	*invalid.QuotedP = math.Inf(1)
+
+	// 朋友中包含自己，以及自己作为下一个
+	cyclicActor, plainCyclicActor := newActor(), plainActorOf(newActor())
+	cyclicActor.Friends = []*sample.Actor{{Name: "Jet"}, cyclicActor}
+	plainCyclicActor.Friends = []*plainActor{plainActorOf(&sample.Actor{Name: "Jet"}), plainCyclicActor}
+	cyclicKinds, plainCyclicKinds := &sample.Kinds{Uint: 1}, &plainKinds{Uint: 1}
+	cyclicKinds.Next, plainCyclicKinds.Next = cyclicKinds, plainCyclicKinds
+	jet := &sample.Actor{Name: "Jet"}
+	shared := &sample.Actor{Name: "Spike", Friends: []*sample.Actor{jet, jet}}
+
+	encodeCases := []encodeCase{
+		{"actor", actor, plainActorOf(actor)},
+		{"sample actor", newActor(), plainActorOf(newActor())},
+		{"zero actor", sample.Actor{}, plainActorOf(&sample.Actor{})},
+		{"skill", sample.Skill{Name: "<script>", Level: -1}, plainSkill{"<script>", -1}},
+		{"kinds", kinds, plainKindsOf(kinds)},
+		{"zero kinds", sample.Kinds{}, plainKindsOf(&sample.Kinds{})},
+		{"invalid float", invalid, plainKindsOf(invalid)},
+		{"cyclic actor", cyclicActor, plainCyclicActor},
+		{"cyclic kinds", cyclicKinds, plainCyclicKinds},
+		{"shared friend is not a cycle", shared, plainActorOf(shared)},
+	}
+
+	failed, total := 0, 0
+	check := func(name string, err error) {
+		total++
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", name, err)
+			return
+		}
+		fmt.Printf("PASS  %s\n", name)
+	}
+
+	for _, c := range encodeCases {
+		got, gotErr := c.generated.MarshalJSON()
+		want, wantErr := jsoncodec.MarshalJSON(c.plain)
+		check("encode "+c.name, sameOutput(got, gotErr, want, wantErr))
+		if compareStd {
+			want, wantErr = json.Marshal(c.plain)
+			check("encode "+c.name+" vs encoding/json", sameOutput(got, gotErr, want, wantErr))
+		}
+	}
+
+	actorJSON, _ := actor.MarshalJSON()
+	kindsJSON, _ := kinds.MarshalJSON()
+	decodeCases := []decodeCase{
+		{"actor output", string(actorJSON)},
+		{"kinds output", string(kindsJSON)},
+		{"case insensitive keys", `{"NAME":"x","Skills":[{"Name":"a","LEVEL":"2"}],"unknown":{"a":[1,2]}}`},
+		{"nulls", `{"name":null,"skills":null,"nickname":null,"extra":null,"weapons":null,"friends":[null]}`},
+		{"extra array elements", `{"weapons":["a","b","c"],"skills":[]}`},
+		{"short array", `{"weapons":["a"]}`},
+		{"null", `null`},
+		{"type error", `{"name":"x",` + "\n" + `"age":"37"}`},
+		{"overflow", `{"skills":[{"level":"99999999999999999999"}]}`},
+		{"nested type error", `{"friends":[{"skills":[{"name":1}]}]}`},
+		{"syntax error", `{"name":"x",}`},
+		{"trailing data", `{} {}`},
+		{"truncated", `{"name":"x"`},
+	}
+	for _, c := range decodeCases {
+		var got sample.Actor
+		var want plainActor
+		gotErr := got.UnmarshalJSON([]byte(c.input))
+		wantErr := jsoncodec.UnmarshalJSON([]byte(c.input), &want)
+		check("decode "+c.name, sameDecode(&got, gotErr, &want, wantErr))
+	}
+	kindsInputs := []decodeCase{
+		{"kinds output", string(kindsJSON)},
+		{"kinds fallbacks", `{"grade":"G7","duration":5,"by_id":{"3":"c"},"any":[true],"by_name":{"x":{"name":"y"}}}`},
+		{"kinds quoted", `{"qp":"1.5","qs":"\"a\"","qb":"false","int8":"-3"}`},
+		{"kinds int8 overflow", `{"Int8":300}`},
+		{"kinds quoted leading zero", `{"qp":"07.5"}`},
+		{"kinds quoted inner null", `{"qp":"null","qs":"null","qb":"null"}`},
+		{"kinds quoted spaces", `{"qp":" 1.5"}`},
+		{"kinds quoted trailing space", `{"qb":"true "}`},
+		{"kinds quoted string spaces", `{"qs":"\"a\" "}`},
+		{"kinds quoted exponent", `{"qp":"1e"}`},
+		{"skill quoted leading zero", `{"skills":[{"level":"07"}]}`},
+	}
+	for _, c := range kindsInputs {
+		var got sample.Kinds
+		var want plainKinds
+		gotErr := got.UnmarshalJSON([]byte(c.input))
+		wantErr := jsoncodec.UnmarshalJSON([]byte(c.input), &want)
+		check("decode "+c.name, sameDecode(&got, gotErr, &want, wantErr))
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, total)
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", total)
+
+	if *benchmark {
+		runBenchmarks(actor)
+	}
+}
+
+// 基准测试使用的角色数据
+func newActor() *sample.Actor {
+	return &sample.Actor{
+		Name: "cow boy",
+		Age:  37,
+		Skills: []sample.Skill{
+			{Name: "Roll and roll", Level: 1},
+			{Name: "Flash your dog eye", Level: 2},
+			{Name: "Time to have Lunch", Level: 3},
+		},
+		Born:    time.Date(1982, 5, 1, 0, 0, 0, 0, time.UTC),
+		Score:   98.5,
+		Weapons: [2]string{"gun", "lasso"},
+	}
+}
+
+// 两者都返回错误并且同为或同不为 UnsupportedValueError，或者输出完全相同
+func sameOutput(got []byte, gotErr error, want []byte, wantErr error) error {
+	switch {
+	case gotErr != nil && wantErr != nil:
+		if isUnsupported(gotErr) != isUnsupported(wantErr) {
+			return fmt.Errorf("got error %q, want %q", gotErr, wantErr)
+		}
+		return nil
+	case wantErr != nil:
+		return fmt.Errorf("got %s, want error %q", got, wantErr)
+	case gotErr != nil:
+		return fmt.Errorf("got error %q, want %s", gotErr, want)
+	case !bytes.Equal(got, want):
+		return fmt.Errorf("\n  got  %s\n  want %s", got, want)
+	}
+	return nil
+}
+
+// NaN、无穷大和循环引用
+func isUnsupported(err error) bool {
+	return errors.As(err, new(*jsoncodec.UnsupportedValueError)) || errors.As(err, new(*json.UnsupportedValueError))
+}
+
+// 两者都返回错误且位置相同，或者解码结果重新编码后相同
+func sameDecode(got json.Marshaler, gotErr error, want interface{}, wantErr error) error {
+	switch {
+	case gotErr != nil && wantErr != nil:
+		gotLine, gotColumn := position(gotErr)
+		wantLine, wantColumn := position(wantErr)
+		if gotLine != wantLine || gotColumn != wantColumn {
+			return fmt.Errorf("error at %d:%d, want %d:%d\n  got  %s\n  want %s", gotLine, gotColumn, wantLine, wantColumn, gotErr, wantErr)
+		}
+		return nil
+	case wantErr != nil:
+		return fmt.Errorf("got no error, want %q", wantErr)
+	case gotErr != nil:
+		return fmt.Errorf("got error %q", gotErr)
+	}
+	gotJSON, gotErr := got.MarshalJSON()
+	wantJSON, wantErr := jsoncodec.MarshalJSON(want)
+	return sameOutput(gotJSON, gotErr, wantJSON, wantErr)
+}
+
+// 错误的行号和列号
+func position(err error) (int, int) {
+	var typeErr *jsoncodec.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Line, typeErr.Column
+	}
+	var syntaxErr *jsoncodec.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr.Line, syntaxErr.Column
+	}
+	return 0, 0
+}
+
+// 分别测试编码和解码的耗时与内存分配
+func runBenchmarks(actor *sample.Actor) {
+	plain := plainActorOf(actor)
+	data, _ := actor.MarshalJSON()
+
+	benchmarks := []struct {
+		name string
+		fn   func() error
+	}{
+		{"encode reflect", func() error { _, err := jsoncodec.MarshalJSON(plain); return err }},
+		{"encode generated", func() error { _, err := actor.MarshalJSON(); return err }},
+		{"encode encoding/json", func() error { _, err := json.Marshal(plain); return err }},
+		{"decode reflect", func() error { var a plainActor; return jsoncodec.UnmarshalJSON(data, &a) }},
+		{"decode generated", func() error { var a sample.Actor; return a.UnmarshalJSON(data) }},
+		{"decode encoding/json", func() error { var a plainActor; return json.Unmarshal(data, &a) }},
+	}
+
+	fmt.Printf("\n%d bytes of JSON\n", len(data))
+	for _, bm := range benchmarks {
+		result := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := bm.fn(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		fmt.Printf("%-22s %s %s\n", bm.name, result.String(), result.MemString())
+	}
+}
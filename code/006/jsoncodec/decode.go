@@ -0,0 +1,740 @@
+package jsoncodec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	unmarshalerType     = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// 解码目标不是非 nil 指针
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "jsoncodec: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "jsoncodec: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "jsoncodec: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// JSON 值无法转换为目标类型
+type UnmarshalTypeError struct {
+	Value  string       // JSON 值的描述，例如 string、number 1.5
+	Type   reflect.Type // 目标类型
+	Field  string       // 出错的结构体字段路径，例如 skills.level
+	Line   int
+	Column int
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("jsoncodec: cannot unmarshal %s into Go struct field %s of type %s at line %d, column %d",
+			e.Value, e.Field, e.Type, e.Line, e.Column)
+	}
+	return fmt.Sprintf("jsoncodec: cannot unmarshal %s into Go value of type %s at line %d, column %d",
+		e.Value, e.Type, e.Line, e.Column)
+}
+
+// 开启 DisallowUnknownFields 后遇到结构体中不存在的字段
+type UnknownFieldError struct {
+	Name   string
+	Type   reflect.Type
+	Line   int
+	Column int
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("jsoncodec: unknown field %q in %s at line %d, column %d", e.Name, e.Type, e.Line, e.Column)
+}
+
+// 从输入流中依次解码 JSON 值
+type Decoder struct {
+	tokenizer *Tokenizer
+
+	useNumber             bool
+	disallowUnknownFields bool
+
+	// 当前所在的结构体字段路径，用于错误信息
+	fieldStack []string
+}
+
+// 创建解码器
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{tokenizer: NewTokenizer(r)}
+}
+
+// 解码到 interface{} 的数字使用 json.Number 而不是 float64，避免丢失精度
+func (d *Decoder) UseNumber() {
+	d.useNumber = true
+}
+
+// 结构体中不存在的字段返回 UnknownFieldError 而不是忽略
+func (d *Decoder) DisallowUnknownFields() {
+	d.disallowUnknownFields = true
+}
+
+// 读取下一个 JSON 值并解码到 v 指向的变量，没有更多值时返回 io.EOF
+func (d *Decoder) Decode(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+
+	if _, err := d.tokenizer.PeekKind(); err != nil {
+		return err
+	}
+	d.fieldStack = d.fieldStack[:0]
+	return d.readValue(value)
+}
+
+// 把 data 解码到 v 指向的变量，data 必须是一个完整的 JSON 值。
+// 先检查语法，语法错误时不修改 v
+func UnmarshalJSON(data []byte, v interface{}) error {
+	check := NewTokenizer(bytes.NewReader(data))
+	if err := check.Skip(); err != nil {
+		if err == io.EOF {
+			return check.eofError()
+		}
+		return err
+	}
+	if err := check.End(); err != nil {
+		return err
+	}
+
+	return NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// 读取一个值并解码到 value
+func (d *Decoder) readValue(value reflect.Value) error {
+	kind, err := d.tokenizer.PeekKind()
+	if err != nil {
+		return d.unexpectedEOF(err)
+	}
+
+	// null 把指针、接口、映射和切片设为 nil，其他类型保持不变
+	if kind == Null {
+		// Decode 传入的指针本身不可设置，null 作用于它指向的变量
+		for value.Kind() == reflect.Ptr && !value.CanSet() && !value.IsNil() {
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.Ptr && value.CanAddr() && reflect.PtrTo(value.Type()).Implements(unmarshalerType) {
+			raw, err := d.tokenizer.RawValue()
+			if err != nil {
+				return err
+			}
+			return value.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(raw)
+		}
+		if _, err = d.tokenizer.Next(); err != nil {
+			return err
+		}
+		switch value.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			value.Set(reflect.Zero(value.Type()))
+		}
+		return nil
+	}
+
+	// 自定义解码
+	u, tu, value := indirect(value)
+	if u != nil {
+		raw, err := d.tokenizer.RawValue()
+		if err != nil {
+			return err
+		}
+		return u.UnmarshalJSON(raw)
+	}
+
+	token, err := d.tokenizer.Next()
+	if err != nil {
+		return d.unexpectedEOF(err)
+	}
+
+	if tu != nil {
+		if token.Kind != String {
+			if err = d.skipRest(token); err != nil {
+				return err
+			}
+			return d.typeError(describe(token), value.Type(), token)
+		}
+		return tu.UnmarshalText([]byte(token.Value))
+	}
+
+	switch token.Kind {
+	case ObjectStart:
+		return d.readObject(value, token)
+	case ArrayStart:
+		return d.readArray(value, token)
+	}
+	return d.storeLiteral(value, token)
+}
+
+// 沿指针向下找到实际存放值的变量，nil 指针分配新值。
+// 途中遇到实现了 json.Unmarshaler 或 encoding.TextUnmarshaler 的类型时返回该接口
+func indirect(value reflect.Value) (json.Unmarshaler, encoding.TextUnmarshaler, reflect.Value) {
+	// 可寻址的命名类型可能在指针接收者上实现了接口
+	if value.Kind() != reflect.Ptr && value.Type().Name() != "" && value.CanAddr() {
+		value = value.Addr()
+	}
+
+	for {
+		// 接口中保存的非 nil 指针直接解码到指向的值
+		if value.Kind() == reflect.Interface && !value.IsNil() {
+			elem := value.Elem()
+			if elem.Kind() == reflect.Ptr && !elem.IsNil() {
+				value = elem
+				continue
+			}
+		}
+
+		if value.Kind() != reflect.Ptr {
+			break
+		}
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		if value.Type().NumMethod() > 0 && value.CanInterface() {
+			if u, ok := value.Interface().(json.Unmarshaler); ok {
+				return u, nil, reflect.Value{}
+			}
+			if tu, ok := value.Interface().(encoding.TextUnmarshaler); ok {
+				return nil, tu, value.Elem()
+			}
+		}
+		value = value.Elem()
+	}
+	return nil, nil, value
+}
+
+// 解码对象，{ 已经读取
+func (d *Decoder) readObject(value reflect.Value, start Token) error {
+	switch {
+	case value.Kind() == reflect.Interface && value.NumMethod() == 0:
+		object, err := d.objectInterface()
+		if err != nil {
+			return err
+		}
+		value.Set(reflect.ValueOf(object))
+		return nil
+	case value.Kind() == reflect.Map:
+		return d.readMap(value, start)
+	case value.Kind() == reflect.Struct:
+		return d.readStruct(value)
+	}
+
+	if err := d.skipRest(start); err != nil {
+		return err
+	}
+	return d.typeError("object", value.Type(), start)
+}
+
+// 解码对象到映射，键的类型可以是字符串、整数或实现了 encoding.TextUnmarshaler 的类型
+func (d *Decoder) readMap(value reflect.Value, start Token) error {
+	mapType := value.Type()
+	keyType := mapType.Key()
+	switch keyType.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		if !reflect.PtrTo(keyType).Implements(textUnmarshalerType) {
+			if err := d.skipRest(start); err != nil {
+				return err
+			}
+			return d.typeError("object", mapType, start)
+		}
+	}
+
+	if value.IsNil() {
+		value.Set(reflect.MakeMap(mapType))
+	}
+
+	for {
+		token, err := d.tokenizer.Next()
+		if err != nil {
+			return d.unexpectedEOF(err)
+		}
+		if token.Kind == ObjectEnd {
+			return nil
+		}
+
+		key, err := d.mapKey(keyType, token)
+		if err != nil {
+			return err
+		}
+
+		// 每个值都解码到新的零值中
+		elem := reflect.New(mapType.Elem()).Elem()
+		if err = d.readValue(elem); err != nil {
+			return err
+		}
+		value.SetMapIndex(key, elem)
+	}
+}
+
+// 把对象的键转换为映射的键类型：优先使用 UnmarshalText，其次是字符串类型，最后是整数
+func (d *Decoder) mapKey(keyType reflect.Type, token Token) (reflect.Value, error) {
+	if reflect.PtrTo(keyType).Implements(textUnmarshalerType) {
+		key := reflect.New(keyType)
+		if err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(token.Value)); err != nil {
+			return reflect.Value{}, err
+		}
+		return key.Elem(), nil
+	}
+
+	if keyType.Kind() == reflect.String {
+		return reflect.ValueOf(token.Value).Convert(keyType), nil
+	}
+
+	key := reflect.New(keyType).Elem()
+	switch keyType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(token.Value, 10, 64)
+		if err != nil || key.OverflowInt(n) {
+			return reflect.Value{}, d.typeError("number "+token.Value, keyType, token)
+		}
+		key.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(token.Value, 10, 64)
+		if err != nil || key.OverflowUint(n) {
+			return reflect.Value{}, d.typeError("number "+token.Value, keyType, token)
+		}
+		key.SetUint(n)
+	}
+	return key, nil
+}
+
+// 解码对象到结构体，字段名先精确匹配，再忽略大小写匹配
+func (d *Decoder) readStruct(value reflect.Value) error {
+	fields := cachedFields(value.Type())
+
+	for {
+		token, err := d.tokenizer.Next()
+		if err != nil {
+			return d.unexpectedEOF(err)
+		}
+		if token.Kind == ObjectEnd {
+			return nil
+		}
+
+		f := findField(fields, token.Value)
+		if f == nil {
+			if d.disallowUnknownFields {
+				return &UnknownFieldError{token.Value, value.Type(), token.Line, token.Column}
+			}
+			if err = d.tokenizer.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fieldValue, err := fieldForDecode(value, f.index)
+		if err != nil {
+			return err
+		}
+
+		d.fieldStack = append(d.fieldStack, f.name)
+		if f.quoted {
+			err = d.readQuoted(fieldValue)
+		} else {
+			err = d.readValue(fieldValue)
+		}
+		if err != nil {
+			return err
+		}
+		d.fieldStack = d.fieldStack[:len(d.fieldStack)-1]
+	}
+}
+
+// 按名称查找字段
+func findField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// 按索引序列取出字段，途中 nil 的嵌入指针分配新值
+func fieldForDecode(value reflect.Value, index []int) (reflect.Value, error) {
+	for i, n := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				if !value.CanSet() {
+					return reflect.Value{}, fmt.Errorf("jsoncodec: cannot set embedded pointer to unexported struct: %v", value.Type().Elem())
+				}
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(n)
+	}
+	return value, nil
+}
+
+// 解码带有 string 选项的字段：值是一个字符串，其内容是数字、布尔值或 JSON 字符串
+func (d *Decoder) readQuoted(value reflect.Value) error {
+	token, err := d.tokenizer.Next()
+	if err != nil {
+		return d.unexpectedEOF(err)
+	}
+
+	switch token.Kind {
+	case Null:
+		return d.storeLiteral(value, token)
+	case String:
+		return d.storeQuoted(value, token)
+	}
+
+	if err = d.skipRest(token); err != nil {
+		return err
+	}
+	return fmt.Errorf("jsoncodec: invalid use of ,string struct tag, trying to unmarshal unquoted value into %v at line %d, column %d",
+		value.Type(), token.Line, token.Column)
+}
+
+// 按 encoding/json 的规则解析字符串的内容：首尾不能有空白，
+// 数字直接交给 strconv 解析，因此接受 "07"，而 "1e2" 写入整数时是类型错误
+func (d *Decoder) storeQuoted(value reflect.Value, token Token) error {
+	s := token.Value
+	invalid := func() error {
+		return fmt.Errorf("jsoncodec: invalid use of ,string struct tag, trying to unmarshal %q into %v at line %d, column %d",
+			s, value.Type(), token.Line, token.Column)
+	}
+
+	// 位置以外层字符串为准
+	literal := Token{Value: s, Line: token.Line, Column: token.Column, Offset: token.Offset}
+	switch {
+	case s == "null":
+		literal.Kind = Null
+		return d.storeLiteral(value, literal)
+	case s == "" || s[0] == 'n':
+		return invalid()
+	}
+
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		value = value.Elem()
+	}
+
+	switch c := s[0]; {
+	case s == "true" || s == "false":
+		if value.Kind() != reflect.Bool && !(value.Kind() == reflect.Interface && value.NumMethod() == 0) {
+			return invalid()
+		}
+		literal.Kind = False
+		if s == "true" {
+			literal.Kind = True
+		}
+	case c == '"':
+		// 内容必须恰好是一个 JSON 字符串
+		inner := NewTokenizer(strings.NewReader(s))
+		str, err := inner.Next()
+		if err != nil || str.Kind != String || s[len(s)-1] != '"' || inner.End() != nil {
+			return invalid()
+		}
+		literal.Kind, literal.Value = String, str.Value
+	case c == '-' || c >= '0' && c <= '9':
+		switch value.Kind() {
+		case reflect.Interface,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64:
+		default:
+			if value.Type() != numberType {
+				return invalid()
+			}
+		}
+		literal.Kind = Number
+	default:
+		return invalid()
+	}
+	return d.storeLiteral(value, literal)
+}
+
+// 解码数组，[ 已经读取
+func (d *Decoder) readArray(value reflect.Value, start Token) error {
+	switch {
+	case value.Kind() == reflect.Interface && value.NumMethod() == 0:
+		array, err := d.arrayInterface()
+		if err != nil {
+			return err
+		}
+		value.Set(reflect.ValueOf(array))
+		return nil
+	case value.Kind() != reflect.Slice && value.Kind() != reflect.Array:
+		if err := d.skipRest(start); err != nil {
+			return err
+		}
+		return d.typeError("array", value.Type(), start)
+	}
+
+	// 切片先清空再逐个追加，数组多出的元素丢弃，不足的部分设为零值
+	if value.Kind() == reflect.Slice {
+		value.SetLen(0)
+	}
+	i := 0
+	for ; ; i++ {
+		kind, err := d.tokenizer.PeekKind()
+		if err != nil {
+			return d.unexpectedEOF(err)
+		}
+		if kind == ArrayEnd {
+			d.tokenizer.Next()
+			break
+		}
+
+		switch {
+		case value.Kind() == reflect.Slice:
+			value.Set(reflect.Append(value, reflect.Zero(value.Type().Elem())))
+			err = d.readValue(value.Index(i))
+		case i < value.Len():
+			err = d.readValue(value.Index(i))
+		default:
+			err = d.tokenizer.Skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if value.Kind() == reflect.Array {
+		for ; i < value.Len(); i++ {
+			value.Index(i).Set(reflect.Zero(value.Type().Elem()))
+		}
+	} else if value.IsNil() {
+		// 空数组解码为长度为 0 的切片而不是 nil
+		value.Set(reflect.MakeSlice(value.Type(), 0, 0))
+	}
+	return nil
+}
+
+// 把字符串、数字、布尔值写入 value
+func (d *Decoder) storeLiteral(value reflect.Value, token Token) error {
+	switch token.Kind {
+	case Null:
+		switch value.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			value.Set(reflect.Zero(value.Type()))
+		}
+		return nil
+
+	case True, False:
+		b := token.Kind == True
+		switch {
+		case value.Kind() == reflect.Bool:
+			value.SetBool(b)
+		case value.Kind() == reflect.Interface && value.NumMethod() == 0:
+			value.Set(reflect.ValueOf(b))
+		default:
+			return d.typeError("bool", value.Type(), token)
+		}
+		return nil
+
+	case String:
+		switch {
+		case value.Kind() == reflect.String:
+			if value.Type() == numberType && !isValidNumber(token.Value) {
+				return fmt.Errorf("jsoncodec: invalid number literal, trying to unmarshal %q into Number at line %d, column %d",
+					token.Value, token.Line, token.Column)
+			}
+			value.SetString(token.Value)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8:
+			data, err := base64.StdEncoding.DecodeString(token.Value)
+			if err != nil {
+				return err
+			}
+			value.SetBytes(data)
+		case value.Kind() == reflect.Interface && value.NumMethod() == 0:
+			value.Set(reflect.ValueOf(token.Value))
+		default:
+			return d.typeError("string", value.Type(), token)
+		}
+		return nil
+	}
+
+	// 数字直接按目标类型解析，整数不经过 float64，不会丢失精度
+	s := token.Value
+	switch value.Kind() {
+	case reflect.Interface:
+		if value.NumMethod() != 0 {
+			return d.typeError("number", value.Type(), token)
+		}
+		n, err := d.convertNumber(s)
+		if err != nil {
+			return d.typeError("number "+s, value.Type(), token)
+		}
+		value.Set(reflect.ValueOf(n))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || value.OverflowInt(n) {
+			return d.typeError("number "+s, value.Type(), token)
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || value.OverflowUint(n) {
+			return d.typeError("number "+s, value.Type(), token)
+		}
+		value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, value.Type().Bits())
+		if err != nil || value.OverflowFloat(n) {
+			return d.typeError("number "+s, value.Type(), token)
+		}
+		value.SetFloat(n)
+	case reflect.String:
+		if value.Type() != numberType {
+			return d.typeError("number", value.Type(), token)
+		}
+		value.SetString(s)
+	default:
+		return d.typeError("number", value.Type(), token)
+	}
+	return nil
+}
+
+// 解码到 interface{} 时数字的类型
+func (d *Decoder) convertNumber(s string) (interface{}, error) {
+	if d.useNumber {
+		return json.Number(s), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// 解码任意值为 map[string]interface{}、[]interface{}、float64、string、bool 或 nil
+func (d *Decoder) valueInterface() (interface{}, error) {
+	token, err := d.tokenizer.Next()
+	if err != nil {
+		return nil, d.unexpectedEOF(err)
+	}
+
+	switch token.Kind {
+	case ObjectStart:
+		return d.objectInterface()
+	case ArrayStart:
+		return d.arrayInterface()
+	case String:
+		return token.Value, nil
+	case Number:
+		n, err := d.convertNumber(token.Value)
+		if err != nil {
+			return nil, d.typeError("number "+token.Value, reflect.TypeOf(0.0), token)
+		}
+		return n, nil
+	case True:
+		return true, nil
+	case False:
+		return false, nil
+	}
+	return nil, nil
+}
+
+// 解码对象为 map[string]interface{}，{ 已经读取
+func (d *Decoder) objectInterface() (map[string]interface{}, error) {
+	object := make(map[string]interface{})
+	for {
+		token, err := d.tokenizer.Next()
+		if err != nil {
+			return nil, d.unexpectedEOF(err)
+		}
+		if token.Kind == ObjectEnd {
+			return object, nil
+		}
+		if object[token.Value], err = d.valueInterface(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 解码数组为 []interface{}，[ 已经读取
+func (d *Decoder) arrayInterface() ([]interface{}, error) {
+	array := make([]interface{}, 0)
+	for {
+		kind, err := d.tokenizer.PeekKind()
+		if err != nil {
+			return nil, d.unexpectedEOF(err)
+		}
+		if kind == ArrayEnd {
+			d.tokenizer.Next()
+			return array, nil
+		}
+
+		value, err := d.valueInterface()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+	}
+}
+
+// 已经读取了对象或数组的开始符时，跳过剩余部分
+func (d *Decoder) skipRest(token Token) error {
+	if token.Kind != ObjectStart && token.Kind != ArrayStart {
+		return nil
+	}
+
+	depth := d.tokenizer.Depth() - 1
+	for d.tokenizer.Depth() > depth {
+		if _, err := d.tokenizer.Next(); err != nil {
+			return d.unexpectedEOF(err)
+		}
+	}
+	return nil
+}
+
+// 生成类型错误，带上当前字段路径
+func (d *Decoder) typeError(what string, t reflect.Type, token Token) error {
+	return &UnmarshalTypeError{
+		Value:  what,
+		Type:   t,
+		Field:  strings.Join(d.fieldStack, "."),
+		Line:   token.Line,
+		Column: token.Column,
+	}
+}
+
+// 值的中途遇到输入结束
+func (d *Decoder) unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return d.tokenizer.eofError()
+	}
+	return err
+}
+
+// 词法单元的描述
+func describe(token Token) string {
+	switch token.Kind {
+	case ObjectStart:
+		return "object"
+	case ArrayStart:
+		return "array"
+	case Number:
+		return "number"
+	case True, False:
+		return "bool"
+	}
+	return token.Kind.String()
+}
@@ -0,0 +1,608 @@
+// jsoncodec 使用反射实现 JSON 编解码，输出与 encoding/json 保持一致
+package jsoncodec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	numberType        = reflect.TypeOf(json.Number(""))
+)
+
+// 无法编码的类型，例如通道、函数和复数
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "jsoncodec: unsupported type: " + e.Type.String()
+}
+
+// 无法编码的值，例如 NaN、无穷大和循环引用
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "jsoncodec: unsupported value: " + e.Str
+}
+
+// 调用 MarshalJSON 或 MarshalText 出错，或者 MarshalJSON 返回的不是合法 JSON
+type MarshalerError struct {
+	Type reflect.Type
+	Err  error
+	Func string // 出错的方法：MarshalJSON 或 MarshalText
+}
+
+func (e *MarshalerError) Error() string {
+	return "jsoncodec: error calling " + e.Func + " for type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+func (e *MarshalerError) Unwrap() error {
+	return e.Err
+}
+
+// 循环引用检测的键，同一地址可能是结构体和它的第一个字段，所以要带上类型
+type visit struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// 值已经在当前路径上
+func cycleError(value reflect.Value) error {
+	return &UnsupportedValueError{value, "encountered a cycle via " + value.Type().String()}
+}
+
+// 编码状态
+type encoder struct {
+	buffer *bytes.Buffer
+
+	// 当前路径上的指针、映射和切片
+	seen map[visit]bool
+}
+
+// 将任意值编码为 JSON
+func MarshalJSON(data interface{}) ([]byte, error) {
+	// 准备一个缓冲区
+	e := &encoder{buffer: new(bytes.Buffer), seen: make(map[visit]bool)}
+
+	// 将任意值转换为 JSON 并输出到缓冲
+	if err := e.writeAny(reflect.ValueOf(data), false); err != nil {
+		return nil, err
+	}
+	return e.buffer.Bytes(), nil
+}
+
+// 写入任意值，quoted 为 true 时数字、布尔值和字符串以 JSON 字符串形式写入
+func (e *encoder) writeAny(value reflect.Value, quoted bool) error {
+	if !value.IsValid() {
+		e.buffer.WriteString("null")
+		return nil
+	}
+
+	// 自定义编码优先，指针接收者的方法只有值可寻址时才能调用
+	valueType := value.Type()
+	if valueType.Implements(marshalerType) || (value.CanAddr() && reflect.PtrTo(valueType).Implements(marshalerType)) {
+		return e.writeMarshaler(value)
+	}
+	if valueType.Implements(textMarshalerType) || (value.CanAddr() && reflect.PtrTo(valueType).Implements(textMarshalerType)) {
+		return e.writeTextMarshaler(value)
+	}
+
+	switch value.Kind() {
+	case reflect.Bool:
+		e.writeQuoted(strconv.FormatBool(value.Bool()), quoted)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// 将整型转换为字符串写入缓冲
+		e.writeQuoted(strconv.FormatInt(value.Int(), 10), quoted)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeQuoted(strconv.FormatUint(value.Uint(), 10), quoted)
+	case reflect.Float32, reflect.Float64:
+		return e.writeFloat(value, quoted)
+	case reflect.String:
+		return e.writeString(value, quoted)
+	case reflect.Interface:
+		if value.IsNil() {
+			e.buffer.WriteString("null")
+			return nil
+		}
+		return e.writeAny(value.Elem(), quoted)
+	case reflect.Ptr:
+		return e.writePtr(value, quoted)
+	case reflect.Struct:
+		return e.writeStruct(value)
+	case reflect.Map:
+		return e.writeMap(value)
+	case reflect.Slice:
+		return e.writeSlice(value)
+	case reflect.Array:
+		return e.writeArray(value)
+	default:
+		return &UnsupportedTypeError{valueType}
+	}
+	return nil
+}
+
+// 写入数字或布尔值，quoted 为 true 时加上双引号
+func (e *encoder) writeQuoted(s string, quoted bool) {
+	if quoted {
+		e.buffer.WriteByte('"')
+	}
+	e.buffer.WriteString(s)
+	if quoted {
+		e.buffer.WriteByte('"')
+	}
+}
+
+// 调用 MarshalJSON，压缩输出并转义 HTML 字符
+func (e *encoder) writeMarshaler(value reflect.Value) error {
+	if value.Kind() == reflect.Ptr && value.IsNil() {
+		e.buffer.WriteString("null")
+		return nil
+	}
+	if !value.Type().Implements(marshalerType) {
+		value = value.Addr()
+	}
+	m, ok := value.Interface().(json.Marshaler)
+	if !ok {
+		// 值为 nil 的接口
+		e.buffer.WriteString("null")
+		return nil
+	}
+
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return &MarshalerError{value.Type(), err, "MarshalJSON"}
+	}
+	b, err := appendCompact(e.buffer.AvailableBuffer(), data)
+	if err != nil {
+		return &MarshalerError{value.Type(), err, "MarshalJSON"}
+	}
+	e.buffer.Write(b)
+	return nil
+}
+
+// 把 MarshalJSON 的输出压缩并转义 HTML 字符后追加到 dst
+func appendCompact(dst []byte, data []byte) ([]byte, error) {
+	compact := new(bytes.Buffer)
+	if err := json.Compact(compact, data); err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(dst)
+	json.HTMLEscape(out, compact.Bytes())
+	return out.Bytes(), nil
+}
+
+// 调用 MarshalText，结果作为字符串写入
+func (e *encoder) writeTextMarshaler(value reflect.Value) error {
+	if value.Kind() == reflect.Ptr && value.IsNil() {
+		e.buffer.WriteString("null")
+		return nil
+	}
+	if !value.Type().Implements(textMarshalerType) {
+		value = value.Addr()
+	}
+	m, ok := value.Interface().(encoding.TextMarshaler)
+	if !ok {
+		e.buffer.WriteString("null")
+		return nil
+	}
+
+	text, err := m.MarshalText()
+	if err != nil {
+		return &MarshalerError{value.Type(), err, "MarshalText"}
+	}
+	e.buffer.Write(appendString(e.buffer.AvailableBuffer(), string(text), true))
+	return nil
+}
+
+// 写入浮点数，格式与 JavaScript 相同：很大或很小的数使用科学计数法
+func (e *encoder) writeFloat(value reflect.Value, quoted bool) error {
+	f := value.Float()
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return &UnsupportedValueError{value, strconv.FormatFloat(f, 'g', -1, value.Type().Bits())}
+	}
+
+	b := appendFloat(nil, f, value.Type().Bits())
+	e.writeQuoted(string(b), quoted)
+	return nil
+}
+
+// 按 ES6 的规则格式化浮点数：绝对值很小或很大时使用科学计数法
+func appendFloat(dst []byte, f float64, bits int) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	n := len(dst)
+	dst = strconv.AppendFloat(dst, f, format, -1, bits)
+	if format == 'e' {
+		// 指数部分去掉前导 0：1e-07 写为 1e-7
+		if b := dst[n:]; len(b) >= 4 && b[len(b)-4] == 'e' && b[len(b)-3] == '-' && b[len(b)-2] == '0' {
+			dst[len(dst)-2] = dst[len(dst)-1]
+			dst = dst[:len(dst)-1]
+		}
+	}
+	return dst
+}
+
+// 写入字符串，json.Number 按数字写入
+func (e *encoder) writeString(value reflect.Value, quoted bool) error {
+	if value.Type() == numberType {
+		number := value.String()
+		if number == "" {
+			number = "0"
+		}
+		if !isValidNumber(number) {
+			return fmt.Errorf("jsoncodec: invalid number literal %q", number)
+		}
+		e.writeQuoted(number, quoted)
+		return nil
+	}
+
+	if quoted {
+		// 先编码为 JSON 字符串，再把结果作为字符串编码一次
+		inner := appendString(nil, value.String(), true)
+		e.buffer.Write(appendString(e.buffer.AvailableBuffer(), string(inner), false))
+		return nil
+	}
+	e.buffer.Write(appendString(e.buffer.AvailableBuffer(), value.String(), true))
+	return nil
+}
+
+// 写入指针指向的值
+func (e *encoder) writePtr(value reflect.Value, quoted bool) error {
+	if value.IsNil() {
+		e.buffer.WriteString("null")
+		return nil
+	}
+
+	key := visit{value.Pointer(), 0, value.Type()}
+	if e.seen[key] {
+		return cycleError(value)
+	}
+	e.seen[key] = true
+	defer delete(e.seen, key)
+
+	return e.writeAny(value.Elem(), quoted)
+}
+
+// 将结构体转换为 JSON 并输出到缓冲区
+func (e *encoder) writeStruct(value reflect.Value) error {
+	// 写入结构体左大括号
+	e.buffer.WriteByte('{')
+
+	first := true
+	for _, f := range cachedFields(value.Type()) {
+		// 获取字段值，经过值为 nil 的嵌入指针时跳过
+		fieldValue, ok := fieldByIndex(value, f.index)
+		if !ok {
+			continue
+		}
+		if f.omitEmpty && isEmptyValue(fieldValue) || f.omitZero && isZeroValue(fieldValue) {
+			continue
+		}
+
+		// 写入每个字段前的逗号，第一个字段不添加
+		if !first {
+			e.buffer.WriteByte(',')
+		}
+		first = false
+
+		// 写入字段名和冒号
+		e.buffer.Write(appendString(e.buffer.AvailableBuffer(), f.name, true))
+		e.buffer.WriteByte(':')
+
+		// 写入每个字段值
+		if err := e.writeAny(fieldValue, f.quoted); err != nil {
+			return err
+		}
+	}
+
+	// 写入结构体右大括号
+	e.buffer.WriteByte('}')
+	return nil
+}
+
+// 将映射转换为 JSON 并输出到缓冲区，键按字符串排序
+func (e *encoder) writeMap(value reflect.Value) error {
+	keyType := value.Type().Key()
+	switch keyType.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		if !keyType.Implements(textMarshalerType) {
+			return &UnsupportedTypeError{value.Type()}
+		}
+	}
+
+	if value.IsNil() {
+		e.buffer.WriteString("null")
+		return nil
+	}
+
+	key := visit{value.Pointer(), 0, value.Type()}
+	if e.seen[key] {
+		return cycleError(value)
+	}
+	e.seen[key] = true
+	defer delete(e.seen, key)
+
+	// 先把所有键转换为字符串再排序
+	type entry struct {
+		name  string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, value.Len())
+	iter := value.MapRange()
+	for iter.Next() {
+		name, err := resolveKeyName(iter.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{name, iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	// 写入映射开始标记
+	e.buffer.WriteByte('{')
+	for i, entry := range entries {
+		if i > 0 {
+			e.buffer.WriteByte(',')
+		}
+
+		// 写入转义后的键名和冒号
+		e.buffer.Write(appendString(e.buffer.AvailableBuffer(), entry.name, true))
+		e.buffer.WriteByte(':')
+
+		// 写入值
+		if err := e.writeAny(entry.value, false); err != nil {
+			return err
+		}
+	}
+	// 写入映射结束标记
+	e.buffer.WriteByte('}')
+	return nil
+}
+
+// 映射的键转换为字符串：字符串类型直接使用，其次是 MarshalText，最后是整数
+func resolveKeyName(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+	if m, ok := key.Interface().(encoding.TextMarshaler); ok {
+		if key.Kind() == reflect.Ptr && key.IsNil() {
+			return "", nil
+		}
+		text, err := m.MarshalText()
+		if err != nil {
+			return "", &MarshalerError{key.Type(), err, "MarshalText"}
+		}
+		return string(text), nil
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	default:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+}
+
+// 将切片转换为 JSON 并输出到缓冲区，nil 切片写为 null，[]byte 写为 base64 字符串
+func (e *encoder) writeSlice(value reflect.Value) error {
+	if value.IsNil() {
+		e.buffer.WriteString("null")
+		return nil
+	}
+
+	// 元素类型实现了自定义编码时仍按数组处理
+	elemType := value.Type().Elem()
+	if elemType.Kind() == reflect.Uint8 {
+		ptr := reflect.PtrTo(elemType)
+		if !ptr.Implements(marshalerType) && !ptr.Implements(textMarshalerType) {
+			e.buffer.WriteByte('"')
+			e.buffer.WriteString(base64.StdEncoding.EncodeToString(value.Bytes()))
+			e.buffer.WriteByte('"')
+			return nil
+		}
+	}
+
+	key := visit{value.Pointer(), value.Len(), value.Type()}
+	if e.seen[key] {
+		return cycleError(value)
+	}
+	e.seen[key] = true
+	defer delete(e.seen, key)
+
+	return e.writeArray(value)
+}
+
+// 将数组或切片的元素逐个写入
+func (e *encoder) writeArray(value reflect.Value) error {
+	// 写入切片开始标记
+	e.buffer.WriteByte('[')
+
+	// 遍历每个元素
+	for i := 0; i < value.Len(); i++ {
+		// 写入每个元素前的逗号，第一个元素不添加
+		if i > 0 {
+			e.buffer.WriteByte(',')
+		}
+		if err := e.writeAny(value.Index(i), false); err != nil {
+			return err
+		}
+	}
+
+	// 写入切片结束标记
+	e.buffer.WriteByte(']')
+	return nil
+}
+
+// omitempty 判断的空值：false、0、nil 指针和接口、长度为 0 的数组、切片、映射和字符串
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Ptr:
+		return value.IsZero()
+	}
+	return false
+}
+
+// 带有 IsZero 方法的类型，例如 time.Time
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeOf((*isZeroer)(nil)).Elem()
+
+// omitzero 判断的零值，类型有 IsZero 方法时以该方法为准
+func isZeroValue(value reflect.Value) bool {
+	valueType := value.Type()
+	switch {
+	case valueType.Kind() == reflect.Interface && valueType.Implements(isZeroerType):
+		return value.IsNil() ||
+			(value.Elem().Kind() == reflect.Ptr && value.Elem().IsNil()) ||
+			value.Interface().(isZeroer).IsZero()
+	case valueType.Kind() == reflect.Ptr && valueType.Implements(isZeroerType):
+		return value.IsNil() || value.Interface().(isZeroer).IsZero()
+	case valueType.Implements(isZeroerType):
+		return value.Interface().(isZeroer).IsZero()
+	case reflect.PtrTo(valueType).Implements(isZeroerType):
+		if !value.CanAddr() {
+			boxed := reflect.New(valueType).Elem()
+			boxed.Set(value)
+			value = boxed
+		}
+		return value.Addr().Interface().(isZeroer).IsZero()
+	}
+	return value.IsZero()
+}
+
+const hex = "0123456789abcdef"
+
+// 把 s 编码为 JSON 字符串追加到 dst，escapeHTML 为 true 时同时转义 <、>、&
+func appendString(dst []byte, s string, escapeHTML bool) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && (!escapeHTML || b != '<' && b != '>' && b != '&') {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '\\', '"':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				// 其他控制字符和 HTML 字符使用 \u00XX
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case c == utf8.RuneError && size == 1:
+			// 非法 UTF-8 替换为 U+FFFD
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, `\ufffd`...)
+		case c == '\u2028' || c == '\u2029':
+			// 行分隔符和段分隔符在 JavaScript 字符串中不合法
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[c&0xF])
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// 检查是否为合法的 JSON 数字
+func isValidNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	// 可选的负号
+	if s[0] == '-' {
+		s = s[1:]
+		if s == "" {
+			return false
+		}
+	}
+
+	// 整数部分：0 或不以 0 开头的数字
+	switch {
+	case s[0] == '0':
+		s = s[1:]
+	case '1' <= s[0] && s[0] <= '9':
+		for s = s[1:]; s != "" && isDigit(s[0]); s = s[1:] {
+		}
+	default:
+		return false
+	}
+
+	// 小数部分
+	if len(s) >= 2 && s[0] == '.' && isDigit(s[1]) {
+		for s = s[2:]; s != "" && isDigit(s[0]); s = s[1:] {
+		}
+	}
+
+	// 指数部分
+	if len(s) >= 2 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if s[0] == '+' || s[0] == '-' {
+			s = s[1:]
+			if s == "" {
+				return false
+			}
+		}
+		for ; s != "" && isDigit(s[0]); s = s[1:] {
+		}
+	}
+	return s == ""
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
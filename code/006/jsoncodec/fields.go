@@ -0,0 +1,239 @@
+package jsoncodec
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// 结构体中参与编解码的字段
+type field struct {
+	name      string // JSON 中的字段名
+	tagged    bool   // 字段名是否来自 json 标签
+	index     []int  // 字段索引序列，嵌入结构体的字段有多级索引
+	typ       reflect.Type
+	omitEmpty bool // omitempty：值为空时省略
+	omitZero  bool // omitzero：值为零值时省略
+	quoted    bool // string：数字、布尔值和字符串以 JSON 字符串形式编码
+}
+
+// 按类型缓存的字段列表
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// 解析 json 标签，返回字段名和选项
+func parseTag(tag string) (string, tagOptions) {
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opts)
+}
+
+// 标签中逗号分隔的选项
+type tagOptions string
+
+// 是否包含指定选项
+func (opts tagOptions) Contains(name string) bool {
+	s := string(opts)
+	for s != "" {
+		var opt string
+		opt, s, _ = strings.Cut(s, ",")
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 标签中的字段名只能由字母、数字和部分标点组成
+func isValidTag(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
+
+// 获取结构体参与编解码的字段，按字段定义顺序排列
+func cachedFields(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return fields.([]field)
+}
+
+// 按广度优先的顺序展开嵌入结构体，收集全部字段，
+// 同名字段按 Go 的嵌入规则取层级最浅的，层级相同时优先取带标签的
+func typeFields(t reflect.Type) []field {
+	// 当前层和下一层待展开的嵌入结构体
+	current := []field{}
+	next := []field{{typ: t}}
+
+	// 当前层和下一层中每种嵌入类型出现的次数
+	var count, nextCount map[reflect.Type]int
+
+	// 已经在更浅的层级展开过的类型
+	visited := make(map[reflect.Type]bool)
+
+	var fields []field
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, make(map[reflect.Type]int)
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					// 未导出的嵌入结构体仍然可能包含导出字段
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseTag(tag)
+				if !isValidTag(name) {
+					name = ""
+				}
+
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				// 匿名的指针类型按指向的类型处理
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				// 只有字符串、数字和布尔值可以使用 string 选项
+				quoted := false
+				if opts.Contains("string") {
+					switch ft.Kind() {
+					case reflect.Bool,
+						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+						reflect.Float32, reflect.Float64,
+						reflect.String:
+						quoted = true
+					}
+				}
+
+				// 没有标签的嵌入结构体留到下一层展开
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					nextCount[ft]++
+					if nextCount[ft] == 1 {
+						next = append(next, field{name: ft.Name(), index: index, typ: ft})
+					}
+					continue
+				}
+
+				tagged := name != ""
+				if name == "" {
+					name = sf.Name
+				}
+				fields = append(fields, field{
+					name:      name,
+					tagged:    tagged,
+					index:     index,
+					typ:       ft,
+					omitEmpty: opts.Contains("omitempty"),
+					omitZero:  opts.Contains("omitzero"),
+					quoted:    quoted,
+				})
+
+				// 同一层中同一类型被嵌入多次时，其字段互相冲突，再添加一份让下面的去重逻辑删除
+				if count[f.typ] > 1 {
+					fields = append(fields, fields[len(fields)-1])
+				}
+			}
+		}
+	}
+
+	// 按字段名、层级、是否带标签、索引序列排序
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if len(a.index) != len(b.index) {
+			return len(a.index) < len(b.index)
+		}
+		if a.tagged != b.tagged {
+			return a.tagged
+		}
+		return indexLess(a.index, b.index)
+	})
+
+	// 同名字段只保留占优的一个，无法确定时全部丢弃
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != fields[i].name {
+				break
+			}
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+
+	// 恢复字段定义顺序
+	fields = out
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].index, fields[j].index)
+	})
+	return fields
+}
+
+// 同名字段中层级最浅的只有一个，或者层级最浅的字段中只有一个带标签时，该字段占优
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+// 按字典序比较索引序列
+func indexLess(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// 按索引序列取出字段值，经过的嵌入指针为 nil 时返回 false
+func fieldByIndex(value reflect.Value, index []int) (reflect.Value, bool) {
+	for i, n := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return reflect.Value{}, false
+			}
+			value = value.Elem()
+		}
+		value = value.Field(n)
+	}
+	return value, true
+}
@@ -0,0 +1,312 @@
+package jsoncodec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// 以下函数供 jsongen 生成的代码调用，除了回退到反射编解码的 AppendValue、IsEmpty、DecodeValue 和 DecodeQuoted，
+// 以及检测循环引用时取得类型的 Enter 系列函数，都不使用反射
+
+// 追加 JSON 字符串
+func AppendString(dst []byte, s string) []byte {
+	return appendString(dst, s, true)
+}
+
+// 追加带有 string 选项的字符串：先编码为 JSON 字符串，再把结果作为字符串编码一次
+func AppendQuotedString(dst []byte, s string) []byte {
+	return appendString(dst, string(appendString(nil, s, true)), false)
+}
+
+// 追加浮点数，格式与反射编码相同，NaN 和无穷大返回错误
+func AppendFloat(dst []byte, f float64, bits int) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, &UnsupportedValueError{reflect.ValueOf(f), strconv.FormatFloat(f, 'g', -1, bits)}
+	}
+	return appendFloat(dst, f, bits), nil
+}
+
+// 追加 base64 编码的字节切片，nil 写为 null
+func AppendBytes(dst []byte, b []byte) []byte {
+	if b == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '"')
+	dst = base64.StdEncoding.AppendEncode(dst, b)
+	return append(dst, '"')
+}
+
+// 生成器无法处理的类型回退到反射编码，实现了 json.Marshaler 的非 nil 值直接调用 MarshalJSON
+func AppendValue(dst []byte, v interface{}) ([]byte, error) {
+	if m, ok := v.(json.Marshaler); ok {
+		if value := reflect.ValueOf(v); value.Kind() != reflect.Ptr || !value.IsNil() {
+			data, err := m.MarshalJSON()
+			if err == nil {
+				dst, err = appendCompact(dst, data)
+			}
+			if err != nil {
+				return nil, &MarshalerError{value.Type(), err, "MarshalJSON"}
+			}
+			return dst, nil
+		}
+	}
+
+	data, err := MarshalJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
+
+// 生成器无法判断类型时使用反射判断 omitempty 的空值
+func IsEmpty(v interface{}) bool {
+	value := reflect.ValueOf(v)
+	return !value.IsValid() || isEmptyValue(value)
+}
+
+// 生成的编码方法检测循环引用的状态，与反射实现一样记录当前路径上的指针、切片和映射。
+// 路径通常很短，按顺序查找比使用映射更快，前几层也不需要另外分配内存
+type Cycles struct {
+	path []visit
+	buf  [8]visit
+}
+
+// 进入指针指向的值，指针已经在当前路径上时返回 UnsupportedValueError
+func EnterPtr[T any](c *Cycles, p *T) error {
+	if c.enter(visit{uintptr(unsafe.Pointer(p)), 0, reflect.TypeFor[*T]()}) {
+		return nil
+	}
+	return cycleError(reflect.ValueOf(p))
+}
+
+// 进入切片，切片已经在当前路径上时返回 UnsupportedValueError
+func EnterSlice[S ~[]E, E any](c *Cycles, s S) error {
+	if c.enter(visit{uintptr(unsafe.Pointer(unsafe.SliceData(s))), len(s), reflect.TypeFor[S]()}) {
+		return nil
+	}
+	return cycleError(reflect.ValueOf(s))
+}
+
+// 进入映射，映射已经在当前路径上时返回 UnsupportedValueError
+func EnterMap[M ~map[K]V, K comparable, V any](c *Cycles, m M) error {
+	if c.enter(visit{reflect.ValueOf(m).Pointer(), 0, reflect.TypeFor[M]()}) {
+		return nil
+	}
+	return cycleError(reflect.ValueOf(m))
+}
+
+// 离开最近一次进入的指针、切片或映射
+func (c *Cycles) Leave() {
+	c.path = c.path[:len(c.path)-1]
+}
+
+// 把 key 加入当前路径，已经在路径上时返回 false
+func (c *Cycles) enter(key visit) bool {
+	for _, k := range c.path {
+		if k == key {
+			return false
+		}
+	}
+	if c.path == nil {
+		c.path = c.buf[:0]
+	}
+	c.path = append(c.path, key)
+	return true
+}
+
+// 生成器无法处理的类型回退到反射解码，v 为指向目标的指针
+func DecodeValue(t *Tokenizer, v interface{}) error {
+	d := &Decoder{tokenizer: t}
+	return d.readValue(reflect.ValueOf(v).Elem())
+}
+
+// 解码带有 string 选项的字段，v 为指向目标的指针
+func DecodeQuoted(t *Tokenizer, v interface{}) error {
+	d := &Decoder{tokenizer: t}
+	return d.readQuoted(reflect.ValueOf(v).Elem())
+}
+
+// 下一个值为 null 时读取并返回 true
+func ReadNull(t *Tokenizer) (bool, error) {
+	kind, err := t.PeekKind()
+	if err != nil {
+		return false, eofToSyntax(t, err)
+	}
+	if kind != Null {
+		return false, nil
+	}
+	_, err = t.Next()
+	return true, err
+}
+
+// 读取 { 或 [，v 为指向目标的指针，用于错误信息
+func ReadDelim(t *Tokenizer, kind Kind, v interface{}) error {
+	token, err := t.Next()
+	if err != nil {
+		return eofToSyntax(t, err)
+	}
+	if token.Kind != kind {
+		return readTypeError(token, v)
+	}
+	return nil
+}
+
+// 读取对象的键，遇到 } 时返回 false
+func ReadKey(t *Tokenizer) (string, bool, error) {
+	token, err := t.Next()
+	if err != nil {
+		return "", false, eofToSyntax(t, err)
+	}
+	return token.Value, token.Kind == Key, nil
+}
+
+// 数组中是否还有元素，遇到 ] 时读取并返回 false
+func More(t *Tokenizer) (bool, error) {
+	kind, err := t.PeekKind()
+	if err != nil {
+		return false, eofToSyntax(t, err)
+	}
+	if kind == ArrayEnd {
+		_, err = t.Next()
+		return false, err
+	}
+	return true, nil
+}
+
+// 按字段名查找，先精确匹配，再忽略大小写匹配，找不到时返回空字符串
+func MatchField(key string, names []string) string {
+	for _, name := range names {
+		if name == key {
+			return name
+		}
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return ""
+}
+
+// 读取字符串
+func ReadString[T ~string](t *Tokenizer, v *T) error {
+	token, err := t.Next()
+	if err != nil {
+		return eofToSyntax(t, err)
+	}
+	if token.Kind != String {
+		return readTypeError(token, v)
+	}
+	*v = T(token.Value)
+	return nil
+}
+
+// 读取布尔值
+func ReadBool[T ~bool](t *Tokenizer, v *T) error {
+	token, err := t.Next()
+	if err != nil {
+		return eofToSyntax(t, err)
+	}
+	if token.Kind != True && token.Kind != False {
+		return readTypeError(token, v)
+	}
+	*v = T(token.Kind == True)
+	return nil
+}
+
+// 读取有符号整数，溢出时返回错误
+func ReadInt[T ~int | ~int8 | ~int16 | ~int32 | ~int64](t *Tokenizer, v *T) error {
+	token, err := t.Next()
+	if err != nil {
+		return eofToSyntax(t, err)
+	}
+	if token.Kind != Number {
+		return readTypeError(token, v)
+	}
+	n, err := strconv.ParseInt(token.Value, 10, 64)
+	if err != nil || int64(T(n)) != n {
+		return readTypeError(token, v)
+	}
+	*v = T(n)
+	return nil
+}
+
+// 读取无符号整数，溢出时返回错误
+func ReadUint[T ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr](t *Tokenizer, v *T) error {
+	token, err := t.Next()
+	if err != nil {
+		return eofToSyntax(t, err)
+	}
+	if token.Kind != Number {
+		return readTypeError(token, v)
+	}
+	n, err := strconv.ParseUint(token.Value, 10, 64)
+	if err != nil || uint64(T(n)) != n {
+		return readTypeError(token, v)
+	}
+	*v = T(n)
+	return nil
+}
+
+// 读取浮点数，按目标类型的精度解析
+func ReadFloat[T ~float32 | ~float64](t *Tokenizer, v *T) error {
+	token, err := t.Next()
+	if err != nil {
+		return eofToSyntax(t, err)
+	}
+	if token.Kind != Number {
+		return readTypeError(token, v)
+	}
+
+	// float32 无法表示 float64 的最大值
+	bits := 64
+	if max := math.MaxFloat64; math.IsInf(float64(T(max)), 0) {
+		bits = 32
+	}
+	n, err := strconv.ParseFloat(token.Value, bits)
+	if err != nil {
+		return readTypeError(token, v)
+	}
+	*v = T(n)
+	return nil
+}
+
+// 读取 base64 编码的字节切片
+func ReadBytes[T ~[]byte](t *Tokenizer, v *T) error {
+	token, err := t.Next()
+	if err != nil {
+		return eofToSyntax(t, err)
+	}
+	if token.Kind != String {
+		return readTypeError(token, v)
+	}
+	data, err := base64.StdEncoding.DecodeString(token.Value)
+	if err != nil {
+		return err
+	}
+	*v = T(data)
+	return nil
+}
+
+// 生成类型错误，只在出错时使用反射取得目标类型
+func readTypeError(token Token, v interface{}) error {
+	what := describe(token)
+	if token.Kind == Number {
+		what = "number " + token.Value
+	}
+	return &UnmarshalTypeError{Value: what, Type: reflect.TypeOf(v).Elem(), Line: token.Line, Column: token.Column}
+}
+
+// 值的中途遇到输入结束
+func eofToSyntax(t *Tokenizer, err error) error {
+	if err == io.EOF {
+		return t.eofError()
+	}
+	return err
+}
@@ -0,0 +1,791 @@
+package main
+
+import (
+	"bytes"
+	"code-snippet/code/006/jsoncodec"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// jsongen 用 go/ast 分析包中的结构体，生成不使用反射的 MarshalJSON 和 UnmarshalJSON 方法，
+// 编码结果与 jsoncodec.MarshalJSON 完全相同。在类型所在的文件中添加：
+//
+//	//go:generate go run code-snippet/code/006/jsoncodec/jsongen -type Actor,Skill
+//
+// 然后在包目录下执行 go generate，生成 <源文件名>_json.go。
+//
+// 生成器只根据语法树判断类型，以下情况回退到 jsoncodec 的反射实现：
+// 其他包中的类型、接口、自定义了 MarshalJSON 等方法的类型、非字符串键的映射。
+// 嵌入字段和 omitzero 选项无法只靠语法树正确处理，遇到时报错。
+// 生成的代码通过 jsoncodec.Cycles 记录正在编码的指针、切片和映射，遇到循环引用时与反射实现一样
+// 返回 *jsoncodec.UnsupportedValueError（"encountered a cycle via ..."），不会栈溢出。
+
+var (
+	typeNames = flag.String("type", "", "要生成方法的结构体，逗号分隔，默认为包中全部结构体")
+	output    = flag.String("output", "", "输出文件名，默认为 <源文件名>_json.go")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("jsongen: ")
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	out := *output
+	if out == "" {
+		// go generate 执行时通过 GOFILE 传入源文件名
+		file := os.Getenv("GOFILE")
+		if file == "" {
+			log.Fatal("-output is required when not run by go generate")
+		}
+		out = strings.TrimSuffix(file, ".go") + "_json.go"
+	}
+
+	g, err := parsePackage(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var names []string
+	if *typeNames != "" {
+		names = strings.Split(*typeNames, ",")
+	}
+	src, err := g.generate(names)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, out), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 类型的分类，决定生成什么样的编解码代码
+type kind int
+
+const (
+	kindString kind = iota
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindBytes
+	kindSlice
+	kindArray
+	kindMap
+	kindPtr
+	kindStruct    // 同时生成方法的结构体
+	kindInterface // 接口，回退到反射
+	kindOther     // 无法确定的类型，回退到反射
+)
+
+// 解析后的类型
+type typeInfo struct {
+	kind kind
+	expr ast.Expr  // 类型表达式，用于声明临时变量
+	bits int       // 整数和浮点数的位数，int 和 uint 为 0
+	elem *typeInfo // 指针、切片、数组和映射的元素类型
+}
+
+// 结构体中参与编解码的字段
+type structField struct {
+	goName    string
+	name      string // JSON 字段名
+	tagged    bool
+	omitEmpty bool
+	quoted    bool
+	info      *typeInfo
+}
+
+// 代码生成器
+type generator struct {
+	pkg       string
+	specs     map[string]*ast.TypeSpec
+	order     []string          // 结构体按定义顺序排列
+	custom    map[string]bool   // 自定义了编解码方法的类型
+	imports   map[string]string // 包名 -> 导入路径
+	targets   map[string]bool   // 要生成方法的类型
+	used      map[string]bool   // 生成的代码用到的导入路径
+	resolving map[string]bool   // 正在解析的命名类型，防止递归定义的类型无限展开
+	buf       bytes.Buffer
+	tmp       int
+}
+
+// 读取目录下的 Go 文件，跳过测试文件和生成的文件
+func parsePackage(dir string) (*generator, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{
+		specs:     map[string]*ast.TypeSpec{},
+		custom:    map[string]bool{},
+		imports:   map[string]string{},
+		targets:   map[string]bool{},
+		used:      map[string]bool{},
+		resolving: map[string]bool{},
+	}
+	fset := token.NewFileSet()
+	for _, p := range paths {
+		if strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, p, nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		if ast.IsGenerated(file) {
+			continue
+		}
+		if g.pkg != "" && g.pkg != file.Name.Name {
+			return nil, fmt.Errorf("%s: found packages %s and %s", dir, g.pkg, file.Name.Name)
+		}
+		g.pkg = file.Name.Name
+		g.collect(file)
+	}
+	if g.pkg == "" {
+		return nil, fmt.Errorf("%s: no Go files", dir)
+	}
+	return g, nil
+}
+
+// 收集类型定义、自定义编解码方法和导入的包
+func (g *generator) collect(file *ast.File) {
+	for _, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		g.imports[name] = importPath
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				g.specs[spec.Name.Name] = spec
+				if _, ok := spec.Type.(*ast.StructType); ok && spec.TypeParams == nil && !spec.Assign.IsValid() {
+					g.order = append(g.order, spec.Name.Name)
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil || len(decl.Recv.List) == 0 {
+				continue
+			}
+			switch decl.Name.Name {
+			case "MarshalJSON", "UnmarshalJSON", "MarshalText", "UnmarshalText":
+				recv := decl.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				if ident, ok := recv.(*ast.Ident); ok {
+					g.custom[ident.Name] = true
+				}
+			}
+		}
+	}
+}
+
+// 生成全部方法并格式化
+func (g *generator) generate(names []string) ([]byte, error) {
+	if len(names) == 0 {
+		names = g.order
+	}
+	for _, name := range names {
+		spec := g.specs[name]
+		if spec == nil {
+			return nil, fmt.Errorf("type %s not found", name)
+		}
+		if _, ok := spec.Type.(*ast.StructType); !ok || spec.TypeParams != nil || spec.Assign.IsValid() {
+			return nil, fmt.Errorf("type %s is not a struct", name)
+		}
+		if g.custom[name] {
+			return nil, fmt.Errorf("type %s already has custom JSON methods", name)
+		}
+		g.targets[name] = true
+	}
+
+	// 先生成方法，再根据用到的包写出文件头
+	g.used["bytes"] = true
+	g.used["code-snippet/code/006/jsoncodec"] = true
+	for _, name := range names {
+		fields, err := g.fields(name, g.specs[name].Type.(*ast.StructType))
+		if err != nil {
+			return nil, err
+		}
+		g.generateMarshal(name, fields)
+		g.generateUnmarshal(name, fields)
+	}
+	body := g.buf.Bytes()
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "// Code generated by jsongen; DO NOT EDIT.\n\npackage %s\n\nimport (\n", g.pkg)
+	var paths []string
+	for p := range g.used {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Fprintf(&head, "\t%q\n", p)
+	}
+	head.WriteString(")\n")
+	head.Write(body)
+
+	src, err := format.Source(head.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v\n%s", err, head.Bytes())
+	}
+	return src, nil
+}
+
+// 解析结构体字段，规则与 jsoncodec 的反射实现相同
+func (g *generator) fields(typeName string, st *ast.StructType) ([]structField, error) {
+	var fields []structField
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded field %s is not supported", typeName, types.ExprString(f.Type))
+		}
+
+		tag := ""
+		if f.Tag != nil {
+			s, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(s).Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if !isValidTag(name) {
+			name = ""
+		}
+		if hasOption(opts, "omitzero") {
+			return nil, fmt.Errorf("%s: omitzero is not supported", typeName)
+		}
+
+		info := g.resolve(f.Type)
+
+		// 只有字符串、数字和布尔值可以使用 string 选项，未命名的指针看它指向的类型
+		quoted := false
+		if hasOption(opts, "string") {
+			t := info
+			if _, ok := f.Type.(*ast.StarExpr); ok {
+				t = t.elem
+			}
+			switch t.kind {
+			case kindString, kindBool, kindInt, kindUint, kindFloat:
+				quoted = true
+			case kindOther:
+				return nil, fmt.Errorf("%s: cannot tell whether ,string applies to %s", typeName, types.ExprString(f.Type))
+			}
+		}
+
+		for _, ident := range f.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			field := structField{
+				goName:    ident.Name,
+				name:      name,
+				tagged:    name != "",
+				omitEmpty: hasOption(opts, "omitempty"),
+				quoted:    quoted,
+				info:      info,
+			}
+			if field.name == "" {
+				field.name = ident.Name
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	// 同名字段只保留唯一带标签的那个，否则全部丢弃
+	count := map[string]int{}
+	taggedCount := map[string]int{}
+	for _, f := range fields {
+		count[f.name]++
+		if f.tagged {
+			taggedCount[f.name]++
+		}
+	}
+	result := fields[:0]
+	for _, f := range fields {
+		if count[f.name] == 1 || f.tagged && taggedCount[f.name] == 1 {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+// 根据类型表达式确定生成代码的方式
+func (g *generator) resolve(expr ast.Expr) *typeInfo {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return g.resolve(e.X)
+	case *ast.Ident:
+		switch e.Name {
+		case "string":
+			return &typeInfo{kind: kindString, expr: e}
+		case "bool":
+			return &typeInfo{kind: kindBool, expr: e}
+		case "int":
+			return &typeInfo{kind: kindInt, expr: e}
+		case "int8", "int16", "int32", "int64":
+			bits, _ := strconv.Atoi(e.Name[3:])
+			return &typeInfo{kind: kindInt, expr: e, bits: bits}
+		case "rune":
+			return &typeInfo{kind: kindInt, expr: e, bits: 32}
+		case "uint", "uintptr":
+			return &typeInfo{kind: kindUint, expr: e}
+		case "uint8", "uint16", "uint32", "uint64":
+			bits, _ := strconv.Atoi(e.Name[4:])
+			return &typeInfo{kind: kindUint, expr: e, bits: bits}
+		case "byte":
+			return &typeInfo{kind: kindUint, expr: e, bits: 8}
+		case "float32":
+			return &typeInfo{kind: kindFloat, expr: e, bits: 32}
+		case "float64":
+			return &typeInfo{kind: kindFloat, expr: e, bits: 64}
+		case "any", "error":
+			return &typeInfo{kind: kindInterface, expr: e}
+		}
+
+		// 包内的命名类型按底层类型处理，但保留类型名
+		spec := g.specs[e.Name]
+		switch {
+		case spec == nil || spec.TypeParams != nil || g.custom[e.Name] || g.resolving[e.Name]:
+			return &typeInfo{kind: kindOther, expr: e}
+		case g.targets[e.Name]:
+			return &typeInfo{kind: kindStruct, expr: e}
+		}
+		g.resolving[e.Name] = true
+		defer delete(g.resolving, e.Name)
+		info := *g.resolve(spec.Type)
+		if !spec.Assign.IsValid() {
+			info.expr = e
+		}
+		return &info
+	case *ast.StarExpr:
+		return &typeInfo{kind: kindPtr, expr: e, elem: g.resolve(e.X)}
+	case *ast.ArrayType:
+		elem := g.resolve(e.Elt)
+		switch {
+		case e.Len != nil:
+			return &typeInfo{kind: kindArray, expr: e, elem: elem}
+		case elem.kind == kindUint && elem.bits == 8:
+			// 字节切片按 base64 编码
+			return &typeInfo{kind: kindBytes, expr: e}
+		}
+		return &typeInfo{kind: kindSlice, expr: e, elem: elem}
+	case *ast.MapType:
+		if key, ok := e.Key.(*ast.Ident); ok && key.Name == "string" {
+			return &typeInfo{kind: kindMap, expr: e, elem: g.resolve(e.Value)}
+		}
+	case *ast.InterfaceType:
+		return &typeInfo{kind: kindInterface, expr: e}
+	}
+	return &typeInfo{kind: kindOther, expr: expr}
+}
+
+// 生成 MarshalJSON 和 appendJSON
+func (g *generator) generateMarshal(name string, fields []structField) {
+	g.tmp = 0
+	g.printf("\n// MarshalJSON 实现 json.Marshaler，输出与 jsoncodec.MarshalJSON 相同\n")
+	g.printf("func (v %s) MarshalJSON() ([]byte, error) {\nreturn v.appendJSON(nil, new(jsoncodec.Cycles))\n}\n", name)
+
+	g.printf("\n// 把 v 编码后追加到 buf，c 记录当前路径上的指针、切片和映射\n")
+	g.printf("func (v %s) appendJSON(buf []byte, c *jsoncodec.Cycles) ([]byte, error) {\n", name)
+	g.printf("var err error\nbuf = append(buf, '{')\n")
+
+	// written 表示前面是否一定写过字段：一定写过时直接加逗号，
+	// 不确定时看 buf 的最后一个字节，字段值不会以 { 结尾
+	written, unsure := false, false
+	for _, f := range fields {
+		cond := ""
+		if f.omitEmpty {
+			cond = nonEmpty("v."+f.goName, f.info)
+		}
+		if cond != "" {
+			g.printf("if %s {\n", cond)
+		}
+
+		key := string(append(jsoncodec.AppendString(nil, f.name), ':'))
+		switch {
+		case written:
+			g.printf("buf = append(buf, %s...)\n", strconv.Quote(","+key))
+		case unsure:
+			g.printf("if buf[len(buf)-1] != '{' {\nbuf = append(buf, ',')\n}\n")
+			g.printf("buf = append(buf, %s...)\n", strconv.Quote(key))
+		default:
+			g.printf("buf = append(buf, %s...)\n", strconv.Quote(key))
+		}
+		// omitempty 已经排除了 nil
+		g.encode("v."+f.goName, f.info, f.quoted, cond != "")
+
+		if cond != "" {
+			g.printf("}\n")
+			unsure = true
+		} else {
+			written = true
+		}
+	}
+
+	g.printf("buf = append(buf, '}')\nreturn buf, err\n}\n")
+}
+
+// omitempty 的判断条件，返回空字符串表示总是写入
+func nonEmpty(x string, info *typeInfo) string {
+	switch info.kind {
+	case kindString, kindBytes, kindSlice, kindArray, kindMap:
+		return "len(" + x + ") != 0"
+	case kindBool:
+		return x
+	case kindInt, kindUint, kindFloat:
+		return x + " != 0"
+	case kindPtr, kindInterface:
+		return x + " != nil"
+	case kindStruct:
+		return ""
+	}
+	return "!jsoncodec.IsEmpty(" + x + ")"
+}
+
+// 生成把 x 编码后追加到 buf 的代码，nonNil 表示 x 一定不是 nil
+func (g *generator) encode(x string, info *typeInfo, quoted, nonNil bool) {
+	// 指针、切片和映射为 nil 时写入 null
+	checkNil, endCheck := func() {
+		if !nonNil {
+			g.printf("if %s == nil {\nbuf = append(buf, \"null\"...)\n} else {\n", x)
+		}
+	}, func() {
+		if !nonNil {
+			g.printf("}\n")
+		}
+	}
+
+	// 只有能到达结构体的指针、切片和映射才可能形成循环引用，与反射实现一样进入时记录、离开时删除
+	cyclic := mayCycle(info)
+	enter, leave := func(fn string) {
+		if cyclic {
+			g.printf("if err = jsoncodec.%s(c, %s); err != nil {\nreturn nil, err\n}\n", fn, x)
+		}
+	}, func() {
+		if cyclic {
+			g.printf("c.Leave()\n")
+		}
+	}
+
+	quote := func() {
+		if quoted {
+			g.printf("buf = append(buf, '\"')\n")
+		}
+	}
+
+	switch info.kind {
+	case kindString:
+		if quoted {
+			g.printf("buf = jsoncodec.AppendQuotedString(buf, string(%s))\n", x)
+		} else {
+			g.printf("buf = jsoncodec.AppendString(buf, string(%s))\n", x)
+		}
+	case kindBool:
+		g.use("strconv")
+		quote()
+		g.printf("buf = strconv.AppendBool(buf, bool(%s))\n", x)
+		quote()
+	case kindInt:
+		g.use("strconv")
+		quote()
+		g.printf("buf = strconv.AppendInt(buf, int64(%s), 10)\n", x)
+		quote()
+	case kindUint:
+		g.use("strconv")
+		quote()
+		g.printf("buf = strconv.AppendUint(buf, uint64(%s), 10)\n", x)
+		quote()
+	case kindFloat:
+		quote()
+		g.printf("if buf, err = jsoncodec.AppendFloat(buf, float64(%s), %d); err != nil {\nreturn nil, err\n}\n", x, info.bits)
+		quote()
+	case kindBytes:
+		g.printf("buf = jsoncodec.AppendBytes(buf, []byte(%s))\n", x)
+	case kindPtr:
+		if info.elem.kind == kindInterface || info.elem.kind == kindOther {
+			// 指针交给反射编码，指针接收者的 MarshalJSON 才会被调用
+			g.printf("if buf, err = jsoncodec.AppendValue(buf, %s); err != nil {\nreturn nil, err\n}\n", x)
+			return
+		}
+		checkNil()
+		enter("EnterPtr")
+		g.encode("*"+x, info.elem, quoted, false)
+		leave()
+		endCheck()
+	case kindSlice, kindArray:
+		if info.kind == kindArray {
+			nonNil = true
+		}
+		checkNil()
+		if info.kind == kindSlice {
+			enter("EnterSlice")
+		}
+		i, e := g.temp("i"), g.temp("e")
+		g.printf("buf = append(buf, '[')\n")
+		g.printf("for %s, %s := range %s {\n", i, e, x)
+		g.printf("if %s > 0 {\nbuf = append(buf, ',')\n}\n", i)
+		g.encode(e, info.elem, false, false)
+		g.printf("}\nbuf = append(buf, ']')\n")
+		if info.kind == kindSlice {
+			leave()
+		}
+		endCheck()
+	case kindMap:
+		// 键按字符串排序
+		g.use("sort")
+		keys, i, k, e := g.temp("keys"), g.temp("i"), g.temp("k"), g.temp("e")
+		checkNil()
+		enter("EnterMap")
+		g.printf("%s := make([]string, 0, len(%s))\n", keys, x)
+		g.printf("for %s := range %s {\n%s = append(%s, %s)\n}\n", k, x, keys, keys, k)
+		g.printf("sort.Strings(%s)\nbuf = append(buf, '{')\n", keys)
+		g.printf("for %s, %s := range %s {\n", i, k, keys)
+		g.printf("if %s > 0 {\nbuf = append(buf, ',')\n}\n", i)
+		g.printf("buf = jsoncodec.AppendString(buf, %s)\nbuf = append(buf, ':')\n", k)
+		g.printf("%s := %s[%s]\n", e, operand(x), k)
+		g.encode(e, info.elem, false, false)
+		g.printf("}\nbuf = append(buf, '}')\n")
+		leave()
+		endCheck()
+	case kindStruct:
+		g.printf("if buf, err = %s.appendJSON(buf, c); err != nil {\nreturn nil, err\n}\n", operand(x))
+	default:
+		g.printf("if buf, err = jsoncodec.AppendValue(buf, %s); err != nil {\nreturn nil, err\n}\n", x)
+	}
+}
+
+// 值中是否可能包含生成了方法的结构体，其他类型回退到反射实现，不会回到生成的代码中
+func mayCycle(info *typeInfo) bool {
+	if info.kind == kindStruct {
+		return true
+	}
+	return info.elem != nil && mayCycle(info.elem)
+}
+
+// 生成 UnmarshalJSON 和 decodeJSON
+func (g *generator) generateUnmarshal(name string, fields []structField) {
+	g.tmp = 0
+	names := lowerFirst(name) + "JSONFields"
+
+	g.printf("\n// UnmarshalJSON 实现 json.Unmarshaler\n")
+	g.printf("func (v *%s) UnmarshalJSON(data []byte) error {\n", name)
+	g.printf("t := jsoncodec.NewTokenizer(bytes.NewReader(data))\n")
+	g.printf("if err := v.decodeJSON(t); err != nil {\nreturn err\n}\nreturn t.End()\n}\n")
+
+	g.printf("\n// %s 参与解码的字段名\nvar %s = []string{", names, names)
+	for i, f := range fields {
+		if i > 0 {
+			g.printf(", ")
+		}
+		g.printf("%s", strconv.Quote(f.name))
+	}
+	g.printf("}\n")
+
+	g.printf("\n// 从 t 中读取一个值解码到 v，null 不修改 v，未知字段被忽略\n")
+	g.printf("func (v *%s) decodeJSON(t *jsoncodec.Tokenizer) error {\n", name)
+	g.printf("null, err := jsoncodec.ReadNull(t)\nif err != nil || null {\nreturn err\n}\n")
+	g.printf("if err = jsoncodec.ReadDelim(t, jsoncodec.ObjectStart, v); err != nil {\nreturn err\n}\n")
+	g.printf("for {\nkey, ok, err := jsoncodec.ReadKey(t)\nif err != nil {\nreturn err\n}\nif !ok {\nreturn nil\n}\n")
+	g.printf("switch jsoncodec.MatchField(key, %s) {\n", names)
+	for _, f := range fields {
+		g.printf("case %s:\n", strconv.Quote(f.name))
+		g.decode("v."+f.goName, f.info, f.quoted, "t")
+	}
+	g.printf("default:\nif err = t.Skip(); err != nil {\nreturn err\n}\n}\n}\n}\n")
+}
+
+// 生成从词法分析器 t 读取一个值解码到 x 的代码，x 必须可以取地址
+func (g *generator) decode(x string, info *typeInfo, quoted bool, t string) {
+	// 带有 string 选项的字段与反射实现共用同一套规则，处理字符串内容的空白、前导零和 null
+	if quoted {
+		g.printf("if err = jsoncodec.DecodeQuoted(%s, %s); err != nil {\nreturn err\n}\n", t, addr(x))
+		return
+	}
+
+	switch info.kind {
+	case kindStruct:
+		g.printf("if err = %s.decodeJSON(%s); err != nil {\nreturn err\n}\n", operand(x), t)
+		return
+	case kindInterface, kindOther:
+		g.printf("if err = jsoncodec.DecodeValue(%s, %s); err != nil {\nreturn err\n}\n", t, addr(x))
+		return
+	}
+
+	// null 把指针、切片和映射设为 nil，其他类型不变
+	null := g.temp("null")
+	g.printf("if %s, err := jsoncodec.ReadNull(%s); err != nil {\nreturn err\n}", null, t)
+	switch info.kind {
+	case kindPtr, kindBytes, kindSlice, kindMap:
+		g.printf(" else if %s {\n%s = nil\n} else {\n", null, x)
+	default:
+		g.printf(" else if !%s {\n", null)
+	}
+
+	g.decodeNonNull(x, info, t)
+	g.printf("}\n")
+}
+
+// 生成读取已知不是 null 的值的代码
+func (g *generator) decodeNonNull(x string, info *typeInfo, t string) {
+	switch {
+	case info.kind == kindStruct || info.kind == kindInterface || info.kind == kindOther:
+		g.decode(x, info, false, t)
+	default:
+		g.decodeValue(x, info, t)
+	}
+}
+
+// 生成读取非 null 值的代码
+func (g *generator) decodeValue(x string, info *typeInfo, t string) {
+	read := map[kind]string{
+		kindString: "ReadString",
+		kindBool:   "ReadBool",
+		kindInt:    "ReadInt",
+		kindUint:   "ReadUint",
+		kindFloat:  "ReadFloat",
+		kindBytes:  "ReadBytes",
+	}
+	if fn, ok := read[info.kind]; ok {
+		g.printf("if err = jsoncodec.%s(%s, %s); err != nil {\nreturn err\n}\n", fn, t, addr(x))
+		return
+	}
+
+	switch info.kind {
+	case kindPtr:
+		g.printf("if %s == nil {\n%s = new(%s)\n}\n", x, x, g.typeString(info.elem.expr))
+		g.decodeNonNull("*"+x, info.elem, t)
+	case kindSlice:
+		// 先清空再逐个追加零值，解码到新追加的元素中
+		g.printf("if err = jsoncodec.ReadDelim(%s, jsoncodec.ArrayStart, %s); err != nil {\nreturn err\n}\n", t, addr(x))
+		zero, more := g.temp("zero"), g.temp("more")
+		g.printf("var %s %s\n%s = %s[:0]\nfor {\n", zero, g.typeString(info.elem.expr), x, x)
+		g.printf("%s, err := jsoncodec.More(%s)\nif err != nil {\nreturn err\n}\nif !%s {\nbreak\n}\n", more, t, more)
+		g.printf("%s = append(%s, %s)\n", x, x, zero)
+		g.decode(operand(x)+"[len("+x+")-1]", info.elem, false, t)
+		g.printf("}\nif %s == nil {\n%s = %s{}\n}\n", x, x, g.typeString(info.expr))
+	case kindArray:
+		// 多出的元素丢弃，不足的部分设为零值
+		g.printf("if err = jsoncodec.ReadDelim(%s, jsoncodec.ArrayStart, %s); err != nil {\nreturn err\n}\n", t, addr(x))
+		i, more := g.temp("i"), g.temp("more")
+		g.printf("%s := 0\nfor ; ; %s++ {\n", i, i)
+		g.printf("%s, err := jsoncodec.More(%s)\nif err != nil {\nreturn err\n}\nif !%s {\nbreak\n}\n", more, t, more)
+		g.printf("if %s >= len(%s) {\nif err = %s.Skip(); err != nil {\nreturn err\n}\ncontinue\n}\n", i, x, t)
+		g.decode(operand(x)+"["+i+"]", info.elem, false, t)
+		g.printf("}\nfor ; %s < len(%s); %s++ {\n%s[%s] = *new(%s)\n}\n", i, x, i, operand(x), i, g.typeString(info.elem.expr))
+	case kindMap:
+		// 每个值都解码到新的零值中
+		g.printf("if err = jsoncodec.ReadDelim(%s, jsoncodec.ObjectStart, %s); err != nil {\nreturn err\n}\n", t, addr(x))
+		g.printf("if %s == nil {\n%s = make(%s)\n}\n", x, x, g.typeString(info.expr))
+		k, ok, e := g.temp("k"), g.temp("ok"), g.temp("e")
+		g.printf("for {\n%s, %s, err := jsoncodec.ReadKey(%s)\nif err != nil {\nreturn err\n}\nif !%s {\nbreak\n}\n", k, ok, t, ok)
+		g.printf("var %s %s\n", e, g.typeString(info.elem.expr))
+		g.decode(e, info.elem, false, t)
+		g.printf("%s[%s] = %s\n}\n", operand(x), k, e)
+	}
+}
+
+// 类型表达式的源码，记录用到的其他包
+func (g *generator) typeString(expr ast.Expr) string {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok {
+				if importPath, ok := g.imports[pkg.Name]; ok {
+					g.used[importPath] = true
+				}
+			}
+		}
+		return true
+	})
+	return types.ExprString(expr)
+}
+
+// 解引用表达式作为索引或方法调用的操作数时需要加括号
+func operand(x string) string {
+	if strings.HasPrefix(x, "*") {
+		return "(" + x + ")"
+	}
+	return x
+}
+
+// 取地址，*p 直接写为 p
+func addr(x string) string {
+	if strings.HasPrefix(x, "*") {
+		return x[1:]
+	}
+	return "&" + x
+}
+
+// 记录用到的标准库包
+func (g *generator) use(importPath string) {
+	g.used[importPath] = true
+}
+
+// 生成不重复的临时变量名
+func (g *generator) temp(prefix string) string {
+	g.tmp++
+	return prefix + strconv.Itoa(g.tmp)
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// 标签选项中是否包含 name
+func hasOption(opts, name string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 标签中的字段名是否合法，规则与 jsoncodec 相同
+func isValidTag(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
+
+// 首字母小写
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"code-snippet/code/006/jsoncodec"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 对比 jsoncodec 与 encoding/json 的编码结果：
+//
+//	go run ./code/006/jsoncodec/parity
+//
+// jsoncodec 以 encoding/json 原有的实现为准，默认启用 jsonv2 实验的 Go 版本需要关闭该实验：
+//
+//	GOEXPERIMENT=nojsonv2 go run ./code/006/jsoncodec/parity
+
+// 测试用例
+type testCase struct {
+	name  string
+	value interface{}
+}
+
+// 基本标签
+type Tagged struct {
+	Renamed    string         `json:"renamed"`
+	Omitted    string         `json:"-"`
+	Dash       string         `json:"-,"`
+	Empty      string         `json:",omitempty"`
+	ZeroInt    int            `json:"zero_int,omitempty"`
+	ZeroPtr    *int           `json:"zero_ptr,omitempty"`
+	ZeroSlice  []int          `json:"zero_slice,omitempty"`
+	ZeroMap    map[string]int `json:"zero_map,omitempty"`
+	Quoted     int            `json:"quoted,string"`
+	QuotedStr  string         `json:"quoted_str,string"`
+	QuotedPtr  *float64       `json:"quoted_ptr,string"`
+	QuotedBad  []int          `json:"quoted_bad,string"`
+	Invalid    string         `json:"in\"valid"`
+	Special    string         `json:"<tag>&"`
+	unexported string
+}
+
+// omitzero 使用 IsZero 方法
+type Zeroes struct {
+	Time   time.Time       `json:"time,omitzero"`
+	Struct struct{ A int } `json:"struct,omitzero"`
+	Array  [2]int          `json:"array,omitzero"`
+	Both   []int           `json:"both,omitempty,omitzero"`
+	Custom custom          `json:"custom,omitzero"`
+}
+
+// 值为 1 时视为零值
+type custom int
+
+func (c custom) IsZero() bool {
+	return c == 1
+}
+
+// 嵌入结构体
+type Base struct {
+	ID   int
+	Name string
+}
+
+type inner struct {
+	Hidden  string
+	Visible string `json:"visible"`
+}
+
+type Other struct {
+	Name string
+	Note string
+}
+
+type Embedded struct {
+	Base
+	*Other
+	inner
+	Name  string // 层级更浅，覆盖 Base.Name 和 Other.Name
+	Extra string
+}
+
+type Conflict struct {
+	Base
+	Other // Base.Name 和 Other.Name 层级相同，两者都被丢弃
+}
+
+type TaggedConflict struct {
+	A struct {
+		X int `json:"x"`
+	}
+	B
+	C
+}
+
+type B struct {
+	X int `json:"x"`
+}
+
+type C struct {
+	X int
+}
+
+type EmbeddedTagged struct {
+	Base `json:"base"`
+}
+
+type EmbeddedPtr struct {
+	*Base
+	Tail int
+}
+
+// 自定义编码
+type marshaler struct {
+	Data string
+}
+
+func (m marshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`{ "data" : "` + m.Data + `", "html": "<b>" }`), nil
+}
+
+type ptrMarshaler struct {
+	N int
+}
+
+func (m *ptrMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%d]", m.N)), nil
+}
+
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`{bad`), nil
+}
+
+type failMarshaler struct{}
+
+func (failMarshaler) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("fail")
+}
+
+type textKey struct {
+	A, B string
+}
+
+func (k textKey) MarshalText() ([]byte, error) {
+	return []byte(k.A + "-" + k.B), nil
+}
+
+type textValue int
+
+func (v *textValue) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("<%d>", *v)), nil
+}
+
+type Holder struct {
+	Value    marshaler
+	Ptr      ptrMarshaler
+	PtrNil   *ptrMarshaler
+	Text     textValue
+	Iface    json.Marshaler
+	Time     time.Time
+	TimePtr  *time.Time
+	Duration time.Duration
+	IP       net.IP
+}
+
+// 自定义字节类型的切片不按 base64 编码
+type myByte byte
+
+func (b myByte) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"%02x"`, byte(b))), nil
+}
+
+// 循环引用
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+type Unsupported struct {
+	Ch chan int
+}
+
+func main() {
+	// 基于 jsonv2 的实现把非法 UTF-8 直接替换为 U+FFFD 字符，原有实现输出 \ufffd
+	if data, _ := json.Marshal("\xff"); !bytes.Equal(data, []byte(`"\ufffd"`)) {
+		fmt.Fprintln(os.Stderr, "encoding/json is built with GOEXPERIMENT=jsonv2, rerun with GOEXPERIMENT=nojsonv2")
+		os.Exit(2)
+	}
+
+	n := 42
+	f := 3.5
+	now := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	holder := &Holder{Value: marshaler{"x"}, Ptr: ptrMarshaler{7}, Text: 9, Time: now, TimePtr: &now, Duration: time.Second, IP: net.IPv4(127, 0, 0, 1)}
+	cycle := &Node{Value: 1}
+	cycle.Next = &Node{Value: 2, Next: cycle}
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["self"] = cyclicMap
+	cyclicSlice := []interface{}{nil}
+	cyclicSlice[0] = cyclicSlice
+	shared := &Base{ID: 1}
+
+	cases := []testCase{
+		// 基本类型
+		{"nil", nil},
+		{"true", true},
+		{"false", false},
+		{"int", -12345},
+		{"int8", int8(-128)},
+		{"int64 min", int64(math.MinInt64)},
+		{"uint", uint(42)},
+		{"uint64 max", uint64(math.MaxUint64)},
+		{"uintptr", uintptr(7)},
+		{"uint8", uint8(255)},
+		{"float zero", 0.0},
+		{"negative zero", math.Copysign(0, -1)},
+		{"float", 3.14159},
+		{"float big", 1e21},
+		{"float almost big", 1e20},
+		{"float small", 1e-7},
+		{"float almost small", 1e-6},
+		{"float max", math.MaxFloat64},
+		{"float smallest", math.SmallestNonzeroFloat64},
+		{"float32", float32(3.14)},
+		{"float32 small", float32(1e-7)},
+		{"float32 big", float32(1e21)},
+		{"NaN", math.NaN()},
+		{"Inf", math.Inf(1)},
+		{"-Inf", math.Inf(-1)},
+		{"complex", complex(1, 2)},
+		{"chan", make(chan int)},
+		{"func", func() {}},
+
+		// 字符串转义
+		{"string", "hello"},
+		{"empty string", ""},
+		{"quotes", `"quoted" \ back\slash`},
+		{"control", "\x00\x01\b\f\n\r\t\x1f\x7f"},
+		{"html", "<script>alert('x') && 1</script>"},
+		{"unicode", "中文 émoji 😀"},
+		{"line separators", "a\u2028b\u2029c"},
+		{"invalid utf8", "a\xffb\xc3(c\xed\xa0\x80"},
+		{"json.Number", json.Number("12.5e-3")},
+		{"json.Number empty", json.Number("")},
+		{"json.Number invalid", json.Number("12a")},
+
+		// 切片、数组和映射
+		{"nil slice", []int(nil)},
+		{"empty slice", []int{}},
+		{"slice", []int{1, 2, 3}},
+		{"byte slice", []byte("hello, world\x00\xff")},
+		{"nil byte slice", []byte(nil)},
+		{"byte array", [4]byte{1, 2, 3, 4}},
+		{"array", [3]string{"a", "b", "c"}},
+		{"empty array", [0]int{}},
+		{"custom byte slice", []myByte{1, 2}},
+		{"nested slice", [][]interface{}{{1, "a"}, {nil, true}}},
+		{"nil map", map[string]int(nil)},
+		{"empty map", map[string]int{}},
+		{"map sorted", map[string]int{"b": 2, "a": 1, "c": 3, "A": 0, "": -1}},
+		{"map escaped keys", map[string]string{"<k>": "v", "a\"b": "c", "\n": "nl"}},
+		{"map int keys", map[int]string{10: "ten", 2: "two", -1: "minus"}},
+		{"map uint keys", map[uint8]bool{200: true, 3: false}},
+		{"map text keys", map[textKey]int{{"b", "1"}: 1, {"a", "2"}: 2}},
+		{"map struct keys", map[Base]int{{}: 1}},
+		{"map float keys", map[float64]int{1.5: 1}},
+		{"map interface values", map[string]interface{}{"n": nil, "f": 1.5, "s": []string{"x"}}},
+
+		// 指针和接口
+		{"pointer", &n},
+		{"pointer to pointer", func() **int { p := &n; return &p }()},
+		{"nil pointer", (*int)(nil)},
+		{"interface slice", []interface{}{1, "two", 3.0, nil, &f}},
+
+		// 结构体
+		{"tagged", Tagged{Renamed: "r", Omitted: "o", Dash: "d", Quoted: 5, QuotedStr: `a"b<`, QuotedPtr: &f, QuotedBad: []int{1}, Invalid: "i", Special: "s", unexported: "u"}},
+		{"tagged zero", Tagged{}},
+		{"omitzero", Zeroes{}},
+		{"omitzero set", Zeroes{Time: now, Array: [2]int{0, 1}, Both: []int{}, Custom: 1}},
+		{"omitzero custom", Zeroes{Custom: 2}},
+		{"embedded", Embedded{Base: Base{1, "base"}, Other: &Other{"other", "note"}, inner: inner{"h", "v"}, Name: "top"}},
+		{"embedded nil pointer", Embedded{Name: "top"}},
+		{"conflict", Conflict{Base{1, "b"}, Other{"o", "n"}}},
+		{"tagged conflict", TaggedConflict{B: B{1}, C: C{2}}},
+		{"embedded tagged", EmbeddedTagged{Base{1, "b"}}},
+		{"embedded ptr", EmbeddedPtr{Base: &Base{2, "p"}, Tail: 3}},
+		{"anonymous struct", struct {
+			A int
+			B []string `json:"b"`
+		}{1, nil}},
+		{"shared pointer is not a cycle", []*Base{shared, shared}},
+
+		// 自定义编码
+		{"marshalers by pointer", holder},
+		{"marshalers by value", *holder},
+		{"bad marshaler", badMarshaler{}},
+		{"failing marshaler", []interface{}{failMarshaler{}}},
+		{"time", now},
+
+		// 循环引用和不支持的类型
+		{"cyclic pointer", cycle},
+		{"cyclic map", cyclicMap},
+		{"cyclic slice", cyclicSlice},
+		{"unsupported field", Unsupported{}},
+	}
+
+	failed := 0
+	for _, c := range cases {
+		if err := compare(c.value); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d cases failed\n", failed, len(cases))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d cases match encoding/json\n", len(cases))
+}
+
+// 两者都返回错误，或者输出完全相同
+func compare(value interface{}) error {
+	want, wantErr := json.Marshal(value)
+	got, gotErr := jsoncodec.MarshalJSON(value)
+
+	switch {
+	case wantErr != nil && gotErr != nil:
+		// 错误信息除了前缀以外应该相同
+		if w, g := strings.TrimPrefix(wantErr.Error(), "json: "), strings.TrimPrefix(gotErr.Error(), "jsoncodec: "); w != g {
+			return fmt.Errorf("error %q, want %q", g, w)
+		}
+		return nil
+	case wantErr != nil:
+		return fmt.Errorf("got %s, want error %q", got, wantErr)
+	case gotErr != nil:
+		return fmt.Errorf("got error %q, want %s", gotErr, want)
+	case !bytes.Equal(got, want):
+		return fmt.Errorf("\n  got  %s\n  want %s", got, want)
+	}
+	return nil
+}
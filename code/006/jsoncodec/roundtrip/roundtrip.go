@@ -0,0 +1,371 @@
+package main
+
+import (
+	"code-snippet/code/006/jsoncodec"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// 检查 jsoncodec 的解码结果：
+//
+//	GOEXPERIMENT=nojsonv2 go run ./code/006/jsoncodec/roundtrip
+
+// 技能
+type Skill struct {
+	Name  string `json:"name"`
+	Level int    `json:"level,string"`
+}
+
+// 角色
+type Actor struct {
+	Name string `json:"name"`
+	Age  int    `json:"age,omitempty"`
+
+	Skills []Skill `json:"skills"`
+
+	Born     time.Time         `json:"born"`
+	Nickname *string           `json:"nickname"`
+	Extra    map[string]string `json:"extra,omitempty"`
+	Weapons  [2]string         `json:"weapons"`
+	Score    float64           `json:"score"`
+	Avatar   []byte            `json:"avatar"`
+	Friends  []*Actor          `json:"friends,omitempty"`
+	password string
+}
+
+type Base struct {
+	ID   int64
+	Name string
+}
+
+type Embedded struct {
+	Base
+	*Detail
+	Name string `json:"name"`
+}
+
+type Detail struct {
+	Note string `json:"note"`
+}
+
+type Quoted struct {
+	Int    int      `json:"int,string"`
+	Bool   bool     `json:"bool,string"`
+	Float  *float64 `json:"float,string"`
+	String string   `json:"string,string"`
+	Uint   uint8    `json:"uint,string"`
+}
+
+// 键实现了 encoding.TextUnmarshaler
+type Point struct {
+	X, Y int
+}
+
+func (p *Point) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d:%d", &p.X, &p.Y)
+	return err
+}
+
+// 自定义解码
+type Celsius float64
+
+func (c *Celsius) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	_, err := fmt.Sscanf(s, "%gC", (*float64)(c))
+	return err
+}
+
+// 测试用例
+type testCase struct {
+	name string
+	run  func() error
+}
+
+func main() {
+	// 基于 jsonv2 的 encoding/json 错误信息和部分行为不同
+	if data, _ := json.Marshal("\xff"); string(data) != `"\ufffd"` {
+		fmt.Fprintln(os.Stderr, "encoding/json is built with GOEXPERIMENT=jsonv2, rerun with GOEXPERIMENT=nojsonv2")
+		os.Exit(2)
+	}
+
+	nickname := "cb"
+	actor := Actor{
+		Name: "cow boy",
+		Age:  37,
+		Skills: []Skill{
+			{Name: "Roll and roll", Level: 1},
+			{Name: "Flash your dog eye", Level: 2},
+			{Name: "Time to have Lunch", Level: 3},
+		},
+		Born:     time.Date(1982, 5, 1, 8, 30, 0, 0, time.UTC),
+		Nickname: &nickname,
+		Extra:    map[string]string{"hat": "<big>", "horse": "Bullseye"},
+		Weapons:  [2]string{"lasso", " "},
+		Score:    98.5e-9,
+		Avatar:   []byte{0, 1, 2, 0xff},
+		Friends:  []*Actor{{Name: "Woody", Skills: []Skill{}}},
+	}
+
+	cases := []testCase{
+		// 往返
+		{"round trip Actor", roundTrip(&actor, new(Actor))},
+		{"round trip empty Actor", roundTrip(&Actor{}, new(Actor))},
+		{"round trip Skill", roundTrip(&Skill{Name: "\"quoted\" \\ \n", Level: -7}, new(Skill))},
+		{"round trip []Skill", roundTrip(&actor.Skills, new([]Skill))},
+		{"round trip map", roundTrip(&map[string][]int{"a": {1}, "b": nil}, new(map[string][]int))},
+
+		// 与 encoding/json 对比
+		{"Actor", sameAsStd(`{"name":"x","age":3,"skills":[{"name":"s","level":"9"}],"born":"2001-02-03T04:05:06Z","nickname":null,"unknown":{"a":[1,2]}}`, func() interface{} { return new(Actor) })},
+		{"case insensitive names", sameAsStd(`{"NAME":"x","Skills":[{"Name":"s","LEVEL":"1"}]}`, func() interface{} { return new(Actor) })},
+		{"exact name wins", sameAsStd(`{"name":"exact","NAME":"fold"}`, func() interface{} { return new(Skill) })},
+		{"embedded", sameAsStd(`{"ID":5,"Name":"base","name":"top","note":"n"}`, func() interface{} { return new(Embedded) })},
+		{"quoted", sameAsStd(`{"int":"12","bool":"true","float":"1.5","string":"\"s\""}`, func() interface{} { return new(Quoted) })},
+		{"quoted null", sameAsStd(`{"int":null,"float":null}`, func() interface{} { return &Quoted{Int: 1} })},
+		{"quoted bad", sameAsStd(`{"int":"x"}`, func() interface{} { return new(Quoted) })},
+		{"quoted unquoted", sameAsStd(`{"int":12}`, func() interface{} { return new(Quoted) })},
+		{"quoted leading space", sameAsStd(`{"int":" 7"}`, func() interface{} { return new(Quoted) })},
+		{"quoted trailing space", sameAsStd(`{"int":"7 "}`, func() interface{} { return new(Quoted) })},
+		{"quoted leading zero", sameAsStd(`{"int":"07","uint":"007","float":"-07.5"}`, func() interface{} { return new(Quoted) })},
+		{"quoted exponent into int", sameAsStd(`{"int":"1e2"}`, func() interface{} { return new(Quoted) })},
+		{"quoted plus sign", sameAsStd(`{"int":"+1"}`, func() interface{} { return new(Quoted) })},
+		{"quoted empty", sameAsStd(`{"int":""}`, func() interface{} { return new(Quoted) })},
+		{"quoted uint overflow", sameAsStd(`{"uint":"300"}`, func() interface{} { return new(Quoted) })},
+		{"quoted float spaces", sameAsStd(`{"float":" 1.5"}`, func() interface{} { return new(Quoted) })},
+		{"quoted inner null", sameAsStd(`{"float":"null","bool":"null","string":"null"}`, func() interface{} { return new(Quoted) })},
+		{"quoted bad null", sameAsStd(`{"float":"nul"}`, func() interface{} { return new(Quoted) })},
+		{"quoted bool spaces", sameAsStd(`{"bool":" true"}`, func() interface{} { return new(Quoted) })},
+		{"quoted bool trailing", sameAsStd(`{"bool":"true "}`, func() interface{} { return new(Quoted) })},
+		{"quoted bool number", sameAsStd(`{"bool":"1"}`, func() interface{} { return new(Quoted) })},
+		{"quoted string spaces", sameAsStd(`{"string":" \"s\""}`, func() interface{} { return new(Quoted) })},
+		{"quoted string trailing", sameAsStd(`{"string":"\"s\" "}`, func() interface{} { return new(Quoted) })},
+		{"quoted string unquoted", sameAsStd(`{"string":"s"}`, func() interface{} { return new(Quoted) })},
+		{"quoted string into int", sameAsStd(`{"int":"\"1\""}`, func() interface{} { return new(Quoted) })},
+		{"quoted number into string", sameAsStd(`{"string":"1"}`, func() interface{} { return new(Quoted) })},
+		{"interface", sameAsStd(`{"a":[1,"x",true,null,{"b":1.5e3}],"c":{}}`, func() interface{} { return new(interface{}) })},
+		{"slice reuse", sameAsStd(`[4,5]`, func() interface{} { return &[]int{1, 2, 3} })},
+		{"empty slice", sameAsStd(`[]`, func() interface{} { return new([]int) })},
+		{"array longer", sameAsStd(`[1,2,3]`, func() interface{} { return new([2]int) })},
+		{"array shorter", sameAsStd(`[1]`, func() interface{} { return &[3]int{7, 8, 9} })},
+		{"map merge", sameAsStd(`{"b":2}`, func() interface{} { return &map[string]int{"a": 1} })},
+		{"map int keys", sameAsStd(`{"1":"a","-2":"b"}`, func() interface{} { return new(map[int]string) })},
+		{"map bad int key", sameAsStd(`{"x":"a"}`, func() interface{} { return new(map[int]string) })},
+		{"map text keys", sameAsStd(`{"1:2":"a"}`, func() interface{} { return new(map[Point]string) })},
+		{"null", sameAsStd(`{"nickname":null,"skills":null,"extra":null,"name":null}`, func() interface{} { return &actor })},
+		{"pointer", sameAsStd(`5`, func() interface{} { return new(*int) })},
+		{"bytes", sameAsStd(`"AAEC/w=="`, func() interface{} { return new([]byte) })},
+		{"bad base64", sameAsStd(`"!!"`, func() interface{} { return new([]byte) })},
+		{"unicode escapes", sameAsStd(`"é😀\ud800x\/\b\f\n\r\t"`, func() interface{} { return new(string) })},
+		{"lone surrogate pair", sameAsStd(`"\ud800A"`, func() interface{} { return new(string) })},
+		{"invalid utf8", sameAsStd("\"a\xffb\"", func() interface{} { return new(string) })},
+		{"number into string", sameAsStd(`1`, func() interface{} { return new(string) })},
+		{"string into int", sameAsStd(`"1"`, func() interface{} { return new(int) })},
+		{"float into int", sameAsStd(`1.5`, func() interface{} { return new(int) })},
+		{"int8 overflow", sameAsStd(`128`, func() interface{} { return new(int8) })},
+		{"negative uint", sameAsStd(`-1`, func() interface{} { return new(uint) })},
+		{"float32 overflow", sameAsStd(`1e39`, func() interface{} { return new(float32) })},
+		{"json.Number", sameAsStd(`-1.5e10`, func() interface{} { return new(json.Number) })},
+		{"custom unmarshaler", sameAsStd(`["21.5C",null]`, func() interface{} { return new([]Celsius) })},
+		{"unmarshaler error", sameAsStd(`[21]`, func() interface{} { return new([]Celsius) })},
+		{"time", sameAsStd(`"2020-01-02T03:04:05.000000006Z"`, func() interface{} { return new(time.Time) })},
+		{"object into slice", sameAsStd(`{"a":1}`, func() interface{} { return new([]int) })},
+		{"array into struct", sameAsStd(`[1]`, func() interface{} { return new(Skill) })},
+		{"trailing comma", sameAsStd(`[1,]`, func() interface{} { return new([]int) })},
+		{"trailing data", sameAsStd(`{} {}`, func() interface{} { return new(map[string]int) })},
+		{"unterminated", sameAsStd(`{"a":`, func() interface{} { return new(map[string]int) })},
+		{"empty input", sameAsStd(``, func() interface{} { return new(interface{}) })},
+		{"leading zero", sameAsStd(`[01]`, func() interface{} { return new([]int) })},
+		{"control character", sameAsStd("\"a\tb\"", func() interface{} { return new(string) })},
+		{"syntax error keeps value", sameAsStd(`{"name":"changed",}`, func() interface{} { return &Skill{Name: "kept"} })},
+		{"not a pointer", func() error {
+			if err := jsoncodec.UnmarshalJSON([]byte(`1`), 1); err == nil {
+				return errors.New("no error")
+			}
+			return nil
+		}},
+
+		// 精确的整数
+		{"exact int64", expectValue(`9007199254740993`, new(int64), int64(9007199254740993))},
+		{"max uint64", expectValue(`18446744073709551615`, new(uint64), uint64(math.MaxUint64))},
+		{"UseNumber", func() error {
+			decoder := jsoncodec.NewDecoder(strings.NewReader(`{"n":9007199254740993}`))
+			decoder.UseNumber()
+			var v map[string]interface{}
+			if err := decoder.Decode(&v); err != nil {
+				return err
+			}
+			if n := v["n"]; n != json.Number("9007199254740993") {
+				return fmt.Errorf("got %#v", n)
+			}
+			return nil
+		}},
+
+		// 出错位置
+		{"syntax error position", expectError("{\n  \"a\": 1,\n  \"b\": tru\n}", new(interface{}),
+			"jsoncodec: invalid character '\\n' in literal true (expecting 'e') at line 3, column 11")},
+		{"type error position", expectError("{\n  \"name\": \"x\",\n  \"skills\": [{\"level\": \"x\"}, {\"name\": 1}]\n}", new(Actor),
+			"jsoncodec: invalid use of ,string struct tag, trying to unmarshal \"x\" into int at line 3, column 24")},
+		{"field path", expectError("{\"skills\": [{\"name\": 1}]}", new(Actor),
+			"jsoncodec: cannot unmarshal number into Go struct field skills.name of type string at line 1, column 22")},
+		{"column counts characters", expectError(`{"名字":"值", "age": "x"}`, new(Actor),
+			"jsoncodec: cannot unmarshal string into Go struct field age of type int at line 1, column 19")},
+		{"DisallowUnknownFields", func() error {
+			decoder := jsoncodec.NewDecoder(strings.NewReader("{\"name\": \"x\",\n \"level\": \"1\", \"power\": 9}"))
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(new(Skill))
+			want := "jsoncodec: unknown field \"power\" in main.Skill at line 2, column 16"
+			if err == nil || err.Error() != want {
+				return fmt.Errorf("error %v, want %q", err, want)
+			}
+			return nil
+		}},
+
+		// 流式解码
+		{"stream", func() error {
+			decoder := jsoncodec.NewDecoder(strings.NewReader(`{"name":"a","level":"1"} {"name":"b","level":"2"}` + "\n" + `{"name":"c","level":"3"}`))
+			var names []string
+			for {
+				var skill Skill
+				err := decoder.Decode(&skill)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				names = append(names, skill.Name)
+			}
+			if strings.Join(names, ",") != "a,b,c" {
+				return fmt.Errorf("got %v", names)
+			}
+			return nil
+		}},
+		{"tokens", func() error {
+			tokenizer := jsoncodec.NewTokenizer(strings.NewReader(`{"a": [1, "x", null]}`))
+			var kinds []string
+			for {
+				token, err := tokenizer.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				kinds = append(kinds, token.Kind.String())
+			}
+			if got := strings.Join(kinds, " "); got != "{ key [ number string null ] }" {
+				return fmt.Errorf("got %s", got)
+			}
+			return nil
+		}},
+	}
+
+	failed := 0
+	for _, c := range cases {
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d cases failed\n", failed, len(cases))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d cases passed\n", len(cases))
+}
+
+// 编码后再解码，结果应与原值相同，再次编码的结果也应相同
+func roundTrip(value, target interface{}) func() error {
+	return func() error {
+		data, err := jsoncodec.MarshalJSON(value)
+		if err != nil {
+			return err
+		}
+		if err = jsoncodec.UnmarshalJSON(data, target); err != nil {
+			return err
+		}
+
+		// 未导出字段不参与编解码
+		if actor, ok := value.(*Actor); ok {
+			copied := *actor
+			copied.password = ""
+			value = &copied
+		}
+		if !reflect.DeepEqual(value, target) {
+			return fmt.Errorf("\n  got  %#v\n  want %#v", target, value)
+		}
+
+		again, err := jsoncodec.MarshalJSON(target)
+		if err != nil {
+			return err
+		}
+		if string(again) != string(data) {
+			return fmt.Errorf("\n  got  %s\n  want %s", again, data)
+		}
+		return nil
+	}
+}
+
+// 解码结果与 encoding/json 相同，出错时两者都出错并且同为或同不为类型错误，语法错误时两者都不修改目标
+func sameAsStd(input string, newTarget func() interface{}) func() error {
+	return func() error {
+		want, got := newTarget(), newTarget()
+		wantErr := json.Unmarshal([]byte(input), want)
+		gotErr := jsoncodec.UnmarshalJSON([]byte(input), got)
+
+		switch {
+		case wantErr != nil && gotErr == nil:
+			return fmt.Errorf("got %#v, want error %q", got, wantErr)
+		case wantErr == nil && gotErr != nil:
+			return fmt.Errorf("got error %q, want %#v", gotErr, want)
+		case wantErr != nil && isTypeError(wantErr) != isTypeError(gotErr):
+			return fmt.Errorf("got error %q, want %q", gotErr, wantErr)
+		case wantErr != nil && !errors.As(wantErr, new(*json.SyntaxError)):
+			// 类型错误时 encoding/json 会继续解码其他字段，jsoncodec 立即停止，不比较结果
+		case !reflect.DeepEqual(got, want):
+			return fmt.Errorf("\n  got  %#v\n  want %#v", got, want)
+		}
+		return nil
+	}
+}
+
+// 类型错误，自定义的 UnmarshalJSON 可能返回 encoding/json 的类型错误
+func isTypeError(err error) bool {
+	return errors.As(err, new(*json.UnmarshalTypeError)) || errors.As(err, new(*jsoncodec.UnmarshalTypeError))
+}
+
+// 解码结果为指定值
+func expectValue(input string, target interface{}, want interface{}) func() error {
+	return func() error {
+		if err := jsoncodec.UnmarshalJSON([]byte(input), target); err != nil {
+			return err
+		}
+		if got := reflect.ValueOf(target).Elem().Interface(); got != want {
+			return fmt.Errorf("got %v, want %v", got, want)
+		}
+		return nil
+	}
+}
+
+// 解码出错，错误信息与预期相同
+func expectError(input string, target interface{}, want string) func() error {
+	return func() error {
+		err := jsoncodec.UnmarshalJSON([]byte(input), target)
+		if err == nil || err.Error() != want {
+			return fmt.Errorf("error %v\n  want %q", err, want)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,32 @@
+package sample
+
+import "time"
+
+// 与 struct-save-json-data.go 中相同的角色结构，MarshalJSON 和 UnmarshalJSON 由 jsongen 生成
+//go:generate go run code-snippet/code/006/jsoncodec/jsongen -type Actor,Skill
+
+// 技能等级
+type Level int
+
+// 声明技能结构
+type Skill struct {
+	Name  string `json:"name"`
+	Level Level  `json:"level,string"`
+}
+
+// 声明角色结构
+type Actor struct {
+	Name string `json:"name"`
+	Age  int    `json:"age,omitempty"`
+
+	Skills []Skill `json:"skills"`
+
+	Born     time.Time         `json:"born"`
+	Nickname *string           `json:"nickname"`
+	Extra    map[string]string `json:"extra,omitempty"`
+	Score    float64           `json:"score"`
+	Weapons  [2]string         `json:"weapons"`
+	Avatar   []byte            `json:"avatar,omitempty"`
+	Friends  []*Actor          `json:"friends,omitempty"`
+	password string
+}
@@ -0,0 +1,407 @@
+// Code generated by jsongen; DO NOT EDIT.
+
+package sample
+
+import (
+	"bytes"
+	"code-snippet/code/006/jsoncodec"
+	"sort"
+	"strconv"
+)
+
+// MarshalJSON 实现 json.Marshaler，输出与 jsoncodec.MarshalJSON 相同
+func (v Actor) MarshalJSON() ([]byte, error) {
+	return v.appendJSON(nil, new(jsoncodec.Cycles))
+}
+
+// 把 v 编码后追加到 buf，c 记录当前路径上的指针、切片和映射
+func (v Actor) appendJSON(buf []byte, c *jsoncodec.Cycles) ([]byte, error) {
+	var err error
+	buf = append(buf, '{')
+	buf = append(buf, "\"name\":"...)
+	buf = jsoncodec.AppendString(buf, string(v.Name))
+	if v.Age != 0 {
+		buf = append(buf, ",\"age\":"...)
+		buf = strconv.AppendInt(buf, int64(v.Age), 10)
+	}
+	buf = append(buf, ",\"skills\":"...)
+	if v.Skills == nil {
+		buf = append(buf, "null"...)
+	} else {
+		if err = jsoncodec.EnterSlice(c, v.Skills); err != nil {
+			return nil, err
+		}
+		buf = append(buf, '[')
+		for i1, e2 := range v.Skills {
+			if i1 > 0 {
+				buf = append(buf, ',')
+			}
+			if buf, err = e2.appendJSON(buf, c); err != nil {
+				return nil, err
+			}
+		}
+		buf = append(buf, ']')
+		c.Leave()
+	}
+	buf = append(buf, ",\"born\":"...)
+	if buf, err = jsoncodec.AppendValue(buf, v.Born); err != nil {
+		return nil, err
+	}
+	buf = append(buf, ",\"nickname\":"...)
+	if v.Nickname == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = jsoncodec.AppendString(buf, string(*v.Nickname))
+	}
+	if len(v.Extra) != 0 {
+		buf = append(buf, ",\"extra\":"...)
+		keys3 := make([]string, 0, len(v.Extra))
+		for k5 := range v.Extra {
+			keys3 = append(keys3, k5)
+		}
+		sort.Strings(keys3)
+		buf = append(buf, '{')
+		for i4, k5 := range keys3 {
+			if i4 > 0 {
+				buf = append(buf, ',')
+			}
+			buf = jsoncodec.AppendString(buf, k5)
+			buf = append(buf, ':')
+			e6 := v.Extra[k5]
+			buf = jsoncodec.AppendString(buf, string(e6))
+		}
+		buf = append(buf, '}')
+	}
+	buf = append(buf, ",\"score\":"...)
+	if buf, err = jsoncodec.AppendFloat(buf, float64(v.Score), 64); err != nil {
+		return nil, err
+	}
+	buf = append(buf, ",\"weapons\":"...)
+	buf = append(buf, '[')
+	for i7, e8 := range v.Weapons {
+		if i7 > 0 {
+			buf = append(buf, ',')
+		}
+		buf = jsoncodec.AppendString(buf, string(e8))
+	}
+	buf = append(buf, ']')
+	if len(v.Avatar) != 0 {
+		buf = append(buf, ",\"avatar\":"...)
+		buf = jsoncodec.AppendBytes(buf, []byte(v.Avatar))
+	}
+	if len(v.Friends) != 0 {
+		buf = append(buf, ",\"friends\":"...)
+		if err = jsoncodec.EnterSlice(c, v.Friends); err != nil {
+			return nil, err
+		}
+		buf = append(buf, '[')
+		for i9, e10 := range v.Friends {
+			if i9 > 0 {
+				buf = append(buf, ',')
+			}
+			if e10 == nil {
+				buf = append(buf, "null"...)
+			} else {
+				if err = jsoncodec.EnterPtr(c, e10); err != nil {
+					return nil, err
+				}
+				if buf, err = (*e10).appendJSON(buf, c); err != nil {
+					return nil, err
+				}
+				c.Leave()
+			}
+		}
+		buf = append(buf, ']')
+		c.Leave()
+	}
+	buf = append(buf, '}')
+	return buf, err
+}
+
+// UnmarshalJSON 实现 json.Unmarshaler
+func (v *Actor) UnmarshalJSON(data []byte) error {
+	t := jsoncodec.NewTokenizer(bytes.NewReader(data))
+	if err := v.decodeJSON(t); err != nil {
+		return err
+	}
+	return t.End()
+}
+
+// actorJSONFields 参与解码的字段名
+var actorJSONFields = []string{"name", "age", "skills", "born", "nickname", "extra", "score", "weapons", "avatar", "friends"}
+
+// 从 t 中读取一个值解码到 v，null 不修改 v，未知字段被忽略
+func (v *Actor) decodeJSON(t *jsoncodec.Tokenizer) error {
+	null, err := jsoncodec.ReadNull(t)
+	if err != nil || null {
+		return err
+	}
+	if err = jsoncodec.ReadDelim(t, jsoncodec.ObjectStart, v); err != nil {
+		return err
+	}
+	for {
+		key, ok, err := jsoncodec.ReadKey(t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		switch jsoncodec.MatchField(key, actorJSONFields) {
+		case "name":
+			if null1, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null1 {
+				if err = jsoncodec.ReadString(t, &v.Name); err != nil {
+					return err
+				}
+			}
+		case "age":
+			if null2, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null2 {
+				if err = jsoncodec.ReadInt(t, &v.Age); err != nil {
+					return err
+				}
+			}
+		case "skills":
+			if null3, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null3 {
+				v.Skills = nil
+			} else {
+				if err = jsoncodec.ReadDelim(t, jsoncodec.ArrayStart, &v.Skills); err != nil {
+					return err
+				}
+				var zero4 Skill
+				v.Skills = v.Skills[:0]
+				for {
+					more5, err := jsoncodec.More(t)
+					if err != nil {
+						return err
+					}
+					if !more5 {
+						break
+					}
+					v.Skills = append(v.Skills, zero4)
+					if err = v.Skills[len(v.Skills)-1].decodeJSON(t); err != nil {
+						return err
+					}
+				}
+				if v.Skills == nil {
+					v.Skills = []Skill{}
+				}
+			}
+		case "born":
+			if err = jsoncodec.DecodeValue(t, &v.Born); err != nil {
+				return err
+			}
+		case "nickname":
+			if null6, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null6 {
+				v.Nickname = nil
+			} else {
+				if v.Nickname == nil {
+					v.Nickname = new(string)
+				}
+				if err = jsoncodec.ReadString(t, v.Nickname); err != nil {
+					return err
+				}
+			}
+		case "extra":
+			if null7, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null7 {
+				v.Extra = nil
+			} else {
+				if err = jsoncodec.ReadDelim(t, jsoncodec.ObjectStart, &v.Extra); err != nil {
+					return err
+				}
+				if v.Extra == nil {
+					v.Extra = make(map[string]string)
+				}
+				for {
+					k8, ok9, err := jsoncodec.ReadKey(t)
+					if err != nil {
+						return err
+					}
+					if !ok9 {
+						break
+					}
+					var e10 string
+					if null11, err := jsoncodec.ReadNull(t); err != nil {
+						return err
+					} else if !null11 {
+						if err = jsoncodec.ReadString(t, &e10); err != nil {
+							return err
+						}
+					}
+					v.Extra[k8] = e10
+				}
+			}
+		case "score":
+			if null12, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null12 {
+				if err = jsoncodec.ReadFloat(t, &v.Score); err != nil {
+					return err
+				}
+			}
+		case "weapons":
+			if null13, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null13 {
+				if err = jsoncodec.ReadDelim(t, jsoncodec.ArrayStart, &v.Weapons); err != nil {
+					return err
+				}
+				i14 := 0
+				for ; ; i14++ {
+					more15, err := jsoncodec.More(t)
+					if err != nil {
+						return err
+					}
+					if !more15 {
+						break
+					}
+					if i14 >= len(v.Weapons) {
+						if err = t.Skip(); err != nil {
+							return err
+						}
+						continue
+					}
+					if null16, err := jsoncodec.ReadNull(t); err != nil {
+						return err
+					} else if !null16 {
+						if err = jsoncodec.ReadString(t, &v.Weapons[i14]); err != nil {
+							return err
+						}
+					}
+				}
+				for ; i14 < len(v.Weapons); i14++ {
+					v.Weapons[i14] = *new(string)
+				}
+			}
+		case "avatar":
+			if null17, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null17 {
+				v.Avatar = nil
+			} else {
+				if err = jsoncodec.ReadBytes(t, &v.Avatar); err != nil {
+					return err
+				}
+			}
+		case "friends":
+			if null18, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null18 {
+				v.Friends = nil
+			} else {
+				if err = jsoncodec.ReadDelim(t, jsoncodec.ArrayStart, &v.Friends); err != nil {
+					return err
+				}
+				var zero19 *Actor
+				v.Friends = v.Friends[:0]
+				for {
+					more20, err := jsoncodec.More(t)
+					if err != nil {
+						return err
+					}
+					if !more20 {
+						break
+					}
+					v.Friends = append(v.Friends, zero19)
+					if null21, err := jsoncodec.ReadNull(t); err != nil {
+						return err
+					} else if null21 {
+						v.Friends[len(v.Friends)-1] = nil
+					} else {
+						if v.Friends[len(v.Friends)-1] == nil {
+							v.Friends[len(v.Friends)-1] = new(Actor)
+						}
+						if err = (*v.Friends[len(v.Friends)-1]).decodeJSON(t); err != nil {
+							return err
+						}
+					}
+				}
+				if v.Friends == nil {
+					v.Friends = []*Actor{}
+				}
+			}
+		default:
+			if err = t.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// MarshalJSON 实现 json.Marshaler，输出与 jsoncodec.MarshalJSON 相同
+func (v Skill) MarshalJSON() ([]byte, error) {
+	return v.appendJSON(nil, new(jsoncodec.Cycles))
+}
+
+// 把 v 编码后追加到 buf，c 记录当前路径上的指针、切片和映射
+func (v Skill) appendJSON(buf []byte, c *jsoncodec.Cycles) ([]byte, error) {
+	var err error
+	buf = append(buf, '{')
+	buf = append(buf, "\"name\":"...)
+	buf = jsoncodec.AppendString(buf, string(v.Name))
+	buf = append(buf, ",\"level\":"...)
+	buf = append(buf, '"')
+	buf = strconv.AppendInt(buf, int64(v.Level), 10)
+	buf = append(buf, '"')
+	buf = append(buf, '}')
+	return buf, err
+}
+
+// UnmarshalJSON 实现 json.Unmarshaler
+func (v *Skill) UnmarshalJSON(data []byte) error {
+	t := jsoncodec.NewTokenizer(bytes.NewReader(data))
+	if err := v.decodeJSON(t); err != nil {
+		return err
+	}
+	return t.End()
+}
+
+// skillJSONFields 参与解码的字段名
+var skillJSONFields = []string{"name", "level"}
+
+// 从 t 中读取一个值解码到 v，null 不修改 v，未知字段被忽略
+func (v *Skill) decodeJSON(t *jsoncodec.Tokenizer) error {
+	null, err := jsoncodec.ReadNull(t)
+	if err != nil || null {
+		return err
+	}
+	if err = jsoncodec.ReadDelim(t, jsoncodec.ObjectStart, v); err != nil {
+		return err
+	}
+	for {
+		key, ok, err := jsoncodec.ReadKey(t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		switch jsoncodec.MatchField(key, skillJSONFields) {
+		case "name":
+			if null1, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null1 {
+				if err = jsoncodec.ReadString(t, &v.Name); err != nil {
+					return err
+				}
+			}
+		case "level":
+			if err = jsoncodec.DecodeQuoted(t, &v.Level); err != nil {
+				return err
+			}
+		default:
+			if err = t.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package sample
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 覆盖生成器支持的各种字段类型，用于和反射编码对比
+//go:generate go run code-snippet/code/006/jsoncodec/jsongen -type Kinds
+
+// 命名的基本类型按底层类型编码
+type Rank uint8
+
+type Tags []string
+
+// 自定义了 MarshalText 的类型回退到反射编码
+type Grade int
+
+func (g Grade) MarshalText() ([]byte, error) {
+	return []byte("G" + strconv.Itoa(int(g))), nil
+}
+
+func (g *Grade) UnmarshalText(text []byte) error {
+	n, err := strconv.Atoi(strings.TrimPrefix(string(text), "G"))
+	*g = Grade(n)
+	return err
+}
+
+type Kinds struct {
+	Int8     int8              `json:",omitempty"`
+	Uint     uint              `json:"uint,omitempty"`
+	Float32  float32           `json:"f32"`
+	Bool     bool              `json:"bool,omitempty"`
+	Rank     Rank              `json:"rank"`
+	Tags     Tags              `json:"tags"`
+	Levels   []Level           `json:"levels"`
+	QuotedP  *float64          `json:"qp,string"`
+	QuotedS  string            `json:"qs,string"`
+	QuotedB  bool              `json:"qb,string"`
+	Any      interface{}       `json:"any"`
+	Grade    Grade             `json:"grade"`
+	ByName   map[string]*Skill `json:"by_name"`
+	Matrix   [][]int           `json:"matrix"`
+	ByID     map[int]string    `json:"by_id"`
+	Duration time.Duration     `json:"duration"`
+	Dash     string            `json:"-,"`
+	Skip     string            `json:"-"`
+	HTML     string            `json:"<html>"`
+	Title    string            // 与下一个字段同名，带标签的字段优先
+	Label    string            `json:"Title"`
+	Next     *Kinds            `json:"next,omitempty"`
+}
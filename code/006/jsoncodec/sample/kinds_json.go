@@ -0,0 +1,467 @@
+// Code generated by jsongen; DO NOT EDIT.
+
+package sample
+
+import (
+	"bytes"
+	"code-snippet/code/006/jsoncodec"
+	"sort"
+	"strconv"
+)
+
+// MarshalJSON 实现 json.Marshaler，输出与 jsoncodec.MarshalJSON 相同
+func (v Kinds) MarshalJSON() ([]byte, error) {
+	return v.appendJSON(nil, new(jsoncodec.Cycles))
+}
+
+// 把 v 编码后追加到 buf，c 记录当前路径上的指针、切片和映射
+func (v Kinds) appendJSON(buf []byte, c *jsoncodec.Cycles) ([]byte, error) {
+	var err error
+	buf = append(buf, '{')
+	if v.Int8 != 0 {
+		buf = append(buf, "\"Int8\":"...)
+		buf = strconv.AppendInt(buf, int64(v.Int8), 10)
+	}
+	if v.Uint != 0 {
+		if buf[len(buf)-1] != '{' {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, "\"uint\":"...)
+		buf = strconv.AppendUint(buf, uint64(v.Uint), 10)
+	}
+	if buf[len(buf)-1] != '{' {
+		buf = append(buf, ',')
+	}
+	buf = append(buf, "\"f32\":"...)
+	if buf, err = jsoncodec.AppendFloat(buf, float64(v.Float32), 32); err != nil {
+		return nil, err
+	}
+	if v.Bool {
+		buf = append(buf, ",\"bool\":"...)
+		buf = strconv.AppendBool(buf, bool(v.Bool))
+	}
+	buf = append(buf, ",\"rank\":"...)
+	buf = strconv.AppendUint(buf, uint64(v.Rank), 10)
+	buf = append(buf, ",\"tags\":"...)
+	if v.Tags == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = append(buf, '[')
+		for i1, e2 := range v.Tags {
+			if i1 > 0 {
+				buf = append(buf, ',')
+			}
+			buf = jsoncodec.AppendString(buf, string(e2))
+		}
+		buf = append(buf, ']')
+	}
+	buf = append(buf, ",\"levels\":"...)
+	if v.Levels == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = append(buf, '[')
+		for i3, e4 := range v.Levels {
+			if i3 > 0 {
+				buf = append(buf, ',')
+			}
+			buf = strconv.AppendInt(buf, int64(e4), 10)
+		}
+		buf = append(buf, ']')
+	}
+	buf = append(buf, ",\"qp\":"...)
+	if v.QuotedP == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = append(buf, '"')
+		if buf, err = jsoncodec.AppendFloat(buf, float64(*v.QuotedP), 64); err != nil {
+			return nil, err
+		}
+		buf = append(buf, '"')
+	}
+	buf = append(buf, ",\"qs\":"...)
+	buf = jsoncodec.AppendQuotedString(buf, string(v.QuotedS))
+	buf = append(buf, ",\"qb\":"...)
+	buf = append(buf, '"')
+	buf = strconv.AppendBool(buf, bool(v.QuotedB))
+	buf = append(buf, '"')
+	buf = append(buf, ",\"any\":"...)
+	if buf, err = jsoncodec.AppendValue(buf, v.Any); err != nil {
+		return nil, err
+	}
+	buf = append(buf, ",\"grade\":"...)
+	if buf, err = jsoncodec.AppendValue(buf, v.Grade); err != nil {
+		return nil, err
+	}
+	buf = append(buf, ",\"by_name\":"...)
+	if v.ByName == nil {
+		buf = append(buf, "null"...)
+	} else {
+		keys5 := make([]string, 0, len(v.ByName))
+		for k7 := range v.ByName {
+			keys5 = append(keys5, k7)
+		}
+		sort.Strings(keys5)
+		buf = append(buf, '{')
+		for i6, k7 := range keys5 {
+			if i6 > 0 {
+				buf = append(buf, ',')
+			}
+			buf = jsoncodec.AppendString(buf, k7)
+			buf = append(buf, ':')
+			e8 := v.ByName[k7]
+			if buf, err = jsoncodec.AppendValue(buf, e8); err != nil {
+				return nil, err
+			}
+		}
+		buf = append(buf, '}')
+	}
+	buf = append(buf, ",\"matrix\":"...)
+	if v.Matrix == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = append(buf, '[')
+		for i9, e10 := range v.Matrix {
+			if i9 > 0 {
+				buf = append(buf, ',')
+			}
+			if e10 == nil {
+				buf = append(buf, "null"...)
+			} else {
+				buf = append(buf, '[')
+				for i11, e12 := range e10 {
+					if i11 > 0 {
+						buf = append(buf, ',')
+					}
+					buf = strconv.AppendInt(buf, int64(e12), 10)
+				}
+				buf = append(buf, ']')
+			}
+		}
+		buf = append(buf, ']')
+	}
+	buf = append(buf, ",\"by_id\":"...)
+	if buf, err = jsoncodec.AppendValue(buf, v.ByID); err != nil {
+		return nil, err
+	}
+	buf = append(buf, ",\"duration\":"...)
+	if buf, err = jsoncodec.AppendValue(buf, v.Duration); err != nil {
+		return nil, err
+	}
+	buf = append(buf, ",\"-\":"...)
+	buf = jsoncodec.AppendString(buf, string(v.Dash))
+	buf = append(buf, ",\"\\u003chtml\\u003e\":"...)
+	buf = jsoncodec.AppendString(buf, string(v.HTML))
+	buf = append(buf, ",\"Title\":"...)
+	buf = jsoncodec.AppendString(buf, string(v.Label))
+	if v.Next != nil {
+		buf = append(buf, ",\"next\":"...)
+		if err = jsoncodec.EnterPtr(c, v.Next); err != nil {
+			return nil, err
+		}
+		if buf, err = (*v.Next).appendJSON(buf, c); err != nil {
+			return nil, err
+		}
+		c.Leave()
+	}
+	buf = append(buf, '}')
+	return buf, err
+}
+
+// UnmarshalJSON 实现 json.Unmarshaler
+func (v *Kinds) UnmarshalJSON(data []byte) error {
+	t := jsoncodec.NewTokenizer(bytes.NewReader(data))
+	if err := v.decodeJSON(t); err != nil {
+		return err
+	}
+	return t.End()
+}
+
+// kindsJSONFields 参与解码的字段名
+var kindsJSONFields = []string{"Int8", "uint", "f32", "bool", "rank", "tags", "levels", "qp", "qs", "qb", "any", "grade", "by_name", "matrix", "by_id", "duration", "-", "<html>", "Title", "next"}
+
+// 从 t 中读取一个值解码到 v，null 不修改 v，未知字段被忽略
+func (v *Kinds) decodeJSON(t *jsoncodec.Tokenizer) error {
+	null, err := jsoncodec.ReadNull(t)
+	if err != nil || null {
+		return err
+	}
+	if err = jsoncodec.ReadDelim(t, jsoncodec.ObjectStart, v); err != nil {
+		return err
+	}
+	for {
+		key, ok, err := jsoncodec.ReadKey(t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		switch jsoncodec.MatchField(key, kindsJSONFields) {
+		case "Int8":
+			if null1, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null1 {
+				if err = jsoncodec.ReadInt(t, &v.Int8); err != nil {
+					return err
+				}
+			}
+		case "uint":
+			if null2, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null2 {
+				if err = jsoncodec.ReadUint(t, &v.Uint); err != nil {
+					return err
+				}
+			}
+		case "f32":
+			if null3, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null3 {
+				if err = jsoncodec.ReadFloat(t, &v.Float32); err != nil {
+					return err
+				}
+			}
+		case "bool":
+			if null4, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null4 {
+				if err = jsoncodec.ReadBool(t, &v.Bool); err != nil {
+					return err
+				}
+			}
+		case "rank":
+			if null5, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null5 {
+				if err = jsoncodec.ReadUint(t, &v.Rank); err != nil {
+					return err
+				}
+			}
+		case "tags":
+			if null6, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null6 {
+				v.Tags = nil
+			} else {
+				if err = jsoncodec.ReadDelim(t, jsoncodec.ArrayStart, &v.Tags); err != nil {
+					return err
+				}
+				var zero7 string
+				v.Tags = v.Tags[:0]
+				for {
+					more8, err := jsoncodec.More(t)
+					if err != nil {
+						return err
+					}
+					if !more8 {
+						break
+					}
+					v.Tags = append(v.Tags, zero7)
+					if null9, err := jsoncodec.ReadNull(t); err != nil {
+						return err
+					} else if !null9 {
+						if err = jsoncodec.ReadString(t, &v.Tags[len(v.Tags)-1]); err != nil {
+							return err
+						}
+					}
+				}
+				if v.Tags == nil {
+					v.Tags = Tags{}
+				}
+			}
+		case "levels":
+			if null10, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null10 {
+				v.Levels = nil
+			} else {
+				if err = jsoncodec.ReadDelim(t, jsoncodec.ArrayStart, &v.Levels); err != nil {
+					return err
+				}
+				var zero11 Level
+				v.Levels = v.Levels[:0]
+				for {
+					more12, err := jsoncodec.More(t)
+					if err != nil {
+						return err
+					}
+					if !more12 {
+						break
+					}
+					v.Levels = append(v.Levels, zero11)
+					if null13, err := jsoncodec.ReadNull(t); err != nil {
+						return err
+					} else if !null13 {
+						if err = jsoncodec.ReadInt(t, &v.Levels[len(v.Levels)-1]); err != nil {
+							return err
+						}
+					}
+				}
+				if v.Levels == nil {
+					v.Levels = []Level{}
+				}
+			}
+		case "qp":
+			if err = jsoncodec.DecodeQuoted(t, &v.QuotedP); err != nil {
+				return err
+			}
+		case "qs":
+			if err = jsoncodec.DecodeQuoted(t, &v.QuotedS); err != nil {
+				return err
+			}
+		case "qb":
+			if err = jsoncodec.DecodeQuoted(t, &v.QuotedB); err != nil {
+				return err
+			}
+		case "any":
+			if err = jsoncodec.DecodeValue(t, &v.Any); err != nil {
+				return err
+			}
+		case "grade":
+			if err = jsoncodec.DecodeValue(t, &v.Grade); err != nil {
+				return err
+			}
+		case "by_name":
+			if null14, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null14 {
+				v.ByName = nil
+			} else {
+				if err = jsoncodec.ReadDelim(t, jsoncodec.ObjectStart, &v.ByName); err != nil {
+					return err
+				}
+				if v.ByName == nil {
+					v.ByName = make(map[string]*Skill)
+				}
+				for {
+					k15, ok16, err := jsoncodec.ReadKey(t)
+					if err != nil {
+						return err
+					}
+					if !ok16 {
+						break
+					}
+					var e17 *Skill
+					if null18, err := jsoncodec.ReadNull(t); err != nil {
+						return err
+					} else if null18 {
+						e17 = nil
+					} else {
+						if e17 == nil {
+							e17 = new(Skill)
+						}
+						if err = jsoncodec.DecodeValue(t, e17); err != nil {
+							return err
+						}
+					}
+					v.ByName[k15] = e17
+				}
+			}
+		case "matrix":
+			if null19, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null19 {
+				v.Matrix = nil
+			} else {
+				if err = jsoncodec.ReadDelim(t, jsoncodec.ArrayStart, &v.Matrix); err != nil {
+					return err
+				}
+				var zero20 []int
+				v.Matrix = v.Matrix[:0]
+				for {
+					more21, err := jsoncodec.More(t)
+					if err != nil {
+						return err
+					}
+					if !more21 {
+						break
+					}
+					v.Matrix = append(v.Matrix, zero20)
+					if null22, err := jsoncodec.ReadNull(t); err != nil {
+						return err
+					} else if null22 {
+						v.Matrix[len(v.Matrix)-1] = nil
+					} else {
+						if err = jsoncodec.ReadDelim(t, jsoncodec.ArrayStart, &v.Matrix[len(v.Matrix)-1]); err != nil {
+							return err
+						}
+						var zero23 int
+						v.Matrix[len(v.Matrix)-1] = v.Matrix[len(v.Matrix)-1][:0]
+						for {
+							more24, err := jsoncodec.More(t)
+							if err != nil {
+								return err
+							}
+							if !more24 {
+								break
+							}
+							v.Matrix[len(v.Matrix)-1] = append(v.Matrix[len(v.Matrix)-1], zero23)
+							if null25, err := jsoncodec.ReadNull(t); err != nil {
+								return err
+							} else if !null25 {
+								if err = jsoncodec.ReadInt(t, &v.Matrix[len(v.Matrix)-1][len(v.Matrix[len(v.Matrix)-1])-1]); err != nil {
+									return err
+								}
+							}
+						}
+						if v.Matrix[len(v.Matrix)-1] == nil {
+							v.Matrix[len(v.Matrix)-1] = []int{}
+						}
+					}
+				}
+				if v.Matrix == nil {
+					v.Matrix = [][]int{}
+				}
+			}
+		case "by_id":
+			if err = jsoncodec.DecodeValue(t, &v.ByID); err != nil {
+				return err
+			}
+		case "duration":
+			if err = jsoncodec.DecodeValue(t, &v.Duration); err != nil {
+				return err
+			}
+		case "-":
+			if null26, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null26 {
+				if err = jsoncodec.ReadString(t, &v.Dash); err != nil {
+					return err
+				}
+			}
+		case "<html>":
+			if null27, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null27 {
+				if err = jsoncodec.ReadString(t, &v.HTML); err != nil {
+					return err
+				}
+			}
+		case "Title":
+			if null28, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if !null28 {
+				if err = jsoncodec.ReadString(t, &v.Label); err != nil {
+					return err
+				}
+			}
+		case "next":
+			if null29, err := jsoncodec.ReadNull(t); err != nil {
+				return err
+			} else if null29 {
+				v.Next = nil
+			} else {
+				if v.Next == nil {
+					v.Next = new(Kinds)
+				}
+				if err = (*v.Next).decodeJSON(t); err != nil {
+					return err
+				}
+			}
+		default:
+			if err = t.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
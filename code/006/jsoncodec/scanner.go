@@ -0,0 +1,630 @@
+package jsoncodec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// 词法单元类型
+type Kind int
+
+const (
+	ObjectStart Kind = iota // {
+	ObjectEnd               // }
+	ArrayStart              // [
+	ArrayEnd                // ]
+	Key                     // 对象的键
+	String                  // 字符串
+	Number                  // 数字
+	True                    // true
+	False                   // false
+	Null                    // null
+)
+
+var kindNames = [...]string{"{", "}", "[", "]", "key", "string", "number", "true", "false", "null"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// 词法单元
+type Token struct {
+	Kind   Kind
+	Value  string // 键和字符串为解码后的内容，数字为原始文本
+	Offset int64  // 在输入中的字节偏移
+	Line   int    // 行号，从 1 开始
+	Column int    // 列号（字符），从 1 开始
+}
+
+// 语法错误，带有出错位置
+type SyntaxError struct {
+	msg    string
+	Offset int64
+	Line   int
+	Column int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("jsoncodec: %s at line %d, column %d", e.msg, e.Line, e.Column)
+}
+
+// 词法分析器的状态：下一个词法单元应该是什么
+type scanState int
+
+const (
+	stateValue           scanState = iota // 值：顶层、冒号或数组中逗号之后
+	stateFirstValueOrEnd                  // 值或 ]：[ 之后
+	stateFirstKeyOrEnd                    // 键或 }：{ 之后
+	stateKey                              // 键：对象中逗号之后
+	stateColon                            // 冒号：键之后
+	stateCommaOrEnd                       // 逗号或结束符：值之后
+)
+
+// 流式词法分析器，从 io.Reader 中逐个读取词法单元并检查语法，
+// 输入可以包含多个以空白分隔的顶层值
+type Tokenizer struct {
+	reader *bufio.Reader
+
+	// 下一个字节的位置
+	offset int64
+	line   int
+	column int
+
+	state scanState
+	stack []Kind // 未结束的对象和数组
+
+	// 不为 nil 时记录读取的每个字节，用于取出原始值
+	capture *bytes.Buffer
+}
+
+// 创建词法分析器
+func NewTokenizer(r io.Reader) *Tokenizer {
+	// 从内存中读取时缓冲区不必大于输入
+	size := 4096
+	if sized, ok := r.(interface{ Len() int }); ok && sized.Len() < size {
+		size = sized.Len()
+	}
+	return &Tokenizer{reader: bufio.NewReaderSize(r, size), line: 1, column: 1}
+}
+
+// 当前嵌套层数
+func (t *Tokenizer) Depth() int {
+	return len(t.stack)
+}
+
+// 读取下一个词法单元，输入结束时返回 io.EOF
+func (t *Tokenizer) Next() (Token, error) {
+	c, err := t.prepare()
+	if err != nil {
+		return Token{}, err
+	}
+	return t.read(c)
+}
+
+// 查看下一个词法单元的类型，不消耗输入。键返回 Key，数字、true、false 只根据首字符判断
+func (t *Tokenizer) PeekKind() (Kind, error) {
+	c, err := t.prepare()
+	if err != nil {
+		return 0, err
+	}
+
+	switch t.state {
+	case stateFirstKeyOrEnd, stateKey:
+		if c == '}' {
+			return ObjectEnd, nil
+		}
+		if c == '"' {
+			return Key, nil
+		}
+		return 0, t.syntaxError(c, "looking for beginning of object key string")
+	case stateCommaOrEnd:
+		return t.closeKind(), nil
+	case stateFirstValueOrEnd:
+		if c == ']' {
+			return ArrayEnd, nil
+		}
+	}
+
+	switch c {
+	case '{':
+		return ObjectStart, nil
+	case '[':
+		return ArrayStart, nil
+	case '"':
+		return String, nil
+	case 't':
+		return True, nil
+	case 'f':
+		return False, nil
+	case 'n':
+		return Null, nil
+	}
+	if c == '-' || isDigit(c) {
+		return Number, nil
+	}
+	return 0, t.syntaxError(c, "looking for beginning of value")
+}
+
+// 读取下一个完整的值，返回原始文本
+func (t *Tokenizer) RawValue() ([]byte, error) {
+	if _, err := t.prepare(); err != nil {
+		return nil, err
+	}
+
+	t.capture = new(bytes.Buffer)
+	defer func() { t.capture = nil }()
+
+	if err := t.skip(); err != nil {
+		return nil, err
+	}
+	return t.capture.Bytes(), nil
+}
+
+// 跳过下一个完整的值
+func (t *Tokenizer) Skip() error {
+	if _, err := t.prepare(); err != nil {
+		return err
+	}
+	return t.skip()
+}
+
+// 检查输入在当前顶层值之后只剩空白
+func (t *Tokenizer) End() error {
+	c, err := t.skipSpace()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return t.syntaxError(c, "after top-level value")
+}
+
+// 读取词法单元直到当前值结束
+func (t *Tokenizer) skip() error {
+	depth := len(t.stack)
+	for {
+		token, err := t.Next()
+		if err == io.EOF {
+			return t.eofError()
+		}
+		if err != nil {
+			return err
+		}
+		if token.Kind == Key {
+			continue
+		}
+		if len(t.stack) == depth {
+			return nil
+		}
+	}
+}
+
+// 消耗下一个词法单元之前的空白、逗号和冒号，返回词法单元的首字节
+func (t *Tokenizer) prepare() (byte, error) {
+	for {
+		c, err := t.skipSpace()
+		if err == io.EOF {
+			if len(t.stack) == 0 && t.state == stateValue {
+				return 0, io.EOF
+			}
+			return 0, t.eofError()
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		switch t.state {
+		case stateColon:
+			if c != ':' {
+				return 0, t.syntaxError(c, "after object key")
+			}
+			t.readByte()
+			t.state = stateValue
+		case stateCommaOrEnd:
+			top := t.stack[len(t.stack)-1]
+			if c == ',' {
+				t.readByte()
+				if top == ObjectStart {
+					t.state = stateKey
+				} else {
+					t.state = stateValue
+				}
+				continue
+			}
+			if top == ObjectStart && c != '}' {
+				return 0, t.syntaxError(c, "after object key:value pair")
+			}
+			if top == ArrayStart && c != ']' {
+				return 0, t.syntaxError(c, "after array element")
+			}
+			return c, nil
+		default:
+			return c, nil
+		}
+	}
+}
+
+// 从首字节 c 开始读取一个词法单元
+func (t *Tokenizer) read(c byte) (Token, error) {
+	token := Token{Offset: t.offset, Line: t.line, Column: t.column}
+
+	switch t.state {
+	case stateFirstKeyOrEnd, stateKey:
+		if c == '}' && t.state == stateFirstKeyOrEnd {
+			return t.close(token)
+		}
+		if c != '"' {
+			return token, t.syntaxError(c, "looking for beginning of object key string")
+		}
+		value, err := t.readString()
+		if err != nil {
+			return token, err
+		}
+		token.Kind, token.Value = Key, value
+		t.state = stateColon
+		return token, nil
+	case stateCommaOrEnd:
+		return t.close(token)
+	case stateFirstValueOrEnd:
+		if c == ']' {
+			return t.close(token)
+		}
+	}
+
+	switch {
+	case c == '{':
+		t.readByte()
+		token.Kind = ObjectStart
+		t.stack = append(t.stack, ObjectStart)
+		t.state = stateFirstKeyOrEnd
+		return token, nil
+	case c == '[':
+		t.readByte()
+		token.Kind = ArrayStart
+		t.stack = append(t.stack, ArrayStart)
+		t.state = stateFirstValueOrEnd
+		return token, nil
+	case c == '"':
+		value, err := t.readString()
+		if err != nil {
+			return token, err
+		}
+		token.Kind, token.Value = String, value
+	case c == '-' || isDigit(c):
+		value, err := t.readNumber()
+		if err != nil {
+			return token, err
+		}
+		token.Kind, token.Value = Number, value
+	case c == 't':
+		token.Kind = True
+		if err := t.readLiteral("true"); err != nil {
+			return token, err
+		}
+	case c == 'f':
+		token.Kind = False
+		if err := t.readLiteral("false"); err != nil {
+			return token, err
+		}
+	case c == 'n':
+		token.Kind = Null
+		if err := t.readLiteral("null"); err != nil {
+			return token, err
+		}
+	default:
+		return token, t.syntaxError(c, "looking for beginning of value")
+	}
+
+	t.endValue()
+	return token, nil
+}
+
+// 读取结束符 } 或 ]
+func (t *Tokenizer) close(token Token) (Token, error) {
+	t.readByte()
+	token.Kind = t.closeKind()
+	t.stack = t.stack[:len(t.stack)-1]
+	t.endValue()
+	return token, nil
+}
+
+// 当前对象或数组对应的结束符类型
+func (t *Tokenizer) closeKind() Kind {
+	if t.stack[len(t.stack)-1] == ObjectStart {
+		return ObjectEnd
+	}
+	return ArrayEnd
+}
+
+// 一个值读取完毕，顶层值之后可以开始下一个顶层值
+func (t *Tokenizer) endValue() {
+	if len(t.stack) == 0 {
+		t.state = stateValue
+	} else {
+		t.state = stateCommaOrEnd
+	}
+}
+
+// 读取字符串并解码转义字符，非法 UTF-8 替换为 U+FFFD
+func (t *Tokenizer) readString() (string, error) {
+	t.readByte() // 左双引号
+
+	var buf []byte
+	for {
+		c, err := t.peekByte()
+		if err != nil {
+			return "", t.eofError()
+		}
+
+		switch {
+		case c == '"':
+			t.readByte()
+			return string(buf), nil
+		case c == '\\':
+			t.readByte()
+			if buf, err = t.readEscape(buf); err != nil {
+				return "", err
+			}
+		case c < 0x20:
+			return "", t.syntaxError(c, "in string literal")
+		case c < utf8.RuneSelf:
+			t.readByte()
+			buf = append(buf, c)
+		default:
+			r, err := t.readRune()
+			if err != nil {
+				return "", err
+			}
+			buf = utf8.AppendRune(buf, r)
+		}
+	}
+}
+
+// 读取反斜杠之后的转义序列
+func (t *Tokenizer) readEscape(buf []byte) ([]byte, error) {
+	c, err := t.peekByte()
+	if err != nil {
+		return nil, t.eofError()
+	}
+
+	switch c {
+	case '"', '\\', '/':
+		t.readByte()
+		return append(buf, c), nil
+	case 'b':
+		t.readByte()
+		return append(buf, '\b'), nil
+	case 'f':
+		t.readByte()
+		return append(buf, '\f'), nil
+	case 'n':
+		t.readByte()
+		return append(buf, '\n'), nil
+	case 'r':
+		t.readByte()
+		return append(buf, '\r'), nil
+	case 't':
+		t.readByte()
+		return append(buf, '\t'), nil
+	case 'u':
+		t.readByte()
+		r, err := t.readHex()
+		if err != nil {
+			return nil, err
+		}
+		if utf16.IsSurrogate(r) {
+			// 代理对的后一半紧跟着出现时一起解码，否则替换为 U+FFFD，后面的转义单独处理
+			if next, _ := t.reader.Peek(6); len(next) == 6 && next[0] == '\\' && next[1] == 'u' {
+				if r2, err := strconv.ParseUint(string(next[2:]), 16, 32); err == nil {
+					if dec := utf16.DecodeRune(r, rune(r2)); dec != utf8.RuneError {
+						for i := 0; i < 6; i++ {
+							t.readByte()
+						}
+						return utf8.AppendRune(buf, dec), nil
+					}
+				}
+			}
+			r = utf8.RuneError
+		}
+		return utf8.AppendRune(buf, r), nil
+	}
+	return nil, t.syntaxError(c, "in string escape code")
+}
+
+// 读取 \u 之后的 4 位十六进制数
+func (t *Tokenizer) readHex() (rune, error) {
+	var r rune
+	for i := 0; i < 4; i++ {
+		c, err := t.peekByte()
+		if err != nil {
+			return 0, t.eofError()
+		}
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, t.syntaxError(c, "in \\u hexadecimal character escape")
+		}
+		t.readByte()
+		r = r<<4 | rune(c)
+	}
+	return r, nil
+}
+
+// 读取数字，只检查语法，不转换
+func (t *Tokenizer) readNumber() (string, error) {
+	var buf []byte
+	next := func() byte {
+		c, err := t.peekByte()
+		if err != nil {
+			return 0
+		}
+		return c
+	}
+	digits := func() {
+		for c := next(); isDigit(c); c = next() {
+			t.readByte()
+			buf = append(buf, c)
+		}
+	}
+	expectDigit := func(context string) error {
+		c, err := t.peekByte()
+		if err != nil {
+			return t.eofError()
+		}
+		if !isDigit(c) {
+			return t.syntaxError(c, context)
+		}
+		return nil
+	}
+
+	// 负号
+	if next() == '-' {
+		t.readByte()
+		buf = append(buf, '-')
+		if err := expectDigit("in numeric literal"); err != nil {
+			return "", err
+		}
+	}
+
+	// 整数部分，0 之后不能再有数字
+	if c := next(); c == '0' {
+		t.readByte()
+		buf = append(buf, c)
+	} else {
+		digits()
+	}
+
+	// 小数部分
+	if next() == '.' {
+		t.readByte()
+		buf = append(buf, '.')
+		if err := expectDigit("after decimal point in numeric literal"); err != nil {
+			return "", err
+		}
+		digits()
+	}
+
+	// 指数部分
+	if c := next(); c == 'e' || c == 'E' {
+		t.readByte()
+		buf = append(buf, c)
+		if c = next(); c == '+' || c == '-' {
+			t.readByte()
+			buf = append(buf, c)
+		}
+		if err := expectDigit("in exponent of numeric literal"); err != nil {
+			return "", err
+		}
+		digits()
+	}
+	return string(buf), nil
+}
+
+// 读取 true、false 或 null
+func (t *Tokenizer) readLiteral(literal string) error {
+	for i := 0; i < len(literal); i++ {
+		c, err := t.peekByte()
+		if err != nil {
+			return t.eofError()
+		}
+		if c != literal[i] {
+			return t.syntaxError(c, "in literal "+literal+" (expecting "+quoteChar(literal[i])+")")
+		}
+		t.readByte()
+	}
+	return nil
+}
+
+// 跳过空白，返回下一个字节但不消耗
+func (t *Tokenizer) skipSpace() (byte, error) {
+	for {
+		c, err := t.peekByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return c, nil
+		}
+		t.readByte()
+	}
+}
+
+// 查看下一个字节
+func (t *Tokenizer) peekByte() (byte, error) {
+	b, err := t.reader.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// 消耗一个字节并更新位置
+func (t *Tokenizer) readByte() {
+	c, _ := t.reader.ReadByte()
+	if t.capture != nil {
+		t.capture.WriteByte(c)
+	}
+	t.offset++
+	if c == '\n' {
+		t.line++
+		t.column = 1
+	} else {
+		t.column++
+	}
+}
+
+// 读取一个多字节字符，非法编码返回 U+FFFD 并只消耗一个字节
+func (t *Tokenizer) readRune() (rune, error) {
+	b, _ := t.reader.Peek(utf8.UTFMax)
+	r, size := utf8.DecodeRune(b)
+	if r == utf8.RuneError && size <= 1 {
+		size = 1
+	}
+	for i := 0; i < size; i++ {
+		t.readByte()
+	}
+	// 列号按字符计算
+	t.column -= size - 1
+	return r, nil
+}
+
+// 在当前位置生成语法错误
+func (t *Tokenizer) syntaxError(c byte, context string) error {
+	return &SyntaxError{
+		msg:    "invalid character " + quoteChar(c) + " " + context,
+		Offset: t.offset,
+		Line:   t.line,
+		Column: t.column,
+	}
+}
+
+// 输入意外结束
+func (t *Tokenizer) eofError() error {
+	return &SyntaxError{msg: "unexpected end of JSON input", Offset: t.offset, Line: t.line, Column: t.column}
+}
+
+// 格式化出错的字符
+func quoteChar(c byte) string {
+	if c == '\'' {
+		return `'\''`
+	}
+	if c == '"' {
+		return `'"'`
+	}
+	s := strconv.Quote(string(c))
+	return "'" + s[1:len(s)-1] + "'"
+}
@@ -0,0 +1,361 @@
+package dictionary
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 可以在多个 goroutine 中同时使用的字典。键按哈希分到多个分片，每个分片有自己的锁，
+// 写入不同分片的键不会互相等待
+type Dictionary[K comparable, V any] struct {
+	seed   maphash.Seed
+	shards []*shard[K, V]
+
+	order Order
+	less  func(a, b K) bool
+
+	seq      atomic.Uint64 // 插入的序号，用于按插入顺序遍历
+	expiring atomic.Bool   // 是否有设置过过期时间的键
+
+	interval  time.Duration
+	onExpire  atomic.Pointer[func(key K, value V)]
+	janitor   sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type shard[K comparable, V any] struct {
+	mu   sync.RWMutex
+	data map[K]*entry[V]
+}
+
+type entry[V any] struct {
+	value   V
+	seq     uint64
+	expires int64 // 过期的时间（UnixNano），0 表示不过期
+}
+
+func (e *entry[V]) expired(now int64) bool {
+	return e.expires != 0 && now >= e.expires
+}
+
+// 遍历的顺序
+type Order int
+
+const (
+	Unordered      Order = iota // 不保证顺序，默认值
+	InsertionOrder              // 按键第一次插入的顺序，覆盖已有的键不改变顺序
+	SortedOrder                 // 按 NewSorted 传入的 less 排序
+)
+
+// 字典的选项
+type Option func(*options)
+
+type options struct {
+	shards   int
+	order    Order
+	interval time.Duration
+}
+
+// 分片的数量，默认为 16
+func WithShards(n int) Option {
+	return func(o *options) {
+		o.shards = max(n, 1)
+	}
+}
+
+// Visit 按键第一次插入的顺序遍历
+func WithInsertionOrder() Option {
+	return func(o *options) {
+		o.order = InsertionOrder
+	}
+}
+
+// 清理过期键的间隔，默认为 1 分钟。过期的键在清理前也不会被读到
+func WithJanitorInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.interval = d
+		}
+	}
+}
+
+// 创建一个字典
+func New[K comparable, V any](opts ...Option) *Dictionary[K, V] {
+	o := options{shards: 16, interval: time.Minute}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	d := &Dictionary[K, V]{
+		seed:     maphash.MakeSeed(),
+		shards:   make([]*shard[K, V], o.shards),
+		order:    o.order,
+		interval: o.interval,
+		done:     make(chan struct{}),
+	}
+	for i := range d.shards {
+		d.shards[i] = &shard[K, V]{data: make(map[K]*entry[V])}
+	}
+	return d
+}
+
+// 创建一个按 less 排序遍历的字典，例如 NewSorted[string, int](cmp.Less[string])
+func NewSorted[K comparable, V any](less func(a, b K) bool, opts ...Option) *Dictionary[K, V] {
+	d := New[K, V](opts...)
+	d.order = SortedOrder
+	d.less = less
+	return d
+}
+
+func (d *Dictionary[K, V]) shard(key K) *shard[K, V] {
+	if len(d.shards) == 1 {
+		return d.shards[0]
+	}
+	return d.shards[maphash.Comparable(d.seed, key)%uint64(len(d.shards))]
+}
+
+// 设置键值，已有的过期时间被清除
+func (d *Dictionary[K, V]) Set(key K, value V) {
+	d.set(key, value, 0)
+}
+
+// 设置键值，ttl 之后过期。第一次调用时启动清理过期键的 goroutine，用 Close 停止它
+func (d *Dictionary[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	d.expiring.Store(true)
+	d.janitor.Do(func() {
+		go d.clean()
+	})
+	d.set(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+func (d *Dictionary[K, V]) set(key K, value V, expires int64) {
+	s := d.shard(key)
+	s.mu.Lock()
+	old := s.data[key]
+	e := &entry[V]{value: value, expires: expires}
+	if old != nil && !old.expired(time.Now().UnixNano()) {
+		e.seq = old.seq
+		old = nil
+	} else {
+		e.seq = d.seq.Add(1)
+	}
+	s.data[key] = e
+	s.mu.Unlock()
+
+	// 被覆盖的键已经过期
+	if old != nil {
+		d.expire(key, old.value)
+	}
+}
+
+// 根据键获取值，键不存在或已经过期时 ok 为 false
+func (d *Dictionary[K, V]) Get(key K) (value V, ok bool) {
+	s := d.shard(key)
+	s.mu.RLock()
+	e := s.data[key]
+	s.mu.RUnlock()
+	if e == nil || e.expired(time.Now().UnixNano()) {
+		return value, false
+	}
+	return e.value, true
+}
+
+// 键存在时返回已有的值，loaded 为 true；否则设置为 value 并返回它
+func (d *Dictionary[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	s := d.shard(key)
+	s.mu.Lock()
+	old := s.data[key]
+	if old != nil && !old.expired(time.Now().UnixNano()) {
+		s.mu.Unlock()
+		return old.value, true
+	}
+	s.data[key] = &entry[V]{value: value, seq: d.seq.Add(1)}
+	s.mu.Unlock()
+
+	if old != nil {
+		d.expire(key, old.value)
+	}
+	return value, false
+}
+
+// 键的值等于 old 时替换为 new，保留原来的过期时间。
+// 与 sync.Map 一样，V 的动态类型不可比较时会 panic
+func (d *Dictionary[K, V]) CompareAndSwap(key K, old, new V) (swapped bool) {
+	s := d.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.data[key]
+	if e == nil || e.expired(time.Now().UnixNano()) || any(e.value) != any(old) {
+		return false
+	}
+	// 读取者可能还持有原来的 entry，所以不修改它
+	s.data[key] = &entry[V]{value: new, seq: e.seq, expires: e.expires}
+	return true
+}
+
+// 删除键，返回删除前键是否存在
+func (d *Dictionary[K, V]) Delete(key K) bool {
+	s := d.shard(key)
+	s.mu.Lock()
+	e := s.data[key]
+	delete(s.data, key)
+	s.mu.Unlock()
+
+	if e == nil {
+		return false
+	}
+	if e.expired(time.Now().UnixNano()) {
+		d.expire(key, e.value)
+		return false
+	}
+	return true
+}
+
+// 没有过期的键的数量
+func (d *Dictionary[K, V]) Len() int {
+	n := 0
+	now := time.Now().UnixNano()
+	expiring := d.expiring.Load()
+	for _, s := range d.shards {
+		s.mu.RLock()
+		if !expiring {
+			n += len(s.data)
+		} else {
+			for _, e := range s.data {
+				if !e.expired(now) {
+					n++
+				}
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// 清空所有字典数据
+func (d *Dictionary[K, V]) Clear() {
+	var expired []Entry[K, V]
+	now := time.Now().UnixNano()
+	for _, s := range d.shards {
+		s.mu.Lock()
+		for k, e := range s.data {
+			if e.expired(now) {
+				expired = append(expired, Entry[K, V]{Key: k, Value: e.value})
+			}
+		}
+		s.data = make(map[K]*entry[V])
+		s.mu.Unlock()
+	}
+	for _, e := range expired {
+		d.expire(e.Key, e.Value)
+	}
+}
+
+// 一个键值对
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// 同一时刻所有没有过期的键值对的快照，按字典的顺序排列
+func (d *Dictionary[K, V]) Entries() []Entry[K, V] {
+	type item struct {
+		Entry[K, V]
+		seq uint64
+	}
+	// 同时持有所有分片的读锁，得到的是某一时刻的完整快照
+	for _, s := range d.shards {
+		s.mu.RLock()
+	}
+	now := time.Now().UnixNano()
+	n := 0
+	for _, s := range d.shards {
+		n += len(s.data)
+	}
+	items := make([]item, 0, n)
+	for _, s := range d.shards {
+		for k, e := range s.data {
+			if !e.expired(now) {
+				items = append(items, item{Entry[K, V]{Key: k, Value: e.value}, e.seq})
+			}
+		}
+	}
+	for _, s := range d.shards {
+		s.mu.RUnlock()
+	}
+
+	switch d.order {
+	case InsertionOrder:
+		sort.Slice(items, func(i, j int) bool { return items[i].seq < items[j].seq })
+	case SortedOrder:
+		sort.Slice(items, func(i, j int) bool { return d.less(items[i].Key, items[j].Key) })
+	}
+	entries := make([]Entry[K, V], len(items))
+	for i, it := range items {
+		entries[i] = it.Entry
+	}
+	return entries
+}
+
+// 遍历所有的键值，如果回调函数返回值为false，停止遍历。
+// 遍历的是调用时的快照，回调函数中可以修改字典，修改不影响这次遍历
+func (d *Dictionary[K, V]) Visit(callback func(key K, value V) bool) {
+	if callback == nil {
+		return
+	}
+	for _, e := range d.Entries() {
+		if !callback(e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// 设置键过期时的回调，在清理的 goroutine 或者覆盖、删除过期键的 goroutine 中调用，
+// 每个过期的值只调用一次。应该在设置过期时间之前调用
+func (d *Dictionary[K, V]) OnExpire(fn func(key K, value V)) {
+	d.onExpire.Store(&fn)
+}
+
+func (d *Dictionary[K, V]) expire(key K, value V) {
+	if fn := d.onExpire.Load(); fn != nil && *fn != nil {
+		(*fn)(key, value)
+	}
+}
+
+// 定期删除过期的键，直到 Close
+func (d *Dictionary[K, V]) clean() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		for _, s := range d.shards {
+			var expired []Entry[K, V]
+			now := time.Now().UnixNano()
+			s.mu.Lock()
+			for k, e := range s.data {
+				if e.expired(now) {
+					delete(s.data, k)
+					expired = append(expired, Entry[K, V]{Key: k, Value: e.value})
+				}
+			}
+			s.mu.Unlock()
+			for _, e := range expired {
+				d.expire(e.Key, e.Value)
+			}
+		}
+	}
+}
+
+// 停止清理过期键的 goroutine，可以重复调用。之后字典仍然可用，过期的键不会被读到，但不再被清理
+func (d *Dictionary[K, V]) Close() {
+	d.closeOnce.Do(func() {
+		close(d.done)
+	})
+}
@@ -0,0 +1,294 @@
+package dictionary_test
+
+import (
+	"cmp"
+	"code-snippet/code/007/dictionary"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 字典的测试：
+//
+//	go test -race code-snippet/code/007/dictionary
+
+func TestBasic(t *testing.T) {
+	d := dictionary.New[string, int]()
+	if _, ok := d.Get("a"); ok {
+		t.Fatal("empty dictionary has a")
+	}
+	d.Set("a", 1)
+	d.Set("b", 2)
+	d.Set("a", 3)
+	if v, ok := d.Get("a"); !ok || v != 3 {
+		t.Fatalf("a = %d %v", v, ok)
+	}
+	if d.Len() != 2 {
+		t.Fatalf("len %d", d.Len())
+	}
+	if !d.Delete("a") || d.Delete("a") || d.Len() != 1 {
+		t.Fatal("delete")
+	}
+	d.Clear()
+	if _, ok := d.Get("b"); ok || d.Len() != 0 {
+		t.Fatal("clear")
+	}
+}
+
+func keys[V any](d *dictionary.Dictionary[string, V]) []string {
+	var list []string
+	d.Visit(func(key string, value V) bool {
+		list = append(list, key)
+		return true
+	})
+	return list
+}
+
+func TestInsertionOrder(t *testing.T) {
+	d := dictionary.New[string, int](dictionary.WithInsertionOrder())
+	for i, k := range []string{"z", "y", "x", "w", "v"} {
+		d.Set(k, i)
+	}
+	d.Set("y", 10) // 覆盖不改变顺序
+	d.Delete("x")
+	d.Set("x", 11) // 删除后重新插入排到最后
+	if got, want := keys(d), []string{"z", "y", "w", "v", "x"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// 回调返回 false 时停止
+	n := 0
+	d.Visit(func(string, int) bool { n++; return n < 2 })
+	if n != 2 {
+		t.Fatalf("visited %d after stop", n)
+	}
+}
+
+func TestSorted(t *testing.T) {
+	d := dictionary.NewSorted[string, int](cmp.Less[string], dictionary.WithShards(4))
+	for _, k := range []string{"pear", "apple", "fig", "kiwi"} {
+		d.Set(k, len(k))
+	}
+	if got, want := keys(d), []string{"apple", "fig", "kiwi", "pear"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := []dictionary.Entry[string, int]{{Key: "apple", Value: 5}, {Key: "fig", Value: 3}, {Key: "kiwi", Value: 4}, {Key: "pear", Value: 4}}
+	if got := d.Entries(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries %v", got)
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	d := dictionary.New[string, int]()
+	if v, loaded := d.GetOrSet("a", 1); loaded || v != 1 {
+		t.Fatalf("first: %d %v", v, loaded)
+	}
+	if v, loaded := d.GetOrSet("a", 2); !loaded || v != 1 {
+		t.Fatalf("second: %d %v", v, loaded)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	d := dictionary.New[string, int]()
+	if d.CompareAndSwap("a", 0, 1) {
+		t.Fatal("swapped a missing key")
+	}
+	d.Set("a", 1)
+	if d.CompareAndSwap("a", 2, 3) || !d.CompareAndSwap("a", 1, 3) {
+		t.Fatal("wrong swap")
+	}
+	if v, _ := d.Get("a"); v != 3 {
+		t.Fatalf("a = %d", v)
+	}
+	// 与 sync.Map 一样，不可比较的值会 panic
+	s := dictionary.New[string, []int]()
+	s.Set("a", []int{1})
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for uncomparable values")
+		}
+	}()
+	s.CompareAndSwap("a", nil, []int{2})
+}
+
+func TestConcurrent(t *testing.T) {
+	d := dictionary.New[int, int](dictionary.WithShards(8))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := i % 50
+				for {
+					n, loaded := d.GetOrSet(key, 1)
+					if !loaded || d.CompareAndSwap(key, n, n+1) {
+						break
+					}
+				}
+				d.Get(i)
+				d.Len()
+			}
+		}()
+	}
+	wg.Wait()
+	total := 0
+	d.Visit(func(key, n int) bool { total += n; return true })
+	if total != 8000 || d.Len() != 50 {
+		t.Fatalf("total %d, len %d", total, d.Len())
+	}
+}
+
+func TestVisit(t *testing.T) {
+	d := dictionary.New[string, int](dictionary.WithInsertionOrder())
+	for i := 0; i < 5; i++ {
+		d.Set(strconv.Itoa(i), i)
+	}
+	// 回调中修改字典不会死锁，也不影响这次遍历
+	var seen []string
+	d.Visit(func(key string, value int) bool {
+		seen = append(seen, key)
+		d.Delete(key)
+		d.Set("new"+key, value)
+		return true
+	})
+	if len(seen) != 5 {
+		t.Fatalf("visited %v", seen)
+	}
+	if got := keys(d); !reflect.DeepEqual(got, []string{"new0", "new1", "new2", "new3", "new4"}) {
+		t.Fatalf("after visit: %v", got)
+	}
+}
+
+func TestConsistent(t *testing.T) {
+	// 一个 goroutine 在两个键之间转移数值，总数不变；快照中的总数也必须不变
+	d := dictionary.New[string, int](dictionary.WithShards(16))
+	names := make([]string, 16)
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+		d.Set(names[i], 100)
+	}
+	var mu sync.Mutex // 转移需要同时修改两个键
+	var stop atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; !stop.Load(); i++ {
+			from, to := names[i%16], names[(i*7+3)%16]
+			if from == to {
+				continue
+			}
+			mu.Lock()
+			a, _ := d.Get(from)
+			b, _ := d.Get(to)
+			// 先加后减，只有快照不一致时才能看到总数变化
+			d.Set(to, b+1)
+			d.Set(from, a-1)
+			mu.Unlock()
+		}
+	}()
+	defer func() {
+		stop.Store(true)
+		<-done
+	}()
+
+	for i := 0; i < 2000; i++ {
+		total := 0
+		for _, e := range d.Entries() {
+			total += e.Value
+		}
+		if total != 1600 && total != 1601 {
+			t.Fatalf("snapshot total %d", total)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	d := dictionary.New[string, int](dictionary.WithInsertionOrder())
+	defer d.Close()
+	var expired []string
+	var mu sync.Mutex
+	d.OnExpire(func(key string, value int) {
+		mu.Lock()
+		expired = append(expired, key)
+		mu.Unlock()
+	})
+	d.SetWithTTL("short", 1, 30*time.Millisecond)
+	d.SetWithTTL("long", 2, time.Hour)
+	d.Set("forever", 3)
+	if d.Len() != 3 {
+		t.Fatalf("len %d before expiry", d.Len())
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := d.Get("short"); ok {
+		t.Fatal("short not expired")
+	}
+	if d.Len() != 2 || !reflect.DeepEqual(keys(d), []string{"long", "forever"}) {
+		t.Fatalf("after expiry: %v", keys(d))
+	}
+	if d.CompareAndSwap("short", 1, 5) {
+		t.Fatal("swapped an expired key")
+	}
+	// 过期的键被覆盖时调用回调，重新插入排到最后
+	if _, loaded := d.GetOrSet("short", 4); loaded {
+		t.Fatal("got an expired value")
+	}
+	if !reflect.DeepEqual(keys(d), []string{"long", "forever", "short"}) {
+		t.Fatalf("after reinsert: %v", keys(d))
+	}
+	// Set 清除过期时间
+	d.Set("long", 5)
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(expired, []string{"short"}) {
+		t.Fatalf("expired %v", expired)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	d := dictionary.New[int, string](dictionary.WithJanitorInterval(5*time.Millisecond), dictionary.WithShards(4))
+	defer d.Close()
+	expired := make(chan int, 100)
+	d.OnExpire(func(key int, value string) {
+		expired <- key
+	})
+	for i := 0; i < 10; i++ {
+		d.SetWithTTL(i, "v", 10*time.Millisecond)
+	}
+	d.SetWithTTL(10, "v", time.Hour)
+
+	// 没有读写，清理的 goroutine 也会删除过期的键
+	seen := make(map[int]bool)
+	timeout := time.After(2 * time.Second)
+	for len(seen) < 10 {
+		select {
+		case k := <-expired:
+			if seen[k] {
+				t.Fatalf("key %d expired twice", k)
+			}
+			seen[k] = true
+		case <-timeout:
+			t.Fatalf("only %d keys expired", len(seen))
+		}
+	}
+	if seen[10] || d.Len() != 1 {
+		t.Fatalf("len %d", d.Len())
+	}
+
+	// Close 之后不再清理，但过期的键仍然读不到
+	d.Close()
+	d.Close()
+	time.Sleep(10 * time.Millisecond)
+	d.SetWithTTL(20, "v", time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case k := <-expired:
+		t.Fatalf("key %d cleaned after close", k)
+	default:
+	}
+	if _, ok := d.Get(20); ok {
+		t.Fatal("got an expired key after close")
+	}
+}
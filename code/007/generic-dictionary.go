@@ -0,0 +1,72 @@
+package main
+
+import (
+	"cmp"
+	"code-snippet/code/007/dictionary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 用泛型的 dictionary 包改写 save-interface-value-into-dictionary.go 中的字典
+func main() {
+	// 创建字典实例，按插入的顺序遍历
+	prices := dictionary.New[string, int](dictionary.WithInsertionOrder())
+
+	// 添加字典数据
+	prices.Set("My Factory", 60)
+	prices.Set("Terra Craft", 36)
+	prices.Set("Don't Hungry", 24)
+
+	// 获取值及打印，值已经是 int，不需要类型断言
+	favorite, _ := prices.Get("Terra Craft")
+	fmt.Println("favorite:", favorite)
+
+	// 遍历所有的字典元素，回调中可以修改字典
+	prices.Visit(func(key string, value int) bool {
+		if value > 40 {
+			fmt.Println(key, "is expensive")
+			prices.Delete(key)
+			return true
+		}
+		fmt.Println(key, "is cheap")
+		return true
+	})
+	fmt.Println("len:", prices.Len())
+
+	// 按键排序的字典，多个 goroutine 同时计数
+	counts := dictionary.NewSorted[string, int](cmp.Less[string])
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			word := []string{"go", "rust", "c"}[i%3]
+			for {
+				n, loaded := counts.GetOrSet(word, 1)
+				if !loaded || counts.CompareAndSwap(word, n, n+1) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	counts.Visit(func(word string, n int) bool {
+		fmt.Println(word, n)
+		return true
+	})
+
+	// 带过期时间的键
+	sessions := dictionary.New[string, string](dictionary.WithJanitorInterval(10 * time.Millisecond))
+	defer sessions.Close()
+	expired := make(chan string, 1)
+	sessions.OnExpire(func(key, value string) {
+		expired <- key
+	})
+	sessions.SetWithTTL("token", "alice", 50*time.Millisecond)
+	user, ok := sessions.Get("token")
+	fmt.Println(user, ok)
+	fmt.Println("expired:", <-expired)
+	user, ok = sessions.Get("token")
+	fmt.Printf("%q %v\n", user, ok)
+}
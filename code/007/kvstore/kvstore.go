@@ -0,0 +1,498 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"code-snippet/code/007/dictionary"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 目录中的文件
+const (
+	logName      = "wal.log"
+	snapshotName = "snapshot.db"
+)
+
+// 日志或快照中的记录损坏
+var ErrCorrupt = errors.New("kvstore: corrupt record")
+
+// 存储关闭后再写入
+var ErrClosed = errors.New("kvstore: closed")
+
+// 值的编码方式
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	JSON Codec = jsonCodec{} // 默认的编码，日志可以直接阅读
+	Gob  Codec = gobCodec{}  // 二进制编码，每条记录都带有类型信息，值中的接口类型需要先 gob.Register
+)
+
+// 写入日志后何时调用 fsync
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // 每次写入后同步，写入返回后断电也不会丢失，默认值
+	SyncInterval                   // 定期同步，断电时可能丢失最近一个间隔内的写入
+	SyncNever                      // 交给操作系统，进程崩溃不会丢失，断电可能丢失
+)
+
+// 存储的选项
+type Option func(*options)
+
+type options struct {
+	codec         Codec
+	sync          SyncPolicy
+	interval      time.Duration
+	snapshotEvery int
+	dictOpts      []dictionary.Option
+}
+
+// 键和值的编码方式，默认为 JSON。同一个目录必须始终使用同一种编码
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// 同步日志的方式，默认为 SyncAlways
+func WithSync(policy SyncPolicy) Option {
+	return func(o *options) {
+		o.sync = policy
+	}
+}
+
+// SyncInterval 的间隔，默认为 1 秒
+func WithSyncInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.interval = d
+		}
+	}
+}
+
+// 每写入 n 条日志生成一次快照并清空日志，默认为 1000，0 表示只在调用 Snapshot 时生成
+func WithSnapshotEvery(n int) Option {
+	return func(o *options) {
+		o.snapshotEvery = max(n, 0)
+	}
+}
+
+// 创建内存中的字典时使用的选项，例如 dictionary.WithInsertionOrder()。
+// 按插入顺序遍历时，恢复后的顺序是快照中的顺序加上日志中新插入的键
+func WithDictionaryOptions(opts ...dictionary.Option) Option {
+	return func(o *options) {
+		o.dictOpts = append(o.dictOpts, opts...)
+	}
+}
+
+// 日志中的操作
+const (
+	opSet    = 1
+	opDelete = 2
+)
+
+// 日志中的一条记录
+type record[K comparable, V any] struct {
+	LSN   uint64 // 日志序号，从 1 开始递增
+	Op    int
+	Key   K
+	Value V `json:",omitempty"`
+}
+
+// 快照的内容
+type snapshot[K comparable, V any] struct {
+	LSN     uint64 // 快照包含的最后一条日志
+	Entries []dictionary.Entry[K, V]
+}
+
+// 保存在磁盘上的字典。写入先追加到预写日志（WAL），再修改内存中的 Dictionary，
+// 读取只访问内存。打开时先加载最近的快照，再重放快照之后的日志
+type Store[K comparable, V any] struct {
+	dir  string
+	opts options
+	dict *dictionary.Dictionary[K, V]
+
+	mu      sync.Mutex // 保护以下字段，写入按日志的顺序修改字典
+	log     *os.File
+	lsn     uint64 // 最后一条日志的序号
+	pending int    // 上次快照之后的日志条数
+	dirty   bool   // 有没有未同步的写入
+	err     error  // 写入日志失败后，日志的末尾可能不完整，之后的写入都返回这个错误
+	closed  bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// 打开目录中的存储，目录不存在时创建。日志末尾不完整或损坏的记录（例如写入时崩溃）被丢弃
+func Open[K comparable, V any](dir string, opts ...Option) (*Store[K, V], error) {
+	o := options{codec: JSON, interval: time.Second, snapshotEvery: 1000}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("kvstore: %w", err)
+	}
+	s := &Store[K, V]{
+		dir:  dir,
+		opts: o,
+		dict: dictionary.New[K, V](o.dictOpts...),
+		done: make(chan struct{}),
+	}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	if o.sync == SyncInterval {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// 加载快照。快照先写到临时文件再改名，所以总是完整的，损坏时返回错误
+func (s *Store[K, V]) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(s.dir, snapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	defer f.Close()
+
+	payload, err := readRecord(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("kvstore: %s: %w", snapshotName, err)
+	}
+	var snap snapshot[K, V]
+	if err := s.opts.codec.Unmarshal(payload, &snap); err != nil {
+		return fmt.Errorf("kvstore: %s: %w", snapshotName, err)
+	}
+	for _, e := range snap.Entries {
+		s.dict.Set(e.Key, e.Value)
+	}
+	s.lsn = snap.LSN
+	return nil
+}
+
+// 重放快照之后的日志，截掉末尾不完整的记录，然后打开日志用于追加
+func (s *Store[K, V]) replay() error {
+	path := filepath.Join(s.dir, logName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	r := bufio.NewReader(f)
+	var good int64 // 最后一条完整记录的结尾
+	for {
+		payload, err := readRecord(r)
+		if err != nil {
+			// io.EOF 是日志正常结束，ErrCorrupt 是写入时崩溃留下的不完整记录，丢弃它和之后的内容
+			break
+		}
+		var rec record[K, V]
+		if err := s.opts.codec.Unmarshal(payload, &rec); err != nil {
+			f.Close()
+			return fmt.Errorf("kvstore: %s at offset %d: %w", logName, good, err)
+		}
+		good += int64(headerSize + len(payload))
+		// 生成快照后、清空日志前崩溃时，日志中还有快照已经包含的记录
+		if rec.LSN <= s.lsn {
+			continue
+		}
+		s.apply(rec)
+		s.lsn = rec.LSN
+		s.pending++
+	}
+
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	s.log = f
+	return nil
+}
+
+func (s *Store[K, V]) apply(rec record[K, V]) {
+	switch rec.Op {
+	case opSet:
+		s.dict.Set(rec.Key, rec.Value)
+	case opDelete:
+		s.dict.Delete(rec.Key)
+	}
+}
+
+// 记录的格式：4 字节长度、4 字节 CRC32 校验和、内容，都是大端序
+const headerSize = 8
+
+// 读取一条记录，没有更多内容时返回 io.EOF，记录不完整或校验失败时返回 ErrCorrupt
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrCorrupt
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	// 长度损坏时避免分配过大的内存
+	if size > 1<<30 {
+		return nil, ErrCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, ErrCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, ErrCorrupt
+	}
+	return payload, nil
+}
+
+func appendRecord(buf, payload []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+// 根据键获取值
+func (s *Store[K, V]) Get(key K) (V, bool) {
+	return s.dict.Get(key)
+}
+
+// 键的数量
+func (s *Store[K, V]) Len() int {
+	return s.dict.Len()
+}
+
+// 遍历某一时刻的快照，回调函数中可以修改存储
+func (s *Store[K, V]) Visit(callback func(key K, value V) bool) {
+	s.dict.Visit(callback)
+}
+
+// 设置键值，按 SyncPolicy 写入日志后返回。自动生成快照失败时也返回错误，但这次写入已经生效
+func (s *Store[K, V]) Set(key K, value V) error {
+	return s.write(record[K, V]{Op: opSet, Key: key, Value: value})
+}
+
+// 删除键，键不存在时也会写入日志
+func (s *Store[K, V]) Delete(key K) error {
+	return s.write(record[K, V]{Op: opDelete, Key: key})
+}
+
+func (s *Store[K, V]) write(rec record[K, V]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	rec.LSN = s.lsn + 1
+	payload, err := s.opts.codec.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	if _, err := s.log.Write(appendRecord(nil, payload)); err != nil {
+		s.err = fmt.Errorf("kvstore: %w", err)
+		return s.err
+	}
+	if s.opts.sync == SyncAlways {
+		if err := s.log.Sync(); err != nil {
+			s.err = fmt.Errorf("kvstore: %w", err)
+			return s.err
+		}
+	} else {
+		s.dirty = true
+	}
+
+	s.apply(rec)
+	s.lsn = rec.LSN
+	s.pending++
+	if s.opts.snapshotEvery > 0 && s.pending >= s.opts.snapshotEvery {
+		return s.snapshot()
+	}
+	return nil
+}
+
+// 生成快照并清空日志。快照期间写入会等待
+func (s *Store[K, V]) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return s.snapshot()
+}
+
+// 调用时持有 mu
+func (s *Store[K, V]) snapshot() error {
+	payload, err := s.opts.codec.Marshal(snapshot[K, V]{LSN: s.lsn, Entries: s.dict.Entries()})
+	if err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	// 先写临时文件再改名，崩溃时留下的是旧快照或新快照
+	path := filepath.Join(s.dir, snapshotName)
+	if err := writeFileSync(path, appendRecord(nil, payload)); err != nil {
+		return err
+	}
+
+	// 快照已经包含所有的日志，换成空的日志。在这之前崩溃时，重放会跳过快照已经包含的记录
+	empty := filepath.Join(s.dir, logName+".tmp")
+	f, err := os.OpenFile(empty, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	if err := os.Rename(empty, filepath.Join(s.dir, logName)); err != nil {
+		f.Close()
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	// 改名以后旧的日志已经不在目录中，之后的写入必须写到新的日志
+	s.log.Close()
+	s.log = f
+	s.pending = 0
+	s.dirty = false
+	return syncDir(s.dir)
+}
+
+// 写入文件并同步，然后原子地替换 path
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// 同步目录，让改名在断电后也生效
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("kvstore: %w", err)
+	}
+	return nil
+}
+
+// 把写入的日志同步到磁盘
+func (s *Store[K, V]) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.sync()
+}
+
+// 调用时持有 mu
+func (s *Store[K, V]) sync() error {
+	if !s.dirty || s.err != nil {
+		return s.err
+	}
+	if err := s.log.Sync(); err != nil {
+		s.err = fmt.Errorf("kvstore: %w", err)
+		return s.err
+	}
+	s.dirty = false
+	return nil
+}
+
+// SyncInterval 模式下定期同步
+func (s *Store[K, V]) syncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.sync()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// 同步日志并关闭文件，可以重复调用
+func (s *Store[K, V]) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.done)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.sync()
+	if cerr := s.log.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("kvstore: %w", cerr)
+	}
+	return err
+}
@@ -0,0 +1,391 @@
+package kvstore_test
+
+import (
+	"code-snippet/code/007/kvstore"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 持久化的字典，包括在日志的任意字节处截断后的恢复：
+//
+//	go test -race code-snippet/code/007/kvstore
+
+type store = kvstore.Store[string, int]
+
+func open(t *testing.T, dir string, opts ...kvstore.Option) *store {
+	t.Helper()
+	s, err := kvstore.Open[string, int](dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func closeStore(t *testing.T, s *store) {
+	t.Helper()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 存储中所有的键值
+func state(s *store) map[string]int {
+	m := make(map[string]int)
+	s.Visit(func(key string, value int) bool {
+		m[key] = value
+		return true
+	})
+	return m
+}
+
+func sameState(t *testing.T, s *store, want map[string]int) {
+	t.Helper()
+	if got := state(s); !maps.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// 一次写入操作
+type op struct {
+	key    string
+	value  int
+	delete bool
+}
+
+func (o op) apply(t *testing.T, s *store, m map[string]int) {
+	t.Helper()
+	var err error
+	if o.delete {
+		delete(m, o.key)
+		err = s.Delete(o.key)
+	} else {
+		m[o.key] = o.value
+		err = s.Set(o.key, o.value)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var ops = []op{
+	{key: "a", value: 1},
+	{key: "b", value: 2},
+	{key: "a", value: 3},
+	{key: "c", value: 400000},
+	{key: "b", delete: true},
+	{key: "long key with spaces", value: -5},
+	{key: "d", value: 6},
+	{key: "c", delete: true},
+	{key: "b", value: 7},
+	{key: "e", value: 8},
+}
+
+func TestReopen(t *testing.T) {
+	for _, codec := range []struct {
+		name  string
+		codec kvstore.Codec
+	}{
+		{"json", kvstore.JSON},
+		{"gob", kvstore.Gob},
+	} {
+		t.Run(codec.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := open(t, dir, kvstore.WithCodec(codec.codec))
+			want := make(map[string]int)
+			for _, o := range ops[:6] {
+				o.apply(t, s, want)
+			}
+			if err := s.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+			for _, o := range ops[6:] {
+				o.apply(t, s, want)
+			}
+			closeStore(t, s)
+
+			s = open(t, dir, kvstore.WithCodec(codec.codec))
+			defer s.Close()
+			sameState(t, s, want)
+		})
+	}
+}
+
+// 执行所有的操作，记录每次操作后日志的长度和存储的状态；snapshot 为 true 时在中间生成快照。
+// 然后在日志的每个字节处截断，恢复的状态必须是截断处之前完整记录的状态，
+// 恢复后可以继续写入，再次打开时能读到
+func TestTruncate(t *testing.T) {
+	for _, snapshot := range []bool{false, true} {
+		name := "log"
+		if snapshot {
+			name = "after snapshot"
+		}
+		t.Run(name, func(t *testing.T) {
+			testTruncate(t, snapshot)
+		})
+	}
+}
+
+func testTruncate(t *testing.T, snapshot bool) {
+	dir := t.TempDir()
+	s := open(t, dir, kvstore.WithSnapshotEvery(0))
+	current := make(map[string]int)
+	var sizes []int64           // 每次操作后日志的长度
+	var states []map[string]int // 每次操作后的状态
+	record := func() {
+		info, err := os.Stat(filepath.Join(dir, "wal.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, info.Size())
+		states = append(states, maps.Clone(current))
+	}
+	record()
+	for i, o := range ops {
+		if snapshot && i == 4 {
+			if err := s.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+			// 快照后日志为空，截断只能回到快照的状态
+			sizes, states = nil, nil
+			record()
+		}
+		o.apply(t, s, current)
+		record()
+	}
+	closeStore(t, s)
+
+	log, err := os.ReadFile(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, snapErr := os.ReadFile(filepath.Join(dir, "snapshot.db"))
+	if snapshot != (snapErr == nil) {
+		t.Fatalf("snapshot file: %v", snapErr)
+	}
+
+	for offset := 0; offset <= len(log); offset++ {
+		t.Run(fmt.Sprintf("offset %d", offset), func(t *testing.T) {
+			recoverAt(t, log[:offset], snap, sizes, states)
+		})
+	}
+}
+
+func recoverAt(t *testing.T, log, snap []byte, sizes []int64, states []map[string]int) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wal.log"), log, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if snap != nil {
+		if err := os.WriteFile(filepath.Join(dir, "snapshot.db"), snap, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 截断处之前最后一条完整记录
+	n := 0
+	for n+1 < len(sizes) && sizes[n+1] <= int64(len(log)) {
+		n++
+	}
+	want := maps.Clone(states[n])
+
+	s := open(t, dir)
+	defer s.Close()
+	sameState(t, s, want)
+	// 不完整的记录被截掉，新的记录接在后面
+	info, err := os.Stat(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != sizes[n] {
+		t.Fatalf("log size %d after recovery, want %d", info.Size(), sizes[n])
+	}
+	want["after"] = 1
+	if err := s.Set("after", 1); err != nil {
+		t.Fatal(err)
+	}
+	closeStore(t, s)
+
+	s = open(t, dir)
+	defer s.Close()
+	sameState(t, s, want)
+}
+
+func TestStaleLog(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, kvstore.WithSnapshotEvery(0))
+	want := make(map[string]int)
+	for _, o := range ops {
+		o.apply(t, s, want)
+	}
+	stale, err := os.ReadFile(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	closeStore(t, s)
+
+	// 模拟写完快照、换成空日志之前崩溃：日志中的记录都已经在快照中，不能再重放一次
+	if err := os.WriteFile(filepath.Join(dir, "wal.log"), stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = open(t, dir)
+	sameState(t, s, want)
+	// 新的记录接在旧记录后面，序号在快照之后，再次打开时不会被跳过
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "a")
+	closeStore(t, s)
+
+	s = open(t, dir)
+	defer s.Close()
+	sameState(t, s, want)
+}
+
+func TestCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir)
+	s.Set("a", 1)
+	s.Set("b", 2)
+	closeStore(t, s)
+
+	// 修改最后一条记录中的一个字节，校验和不再匹配
+	path := filepath.Join(dir, "wal.log")
+	log, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log[len(log)-3] ^= 0xff
+	if err := os.WriteFile(path, log, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = open(t, dir)
+	defer s.Close()
+	sameState(t, s, map[string]int{"a": 1})
+}
+
+func TestAutoSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, kvstore.WithSnapshotEvery(10), kvstore.WithCodec(kvstore.Gob))
+	want := make(map[string]int)
+	for i := 0; i < 25; i++ {
+		key := strconv.Itoa(i % 7)
+		want[key] = i
+		if err := s.Set(key, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	closeStore(t, s)
+
+	// 第 20 次写入后生成快照，日志中只剩 5 条记录
+	if _, err := os.Stat(filepath.Join(dir, "snapshot.db")); err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := 0
+	for len(log) >= 8 {
+		size := int(log[0])<<24 | int(log[1])<<16 | int(log[2])<<8 | int(log[3])
+		log = log[8+size:]
+		records++
+	}
+	if records != 5 {
+		t.Fatalf("%d records in the log", records)
+	}
+
+	s = open(t, dir, kvstore.WithCodec(kvstore.Gob))
+	defer s.Close()
+	sameState(t, s, want)
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, kvstore.WithSync(kvstore.SyncNever), kvstore.WithSnapshotEvery(50))
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("%d-%d", g, i%10)
+				if err := s.Set(key, i); err != nil {
+					errs <- err
+					return
+				}
+				s.Get(key)
+				s.Len()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	want := state(s)
+	if len(want) != 80 {
+		t.Fatalf("len %d", len(want))
+	}
+	closeStore(t, s)
+
+	s = open(t, dir)
+	defer s.Close()
+	sameState(t, s, want)
+}
+
+func TestSyncInterval(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, kvstore.WithSync(kvstore.SyncInterval), kvstore.WithSyncInterval(time.Millisecond))
+	for i := 0; i < 20; i++ {
+		s.Set("n", i)
+		time.Sleep(100 * time.Microsecond)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	closeStore(t, s)
+
+	s = open(t, dir)
+	defer s.Close()
+	sameState(t, s, map[string]int{"n": 19})
+}
+
+func TestClosed(t *testing.T) {
+	s := open(t, t.TempDir())
+	s.Set("a", 1)
+	closeStore(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+	if err := s.Set("b", 2); !errors.Is(err, kvstore.ErrClosed) {
+		t.Fatalf("set after close: %v", err)
+	}
+	// 关闭后仍然可以读取内存中的数据
+	if v, ok := s.Get("a"); !ok || v != 1 {
+		t.Fatal("get after close")
+	}
+}
+
+func TestCodecMismatch(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, kvstore.WithCodec(kvstore.Gob))
+	s.Set("a", 1)
+	closeStore(t, s)
+
+	// 校验和正确但不能解码的记录不是写入时崩溃造成的，返回错误而不是丢弃
+	if s, err := kvstore.Open[string, int](dir); err == nil {
+		s.Close()
+		t.Fatal("opened a gob log as json")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"code-snippet/code/007/dictionary"
+	"code-snippet/code/007/kvstore"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// 把 generic-dictionary.go 中的字典保存到磁盘，多次运行可以看到上次写入的数据
+func main() {
+	dir := flag.String("dir", filepath.Join(os.TempDir(), "kvstore-demo"), "数据目录")
+	reset := flag.Bool("reset", false, "运行前删除数据目录")
+	flag.Parse()
+	if *reset {
+		os.RemoveAll(*dir)
+	}
+
+	// 每次写入后同步日志，每 3 条日志生成一次快照
+	prices, err := kvstore.Open[string, int](*dir,
+		kvstore.WithSnapshotEvery(3),
+		kvstore.WithDictionaryOptions(dictionary.WithInsertionOrder()),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer prices.Close()
+
+	// 运行的次数也保存在存储中
+	runs, _ := prices.Get("runs")
+	runs++
+	fmt.Println("run:", runs)
+
+	if runs == 1 {
+		prices.Set("My Factory", 60)
+		prices.Set("Terra Craft", 36)
+		prices.Set("Don't Hungry", 24)
+	} else {
+		// 之后每次运行都打折
+		prices.Visit(func(key string, value int) bool {
+			if key != "runs" {
+				prices.Set(key, value*9/10)
+			}
+			return true
+		})
+	}
+	if err := prices.Set("runs", runs); err != nil {
+		log.Fatal(err)
+	}
+
+	prices.Visit(func(key string, value int) bool {
+		if key != "runs" {
+			fmt.Println(key, value)
+		}
+		return true
+	})
+}
@@ -0,0 +1,107 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// 参数的类型不正确
+type ParamError struct {
+	Key   string
+	Want  string
+	Value interface{}
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("param %q: want %s, got %T %v", e.Key, e.Want, e.Value, e.Value)
+}
+
+// 读取字符串参数，没有时返回 def
+func (c Config) String(key, def string) (string, error) {
+	v, ok := c[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", &ParamError{key, "string", v}
+}
+
+// 读取整数参数，没有时返回 def。接受整数、没有小数部分的浮点数（JSON 解码的结果）和数字字符串（INI 文件中的值）
+func (c Config) Int(key string, def int) (int, error) {
+	v, ok := c[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int64:
+		if v >= math.MinInt && v <= math.MaxInt {
+			return int(v), nil
+		}
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt && v < math.MaxInt {
+			return int(v), nil
+		}
+	case json.Number:
+		if n, err := strconv.Atoi(v.String()); err == nil {
+			return n, nil
+		}
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n, nil
+		}
+	}
+	return 0, &ParamError{key, "int", v}
+}
+
+// 读取布尔参数，没有时返回 def。字符串按 strconv.ParseBool 解析
+func (c Config) Bool(key string, def bool) (bool, error) {
+	v, ok := c[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
+		}
+	}
+	return false, &ParamError{key, "bool", v}
+}
+
+// 读取引用其他对象的参数，没有时返回 nil
+func (c Config) Class(key string) (Class, error) {
+	v, ok := c[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	if class, ok := v.(Class); ok {
+		return class, nil
+	}
+	return nil, &ParamError{key, "class", v}
+}
+
+// 读取引用一组对象的参数，单个对象作为只有一个元素的列表，没有时返回 nil
+func (c Config) Classes(key string) ([]Class, error) {
+	v, ok := c[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case Class:
+		return []Class{v}, nil
+	case []Class:
+		return v, nil
+	}
+	return nil, &ParamError{key, "class list", v}
+}
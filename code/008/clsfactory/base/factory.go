@@ -1,25 +1,172 @@
 package base
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
 // 类接口
 type Class interface {
 	Do()
 }
 
+// 创建类实例的参数
+type Config map[string]interface{}
+
+// 类生成工厂，根据参数创建一个类实例
+type Factory func(cfg Config) (Class, error)
+
 var (
-	// 保存注册好的工厂信息
-	factoryByName = make(map[string]func() Class)
+	// 名称没有注册
+	ErrNotFound = errors.New("class not found")
+	// 名称已经注册过
+	ErrDuplicate = errors.New("class already registered")
 )
 
-// 注册一个类生成工厂
-func Register(name string, factory func() Class) {
-	factoryByName[name] = factory
+// 已注册的类
+type Info struct {
+	Name        string
+	Description string
+}
+
+// 注册表，保存名称到工厂的映射，可以被多个 goroutine 同时使用
+type Registry struct {
+	parent *Registry
+
+	mu      sync.RWMutex
+	entries map[string]entry
+}
+
+type entry struct {
+	factory     Factory
+	description string
+}
+
+// 创建一个空的注册表
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]entry)}
 }
 
-// 根据名称创建对应的类
-func Create(name string) Class {
-	if class, ok := factoryByName[name]; ok {
-		return class()
-	} else {
-		panic("name not found")
+// 创建子注册表：可以创建父注册表中的类，新注册的类只在子注册表中可见。
+// 测试中用来注册临时的类而不影响全局的注册表
+func (r *Registry) Scope() *Registry {
+	s := NewRegistry()
+	s.parent = r
+	return s
+}
+
+// 注册一个类生成工厂，名称在当前或上级注册表中已经存在时返回 ErrDuplicate
+func (r *Registry) Register(name, description string, factory Factory) error {
+	if name == "" {
+		return errors.New("clsfactory: empty class name")
+	}
+	if factory == nil {
+		return fmt.Errorf("clsfactory: nil factory for %q", name)
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[name]; ok || (r.parent != nil && r.parent.Has(name)) {
+		return fmt.Errorf("clsfactory: %q: %w", name, ErrDuplicate)
+	}
+	r.entries[name] = entry{factory: factory, description: description}
+	return nil
+}
+
+// 与 Register 相同，出错时 panic，用于 init 函数中
+func (r *Registry) MustRegister(name, description string, factory Factory) {
+	if err := r.Register(name, description, factory); err != nil {
+		panic(err)
+	}
+}
+
+// 取消注册，只影响当前注册表，返回名称是否存在
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.entries[name]
+	delete(r.entries, name)
+	return ok
+}
+
+// 查找工厂，当前注册表中没有时查找上级
+func (r *Registry) lookup(name string) (entry, bool) {
+	for ; r != nil; r = r.parent {
+		r.mu.RLock()
+		e, ok := r.entries[name]
+		r.mu.RUnlock()
+		if ok {
+			return e, true
+		}
+	}
+	return entry{}, false
+}
+
+// 名称是否已经注册
+func (r *Registry) Has(name string) bool {
+	_, ok := r.lookup(name)
+	return ok
+}
+
+// 根据名称和参数创建对应的类
+func (r *Registry) Create(name string, cfg Config) (Class, error) {
+	e, ok := r.lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("clsfactory: %q: %w", name, ErrNotFound)
+	}
+	if cfg == nil {
+		cfg = Config{}
+	}
+	c, err := e.factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("clsfactory: create %q: %w", name, err)
+	}
+	if c == nil {
+		return nil, fmt.Errorf("clsfactory: create %q: factory returned nil", name)
+	}
+	return c, nil
+}
+
+// 所有可以创建的类，包括上级注册表中的，按名称排序
+func (r *Registry) List() []Info {
+	seen := make(map[string]bool)
+	var list []Info
+	for ; r != nil; r = r.parent {
+		r.mu.RLock()
+		for name, e := range r.entries {
+			if !seen[name] {
+				seen[name] = true
+				list = append(list, Info{Name: name, Description: e.description})
+			}
+		}
+		r.mu.RUnlock()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// 全局注册表，各个包在 init 函数中注册
+var Default = NewRegistry()
+
+// 在全局注册表中注册一个类生成工厂
+func Register(name, description string, factory Factory) error {
+	return Default.Register(name, description, factory)
+}
+
+// 在全局注册表中注册一个类生成工厂，出错时 panic
+func MustRegister(name, description string, factory Factory) {
+	Default.MustRegister(name, description, factory)
+}
+
+// 根据名称和参数从全局注册表创建对应的类
+func Create(name string, cfg Config) (Class, error) {
+	return Default.Create(name, cfg)
+}
+
+// 全局注册表中所有的类
+func List() []Info {
+	return Default.List()
 }
@@ -0,0 +1,243 @@
+package base_test
+
+import (
+	"code-snippet/code/008/clsfactory/base"
+	"code-snippet/code/008/clsfactory/cls1"
+	"code-snippet/code/008/clsfactory/cls2"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 类工厂的注册表：
+//
+//	go test -race code-snippet/code/008/clsfactory/base
+
+// 测试用的类
+type counter struct {
+	name string
+}
+
+func (c *counter) Do() {}
+
+func newCounter(cfg base.Config) (base.Class, error) {
+	name, err := cfg.String("name", "counter")
+	return &counter{name}, err
+}
+
+func TestCreate(t *testing.T) {
+	r := base.NewRegistry()
+	if err := r.Register("Counter", "计数", newCounter); err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.Create("Counter", base.Config{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.(*counter).name != "a" {
+		t.Fatalf("created %+v", c)
+	}
+	// 没有参数时使用默认值
+	c, err = r.Create("Counter", nil)
+	if err != nil || c.(*counter).name != "counter" {
+		t.Fatalf("created %+v, %v", c, err)
+	}
+	if err := r.Register("", "", newCounter); err == nil {
+		t.Fatal("registered an empty name")
+	}
+	if err := r.Register("Nil", "", nil); err == nil {
+		t.Fatal("registered a nil factory")
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	r := base.NewRegistry()
+	r.MustRegister("Counter", "第一个", newCounter)
+	err := r.Register("Counter", "第二个", newCounter)
+	if !errors.Is(err, base.ErrDuplicate) {
+		t.Fatalf("duplicate register: %v", err)
+	}
+	// 原来的注册不受影响
+	if list := r.List(); len(list) != 1 || list[0].Description != "第一个" {
+		t.Fatalf("list %+v", list)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustRegister did not panic")
+		}
+	}()
+	r.MustRegister("Counter", "", newCounter)
+}
+
+func TestNotFound(t *testing.T) {
+	r := base.NewRegistry()
+	c, err := r.Create("Missing", nil)
+	if c != nil || !errors.Is(err, base.ErrNotFound) {
+		t.Fatalf("create missing: %v, %v", c, err)
+	}
+	if !strings.Contains(err.Error(), `"Missing"`) {
+		t.Fatalf("error does not name the class: %v", err)
+	}
+	if r.Has("Missing") || r.Unregister("Missing") {
+		t.Fatal("missing class reported as registered")
+	}
+	r.MustRegister("Counter", "", newCounter)
+	if !r.Unregister("Counter") || r.Has("Counter") {
+		t.Fatal("unregister failed")
+	}
+}
+
+func TestFactoryError(t *testing.T) {
+	r := base.NewRegistry()
+	errBroken := errors.New("broken")
+	r.MustRegister("Broken", "", func(base.Config) (base.Class, error) {
+		return nil, errBroken
+	})
+	r.MustRegister("Nil", "", func(base.Config) (base.Class, error) {
+		return nil, nil
+	})
+	r.MustRegister("Class2", "", cls2.New)
+
+	if _, err := r.Create("Broken", nil); !errors.Is(err, errBroken) {
+		t.Fatalf("factory error not wrapped: %v", err)
+	}
+	if _, err := r.Create("Nil", nil); err == nil {
+		t.Fatal("nil class accepted")
+	}
+	_, err := r.Create("Class2", base.Config{"times": "many"})
+	var pe *base.ParamError
+	if !errors.As(err, &pe) || pe.Key != "times" {
+		t.Fatalf("param error: %v", err)
+	}
+	if _, err := r.Create("Class2", base.Config{"times": -1}); err == nil {
+		t.Fatal("negative times accepted")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	// JSON 解码得到的 float64 和 json.Number，INI 文件中的字符串
+	var fromJSON base.Config
+	if err := json.Unmarshal([]byte(`{"times":3,"message":"hi","debug":true,"ratio":1.5}`), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	fromINI := base.Config{"times": "3", "message": "hi", "debug": "true", "ratio": "1.5"}
+	for _, cfg := range []base.Config{fromJSON, fromINI, {"times": json.Number("3"), "message": "hi", "debug": true}} {
+		times, err := cfg.Int("times", 1)
+		if err != nil || times != 3 {
+			t.Fatalf("times %d, %v", times, err)
+		}
+		message, err := cfg.String("message", "")
+		if err != nil || message != "hi" {
+			t.Fatalf("message %q, %v", message, err)
+		}
+		debug, err := cfg.Bool("debug", false)
+		if err != nil || !debug {
+			t.Fatalf("debug %v, %v", debug, err)
+		}
+		if _, err := cfg.Int("ratio", 0); cfg["ratio"] != nil && err == nil {
+			t.Fatalf("fractional ratio %v accepted as int", cfg["ratio"])
+		}
+	}
+	if n, err := (base.Config{}).Int("missing", 7); n != 7 || err != nil {
+		t.Fatalf("default %d, %v", n, err)
+	}
+	if _, err := (base.Config{"message": 1}).String("message", ""); err == nil {
+		t.Fatal("number accepted as string")
+	}
+
+	c, err := cls1.New(base.Config{"message": "hello"})
+	if err != nil || c.(*cls1.Class1).Message != "hello" {
+		t.Fatalf("cls1 %+v, %v", c, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	r := base.NewRegistry()
+	r.MustRegister("b", "第二", newCounter)
+	r.MustRegister("a", "第一", newCounter)
+	r.MustRegister("c", "", newCounter)
+	want := []base.Info{{Name: "a", Description: "第一"}, {Name: "b", Description: "第二"}, {Name: "c"}}
+	if got := r.List(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("list %+v, want %+v", got, want)
+	}
+}
+
+func TestScope(t *testing.T) {
+	parent := base.NewRegistry()
+	parent.MustRegister("Counter", "全局", newCounter)
+	scope := parent.Scope()
+
+	// 子注册表可以创建上级的类，新注册的类只在子注册表中可见
+	if _, err := scope.Create("Counter", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := scope.Register("Counter", "", newCounter); !errors.Is(err, base.ErrDuplicate) {
+		t.Fatalf("shadowing the parent: %v", err)
+	}
+	scope.MustRegister("Fake", "测试用", newCounter)
+	if !scope.Has("Fake") || parent.Has("Fake") {
+		t.Fatal("scoped class leaked into the parent")
+	}
+	want := []base.Info{{Name: "Counter", Description: "全局"}, {Name: "Fake", Description: "测试用"}}
+	if got := scope.List(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scope list %+v", got)
+	}
+	// 子注册表不能取消上级的注册
+	if scope.Unregister("Counter") || !scope.Has("Counter") {
+		t.Fatal("scope removed a parent class")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	r := base.NewRegistry()
+	scope := r.Scope()
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprint("Class", i%8)
+			// 同一个名字只有一个注册成功
+			if err := r.Register(name, "", newCounter); err != nil && !errors.Is(err, base.ErrDuplicate) {
+				errs <- err
+			}
+			for j := 0; j < 100; j++ {
+				if _, err := scope.Create(name, nil); err != nil {
+					errs <- err
+					return
+				}
+				scope.List()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.List()); n != 8 {
+		t.Fatalf("%d classes registered, want 8", n)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	// cls1 和 cls2 在 init 中注册到全局注册表
+	for _, name := range []string{"Class1", "Class2"} {
+		if !base.Default.Has(name) {
+			t.Fatalf("%s not registered", name)
+		}
+	}
+	c, err := base.Create("Class2", base.Config{"times": 2})
+	if err != nil || c.(*cls2.Class2).Times != 2 {
+		t.Fatalf("create Class2: %+v, %v", c, err)
+	}
+	if err := base.Register("Class1", "", cls1.New); !errors.Is(err, base.ErrDuplicate) {
+		t.Fatalf("duplicate global register: %v", err)
+	}
+}
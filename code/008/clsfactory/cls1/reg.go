@@ -7,16 +7,24 @@ import (
 
 // 定义类1
 type Class1 struct {
+	Message string
 }
 
 // 实现Class接口
 func (c *Class1) Do() {
-	fmt.Println("Class1")
+	fmt.Println(c.Message)
+}
+
+// 类1的工厂，参数 message 是 Do 输出的内容
+func New(cfg base.Config) (base.Class, error) {
+	message, err := cfg.String("message", "Class1")
+	if err != nil {
+		return nil, err
+	}
+	return &Class1{Message: message}, nil
 }
 
 func init() {
 	// 在启动时注册类1工厂
-	base.Register("Class1", func() base.Class {
-		return new(Class1)
-	})
+	base.MustRegister("Class1", "输出一行消息，参数 message", New)
 }
@@ -7,16 +7,29 @@ import (
 
 // 定义类2
 type Class2 struct {
+	Times int
 }
 
 // 实现Class接口
 func (c *Class2) Do() {
-	fmt.Println("Class2")
+	for i := 0; i < c.Times; i++ {
+		fmt.Println("Class2")
+	}
+}
+
+// 类2的工厂，参数 times 是 Do 输出的次数
+func New(cfg base.Config) (base.Class, error) {
+	times, err := cfg.Int("times", 1)
+	if err != nil {
+		return nil, err
+	}
+	if times < 0 {
+		return nil, fmt.Errorf("times %d is negative", times)
+	}
+	return &Class2{Times: times}, nil
 }
 
 func init() {
 	// 在启动时注册类2工厂
-	base.Register("Class2", func() base.Class {
-		return new(Class2)
-	})
+	base.MustRegister("Class2", "重复输出 Class2，参数 times", New)
 }
@@ -0,0 +1,51 @@
+package cls3
+
+import (
+	"code-snippet/code/008/clsfactory/base"
+	"fmt"
+)
+
+// 定义流水线类，依次调用各个阶段
+type Pipeline struct {
+	Name   string
+	Stages []base.Class
+}
+
+// 实现Class接口
+func (p *Pipeline) Do() {
+	for _, stage := range p.Stages {
+		stage.Do()
+	}
+}
+
+// 所有阶段都创建好后检查流水线
+func (p *Pipeline) Init() error {
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("pipeline %s has no stages", p.Name)
+	}
+	return nil
+}
+
+// 释放流水线，在它引用的阶段之前调用
+func (p *Pipeline) Close() error {
+	p.Stages = nil
+	return nil
+}
+
+// 流水线的工厂，参数 name 是名称，stages 引用其他对象
+func New(cfg base.Config) (base.Class, error) {
+	name, err := cfg.String("name", "pipeline")
+	if err != nil {
+		return nil, err
+	}
+	stages, err := cfg.Classes("stages")
+	if err != nil {
+		return nil, err
+	}
+	return &Pipeline{Name: name, Stages: stages}, nil
+}
+
+func init() {
+	// 在启动时注册流水线工厂
+	base.MustRegister("Pipeline", "依次调用 stages 引用的对象，参数 name、stages", New)
+}
@@ -0,0 +1,257 @@
+package graph
+
+import (
+	"code-snippet/code/008/clsfactory/base"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 需要初始化的类，在它依赖的对象都初始化之后调用
+type Initializer interface {
+	Init() error
+}
+
+// 需要释放资源的类，关闭对象图时按创建的相反顺序调用
+type Closer interface {
+	Close() error
+}
+
+// 对象之间存在循环依赖
+type CycleError struct {
+	Path []string // 循环经过的对象，首尾相同
+}
+
+func (e *CycleError) Error() string {
+	return "graph: dependency cycle: " + strings.Join(e.Path, " -> ")
+}
+
+// 按配置创建好的对象
+type Graph struct {
+	order   []string // 创建顺序
+	objects map[string]base.Class
+}
+
+// 按依赖顺序创建配置中的所有对象，每个对象创建后立即调用 Init。
+// 任何一步出错时，已经创建的对象按相反顺序 Close 后返回错误
+func Build(registry *base.Registry, spec *Spec) (*Graph, error) {
+	order, err := sortObjects(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Graph{objects: make(map[string]base.Class)}
+	for _, name := range order {
+		obj := spec.Objects[name]
+		params, err := g.resolveMap(obj.Params)
+		if err != nil {
+			return nil, g.abort(fmt.Errorf("graph: object %q: %w", name, err))
+		}
+		class, err := registry.Create(obj.Type, params)
+		if err != nil {
+			return nil, g.abort(fmt.Errorf("graph: object %q: %w", name, err))
+		}
+		g.order = append(g.order, name)
+		g.objects[name] = class
+		if init, ok := class.(Initializer); ok {
+			if err := init.Init(); err != nil {
+				return nil, g.abort(fmt.Errorf("graph: init %q: %w", name, err))
+			}
+		}
+	}
+	return g, nil
+}
+
+// 创建失败时关闭已经创建的对象
+func (g *Graph) abort(err error) error {
+	if closeErr := g.Close(); closeErr != nil {
+		return errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// 按名称获取对象，不存在时返回 nil
+func (g *Graph) Get(name string) base.Class {
+	return g.objects[name]
+}
+
+// 所有对象的名称，按创建顺序排列
+func (g *Graph) Names() []string {
+	return append([]string(nil), g.order...)
+}
+
+// 按创建的相反顺序关闭对象，返回所有 Close 的错误。关闭后对象图为空
+func (g *Graph) Close() error {
+	var errs []error
+	for i := len(g.order) - 1; i >= 0; i-- {
+		name := g.order[i]
+		if closer, ok := g.objects[name].(Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("graph: close %q: %w", name, err))
+			}
+		}
+	}
+	g.order = nil
+	g.objects = map[string]base.Class{}
+	return errors.Join(errs...)
+}
+
+// 按依赖关系排序，被依赖的对象在前，没有依赖关系的对象按名称排序
+func sortObjects(spec *Spec) ([]string, error) {
+	names := make([]string, 0, len(spec.Objects))
+	deps := make(map[string][]string, len(spec.Objects))
+	for name, obj := range spec.Objects {
+		if obj == nil || obj.Type == "" {
+			return nil, fmt.Errorf("graph: object %q has no type", name)
+		}
+		names = append(names, name)
+		list := append(append([]string(nil), obj.Depends...), references(obj.Params)...)
+		sort.Strings(list)
+		for _, dep := range list {
+			if spec.Objects[dep] == nil {
+				return nil, fmt.Errorf("graph: object %q depends on unknown object %q", name, dep)
+			}
+		}
+		deps[name] = list
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var order, path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			// path 中从 name 第一次出现的位置开始是一个环
+			for i, p := range path {
+				if p == name {
+					cycle := append(append([]string(nil), path[i:]...), name)
+					return &CycleError{Path: cycle}
+				}
+			}
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// 参数中引用的对象名
+func references(v interface{}) []string {
+	var names []string
+	switch v := v.(type) {
+	case string:
+		refs, _ := parseRefs(v)
+		names = append(names, refs...)
+	case []interface{}:
+		for _, item := range v {
+			names = append(names, references(item)...)
+		}
+	case base.Config:
+		for _, item := range v {
+			names = append(names, references(item)...)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			names = append(names, references(item)...)
+		}
+	}
+	return names
+}
+
+// 解析字符串中的引用："@a" 引用一个对象，"@a, @b" 引用一组对象，"@@" 开头表示普通的 @ 字符串。
+// 不是引用时返回 ok 为 false
+func parseRefs(s string) (names []string, ok bool) {
+	if !strings.HasPrefix(s, "@") || strings.HasPrefix(s, "@@") {
+		return nil, false
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) < 2 || item[0] != '@' {
+			return nil, false
+		}
+		names = append(names, item[1:])
+	}
+	return names, true
+}
+
+// 把参数中的引用替换为已经创建的对象，不修改原来的参数
+func (g *Graph) resolve(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		names, ok := parseRefs(v)
+		if !ok {
+			if strings.HasPrefix(v, "@@") {
+				return v[1:], nil
+			}
+			if strings.HasPrefix(v, "@") {
+				return nil, fmt.Errorf("invalid reference %q", v)
+			}
+			return v, nil
+		}
+		classes := make([]base.Class, len(names))
+		for i, name := range names {
+			classes[i] = g.objects[name]
+		}
+		if len(classes) == 1 {
+			return classes[0], nil
+		}
+		return classes, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		var classes []base.Class
+		for i, item := range v {
+			r, err := g.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = r
+			if c, ok := r.(base.Class); ok {
+				classes = append(classes, c)
+			}
+		}
+		// 全部是引用时得到一组对象
+		if len(v) > 0 && len(classes) == len(v) {
+			return classes, nil
+		}
+		return list, nil
+	case base.Config:
+		return g.resolveMap(v)
+	case map[string]interface{}:
+		return g.resolveMap(v)
+	}
+	return v, nil
+}
+
+func (g *Graph) resolveMap(m map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(m))
+	for key, item := range m {
+		r, err := g.resolve(item)
+		if err != nil {
+			return nil, fmt.Errorf("param %q: %w", key, err)
+		}
+		resolved[key] = r
+	}
+	return resolved, nil
+}
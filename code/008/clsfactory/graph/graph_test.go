@@ -0,0 +1,321 @@
+package graph_test
+
+import (
+	"code-snippet/code/008/clsfactory/base"
+	_ "code-snippet/code/008/clsfactory/cls1"
+	_ "code-snippet/code/008/clsfactory/cls2"
+	"code-snippet/code/008/clsfactory/cls3"
+	"code-snippet/code/008/clsfactory/graph"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 按配置创建对象图：
+//
+//	go test -race code-snippet/code/008/clsfactory/graph
+
+// 记录生命周期的类
+type node struct {
+	name      string
+	log       *[]string
+	deps      []base.Class
+	failInit  bool
+	failClose bool
+}
+
+func (n *node) Do() {}
+
+func (n *node) Init() error {
+	*n.log = append(*n.log, "init "+n.name)
+	if n.failInit {
+		return errors.New("init failed")
+	}
+	return nil
+}
+
+func (n *node) Close() error {
+	*n.log = append(*n.log, "close "+n.name)
+	if n.failClose {
+		return errors.New("close failed")
+	}
+	return nil
+}
+
+// 注册 Node 类的测试注册表，生命周期记录到 log 中
+func registry(log *[]string) *base.Registry {
+	r := base.Default.Scope()
+	r.MustRegister("Node", "测试用", func(cfg base.Config) (base.Class, error) {
+		n := &node{log: log}
+		var err error
+		if n.name, err = cfg.String("name", ""); err != nil {
+			return nil, err
+		}
+		if n.deps, err = cfg.Classes("deps"); err != nil {
+			return nil, err
+		}
+		if n.failInit, err = cfg.Bool("fail_init", false); err != nil {
+			return nil, err
+		}
+		if n.failClose, err = cfg.Bool("fail_close", false); err != nil {
+			return nil, err
+		}
+		if fail, _ := cfg.Bool("fail_create", false); fail {
+			return nil, errors.New("create failed")
+		}
+		*log = append(*log, "create "+n.name)
+		return n, nil
+	})
+	return r
+}
+
+func build(config string, log *[]string) (*graph.Graph, error) {
+	var spec *graph.Spec
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(config), "{") {
+		spec, err = graph.ParseJSON(strings.NewReader(config))
+	} else {
+		spec, err = graph.ParseINI(strings.NewReader(config))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return graph.Build(registry(log), spec)
+}
+
+func expect(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: got %q, want %q", what, got, want)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	var log []string
+	g, err := build(`{"objects": {
+		"app":   {"type": "Node", "params": {"name": "app", "deps": ["@db", "@cache"]}},
+		"db":    {"type": "Node", "params": {"name": "db"}, "depends": ["conf"]},
+		"cache": {"type": "Node", "params": {"name": "cache", "deps": "@conf"}},
+		"conf":  {"type": "Node", "params": {"name": "conf"}},
+		"extra": {"type": "Node", "params": {"name": "extra"}}
+	}}`, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 被依赖的对象在前，其他按名称排序
+	expect(t, "order", g.Names(), []string{"conf", "cache", "db", "app", "extra"})
+}
+
+func TestFormats(t *testing.T) {
+	var jsonLog, iniLog []string
+	g1, err := build(`{"objects": {
+		"a": {"type": "Node", "params": {"name": "a", "deps": ["@b", "@c"]}},
+		"b": {"type": "Node", "params": {"name": "b", "fail_init": false}},
+		"c": {"type": "Node", "params": {"name": "c"}, "depends": ["b"]}
+	}}`, &jsonLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2, err := build(`
+# 注释
+[a]
+type = Node
+name = a
+deps = @b, @c
+
+[b]
+type = Node
+name = b
+fail_init = false
+
+; 注释
+[c]
+type = Node
+name = c
+depends = b
+`, &iniLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "order", g2.Names(), g1.Names())
+	expect(t, "log", iniLog, jsonLog)
+}
+
+func TestReferences(t *testing.T) {
+	var log []string
+	g, err := build(`{"objects": {
+		"a": {"type": "Node", "params": {"name": "@@a", "deps": "@b, @c"}},
+		"b": {"type": "Node", "params": {"name": "b", "deps": ["@c"]}},
+		"c": {"type": "Node", "params": {"name": "c"}}
+	}}`, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := g.Get("a").(*node), g.Get("b").(*node), g.Get("c").(*node)
+	// "@@" 开头的字符串不是引用
+	if a.name != "@a" {
+		t.Fatalf("escaped name %q", a.name)
+	}
+	if len(a.deps) != 2 || a.deps[0] != b || a.deps[1] != c || len(b.deps) != 1 || b.deps[0] != c {
+		t.Fatalf("deps not resolved: %v %v", a.deps, b.deps)
+	}
+	if g.Get("missing") != nil {
+		t.Fatal("missing object found")
+	}
+
+	_, err = build("[a]\ntype = Node\ndeps = @b, c\n[b]\ntype = Node\n", &log)
+	if err == nil || !strings.Contains(err.Error(), "invalid reference") {
+		t.Fatalf("mixed reference list: %v", err)
+	}
+}
+
+func TestCycle(t *testing.T) {
+	var log []string
+	_, err := build(`{"objects": {
+		"a": {"type": "Node", "params": {"deps": "@b"}},
+		"b": {"type": "Node", "depends": ["c"]},
+		"c": {"type": "Node", "params": {"deps": ["@a"]}},
+		"d": {"type": "Node", "params": {"deps": "@a"}}
+	}}`, &log)
+	var cycle *graph.CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("cycle not detected: %v", err)
+	}
+	expect(t, "cycle", cycle.Path, []string{"a", "b", "c", "a"})
+	if len(log) != 0 {
+		t.Fatalf("objects created before the cycle was found: %q", log)
+	}
+
+	_, err = build("[self]\ntype = Node\ndeps = @self\n", &log)
+	if !errors.As(err, &cycle) || err.Error() != "graph: dependency cycle: self -> self" {
+		t.Fatalf("self reference: %v", err)
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	var log []string
+	_, err := build(`{"objects": {"a": {"type": "Node", "depends": ["ghost"]}}}`, &log)
+	if err == nil || !strings.Contains(err.Error(), `unknown object "ghost"`) {
+		t.Fatalf("unknown dependency: %v", err)
+	}
+	_, err = build(`{"objects": {"a": {"type": "Ghost"}}}`, &log)
+	if !errors.Is(err, base.ErrNotFound) || !strings.Contains(err.Error(), `object "a"`) {
+		t.Fatalf("unknown type: %v", err)
+	}
+	_, err = build(`{"objects": {"a": {"params": {}}}}`, &log)
+	if err == nil || !strings.Contains(err.Error(), "no type") {
+		t.Fatalf("missing type: %v", err)
+	}
+	// 字段拼写错误
+	_, err = build(`{"objects": {"a": {"type": "Node", "depend": ["b"]}}}`, &log)
+	if err == nil {
+		t.Fatal("unknown field accepted")
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	var log []string
+	g, err := build(`{"objects": {
+		"app": {"type": "Node", "params": {"name": "app", "deps": "@db"}},
+		"db":  {"type": "Node", "params": {"name": "db"}}
+	}}`, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"create db", "init db", "create app", "init app", "close app", "close db"}
+	expect(t, "lifecycle", log, want)
+	// 关闭后对象图为空，再次关闭不会重复调用
+	if err := g.Close(); err != nil || len(log) != len(want) || len(g.Names()) != 0 {
+		t.Fatalf("second close: %v, %q", err, log)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	config := `{"objects": {
+		"a": {"type": "Node", "params": {"name": "a"}},
+		"b": {"type": "Node", "params": {"name": "b", "deps": "@a", "fail_close": true}},
+		"c": {"type": "Node", "params": {"name": "c", "deps": "@b", %s: true}}
+	}}`
+
+	// 创建失败时关闭已经创建的对象，Close 的错误一起返回
+	var log []string
+	_, err := build(fmt.Sprintf(config, `"fail_create"`), &log)
+	if err == nil || !strings.Contains(err.Error(), `object "c": clsfactory: create "Node": create failed`) ||
+		!strings.Contains(err.Error(), `close "b": close failed`) {
+		t.Fatalf("create failure: %v", err)
+	}
+	want := []string{"create a", "init a", "create b", "init b", "close b", "close a"}
+	expect(t, "create failure", log, want)
+
+	// Init 失败的对象自己也会被关闭
+	log = nil
+	_, err = build(fmt.Sprintf(config, `"fail_init"`), &log)
+	if err == nil || !strings.Contains(err.Error(), `init "c": init failed`) {
+		t.Fatalf("init failure: %v", err)
+	}
+	want = []string{"create a", "init a", "create b", "init b", "create c", "init c", "close c", "close b", "close a"}
+	expect(t, "init failure", log, want)
+}
+
+func TestINIErrors(t *testing.T) {
+	cases := []struct {
+		config, want string
+	}{
+		{"type = Node", "line 1: key outside of a section"},
+		{"[a]\ntype = Node\n[a]", `line 3: duplicate object "a"`},
+		{"[a\ntype = Node", "line 1: unterminated section"},
+		{"[]", "line 1: empty section name"},
+		{"[a]\n\nname", "line 3: expected key = value"},
+		{"[a]\nname = x\nname = y", `line 3: duplicate key "name"`},
+	}
+	for _, c := range cases {
+		_, err := graph.ParseINI(strings.NewReader(c.config))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%q: got %v, want %q", c.config, err, c.want)
+		}
+	}
+	if _, err := graph.Load("pipeline.yaml"); err == nil {
+		t.Fatal("yaml accepted")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	// 使用全局注册表中的 Class1、Class2 和 Pipeline
+	spec, err := graph.ParseINI(strings.NewReader(`
+[main]
+type = Pipeline
+name = check
+stages = @greeter, @repeat
+
+[greeter]
+type = Class1
+message = hi
+
+[repeat]
+type = Class2
+times = 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := graph.Build(base.Default, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	p, ok := g.Get("main").(*cls3.Pipeline)
+	if !ok || len(p.Stages) != 2 || p.Stages[0] != g.Get("greeter") {
+		t.Fatalf("pipeline %+v", g.Get("main"))
+	}
+
+	// 没有阶段的流水线 Init 失败
+	spec.Objects["main"].Params["stages"] = nil
+	if _, err := graph.Build(base.Default, spec); err == nil || !strings.Contains(err.Error(), "no stages") {
+		t.Fatalf("empty pipeline: %v", err)
+	}
+}
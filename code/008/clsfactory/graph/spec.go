@@ -0,0 +1,124 @@
+package graph
+
+import (
+	"bufio"
+	"code-snippet/code/008/clsfactory/base"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 对象图的配置：对象名到对象配置的映射
+type Spec struct {
+	Objects map[string]*Object `json:"objects"`
+}
+
+// 一个对象的配置
+type Object struct {
+	Type    string      `json:"type"`              // 注册的类名
+	Params  base.Config `json:"params,omitempty"`  // 工厂的参数，"@名称" 引用其他对象
+	Depends []string    `json:"depends,omitempty"` // 没有通过参数引用、但需要先创建的对象
+}
+
+// 读取 JSON 格式的配置，例如
+//
+//	{"objects": {
+//		"greeter":  {"type": "Class1", "params": {"message": "hello"}},
+//		"pipeline": {"type": "Pipeline", "params": {"stages": ["@greeter"]}}
+//	}}
+func ParseJSON(r io.Reader) (*Spec, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	var spec Spec
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("graph: %w", err)
+	}
+	return &spec, nil
+}
+
+// 读取 INI 格式的配置，每一节是一个对象，type 和 depends 以外的键都是参数，例如
+//
+//	[greeter]
+//	type = Class1
+//	message = hello
+//
+//	[pipeline]
+//	type = Pipeline
+//	stages = @greeter, @repeat
+//
+// depends 的多个对象用逗号分隔，以 ; 或 # 开头的行是注释
+func ParseINI(r io.Reader) (*Spec, error) {
+	spec := &Spec{Objects: make(map[string]*Object)}
+	var current *Object
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == ';' || line[0] == '#':
+			continue
+		case line[0] == '[':
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("graph: line %d: unterminated section %q", n, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("graph: line %d: empty section name", n)
+			}
+			if spec.Objects[name] != nil {
+				return nil, fmt.Errorf("graph: line %d: duplicate object %q", n, name)
+			}
+			current = &Object{Params: base.Config{}}
+			spec.Objects[name] = current
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("graph: line %d: expected key = value", n)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("graph: line %d: key outside of a section", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "type":
+			current.Type = value
+		case "depends":
+			for _, dep := range strings.Split(value, ",") {
+				if dep = strings.TrimSpace(dep); dep != "" {
+					current.Depends = append(current.Depends, dep)
+				}
+			}
+		default:
+			if _, ok := current.Params[key]; ok {
+				return nil, fmt.Errorf("graph: line %d: duplicate key %q", n, key)
+			}
+			current.Params[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("graph: %w", err)
+	}
+	return spec, nil
+}
+
+// 读取配置文件，按扩展名选择 JSON 或 INI 格式
+func Load(path string) (*Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseJSON(f)
+	case ".ini":
+		return ParseINI(f)
+	}
+	return nil, fmt.Errorf("graph: %s: unknown config format", path)
+}
@@ -4,14 +4,38 @@ import (
 	"code-snippet/code/008/clsfactory/base"
 	_ "code-snippet/code/008/clsfactory/cls1"
 	_ "code-snippet/code/008/clsfactory/cls2"
+	"fmt"
+	"log"
 )
 
 func main() {
+	// 列出所有注册的类
+	for _, info := range base.List() {
+		fmt.Printf("%s: %s\n", info.Name, info.Description)
+	}
+
 	// 根据字符串动态创建一个 Class1 实例
-	c1 := base.Create("Class1")
+	c1, err := base.Create("Class1", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
 	c1.Do()
 
-	// 根据字符串动态创建一个 Class2 实例
-	c2 := base.Create("Class2")
+	// 根据字符串和参数动态创建一个 Class2 实例
+	c2, err := base.Create("Class2", base.Config{"times": 2})
+	if err != nil {
+		log.Fatal(err)
+	}
 	c2.Do()
+
+	// 名称不存在、参数错误时返回错误
+	_, err = base.Create("Class3", nil)
+	fmt.Println(err)
+	_, err = base.Create("Class2", base.Config{"times": "many"})
+	fmt.Println(err)
+
+	// 重复注册返回错误
+	fmt.Println(base.Register("Class1", "", func(base.Config) (base.Class, error) {
+		return nil, nil
+	}))
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"code-snippet/code/008/clsfactory/base"
+	_ "code-snippet/code/008/clsfactory/cls1"
+	_ "code-snippet/code/008/clsfactory/cls2"
+	_ "code-snippet/code/008/clsfactory/cls3"
+	"code-snippet/code/008/clsfactory/graph"
+	"flag"
+	"fmt"
+	"log"
+)
+
+var (
+	config = flag.String("config", "pipeline.json", "对象图的配置文件，.json 或 .ini")
+	object = flag.String("run", "main", "创建后调用的对象")
+)
+
+func main() {
+	flag.Parse()
+
+	spec, err := graph.Load(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 按依赖顺序创建所有对象
+	g, err := graph.Build(base.Default, spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("created:", g.Names())
+
+	c := g.Get(*object)
+	if c == nil {
+		g.Close()
+		log.Fatalf("object %q not found", *object)
+	}
+	c.Do()
+
+	// 按相反顺序关闭
+	if err := g.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
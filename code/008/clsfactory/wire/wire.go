@@ -0,0 +1,42 @@
+package wire
+
+import (
+	"code-snippet/code/008/clsfactory/base"
+	"code-snippet/code/008/clsfactory/cls1"
+	"code-snippet/code/008/clsfactory/cls2"
+	"code-snippet/code/008/clsfactory/cls3"
+	"code-snippet/code/010/injection/di"
+)
+
+// 直接调用各个类的工厂创建流水线，不经过注册表，注入代码由 digen 生成
+//go:generate go run code-snippet/code/010/injection/digen
+
+// 三个工厂的返回值都是 base.Class，按名称区分
+var (
+	//di:provide class1
+	_ = cls1.New
+
+	//di:provide class2
+	_ = cls2.New
+)
+
+// 两个类共用的参数
+//
+//di:provide
+func Config() base.Config {
+	return base.Config{"message": "hello", "times": 2}
+}
+
+// 流水线的阶段，嵌入 di.In 后各个字段按名称注入
+type Stages struct {
+	di.In
+	First  base.Class `inject:"class1"`
+	Second base.Class `inject:"class2"`
+}
+
+// 依次调用 Class1 和 Class2 的流水线
+//
+//di:invoke
+func Pipeline(s Stages) (base.Class, error) {
+	return cls3.New(base.Config{"name": "wired", "stages": []base.Class{s.First, s.Second}})
+}
@@ -0,0 +1,24 @@
+// Code generated by digen; DO NOT EDIT.
+
+package wire
+
+import (
+	"code-snippet/code/008/clsfactory/base"
+	"code-snippet/code/008/clsfactory/cls1"
+	"code-snippet/code/008/clsfactory/cls2"
+	"code-snippet/code/010/injection/di"
+)
+
+// InvokePipeline 注入参数并调用 Pipeline
+func InvokePipeline() (result base.Class, err error) {
+	config := Config()
+	class1, err := cls1.New(config)
+	if err != nil {
+		return result, &di.ResolveError{Path: []string{"base.Class \"class1\""}, Err: err}
+	}
+	class2, err := cls2.New(config)
+	if err != nil {
+		return result, &di.ResolveError{Path: []string{"base.Class \"class2\""}, Err: err}
+	}
+	return Pipeline(Stages{First: class1, Second: class2})
+}
@@ -12,6 +12,15 @@ type CustomerView struct {
 	CustomerService *service.CustomerService // 增加一个字段 CustomerService
 }
 
+// 创建客户界面，customerService 是它操作的客户数据
+func NewCustomerView(customerService *service.CustomerService) *CustomerView {
+	return &CustomerView{
+		Key:             "",
+		Loop:            true,
+		CustomerService: customerService,
+	}
+}
+
 // 显示所有的客户信息
 func (c *CustomerView) list() {
 	// 首先，获取到当前所有的客户信息(在切片中)
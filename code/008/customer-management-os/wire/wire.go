@@ -0,0 +1,22 @@
+package wire
+
+import (
+	"code-snippet/code/008/customer-management-os/service"
+	"code-snippet/code/008/customer-management-os/view"
+)
+
+// 客户管理系统的对象由 digen 生成的代码创建，不使用反射
+//go:generate go run code-snippet/code/010/injection/digen
+
+//di:provide
+var _ = service.NewCustomerService
+
+//di:provide
+var _ = view.NewCustomerView
+
+// 返回注入了 CustomerService 的客户界面
+//
+//di:invoke
+func View(customerView *view.CustomerView) *view.CustomerView {
+	return customerView
+}
@@ -1,14 +1,19 @@
 package main
 
+import "sync"
+
 type config struct {
 }
 
 var cfg *config
+var mu sync.Mutex
 
+// 多个 goroutine 同时判断 cfg == nil 会重复创建实例，并且存在数据竞争，判断和创建需要加锁
 func GetConfigInstance() *config {
+	mu.Lock()
+	defer mu.Unlock()
 	if cfg == nil {
 		cfg = new(config)
-		return cfg
 	}
 	return cfg
 }
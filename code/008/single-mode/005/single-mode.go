@@ -0,0 +1,84 @@
+package main
+
+import (
+	"code-snippet/code/008/single-mode/config"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// 数据库配置
+type DBConfig struct {
+	DSN      string `json:"dsn" env:"APP_DB_DSN" validate:"required"`
+	MaxConns int    `json:"max_conns" env:"APP_DB_MAX_CONNS" default:"10" validate:"min=1,max=100"`
+}
+
+// 应用的配置，依次从默认值、配置文件、环境变量和命令行参数加载
+type Config struct {
+	Addr     string        `json:"addr" env:"APP_ADDR" flag:"addr" default:":80" usage:"监听地址" validate:"required"`
+	LogLevel string        `json:"log_level" env:"APP_LOG_LEVEL" flag:"log-level" default:"info" usage:"日志级别" validate:"oneof=debug info warn error"`
+	Timeout  time.Duration `json:"timeout" env:"APP_TIMEOUT" flag:"timeout" default:"5s" usage:"请求超时" validate:"min=100ms,max=1m"`
+	Admins   []string      `json:"admins" env:"APP_ADMINS"`
+	DB       DBConfig      `json:"db"`
+	Watch    time.Duration `json:"-" flag:"watch" usage:"轮询配置文件的间隔，0 表示不监视"`
+}
+
+// 自定义校验
+func (c *Config) Validate() error {
+	if c.LogLevel == "debug" && len(c.Admins) == 0 {
+		return errors.New("debug log needs at least one admin")
+	}
+	return nil
+}
+
+// 配置文件由环境变量 APP_CONFIG 指定，默认为与本文件同目录的 config.json，
+// 这样在仓库根目录执行 go run ./code/008/single-mode/005 也能找到
+func configPath() string {
+	if path := os.Getenv("APP_CONFIG"); path != "" {
+		return path
+	}
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "config.json")
+}
+
+func main() {
+	// 配置的单例，其他地方调用 config.GetConfigInstance[Config]() 得到同一个 Store
+	s, err := config.GetConfigInstance[Config](
+		config.WithFile(configPath()),
+		config.WithEnv(nil),
+		config.WithArgs(os.Args[1:]),
+		config.WithErrorHandler(func(err error) {
+			log.Println("reload:", err)
+		}),
+	)
+	if errors.Is(err, flag.ErrHelp) {
+		// 出错时没有得到 Store，另外创建一个不带命令行参数的 Store 打印说明
+		usage, _ := config.New[Config]()
+		fmt.Println("Usage of single-mode:")
+		usage.PrintUsage(os.Stdout)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := s.Get()
+	fmt.Printf("%+v\n", *cfg)
+
+	if cfg.Watch <= 0 {
+		return
+	}
+	// 修改 config.json 后打印新的配置，按 Ctrl+C 退出
+	s.Subscribe(func(old, new *Config) {
+		fmt.Printf("reloaded: %+v\n", *new)
+	})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	s.Watch(ctx, cfg.Watch)
+}
@@ -0,0 +1,379 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 按层加载到结构体 T 的配置，后面的层覆盖前面的层：
+//
+//  1. default 标签中的默认值
+//  2. WithFile 指定的 JSON 文件，按 json 标签对应，时长可以写成 "3s" 或者纳秒数
+//  3. WithEnv 启用的环境变量，env 标签是变量名
+//  4. WithArgs 传入的命令行参数，flag 标签是参数名，usage 标签是说明
+//
+// 加载后按 validate 标签校验，*T 实现了 Validator 时再调用 Validate。
+// 加载或校验失败时保留原来的配置。Get 返回的快照不会再被修改，可以在任意 goroutine 中读取
+type Store[T any] struct {
+	fields  []field
+	file    string
+	lookup  func(string) (string, bool)
+	args    []string
+	onError func(error)
+
+	current atomic.Pointer[T]
+
+	loadMu sync.Mutex // 同一时间只有一次加载，订阅者按加载的顺序收到通知
+	raw    []byte     // 上次成功加载的文件内容
+
+	subMu  sync.Mutex
+	subs   map[int]func(old, new *T)
+	nextID int
+}
+
+// 自定义的校验，在标签校验通过后调用
+type Validator interface {
+	Validate() error
+}
+
+// 配置的选项
+type Option func(*options)
+
+type options struct {
+	file    string
+	lookup  func(string) (string, bool)
+	args    []string
+	hasArgs bool
+	onError func(error)
+}
+
+// 从 JSON 文件加载，文件中不能有结构体中没有的字段
+func WithFile(path string) Option {
+	return func(o *options) {
+		o.file = path
+	}
+}
+
+// 从环境变量加载，lookup 为 nil 时使用 os.LookupEnv
+func WithEnv(lookup func(string) (string, bool)) Option {
+	return func(o *options) {
+		if lookup == nil {
+			lookup = os.LookupEnv
+		}
+		o.lookup = lookup
+	}
+}
+
+// 从命令行参数加载，只有出现在参数中的值才覆盖前面的层，通常传入 os.Args[1:]
+func WithArgs(args []string) Option {
+	return func(o *options) {
+		o.args = args
+		o.hasArgs = true
+	}
+}
+
+// Watch 重新加载失败时的回调，默认忽略
+func WithErrorHandler(fn func(error)) Option {
+	return func(o *options) {
+		o.onError = fn
+	}
+}
+
+// 创建配置，T 必须是结构体，标签或命令行参数有误时返回错误，参数中有 -h 时返回的错误包含 flag.ErrHelp。
+// 创建后需要调用 Load
+func New[T any](opts ...Option) (*Store[T], error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	fields, err := parseFields(reflect.TypeOf((*T)(nil)).Elem(), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	s := &Store[T]{
+		fields:  fields,
+		file:    o.file,
+		lookup:  o.lookup,
+		onError: o.onError,
+		subs:    make(map[int]func(old, new *T)),
+	}
+	if o.hasArgs {
+		s.args = append([]string{}, o.args...)
+	}
+	// 提前检查命令行参数，-h 等错误在创建时就能发现
+	if s.args != nil {
+		var v T
+		fs := flagSet("config", fields, reflect.ValueOf(&v).Elem())
+		fs.SetOutput(io.Discard)
+		if err := fs.Parse(s.args); err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// 打印命令行参数的说明，默认值取自 default 标签
+func (s *Store[T]) PrintUsage(w io.Writer) {
+	v := reflect.New(reflect.TypeOf((*T)(nil)).Elem()).Elem()
+	for _, f := range s.fields {
+		if f.hasDef {
+			setString(v.FieldByIndex(f.index), f.def)
+		}
+	}
+	fs := flagSet("config", s.fields, v)
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// 当前配置的快照，Load 成功之前为 nil。快照是只读的，修改它会影响其他读取者
+func (s *Store[T]) Get() *T {
+	return s.current.Load()
+}
+
+// 重新读取所有的层，校验通过后替换当前配置并通知订阅者。失败时保留原来的配置
+func (s *Store[T]) Load() error {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+
+	var raw []byte
+	if s.file != "" {
+		var err error
+		if raw, err = os.ReadFile(s.file); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	return s.load(raw)
+}
+
+// 用读取到的文件内容加载，调用时持有 loadMu
+func (s *Store[T]) load(raw []byte) error {
+	cfg := new(T)
+	v := reflect.ValueOf(cfg).Elem()
+
+	for _, f := range s.fields {
+		if f.hasDef {
+			if err := setString(v.FieldByIndex(f.index), f.def); err != nil {
+				return &FieldError{Field: f.path, Source: "default", Err: err}
+			}
+		}
+	}
+
+	if s.file != "" {
+		if err := decodeFile(raw, s.fields, v); err != nil {
+			var fe *FieldError
+			if errors.As(err, &fe) {
+				return err
+			}
+			return fmt.Errorf("config: %s: %w", s.file, err)
+		}
+	}
+
+	if s.lookup != nil {
+		for _, f := range s.fields {
+			if f.env == "" {
+				continue
+			}
+			if value, ok := s.lookup(f.env); ok {
+				if err := setString(v.FieldByIndex(f.index), value); err != nil {
+					return &FieldError{Field: f.path, Source: "env " + f.env, Err: err}
+				}
+			}
+		}
+	}
+
+	if s.args != nil {
+		fs := flagSet("config", s.fields, v)
+		fs.SetOutput(io.Discard)
+		if err := fs.Parse(s.args); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+
+	if err := validate(s.fields, v); err != nil {
+		return err
+	}
+	if validator, ok := any(cfg).(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+
+	s.raw = raw
+	old := s.current.Swap(cfg)
+	if old != nil && reflect.DeepEqual(old, cfg) {
+		// 内容没有变化，例如只修改了文件中的空白，不通知订阅者
+		return nil
+	}
+	s.notify(old, cfg)
+	return nil
+}
+
+// 解码 JSON 文件，v 是要写入的结构体。time.Duration 字段可以写成纳秒数，
+// 也可以像默认值和环境变量一样写成 "1m30s" 这样的字符串，字符串用 setString 解析
+func decodeFile(raw []byte, fields []field, v reflect.Value) error {
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return err
+	}
+
+	// 先写入字符串形式的时长，再从文件内容中去掉，其余的值交给 encoding/json
+	changed := false
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if fv.Type() != durationType {
+			continue
+		}
+		keys, ok := jsonKeys(v.Type(), f.index)
+		if !ok {
+			continue
+		}
+		obj, key, ok := lookupKey(doc, keys)
+		if !ok {
+			continue
+		}
+		if str, isString := obj[key].(string); isString {
+			if err := setString(fv, str); err != nil {
+				return &FieldError{Field: f.path, Source: "file", Err: err}
+			}
+			delete(obj, key)
+			changed = true
+		}
+	}
+	if changed {
+		var err error
+		if raw, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+
+	decoder = json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v.Addr().Interface())
+}
+
+// 按键的路径找到 JSON 对象中的值，返回所在的对象和实际的键。
+// 与 encoding/json 相同，键优先完全匹配，其次不区分大小写
+func lookupKey(doc any, keys []string) (obj map[string]any, key string, ok bool) {
+	for i, k := range keys {
+		if i > 0 {
+			doc = obj[key]
+		}
+		if obj, ok = doc.(map[string]any); !ok {
+			return nil, "", false
+		}
+		if _, ok = obj[k]; ok {
+			key = k
+			continue
+		}
+		for name := range obj {
+			if strings.EqualFold(name, k) {
+				key, ok = name, true
+				break
+			}
+		}
+		if !ok {
+			return nil, "", false
+		}
+	}
+	return obj, key, true
+}
+
+// 订阅配置的变化，每次加载得到不同的配置后调用 fn，第一次加载时 old 为 nil。
+// fn 在加载的 goroutine 中按顺序调用，不应阻塞。返回取消订阅的函数
+func (s *Store[T]) Subscribe(fn func(old, new *T)) (cancel func()) {
+	s.subMu.Lock()
+	id := s.nextID
+	s.nextID++
+	s.subs[id] = fn
+	s.subMu.Unlock()
+
+	return func() {
+		s.subMu.Lock()
+		delete(s.subs, id)
+		s.subMu.Unlock()
+	}
+}
+
+func (s *Store[T]) notify(old, cfg *T) {
+	s.subMu.Lock()
+	ids := make([]int, 0, len(s.subs))
+	for id := range s.subs {
+		ids = append(ids, id)
+	}
+	s.subMu.Unlock()
+	// 按订阅的顺序通知
+	sort.Ints(ids)
+	for _, id := range ids {
+		s.subMu.Lock()
+		fn := s.subs[id]
+		s.subMu.Unlock()
+		if fn != nil {
+			fn(old, cfg)
+		}
+	}
+}
+
+// 每隔 interval 检查一次配置文件，内容变化后重新加载，直到 ctx 结束。
+// 重新加载失败时调用 WithErrorHandler 设置的回调并保留原来的配置，文件再次变化时重试
+func (s *Store[T]) Watch(ctx context.Context, interval time.Duration) error {
+	if s.file == "" {
+		return errors.New("config: no file to watch")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.loadMu.Lock()
+	last := s.raw // 上次检查时的文件内容，加载失败的内容不变时不重复报告
+	s.loadMu.Unlock()
+	readFailed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		raw, err := os.ReadFile(s.file)
+		if err != nil {
+			// 例如编辑器保存时先删除再创建文件，只报告一次
+			if !readFailed {
+				s.report(fmt.Errorf("config: %w", err))
+			}
+			readFailed = true
+			continue
+		}
+		readFailed = false
+		if bytes.Equal(raw, last) {
+			continue
+		}
+		last = raw
+
+		s.loadMu.Lock()
+		if !bytes.Equal(raw, s.raw) {
+			err = s.load(raw)
+		}
+		s.loadMu.Unlock()
+		if err != nil {
+			s.report(err)
+		}
+	}
+}
+
+func (s *Store[T]) report(err error) {
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
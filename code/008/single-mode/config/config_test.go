@@ -0,0 +1,474 @@
+package config_test
+
+import (
+	"code-snippet/code/008/single-mode/config"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 分层加载、校验和热加载的配置：
+//
+//	go test -race code-snippet/code/008/single-mode/config
+
+type DB struct {
+	Host string `json:"host" env:"DB_HOST" default:"localhost"`
+	Port int    `json:"port" env:"DB_PORT" flag:"db-port" default:"3306" validate:"min=1,max=65535"`
+}
+
+type Config struct {
+	Name    string        `json:"name" env:"NAME" flag:"name" default:"app" validate:"required"`
+	Debug   bool          `json:"debug" env:"DEBUG" flag:"debug"`
+	Level   string        `json:"level" flag:"level" default:"info" validate:"oneof=debug info"`
+	Timeout time.Duration `json:"timeout" env:"TIMEOUT" default:"1s" validate:"min=10ms"`
+	Tags    []string      `json:"tags" env:"TAGS" validate:"max=3"`
+	Ratio   float64       `json:"ratio" env:"RATIO" default:"0.5"`
+	Limit   uint16        `json:"limit" env:"LIMIT"`
+	DB      DB            `json:"db"`
+	Secret  string        `json:"-"`
+}
+
+// 环境变量，不读取进程的环境
+func env(pairs ...string) config.Option {
+	m := map[string]string{}
+	for i := 0; i < len(pairs); i += 2 {
+		m[pairs[i]] = pairs[i+1]
+	}
+	return config.WithEnv(func(k string) (string, bool) {
+		v, ok := m[k]
+		return v, ok
+	})
+}
+
+// 写入临时配置文件，先写到另一个文件再改名，Watch 不会读到写了一半的内容
+func writeFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(opts ...config.Option) (*config.Store[Config], error) {
+	s, err := config.New[Config](opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s, s.Load()
+}
+
+func TestDefaults(t *testing.T) {
+	s, err := config.New[Config]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Get() != nil {
+		t.Fatal("snapshot before Load")
+	}
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{Name: "app", Level: "info", Timeout: time.Second, Ratio: 0.5, DB: DB{Host: "localhost", Port: 3306}}
+	if got := *s.Get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestLayers(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, `{"name": "file", "level": "debug", "db": {"host": "db.file", "port": 1}}`)
+
+	// 文件覆盖默认值，环境变量覆盖文件，命令行参数覆盖环境变量；没有出现的值保留前面的层
+	s, err := load(
+		config.WithFile(path),
+		env("NAME", "env", "DB_PORT", "2", "DEBUG", "true"),
+		config.WithArgs([]string{"-db-port", "3", "-level=info"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Get()
+	if got.Name != "env" || got.Level != "info" || got.DB.Host != "db.file" || got.DB.Port != 3 ||
+		!got.Debug || got.Timeout != time.Second {
+		t.Fatalf("layers: %+v", *got)
+	}
+
+	// 布尔参数可以只写名称
+	s, err = load(env("DEBUG", "false"), config.WithArgs([]string{"-debug"}))
+	if err != nil || !s.Get().Debug {
+		t.Fatalf("bool flag: %v", err)
+	}
+	_, err = config.New[Config](config.WithArgs([]string{"-h"}))
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("help: %v", err)
+	}
+}
+
+func TestTypes(t *testing.T) {
+	s, err := load(env("TIMEOUT", "250ms", "TAGS", "a, b,c", "RATIO", "1.25", "LIMIT", "0x10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Get()
+	if got.Timeout != 250*time.Millisecond || !reflect.DeepEqual(got.Tags, []string{"a", "b", "c"}) ||
+		got.Ratio != 1.25 || got.Limit != 16 {
+		t.Fatalf("types: %+v", *got)
+	}
+	// 空字符串得到空切片
+	s, err = load(env("TAGS", ""))
+	if err != nil || len(s.Get().Tags) != 0 {
+		t.Fatalf("empty list: %v %v", err, s.Get().Tags)
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	var fe *config.FieldError
+	_, err := load(env("LIMIT", "70000"))
+	if !errors.As(err, &fe) || fe.Field != "Limit" || fe.Source != "env LIMIT" {
+		t.Fatalf("env overflow: %v", err)
+	}
+	_, err = load(config.WithArgs([]string{"-db-port", "x"}))
+	if err == nil || !strings.Contains(err.Error(), "-db-port") {
+		t.Fatalf("bad flag: %v", err)
+	}
+
+	dir := t.TempDir()
+	// 文件中的字段拼写错误
+	path := writeFile(t, dir, `{"nmae": "typo"}`)
+	if _, err = load(config.WithFile(path)); err == nil || !strings.Contains(err.Error(), `unknown field "nmae"`) {
+		t.Fatalf("unknown field: %v", err)
+	}
+	if _, err = load(config.WithFile(filepath.Join(dir, "missing.json"))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing file: %v", err)
+	}
+}
+
+func TestFileDuration(t *testing.T) {
+	dir := t.TempDir()
+	// 时长可以写成字符串或者纳秒数，键与 encoding/json 一样不区分大小写
+	for content, want := range map[string]time.Duration{
+		`{"timeout": "3s"}`:                 3 * time.Second,
+		`{"Timeout": "1m30s"}`:              90 * time.Second,
+		`{"timeout": 2000000000}`:           2 * time.Second,
+		`{"name": "file"}`:                  time.Second,
+		`{"timeout": "250ms", "name": "x"}`: 250 * time.Millisecond,
+	} {
+		s, err := load(config.WithFile(writeFile(t, dir, content)))
+		if err != nil {
+			t.Fatalf("%s: %v", content, err)
+		}
+		if got := s.Get().Timeout; got != want {
+			t.Fatalf("%s: timeout %s, want %s", content, got, want)
+		}
+	}
+
+	// 环境变量仍然覆盖文件
+	s, err := load(config.WithFile(writeFile(t, dir, `{"timeout": "3s"}`)), env("TIMEOUT", "4s"))
+	if err != nil || s.Get().Timeout != 4*time.Second {
+		t.Fatalf("env over file: %v", err)
+	}
+
+	var fe *config.FieldError
+	_, err = load(config.WithFile(writeFile(t, dir, `{"timeout": "3 seconds"}`)))
+	if !errors.As(err, &fe) || fe.Field != "Timeout" || fe.Source != "file" {
+		t.Fatalf("bad duration: %v", err)
+	}
+	// 校验用解析后的值
+	_, err = load(config.WithFile(writeFile(t, dir, `{"timeout": "1ms"}`)))
+	if err == nil || !strings.Contains(err.Error(), "Timeout (validate): 1ms is out of range") {
+		t.Fatalf("validate: %v", err)
+	}
+	// 去掉时长后其他字段的错误照常报告
+	_, err = load(config.WithFile(writeFile(t, dir, `{"timeout": "3s", "nmae": "typo"}`)))
+	if err == nil || !strings.Contains(err.Error(), `unknown field "nmae"`) {
+		t.Fatalf("unknown field: %v", err)
+	}
+}
+
+// 带自定义校验的配置
+type Checked struct {
+	Min int `default:"1" env:"MIN"`
+	Max int `default:"2" env:"MAX"`
+}
+
+func (c *Checked) Validate() error {
+	if c.Min > c.Max {
+		return fmt.Errorf("min %d > max %d", c.Min, c.Max)
+	}
+	return nil
+}
+
+func TestValidation(t *testing.T) {
+	// 所有字段的错误一起返回
+	_, err := load(env("NAME", "", "DB_PORT", "0", "TAGS", "a,b,c,d", "TIMEOUT", "1ms"), config.WithArgs([]string{"-level", "warn"}))
+	for _, want := range []string{
+		"Name (validate): required",
+		`Level (validate): "warn" is not one of debug info`,
+		"Timeout (validate): 1ms is out of range, min=10ms",
+		"Tags (validate): length 4 is greater than 3",
+		"DB.Port (validate): value 0 is less than 1",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("got %v, want %q", err, want)
+		}
+	}
+
+	s, err := config.New[Checked](env("MIN", "5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(); err == nil || !strings.Contains(err.Error(), "min 5 > max 2") {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	type badRule struct {
+		N int `validate:"positive"`
+	}
+	if _, err := config.New[badRule](); err == nil || !strings.Contains(err.Error(), `unknown rule "positive"`) {
+		t.Fatalf("bad rule: %v", err)
+	}
+	type badType struct {
+		M map[string]int `env:"M"`
+	}
+	if _, err := config.New[badType](); err == nil || !strings.Contains(err.Error(), "unsupported type") {
+		t.Fatalf("bad type: %v", err)
+	}
+	if _, err := config.New[int](); err == nil {
+		t.Fatal("non-struct accepted")
+	}
+}
+
+// 等待条件成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, `{"name": "v1"}`)
+
+	var mu sync.Mutex
+	var errs []error
+	var changes []string
+	s, err := config.New[Config](
+		config.WithFile(path),
+		config.WithArgs([]string{"-level", "debug"}),
+		config.WithErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	s.Subscribe(func(old, new *Config) {
+		mu.Lock()
+		changes = append(changes, old.Name+" -> "+new.Name)
+		mu.Unlock()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Watch(ctx, 5*time.Millisecond) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	errCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs)
+	}
+
+	// 修改后重新加载，命令行参数仍然覆盖文件
+	writeFile(t, dir, `{"name": "v2", "level": "info"}`)
+	waitFor(t, "reload", func() bool { return s.Get().Name == "v2" })
+	if s.Get().Level != "debug" {
+		t.Fatalf("flag lost on reload: %+v", *s.Get())
+	}
+
+	// 格式错误和校验失败时保留原来的配置，同样的内容只报告一次
+	writeFile(t, dir, `{"name": `)
+	waitFor(t, "syntax error", func() bool { return errCount() == 1 })
+	writeFile(t, dir, `{"name": ""}`)
+	waitFor(t, "validation error", func() bool { return errCount() == 2 })
+	time.Sleep(30 * time.Millisecond)
+	if errCount() != 2 || s.Get().Name != "v2" {
+		t.Fatalf("after bad edits: %d errors, name %q", errCount(), s.Get().Name)
+	}
+
+	// 只改变空白时内容相同，不通知订阅者
+	writeFile(t, dir, `{"name": "v3"}`)
+	waitFor(t, "second reload", func() bool { return s.Get().Name == "v3" })
+	writeFile(t, dir, `{ "name" : "v3" }`)
+	time.Sleep(30 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"v1 -> v2", "v2 -> v3"}; !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes %q, want %q", changes, want)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s, err := config.New[Config](env("NAME", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	cancel := s.Subscribe(func(old, new *Config) {
+		if old == nil {
+			got = append(got, "first "+new.Name)
+			return
+		}
+		got = append(got, "first "+old.Name+" -> "+new.Name)
+	})
+	s.Subscribe(func(old, new *Config) { got = append(got, "second") })
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	// 第二次加载的内容相同，不通知
+	if want := []string{"first a", "second"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, `{"name": "n0", "tags": ["n0"]}`)
+	s, err := load(config.WithFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 读取者看到的快照总是完整的：Name 和 Tags 来自同一次加载
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var bad error
+	var once sync.Once
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cfg := s.Get()
+				if len(cfg.Tags) != 1 || cfg.Tags[0] != cfg.Name {
+					once.Do(func() { bad = fmt.Errorf("torn snapshot %+v", *cfg) })
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		name := fmt.Sprintf("n%d", i)
+		writeFile(t, dir, fmt.Sprintf(`{"name": %q, "tags": [%q]}`, name, name))
+		if err := s.Load(); err != nil {
+			close(stop)
+			wg.Wait()
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if bad != nil {
+		t.Fatal(bad)
+	}
+}
+
+// 与 005 相同的单例写法
+// 只在单例检查中使用的配置类型，每个类型有自己的单例
+type Single struct {
+	Name string `env:"NAME" default:"single" validate:"required"`
+}
+
+type Broken struct {
+	Name string `env:"NAME" validate:"required"`
+}
+
+func TestSingleton(t *testing.T) {
+	// 读取 NAME 的次数就是加载的次数
+	var loads atomic.Int32
+	lookup := config.WithEnv(func(k string) (string, bool) {
+		if k != "NAME" {
+			return "", false
+		}
+		loads.Add(1)
+		return "singleton", true
+	})
+
+	var wg sync.WaitGroup
+	stores := make([]*config.Store[Config], 16)
+	errs := make([]error, len(stores))
+	for i := range stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stores[i], errs[i] = config.GetConfigInstance[Config](lookup)
+		}(i)
+	}
+	wg.Wait()
+	for i, s := range stores {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if s == nil || s != stores[0] || s.Get().Name != "singleton" {
+			t.Fatal("different instances")
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("loaded %d times", n)
+	}
+
+	// 之后的调用忽略选项
+	if s, _ := config.GetConfigInstance[Config](env("NAME", "other")); s != stores[0] || s.Get().Name != "singleton" {
+		t.Fatal("options of a later call were used")
+	}
+	// 其他类型有自己的单例
+	single, err := config.GetConfigInstance[Single]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Get().Name != "single" {
+		t.Fatalf("Single: %+v", *single.Get())
+	}
+	// 第一次加载的错误每次都返回
+	_, err = config.GetConfigInstance[Broken](env())
+	_, again := config.GetConfigInstance[Broken](env("NAME", "fixed"))
+	if err == nil || again != err {
+		t.Fatalf("Broken: %v, then %v", err, again)
+	}
+}
@@ -0,0 +1,290 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 某个字段从某个来源取值或校验失败
+type FieldError struct {
+	Field  string // 字段路径，例如 DB.Port
+	Source string // default、file、env APP_PORT、flag -port 或 validate
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("config: %s (%s): %v", e.Field, e.Source, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// 可以用标签配置的字段
+type field struct {
+	index    []int
+	path     string
+	def      string
+	hasDef   bool
+	env      string
+	flag     string
+	usage    string
+	validate []rule
+}
+
+// 校验规则，例如 min=1
+type rule struct {
+	name, arg string
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// 解析结构体的字段，嵌套的结构体展开，字段路径用 . 连接
+func parseFields(t reflect.Type, index []int, prefix string) ([]field, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: %s is not a struct", t)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		f := field{
+			index: append(index[:len(index):len(index)], i),
+			path:  prefix + sf.Name,
+			env:   sf.Tag.Get("env"),
+			flag:  sf.Tag.Get("flag"),
+			usage: sf.Tag.Get("usage"),
+		}
+		f.def, f.hasDef = sf.Tag.Lookup("default")
+		if rules := sf.Tag.Get("validate"); rules != "" {
+			for _, r := range strings.Split(rules, ",") {
+				name, arg, _ := strings.Cut(strings.TrimSpace(r), "=")
+				switch name {
+				case "required", "min", "max", "oneof":
+				default:
+					return nil, fmt.Errorf("config: %s: unknown rule %q", f.path, name)
+				}
+				f.validate = append(f.validate, rule{name: name, arg: arg})
+			}
+		}
+
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+			if f.hasDef || f.env != "" || f.flag != "" {
+				return nil, fmt.Errorf("config: %s: struct fields only take tags on their own fields", f.path)
+			}
+			nested, err := parseFields(sf.Type, f.index, f.path+".")
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+			continue
+		}
+		if !supported(sf.Type) {
+			if f.hasDef || f.env != "" || f.flag != "" {
+				return nil, fmt.Errorf("config: %s: unsupported type %s", f.path, sf.Type)
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// 字段在 JSON 文件中的键的路径，按 json 标签命名，没有标签时使用字段名。
+// 字段或外层的结构体标记为 json:"-" 时不在文件中
+func jsonKeys(t reflect.Type, index []int) (keys []string, ok bool) {
+	for _, i := range index {
+		sf := t.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			return nil, false
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		keys = append(keys, name)
+		t = sf.Type
+	}
+	return keys, true
+}
+
+// 可以从字符串解析的类型
+func supported(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Slice && supported(t.Elem())
+	}
+	return false
+}
+
+// 把字符串解析为字段的值，time.Duration 使用 time.ParseDuration，切片用逗号分隔
+func setString(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		if s != "" {
+			items = strings.Split(s, ",")
+		}
+		list := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setString(list.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(list)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// 把字段注册为命令行参数，flag.Value 直接写入字段
+type flagValue struct {
+	v reflect.Value
+}
+
+func (f flagValue) String() string {
+	if !f.v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(f.v.Interface())
+}
+
+func (f flagValue) Set(s string) error {
+	return setString(f.v, s)
+}
+
+// 布尔字段可以只写 -debug
+func (f flagValue) IsBoolFlag() bool {
+	return f.v.IsValid() && f.v.Kind() == reflect.Bool
+}
+
+// 按字段的 flag 标签创建参数集合，v 是要写入的结构体
+func flagSet(name string, fields []field, v reflect.Value) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, f := range fields {
+		if f.flag != "" {
+			fs.Var(flagValue{v.FieldByIndex(f.index)}, f.flag, f.usage)
+		}
+	}
+	return fs
+}
+
+// 按 validate 标签校验字段：required 不能是零值；min、max 限制数值的大小或者字符串、切片的长度，
+// 时长的限制写成时长；oneof 限制取值，多个值用空格分隔
+func validate(fields []field, v reflect.Value) error {
+	var errs []error
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		for _, r := range f.validate {
+			if err := check(r, fv); err != nil {
+				errs = append(errs, &FieldError{Field: f.path, Source: "validate", Err: err})
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func check(r rule, v reflect.Value) error {
+	switch r.name {
+	case "required":
+		if v.IsZero() {
+			return errors.New("required")
+		}
+		return nil
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, allowed := range strings.Fields(r.arg) {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", s, r.arg)
+	}
+
+	if v.Type() == durationType {
+		// 时长的限制也写成时长，例如 min=1s
+		limit, err := time.ParseDuration(r.arg)
+		if err != nil {
+			return fmt.Errorf("invalid %s=%s", r.name, r.arg)
+		}
+		d := time.Duration(v.Int())
+		if r.name == "min" && d < limit || r.name == "max" && d > limit {
+			return fmt.Errorf("%s is out of range, %s=%s", d, r.name, r.arg)
+		}
+		return nil
+	}
+
+	limit, err := strconv.ParseFloat(r.arg, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s=%s", r.name, r.arg)
+	}
+	var n float64
+	what := "value"
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String, reflect.Slice, reflect.Map:
+		n = float64(v.Len())
+		what = "length"
+	default:
+		return fmt.Errorf("%s does not apply to %s", r.name, v.Type())
+	}
+	if r.name == "min" && n < limit {
+		return fmt.Errorf("%s %v is less than %s", what, n, r.arg)
+	}
+	if r.name == "max" && n > limit {
+		return fmt.Errorf("%s %v is greater than %s", what, n, r.arg)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+)
+
+// 一个配置类型的单例
+type instance struct {
+	once  sync.Once
+	store any
+	err   error
+}
+
+// 配置类型 -> *instance
+var instances sync.Map
+
+// GetConfigInstance 返回配置类型 T 的单例，第一次调用时用 opts 创建并加载，
+// 之后的调用忽略 opts，返回同一个 Store 和第一次的错误。可以在任意 goroutine 中调用
+func GetConfigInstance[T any](opts ...Option) (*Store[T], error) {
+	v, _ := instances.LoadOrStore(reflect.TypeFor[T](), new(instance))
+	i := v.(*instance)
+	i.once.Do(func() {
+		s, err := New[T](opts...)
+		if err == nil {
+			err = s.Load()
+		}
+		i.store, i.err = s, err
+	})
+	return i.store.(*Store[T]), i.err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"code-snippet/code/009/mock-rpc/rpc"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+func main() {
+	// 创建进程内传输层
+	transport := rpc.NewChanTransport(16)
+	defer transport.Close()
+
+	// 注册方法并并发执行服务器逻辑
+	server := rpc.NewServer()
+	if err := rpc.Register(server, "Upper", upper); err != nil {
+		log.Fatal(err)
+	}
+	if err := rpc.Register(server, "Slow", slow); err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(transport)
+
+	// 多个客户端同时请求，应答不会串到其他调用方
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			client := rpc.NewClient(transport)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			data := fmt.Sprintf("hi from client %d", i)
+			response, err := rpc.Call[string, string](ctx, client, "Upper", data)
+			if err != nil {
+				log.Printf("client %d error: %s\n", i, err)
+				return
+			}
+			fmt.Printf("client %d received: %s\n", i, response)
+		}(i)
+	}
+	wg.Wait()
+
+	// 模拟超时，服务器处理2秒而客户端只等待1秒
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := rpc.Call[string, string](ctx, rpc.NewClient(transport), "Slow", "hi"); err != nil {
+		log.Printf("Slow error: %s\n", err)
+	}
+}
+
+// 将字符串转为大写
+func upper(ctx context.Context, data string) (string, error) {
+	fmt.Printf("Server received: %s\n", data)
+	return strings.ToUpper(data), nil
+}
+
+// 模拟耗时的处理，调用方取消时提前返回
+func slow(ctx context.Context, data string) (string, error) {
+	select {
+	case <-time.After(2 * time.Second):
+		return "ok", nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"code-snippet/code/009/mock-rpc/rpc"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 检查并发调用、取消和关闭传输层：
+//
+//	go run -race ./code/009/mock-rpc/rpc/check
+
+var checks = []struct {
+	name string
+	run  func() error
+}{
+	{"concurrent clients get their own replies", checkConcurrent},
+	{"unknown method and bad args", checkErrors},
+	{"cancel while waiting for the reply", checkCancelReply},
+	{"cancel while the queue is full", checkCancelSend},
+	{"closed transport", checkClosed},
+	{"close while waiting for the reply", checkCloseWaiting},
+}
+
+// 启动一个注册了 Echo 和 Block 的服务器。Block 在 release 关闭或调用方取消前不返回
+func serve(size int) (*rpc.ChanTransport, chan struct{}, chan error) {
+	transport := rpc.NewChanTransport(size)
+	server := rpc.NewServer()
+	release := make(chan struct{})
+	cancelled := make(chan error, 16)
+	rpc.Register(server, "Echo", func(ctx context.Context, n int) (string, error) {
+		// 打乱完成的顺序
+		time.Sleep(time.Duration(n%7) * 100 * time.Microsecond)
+		return strconv.Itoa(n), nil
+	})
+	rpc.Register(server, "Block", func(ctx context.Context, _ string) (string, error) {
+		select {
+		case <-release:
+			return "released", nil
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+			return "", ctx.Err()
+		}
+	})
+	go server.Serve(transport)
+	return transport, release, cancelled
+}
+
+func checkConcurrent() error {
+	transport, _, _ := serve(4)
+	defer transport.Close()
+
+	// 多个客户端共享传输层，每个客户端又有多个 goroutine 同时调用
+	clients := []*rpc.Client{rpc.NewClient(transport), rpc.NewClient(transport), rpc.NewClient(transport)}
+	var wg sync.WaitGroup
+	errs := make(chan error, 300)
+	for i := 0; i < 300; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := rpc.Call[int, string](context.Background(), clients[i%3], "Echo", i)
+			if err != nil {
+				errs <- err
+			} else if got != strconv.Itoa(i) {
+				errs <- fmt.Errorf("call %d got reply %s", i, got)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func checkErrors() error {
+	transport, _, _ := serve(1)
+	defer transport.Close()
+	client := rpc.NewClient(transport)
+
+	if _, err := client.Call(context.Background(), "Missing", nil); !errors.Is(err, rpc.ErrMethodNotFound) {
+		return fmt.Errorf("missing method: %v", err)
+	}
+	if _, err := rpc.Call[string, string](context.Background(), client, "Echo", "1"); !errors.Is(err, rpc.ErrBadArgs) {
+		return fmt.Errorf("bad args: %v", err)
+	}
+	if _, err := rpc.Call[int, int](context.Background(), client, "Echo", 1); err == nil {
+		return errors.New("wrong result type accepted")
+	}
+	return nil
+}
+
+func checkCancelReply() error {
+	transport, _, cancelled := serve(1)
+	defer transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := rpc.Call[string, string](ctx, rpc.NewClient(transport), "Block", "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		return fmt.Errorf("returned after %s", d)
+	}
+	// 处理函数收到的是同一个上下文
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("handler saw %v", err)
+		}
+	case <-time.After(time.Second):
+		return errors.New("handler was not cancelled")
+	}
+	return nil
+}
+
+func checkCancelSend() error {
+	// 没有服务器接收，队列满后 Send 阻塞
+	transport := rpc.NewChanTransport(1)
+	defer transport.Close()
+	client := rpc.NewClient(transport)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := transport.Send(ctx, &rpc.Request{Reply: make(chan *rpc.Response, 1)}); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Call(ctx, "Echo", 1)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("got %v", err)
+		}
+	case <-time.After(time.Second):
+		return errors.New("call blocked after cancel")
+	}
+
+	// 已经取消的上下文，服务器不再执行处理函数
+	server, release, _ := serve(1)
+	defer server.Close()
+	defer close(release)
+	if _, err := rpc.NewClient(server).Call(ctx, "Block", ""); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("cancelled call: %v", err)
+	}
+	return nil
+}
+
+func checkClosed() error {
+	transport, _, _ := serve(1)
+	client := rpc.NewClient(transport)
+	if _, err := rpc.Call[int, string](context.Background(), client, "Echo", 1); err != nil {
+		return err
+	}
+	transport.Close()
+	transport.Close()
+	if _, err := client.Call(context.Background(), "Echo", 1); !errors.Is(err, rpc.ErrClosed) {
+		return fmt.Errorf("call after close: %v", err)
+	}
+
+	// 队列满时阻塞的发送在关闭时返回
+	full := rpc.NewChanTransport(0)
+	done := make(chan error, 1)
+	go func() {
+		_, err := rpc.NewClient(full).Call(context.Background(), "Echo", 1)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	full.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, rpc.ErrClosed) {
+			return fmt.Errorf("blocked send: %v", err)
+		}
+	case <-time.After(time.Second):
+		return errors.New("blocked send did not return")
+	}
+	return nil
+}
+
+func checkCloseWaiting() error {
+	// 请求已经进入队列，但服务器不会再接收它，调用方不能一直等待
+	transport := rpc.NewChanTransport(1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := rpc.NewClient(transport).Call(context.Background(), "Echo", 1)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	transport.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, rpc.ErrClosed) {
+			return fmt.Errorf("got %v", err)
+		}
+	case <-time.After(time.Second):
+		return errors.New("call blocked after close")
+	}
+
+	// 关闭前已经处理完的请求仍然拿到应答
+	server, release, _ := serve(1)
+	result := make(chan string, 1)
+	go func() {
+		got, _ := rpc.Call[string, string](context.Background(), rpc.NewClient(server), "Block", "")
+		result <- got
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	time.Sleep(10 * time.Millisecond)
+	server.Close()
+	if got := <-result; got != "released" {
+		return fmt.Errorf("finished call got %q", got)
+	}
+	return nil
+}
+
+func main() {
+	failed := 0
+	for _, c := range checks {
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", len(checks))
+}
@@ -0,0 +1,183 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// 方法未注册
+	ErrMethodNotFound = errors.New("rpc: method not found")
+
+	// 参数类型与注册的方法不匹配
+	ErrBadArgs = errors.New("rpc: bad argument type")
+)
+
+// 请求，自带关联ID和应答通道
+type Request struct {
+	// 关联ID，由客户端生成，应答携带同一个ID。进程内的应答通道每个请求一个，
+	// 不需要按ID匹配；多个请求共用一条连接时，传输层按ID把应答路由回调用方
+	ID uint64
+
+	// 方法名称
+	Method string
+
+	// 调用参数
+	Args interface{}
+
+	// 调用方的上下文，服务器处理时使用
+	Context context.Context
+
+	// 应答通道，缓冲为1，服务器写入时不会因为调用方放弃等待而阻塞
+	Reply chan *Response
+}
+
+// 应答
+type Response struct {
+	// 对应请求的关联ID
+	ID uint64
+
+	// 返回结果
+	Result interface{}
+
+	// 调用错误
+	Err error
+}
+
+// 方法处理函数
+type HandlerFunc func(ctx context.Context, args interface{}) (interface{}, error)
+
+// RPC服务器
+type Server struct {
+	mu      sync.RWMutex
+	methods map[string]HandlerFunc
+}
+
+// 创建RPC服务器
+func NewServer() *Server {
+	return &Server{methods: make(map[string]HandlerFunc)}
+}
+
+// 按名称注册方法处理函数，重复注册返回错误
+func (s *Server) Handle(name string, handler HandlerFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.methods[name]; ok {
+		return fmt.Errorf("rpc: method %q already registered", name)
+	}
+	s.methods[name] = handler
+	return nil
+}
+
+// 按名称注册带类型的方法
+func Register[A, R any](s *Server, name string, fn func(ctx context.Context, args A) (R, error)) error {
+	return s.Handle(name, func(ctx context.Context, args interface{}) (interface{}, error) {
+		a, ok := args.(A)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s wants %T, got %T", ErrBadArgs, name, *new(A), args)
+		}
+		return fn(ctx, a)
+	})
+}
+
+// 从传输层接收请求并逐个并发处理，直到传输层关闭
+func (s *Server) Serve(t Transport) {
+	for {
+		select {
+		case request := <-t.Requests():
+			go s.dispatch(request)
+		case <-t.Done():
+			return
+		}
+	}
+}
+
+// 处理单个请求并写回应答
+func (s *Server) dispatch(request *Request) {
+	s.mu.RLock()
+	handler, ok := s.methods[request.Method]
+	s.mu.RUnlock()
+
+	response := &Response{ID: request.ID}
+
+	ctx := request.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	switch {
+	case !ok:
+		response.Err = fmt.Errorf("%w: %s", ErrMethodNotFound, request.Method)
+	case ctx.Err() != nil:
+		// 调用方已经放弃，不再执行
+		response.Err = ctx.Err()
+	default:
+		response.Result, response.Err = handler(ctx, request.Args)
+	}
+
+	request.Reply <- response
+}
+
+// RPC客户端
+type Client struct {
+	transport Transport
+	seq       uint64
+}
+
+// 创建RPC客户端，多个客户端可以共享同一个传输层
+func NewClient(t Transport) *Client {
+	return &Client{transport: t}
+}
+
+// 调用远程方法，等待应答直到上下文取消或传输层关闭
+func (c *Client) Call(ctx context.Context, method string, args interface{}) (interface{}, error) {
+	request := &Request{
+		ID:      atomic.AddUint64(&c.seq, 1),
+		Method:  method,
+		Args:    args,
+		Context: ctx,
+		Reply:   make(chan *Response, 1),
+	}
+
+	if err := c.transport.Send(ctx, request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-request.Reply:
+		return response.Result, response.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.transport.Done():
+		// 请求可能还在队列中，服务器已经不会再接收它；已经处理完的请求仍然返回应答
+		select {
+		case response := <-request.Reply:
+			return response.Result, response.Err
+		default:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// 调用带类型的远程方法
+func Call[A, R any](ctx context.Context, c *Client, method string, args A) (R, error) {
+	var zero R
+
+	result, err := c.Call(ctx, method, args)
+	if err != nil {
+		return zero, err
+	}
+	if result == nil {
+		return zero, nil
+	}
+
+	r, ok := result.(R)
+	if !ok {
+		return zero, fmt.Errorf("rpc: %s returned %T, want %T", method, result, zero)
+	}
+	return r, nil
+}
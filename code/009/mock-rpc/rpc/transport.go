@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// 传输层已关闭
+var ErrClosed = errors.New("rpc: transport closed")
+
+// 传输层接口，客户端通过 Send 投递请求，服务器通过 Requests 接收请求
+// 请求自带应答通道，基于 net.Conn 的实现可以在连接两端按 ID 把应答路由回调用方
+type Transport interface {
+	// 发送请求到服务器
+	Send(ctx context.Context, request *Request) error
+
+	// 服务器接收请求的通道
+	Requests() <-chan *Request
+
+	// 传输层关闭时被关闭的通知通道
+	Done() <-chan struct{}
+
+	// 关闭传输层
+	Close() error
+}
+
+// 进程内基于通道的传输层
+type ChanTransport struct {
+	requests chan *Request
+	done     chan struct{}
+	once     sync.Once
+}
+
+// 创建进程内传输层，size 为请求队列的缓冲大小
+func NewChanTransport(size int) *ChanTransport {
+	return &ChanTransport{
+		requests: make(chan *Request, size),
+		done:     make(chan struct{}),
+	}
+}
+
+// 发送请求，队列满时阻塞直到服务器接收、上下文取消或传输层关闭
+func (t *ChanTransport) Send(ctx context.Context, request *Request) error {
+	select {
+	case <-t.done:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case t.requests <- request:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.done:
+		return ErrClosed
+	}
+}
+
+// 服务器接收请求的通道
+func (t *ChanTransport) Requests() <-chan *Request {
+	return t.requests
+}
+
+// 关闭传输层，之后的 Send 都会返回 ErrClosed
+func (t *ChanTransport) Close() error {
+	t.once.Do(func() {
+		close(t.done)
+	})
+	return nil
+}
+
+// 传输层是否已关闭的通知通道
+func (t *ChanTransport) Done() <-chan struct{} {
+	return t.done
+}
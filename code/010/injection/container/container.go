@@ -0,0 +1,122 @@
+package main
+
+import (
+	"code-snippet/code/010/injection/di"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// 员工信息，两个 int 字段通过标签中的名称区分，不再需要 S1、S2 这样的接口
+type Staff struct {
+	Name    string `inject:"name"`
+	Company string `inject:"company"`
+	Level   int    `inject:"level"`
+	Age     int    `inject:"age"`
+}
+
+// Format 的参数，嵌入 di.In 后各个字段按标签注入
+type Profile struct {
+	di.In
+	Name    string `inject:"name"`
+	Company string `inject:"company"`
+	Level   int    `inject:"level"`
+	Age     int    `inject:"age"`
+}
+
+func Format(p Profile) {
+	fmt.Printf("name: %s, company: %s, level: %d, age: %d\n", p.Name, p.Company, p.Level, p.Age)
+}
+
+// 数据库连接
+type DB struct {
+	DSN string
+}
+
+// 提供函数的参数是它的依赖
+func NewDB(dsn string) (*DB, error) {
+	if dsn == "" {
+		return nil, errors.New("empty dsn")
+	}
+	fmt.Println("open db:", dsn)
+	return &DB{DSN: dsn}, nil
+}
+
+// 员工仓库
+type Repo struct {
+	DB *DB
+}
+
+func NewRepo(db *DB) *Repo {
+	return &Repo{DB: db}
+}
+
+// 一次请求，每个请求一个实例
+type Request struct {
+	ID int
+}
+
+// 请求的处理器，每次获取都创建
+type Handler struct {
+	Repo    *Repo
+	Request *Request
+}
+
+func NewHandler(repo *Repo, req *Request) *Handler {
+	return &Handler{Repo: repo, Request: req}
+}
+
+func main() {
+	// 控制实例的创建
+	c := di.New()
+
+	// 按名称注入值
+	c.Value("张三", di.Named("name"))
+	c.Value("阿里巴巴", di.Named("company"))
+	c.Value(8, di.Named("level"))
+	c.Value(18, di.Named("age"))
+
+	// 实现对 struct 注入
+	var s Staff
+	if err := c.Apply(&s); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("s: %+v\n", s)
+
+	// 函数反转调用
+	if _, err := c.Invoke(Format); err != nil {
+		log.Fatal(err)
+	}
+
+	// 提供函数在第一次获取时才调用，单例只创建一次
+	c.Value("mysql://localhost/staff")
+	c.Provide(NewDB)
+	c.Provide(NewRepo)
+	c.Provide(NewHandler, di.WithLifetime(di.Transient))
+	requests := 0
+	c.Provide(func() *Request {
+		requests++
+		return &Request{ID: requests}
+	}, di.WithLifetime(di.Scoped))
+
+	// 每个请求一个子容器，同一个子容器中的 Request 相同
+	for i := 0; i < 2; i++ {
+		scope := c.Scope()
+		var h1, h2 *Handler
+		scope.Resolve(&h1)
+		scope.Resolve(&h2)
+		fmt.Printf("request %d: same handler %v, same request %v, db %s\n",
+			h1.Request.ID, h1 == h2, h1.Request == h2.Request, h1.Repo.DB.DSN)
+	}
+
+	// 获取失败时错误中带有完整的依赖路径
+	var h *Handler
+	fmt.Println(c.Resolve(&h))
+
+	empty := di.New()
+	empty.Value("")
+	empty.Provide(NewDB)
+	empty.Provide(NewRepo)
+	var repo *Repo
+	fmt.Println(empty.Resolve(&repo))
+}
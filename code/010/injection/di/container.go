@@ -0,0 +1,568 @@
+package di
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// 没有绑定
+	ErrNotFound = errors.New("no binding")
+	// 依赖形成了环
+	ErrCycle = errors.New("dependency cycle")
+	// 在根容器中获取 Scoped 的绑定，或者单例依赖了 Scoped 的绑定
+	ErrNoScope = errors.New("scoped binding resolved outside of a scope")
+	// 同一个容器中重复绑定
+	ErrDuplicate = errors.New("duplicate binding")
+)
+
+// 获取依赖失败，Path 是从请求的类型到出错的类型经过的依赖
+type ResolveError struct {
+	Path []string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("di: %s: %v", strings.Join(e.Path, " -> "), e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// 实例的生命周期
+type Lifetime int
+
+const (
+	Singleton Lifetime = iota // 绑定所在的容器中只创建一次，默认值
+	Transient                 // 每次获取都创建新的实例
+	Scoped                    // 每个 Scope 中创建一次
+)
+
+func (l Lifetime) String() string {
+	switch l {
+	case Singleton:
+		return "singleton"
+	case Transient:
+		return "transient"
+	case Scoped:
+		return "scoped"
+	}
+	return fmt.Sprintf("Lifetime(%d)", int(l))
+}
+
+// 嵌入到结构体中作为提供函数或 Invoke 的参数时，结构体的字段按 inject 标签分别注入
+type In struct{}
+
+var (
+	inType        = reflect.TypeOf(In{})
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	containerType = reflect.TypeOf((*Container)(nil))
+)
+
+// 绑定的键：类型和名称
+type key struct {
+	typ  reflect.Type
+	name string
+}
+
+func (k key) String() string {
+	if k.name == "" {
+		return k.typ.String()
+	}
+	return fmt.Sprintf("%s %q", k.typ, k.name)
+}
+
+// 一个绑定
+type binding struct {
+	key      key
+	lifetime Lifetime
+	owner    *Container
+	value    reflect.Value // Value 绑定的值
+	fn       reflect.Value // 提供函数
+	hasErr   bool          // 提供函数的第二个返回值是 error
+}
+
+// 缓存的实例，并发获取同一个实例时只创建一次
+type instance struct {
+	once sync.Once
+	v    reflect.Value
+	err  error
+}
+
+// 依赖注入容器，可以被多个 goroutine 同时使用
+type Container struct {
+	parent *Container
+
+	mu        sync.RWMutex
+	bindings  map[key]*binding
+	instances map[*binding]*instance
+}
+
+// 创建根容器
+func New() *Container {
+	return &Container{
+		bindings:  make(map[key]*binding),
+		instances: make(map[*binding]*instance),
+	}
+}
+
+// 创建子容器。子容器可以获取上级的绑定，Scoped 的绑定在每个子容器中创建一次，
+// 在子容器中添加的绑定只在子容器中可见，可以覆盖上级的同名绑定
+func (c *Container) Scope() *Container {
+	s := New()
+	s.parent = c
+	return s
+}
+
+// 绑定的选项
+type Option func(*binding)
+
+// 绑定的名称，对应字段标签 inject:"name"
+func Named(name string) Option {
+	return func(b *binding) {
+		b.key.name = name
+	}
+}
+
+// 绑定到接口类型，参数是接口的指针，例如 (*io.Writer)(nil)
+func As(ifacePtr interface{}) Option {
+	return func(b *binding) {
+		b.key.typ = reflect.TypeOf(ifacePtr).Elem()
+	}
+}
+
+// 实例的生命周期，默认为 Singleton
+func WithLifetime(l Lifetime) Option {
+	return func(b *binding) {
+		b.lifetime = l
+	}
+}
+
+// 绑定一个值，相当于总是返回这个值的单例
+func (c *Container) Value(v interface{}, opts ...Option) error {
+	if v == nil {
+		return errors.New("di: nil value")
+	}
+	rv := reflect.ValueOf(v)
+	b := &binding{key: key{typ: rv.Type()}, value: rv}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.lifetime = Singleton
+	if b.key.typ.Kind() == reflect.Interface && rv.Type().Implements(b.key.typ) {
+		// 通过 As 绑定到接口时保存为接口类型的值
+		iface := reflect.New(b.key.typ).Elem()
+		iface.Set(rv)
+		b.value = iface
+	}
+	return c.add(b, rv.Type())
+}
+
+// 绑定提供函数，函数的返回值是 T 或 (T, error)，参数是它的依赖。
+// 提供函数在第一次获取时才调用
+func (c *Container) Provide(provider interface{}, opts ...Option) error {
+	fn := reflect.ValueOf(provider)
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return fmt.Errorf("di: provider %s is not a function", t)
+	}
+	if t.NumOut() == 0 || t.NumOut() > 2 || (t.NumOut() == 2 && t.Out(1) != errorType) {
+		return fmt.Errorf("di: provider %s must return T or (T, error)", t)
+	}
+	if t.IsVariadic() {
+		return fmt.Errorf("di: provider %s is variadic", t)
+	}
+	b := &binding{key: key{typ: t.Out(0)}, fn: fn, hasErr: t.NumOut() == 2}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return c.add(b, t.Out(0))
+}
+
+// 检查并添加绑定，typ 是值的实际类型
+func (c *Container) add(b *binding, typ reflect.Type) error {
+	if !typ.AssignableTo(b.key.typ) {
+		return fmt.Errorf("di: %s does not implement %s", typ, b.key.typ)
+	}
+	if b.lifetime < Singleton || b.lifetime > Scoped {
+		return fmt.Errorf("di: invalid lifetime %d", b.lifetime)
+	}
+	b.owner = c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.bindings[b.key]; ok {
+		return fmt.Errorf("di: %s: %w", b.key, ErrDuplicate)
+	}
+	c.bindings[b.key] = b
+	return nil
+}
+
+// 查找绑定，当前容器中没有时查找上级
+func (c *Container) lookup(k key) *binding {
+	for ; c != nil; c = c.parent {
+		c.mu.RLock()
+		b := c.bindings[k]
+		c.mu.RUnlock()
+		if b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+// 获取 ptr 指向的类型的实例并保存到 ptr 中
+func (c *Container) Resolve(ptr interface{}) error {
+	return c.ResolveNamed(ptr, "")
+}
+
+// 按名称获取实例并保存到 ptr 中
+func (c *Container) ResolveNamed(ptr interface{}, name string) error {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("di: Resolve needs a non-nil pointer, got %T", ptr)
+	}
+	v, err := c.get([]key{{typ: rv.Type().Elem(), name: name}}, nil)
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(v[0])
+	return nil
+}
+
+// 按结构体字段的 inject 标签注入，标签的值是绑定的名称，inject:"" 按类型注入，没有标签的字段保持不变
+func (c *Container) Apply(structPtr interface{}) error {
+	rv := reflect.ValueOf(structPtr)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("di: Apply needs a pointer to struct, got %T", structPtr)
+	}
+	keys, fields, err := fieldKeys(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	values, err := c.get(keys, nil)
+	if err != nil {
+		return err
+	}
+	for i, v := range values {
+		rv.Elem().Field(fields[i]).Set(v)
+	}
+	return nil
+}
+
+// 按参数类型注入并调用函数，返回函数的返回值。函数的最后一个返回值是非 nil 的 error 时同时作为错误返回
+func (c *Container) Invoke(fn interface{}) ([]reflect.Value, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		return nil, fmt.Errorf("di: Invoke needs a function, got %T", fn)
+	}
+	t := fv.Type()
+	if err := c.planArgs(t, nil, make(map[planned]bool)); err != nil {
+		return nil, err
+	}
+	args, err := c.args(t, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := fv.Call(args)
+	if n := len(out); n > 0 && t.Out(n-1) == errorType && !out[n-1].IsNil() {
+		return out, out[n-1].Interface().(error)
+	}
+	return out, nil
+}
+
+// 正在调用的提供函数
+type frame struct {
+	path []key       // 从请求的类型到这个提供函数经过的依赖
+	done atomic.Bool // 提供函数返回后置为 true，之后调用延迟获取的函数不再属于这次获取
+}
+
+func (f *frame) keys() []key {
+	if f == nil || f.done.Load() {
+		return nil
+	}
+	return f.path
+}
+
+func resolveError(path []key, err error) error {
+	list := make([]string, len(path))
+	for i, k := range path {
+		list[i] = k.String()
+	}
+	return &ResolveError{Path: list, Err: err}
+}
+
+// 先检查再获取一组依赖，检查通过后才调用提供函数
+func (c *Container) get(keys []key, parent *frame) ([]reflect.Value, error) {
+	seen := make(map[planned]bool)
+	for _, k := range keys {
+		if err := c.plan(k, parent.keys(), seen); err != nil {
+			return nil, err
+		}
+	}
+	values := make([]reflect.Value, len(keys))
+	for i, k := range keys {
+		v, err := c.resolve(k, parent)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// 已经检查过的绑定和获取它的容器
+type planned struct {
+	c *Container
+	b *binding
+}
+
+// 不调用提供函数，检查依赖是否都有绑定、是否有环以及生命周期是否正确
+func (c *Container) plan(k key, path []key, seen map[planned]bool) error {
+	path = append(path[:len(path):len(path)], k)
+	for _, p := range path[:len(path)-1] {
+		if p == k {
+			return resolveError(path, ErrCycle)
+		}
+	}
+
+	b := c.lookup(k)
+	if b == nil {
+		// 延迟获取的函数只检查目标有没有绑定，它不参与依赖环
+		if _, ok := c.lazyTarget(k); ok || (k.typ == containerType && k.name == "") {
+			return nil
+		}
+		return resolveError(path, ErrNotFound)
+	}
+	if !b.fn.IsValid() {
+		return nil
+	}
+	from := c
+	switch b.lifetime {
+	case Scoped:
+		if c.parent == nil {
+			return resolveError(path, ErrNoScope)
+		}
+	case Singleton:
+		from = b.owner
+	}
+	if seen[planned{from, b}] {
+		return nil
+	}
+	if err := from.planArgs(b.fn.Type(), path, seen); err != nil {
+		return err
+	}
+	seen[planned{from, b}] = true
+	return nil
+}
+
+func (c *Container) planArgs(t reflect.Type, path []key, seen map[planned]bool) error {
+	keys, err := paramKeys(t)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := c.plan(k, path, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 函数参数对应的键，嵌入了 In 的结构体展开为各个字段
+func paramKeys(t reflect.Type) ([]key, error) {
+	var keys []key
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if !isIn(in) {
+			keys = append(keys, key{typ: in})
+			continue
+		}
+		fields, _, err := fieldKeys(in)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fields...)
+	}
+	return keys, nil
+}
+
+// 带有 inject 标签的字段对应的键和字段的序号
+func fieldKeys(t reflect.Type) ([]key, []int, error) {
+	var keys []key
+	var index []int
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := field.Tag.Lookup("inject")
+		if !ok {
+			continue
+		}
+		if !field.IsExported() {
+			return nil, nil, fmt.Errorf("di: field %s.%s is not exported", t, field.Name)
+		}
+		keys = append(keys, key{typ: field.Type, name: name})
+		index = append(index, i)
+	}
+	return keys, index, nil
+}
+
+// 结构体是否嵌入了 In
+func isIn(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Anonymous && f.Type == inType {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取实例，parent 是需要这个实例的提供函数
+func (c *Container) resolve(k key, parent *frame) (reflect.Value, error) {
+	path := parent.keys()
+	path = append(path[:len(path):len(path)], k)
+
+	b := c.lookup(k)
+	if b == nil {
+		if target, ok := c.lazyTarget(k); ok {
+			return c.lazy(k.typ, target, parent), nil
+		}
+		if k.typ == containerType && k.name == "" {
+			return reflect.ValueOf(c), nil
+		}
+		return reflect.Value{}, resolveError(path, ErrNotFound)
+	}
+	if !b.fn.IsValid() {
+		return b.value, nil
+	}
+
+	switch b.lifetime {
+	case Transient:
+		return c.create(b, path)
+	case Scoped:
+		return c.cached(b, path)
+	default:
+		// 单例的依赖从绑定所在的容器获取
+		return b.owner.cached(b, path)
+	}
+}
+
+// 从缓存中获取实例，没有时创建
+func (c *Container) cached(b *binding, path []key) (reflect.Value, error) {
+	c.mu.Lock()
+	inst := c.instances[b]
+	if inst == nil {
+		inst = &instance{}
+		c.instances[b] = inst
+	}
+	c.mu.Unlock()
+
+	inst.once.Do(func() {
+		inst.v, inst.err = c.create(b, path)
+	})
+	if inst.err != nil {
+		// 失败的实例不缓存，下次获取时重试
+		c.mu.Lock()
+		if c.instances[b] == inst {
+			delete(c.instances, b)
+		}
+		c.mu.Unlock()
+	}
+	return inst.v, inst.err
+}
+
+// 获取依赖并调用提供函数
+func (c *Container) create(b *binding, path []key) (reflect.Value, error) {
+	f := &frame{path: path}
+	defer f.done.Store(true)
+
+	args, err := c.args(b.fn.Type(), f)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	out := b.fn.Call(args)
+	if b.hasErr && !out[1].IsNil() {
+		return reflect.Value{}, resolveError(path, out[1].Interface().(error))
+	}
+	v := out[0]
+	if v.Type() != b.key.typ {
+		// 通过 As 绑定到接口时转换为接口类型
+		iface := reflect.New(b.key.typ).Elem()
+		iface.Set(v)
+		v = iface
+	}
+	return v, nil
+}
+
+// 按函数的参数类型获取依赖
+func (c *Container) args(t reflect.Type, f *frame) ([]reflect.Value, error) {
+	args := make([]reflect.Value, t.NumIn())
+	for i := range args {
+		in := t.In(i)
+		if !isIn(in) {
+			v, err := c.resolve(key{typ: in}, f)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+			continue
+		}
+		v := reflect.New(in).Elem()
+		keys, fields, err := fieldKeys(in)
+		if err != nil {
+			return nil, err
+		}
+		for j, k := range keys {
+			fv, err := c.resolve(k, f)
+			if err != nil {
+				return nil, err
+			}
+			v.Field(fields[j]).Set(fv)
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+// 没有绑定的 func() T 或 func() (T, error)，在 T 有绑定时注入为延迟获取 T 的函数
+func (c *Container) lazyTarget(k key) (key, bool) {
+	t := k.typ
+	if t.Kind() != reflect.Func || t.NumIn() != 0 || t.NumOut() == 0 || t.NumOut() > 2 ||
+		(t.NumOut() == 2 && t.Out(1) != errorType) {
+		return key{}, false
+	}
+	target := key{typ: t.Out(0), name: k.name}
+	return target, c.lookup(target) != nil
+}
+
+// 创建延迟获取 target 的函数，调用时才获取，可以用来打破依赖环。
+// func() T 的形式获取失败时 panic
+func (c *Container) lazy(t reflect.Type, target key, parent *frame) reflect.Value {
+	return reflect.MakeFunc(t, func([]reflect.Value) []reflect.Value {
+		// 在提供函数返回前调用时仍然属于这次获取，这样可以发现通过它形成的环
+		values, err := c.get([]key{target}, parent)
+		var v reflect.Value
+		if err == nil {
+			v = values[0]
+		} else {
+			v = reflect.New(target.typ).Elem()
+		}
+		if t.NumOut() == 1 {
+			if err != nil {
+				panic(err)
+			}
+			return []reflect.Value{v}
+		}
+		errv := reflect.New(errorType).Elem()
+		if err != nil {
+			errv.Set(reflect.ValueOf(err))
+		}
+		return []reflect.Value{v, errv}
+	})
+}
@@ -0,0 +1,338 @@
+package di_test
+
+import (
+	"code-snippet/code/010/injection/di"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 依赖注入容器：
+//
+//	go test -race code-snippet/code/010/injection/di
+
+type Staff struct {
+	Name  string `inject:"name"`
+	Level int    `inject:"level"`
+	Age   int    `inject:"age"`
+	Note  string // 没有标签，不注入
+}
+
+func staffContainer() *di.Container {
+	c := di.New()
+	c.Value("张三", di.Named("name"))
+	c.Value(8, di.Named("level"))
+	c.Value(18, di.Named("age"))
+	return c
+}
+
+func TestApply(t *testing.T) {
+	c := staffContainer()
+	s := Staff{Note: "keep"}
+	if err := c.Apply(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s != (Staff{Name: "张三", Level: 8, Age: 18, Note: "keep"}) {
+		t.Fatalf("staff %+v", s)
+	}
+	var age int
+	if err := c.ResolveNamed(&age, "age"); err != nil || age != 18 {
+		t.Fatalf("age %d: %v", age, err)
+	}
+	// 没有不带名称的 int
+	if err := c.Resolve(&age); !errors.Is(err, di.ErrNotFound) {
+		t.Fatalf("unnamed int: %v", err)
+	}
+	if err := c.Apply(s); err == nil {
+		t.Fatal("Apply accepted a non-pointer")
+	}
+}
+
+type Profile struct {
+	di.In
+	Name string `inject:"name"`
+	Age  int    `inject:"age"`
+}
+
+func TestInvoke(t *testing.T) {
+	c := staffContainer()
+	out, err := c.Invoke(func(p Profile, self *di.Container) string {
+		if self != c {
+			return "wrong container"
+		}
+		return fmt.Sprintf("%s %d", p.Name, p.Age)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out[0].String(); got != "张三 18" {
+		t.Fatalf("invoke returned %q", got)
+	}
+	// 最后一个返回值是 error 时作为错误返回
+	_, err = c.Invoke(func(p Profile) error { return errors.New("boom") })
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("invoke error: %v", err)
+	}
+}
+
+type DB struct{ DSN string }
+type Repo struct{ DB *DB }
+type Service struct{ Repo *Repo }
+
+func TestProviders(t *testing.T) {
+	c := di.New()
+	c.Value("mysql://x")
+	calls := 0
+	c.Provide(func(dsn string) *DB { calls++; return &DB{DSN: dsn} })
+	c.Provide(func(db *DB) (*Repo, error) { return &Repo{DB: db}, nil })
+	c.Provide(func(r *Repo) *Service { return &Service{Repo: r} })
+	if calls != 0 {
+		t.Fatal("provider called before resolve")
+	}
+	var s *Service
+	if err := c.Resolve(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Repo.DB.DSN != "mysql://x" || calls != 1 {
+		t.Fatalf("service %+v, %d calls", s, calls)
+	}
+
+	// 错误中带有完整的依赖路径
+	c = di.New()
+	c.Provide(func(db *DB) (*Repo, error) { return nil, errors.New("no db") })
+	c.Provide(func(r *Repo) *Service { return &Service{Repo: r} })
+	c.Value(&DB{})
+	err := c.Resolve(&s)
+	var re *di.ResolveError
+	if !errors.As(err, &re) || err.Error() != "di: *di_test.Service -> *di_test.Repo: no db" {
+		t.Fatalf("provider error: %v", err)
+	}
+	c = di.New()
+	c.Provide(func(r *Repo) *Service { return &Service{Repo: r} })
+	err = c.Resolve(&s)
+	if !errors.Is(err, di.ErrNotFound) || !strings.Contains(err.Error(), "*di_test.Service -> *di_test.Repo") {
+		t.Fatalf("missing dependency: %v", err)
+	}
+	if err := c.Provide(func() {}); err == nil {
+		t.Fatal("provider without results accepted")
+	}
+	if err := c.Provide(func() (int, string) { return 0, "" }); err == nil {
+		t.Fatal("second result other than error accepted")
+	}
+}
+
+type Request struct{ ID int }
+type Handler struct {
+	Req *Request
+	DB  *DB
+}
+
+func lifetimeContainer() *di.Container {
+	c := di.New()
+	var requests int64
+	c.Provide(func() *DB { return &DB{} })
+	c.Provide(func() *Request {
+		return &Request{ID: int(atomic.AddInt64(&requests, 1))}
+	}, di.WithLifetime(di.Scoped))
+	c.Provide(func(r *Request, db *DB) *Handler {
+		return &Handler{Req: r, DB: db}
+	}, di.WithLifetime(di.Transient))
+	return c
+}
+
+func TestLifetimes(t *testing.T) {
+	c := lifetimeContainer()
+	s1, s2 := c.Scope(), c.Scope()
+	var a, b, d *Handler
+	if err := s1.Resolve(&a); err != nil {
+		t.Fatal(err)
+	}
+	s1.Resolve(&b)
+	s2.Resolve(&d)
+	if a == b {
+		t.Fatal("transient instance reused")
+	}
+	if a.Req != b.Req || a.Req == d.Req {
+		t.Fatalf("scoped requests %d %d %d", a.Req.ID, b.Req.ID, d.Req.ID)
+	}
+	if a.DB != d.DB {
+		t.Fatal("singleton created twice")
+	}
+	var db *DB
+	if c.Resolve(&db); db != a.DB {
+		t.Fatal("scope and root see different singletons")
+	}
+	if di.Scoped.String() != "scoped" {
+		t.Fatalf("lifetime name %q", di.Scoped)
+	}
+}
+
+func TestNoScope(t *testing.T) {
+	c := lifetimeContainer()
+	var h *Handler
+	if err := c.Resolve(&h); !errors.Is(err, di.ErrNoScope) {
+		t.Fatalf("scoped from root: %v", err)
+	}
+	// 单例依赖 Scoped 的绑定时，即使在 Scope 中获取也是错误
+	c.Provide(func(r *Request) *Service { return &Service{} })
+	var s *Service
+	err := c.Scope().Resolve(&s)
+	if !errors.Is(err, di.ErrNoScope) || !strings.Contains(err.Error(), "*di_test.Service -> *di_test.Request") {
+		t.Fatalf("singleton depends on scoped: %v", err)
+	}
+}
+
+type A struct{ B *B }
+type B struct{ C *C }
+type C struct{ A *A }
+
+func TestCycle(t *testing.T) {
+	c := di.New()
+	calls := 0
+	c.Provide(func(b *B) *A { calls++; return &A{B: b} })
+	c.Provide(func(x *C) *B { calls++; return &B{C: x} })
+	c.Provide(func(a *A) *C { calls++; return &C{A: a} })
+	var a *A
+	err := c.Resolve(&a)
+	if !errors.Is(err, di.ErrCycle) ||
+		!strings.Contains(err.Error(), "*di_test.A -> *di_test.B -> *di_test.C -> *di_test.A") {
+		t.Fatalf("cycle: %v", err)
+	}
+	// 在调用任何提供函数之前发现
+	if calls != 0 {
+		t.Fatalf("%d providers called", calls)
+	}
+}
+
+type Parent struct {
+	Child *Child
+}
+type Child struct {
+	Parent func() *Parent
+}
+
+func TestLazy(t *testing.T) {
+	// 通过延迟获取的函数打破依赖环
+	c := di.New()
+	c.Provide(func(ch *Child) *Parent { return &Parent{Child: ch} })
+	c.Provide(func(p func() *Parent) *Child { return &Child{Parent: p} })
+	var p *Parent
+	if err := c.Resolve(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Child.Parent() != p {
+		t.Fatal("lazy parent is a different instance")
+	}
+
+	// 在提供函数中立即调用仍然是环
+	c = di.New()
+	c.Provide(func(ch *Child) *Parent { return &Parent{Child: ch} })
+	c.Provide(func(p func() (*Parent, error)) (*Child, error) {
+		_, err := p()
+		return &Child{}, err
+	})
+	if err := c.Resolve(&p); !errors.Is(err, di.ErrCycle) {
+		t.Fatalf("eager call of lazy dependency: %v", err)
+	}
+}
+
+type Greeter interface{ Greet() string }
+type english struct{}
+
+func (english) Greet() string { return "hello" }
+
+type chinese struct{}
+
+func (chinese) Greet() string { return "你好" }
+
+func TestBindings(t *testing.T) {
+	c := di.New()
+	if err := c.Value(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Value(2); !errors.Is(err, di.ErrDuplicate) {
+		t.Fatalf("duplicate: %v", err)
+	}
+	// 同类型不同名称不冲突
+	if err := c.Value(2, di.Named("two")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按接口绑定
+	if err := c.Value(english{}, di.As((*Greeter)(nil))); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Provide(func() chinese { return chinese{} }, di.As((*Greeter)(nil)), di.Named("zh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Value(1, di.As((*Greeter)(nil)), di.Named("int")); err == nil {
+		t.Fatal("int bound as Greeter")
+	}
+	var g, zh Greeter
+	if err := c.Resolve(&g); err != nil || g.Greet() != "hello" {
+		t.Fatalf("greeter: %v", err)
+	}
+	if err := c.ResolveNamed(&zh, "zh"); err != nil || zh.Greet() != "你好" {
+		t.Fatalf("named greeter: %v", err)
+	}
+
+	// 子容器中的绑定覆盖上级
+	scope := c.Scope()
+	if err := scope.Value(3); err != nil {
+		t.Fatal(err)
+	}
+	var n, m int
+	scope.Resolve(&n)
+	c.Resolve(&m)
+	if n != 3 || m != 1 {
+		t.Fatalf("override: scope %d, root %d", n, m)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	c := di.New()
+	var calls int64
+	c.Provide(func() *DB {
+		atomic.AddInt64(&calls, 1)
+		return &DB{}
+	})
+	var wg sync.WaitGroup
+	dbs := make([]*DB, 32)
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Scope().Resolve(&dbs[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, db := range dbs {
+		if db == nil || db != dbs[0] {
+			t.Fatal("different singleton instances")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("provider called %d times", calls)
+	}
+
+	// 失败的单例不缓存
+	c = di.New()
+	fail := true
+	c.Provide(func() (*DB, error) {
+		if fail {
+			return nil, errors.New("not ready")
+		}
+		return &DB{}, nil
+	})
+	var db *DB
+	if err := c.Resolve(&db); err == nil {
+		t.Fatal("failure not reported")
+	}
+	fail = false
+	if err := c.Resolve(&db); err != nil || db == nil {
+		t.Fatalf("retry: %v", err)
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"code-snippet/code/008/clsfactory/base"
+	"code-snippet/code/008/clsfactory/cls1"
+	"code-snippet/code/008/clsfactory/cls2"
+	clswire "code-snippet/code/008/clsfactory/wire"
+	"code-snippet/code/008/customer-management-os/service"
+	"code-snippet/code/008/customer-management-os/view"
+	customerwire "code-snippet/code/008/customer-management-os/wire"
+	"code-snippet/code/010/injection/di"
+	"code-snippet/code/010/injection/digen/sample"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// 检查 digen 生成的代码与 di 容器得到相同的对象，并在生成时报告错误：
+//
+//	go run ./code/010/injection/digen/check
+//
+// 需要 go 命令编译 digen 和生成的代码
+
+var checks = []struct {
+	name string
+	run  func() error
+}{
+	{"staff matches the container", checkStaff},
+	{"format matches the container", checkFormat},
+	{"provider graph matches the container", checkGraph},
+	{"provider errors match the container", checkProviderError},
+	{"customer view matches the container", checkCustomerView},
+	{"class pipeline matches the container", checkPipeline},
+	{"generated file is up to date", checkUpToDate},
+	{"generated code runs", checkRun},
+	{"missing providers", checkMissing},
+	{"ambiguous providers", checkAmbiguous},
+	{"dependency cycles", checkCycle},
+	{"unsupported declarations", checkUnsupported},
+}
+
+// 与生成的代码使用相同提供函数的容器
+func container() *di.Container {
+	c := di.New()
+	c.Provide(sample.Name, di.Named("name"))
+	c.Provide(sample.Company, di.Named("company"))
+	c.Provide(sample.Level, di.Named("level"))
+	c.Provide(sample.Age, di.Named("age"))
+	c.Provide(sample.LoadDSN)
+	c.Provide(sample.NewDB)
+	c.Provide(sample.NewRepo)
+	c.Provide(sample.NewService)
+	return c
+}
+
+func checkStaff() error {
+	var want, got sample.Staff
+	if err := container().Apply(&want); err != nil {
+		return err
+	}
+	if err := sample.ApplyStaff(&got); err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("generated %+v, container %+v", got, want)
+	}
+	return nil
+}
+
+func checkFormat() error {
+	out, err := container().Invoke(sample.Format)
+	if err != nil {
+		return err
+	}
+	got, err := sample.InvokeFormat()
+	if err != nil {
+		return err
+	}
+	if want := out[0].String(); got != want {
+		return fmt.Errorf("generated %q, container %q", got, want)
+	}
+	return nil
+}
+
+func checkGraph() error {
+	// Describe 检查 Repo 和 Service 使用同一个 DB，生成的代码与容器中的单例一样只创建一次
+	out, err := container().Invoke(sample.Describe)
+	if err != nil {
+		return err
+	}
+	got, err := sample.InvokeDescribe()
+	if err != nil {
+		return err
+	}
+	if want := out[0].String(); got != want {
+		return fmt.Errorf("generated %q, container %q", got, want)
+	}
+	return nil
+}
+
+func checkProviderError() error {
+	os.Setenv("STAFF_DSN", "bad")
+	defer os.Unsetenv("STAFF_DSN")
+	_, want := container().Invoke(sample.Describe)
+	_, got := sample.InvokeDescribe()
+	var re *di.ResolveError
+	if !errors.As(got, &re) || want == nil || got.Error() != want.Error() {
+		return fmt.Errorf("generated %v, container %v", got, want)
+	}
+	return nil
+}
+
+func checkCustomerView() error {
+	c := di.New()
+	c.Provide(service.NewCustomerService)
+	c.Provide(view.NewCustomerView)
+	out, err := c.Invoke(customerwire.View)
+	if err != nil {
+		return err
+	}
+	got, err := customerwire.InvokeView()
+	if err != nil {
+		return err
+	}
+	if want := out[0].Interface(); !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("generated %+v, container %+v", got, want)
+	}
+	return nil
+}
+
+func checkPipeline() error {
+	// 三个工厂返回相同的接口类型，容器中同样按名称注册
+	c := di.New()
+	c.Provide(clswire.Config)
+	c.Provide(cls1.New, di.Named("class1"))
+	c.Provide(cls2.New, di.Named("class2"))
+	out, err := c.Invoke(clswire.Pipeline)
+	if err != nil {
+		return err
+	}
+	if !out[1].IsNil() {
+		return out[1].Interface().(error)
+	}
+	got, err := clswire.InvokePipeline()
+	if err != nil {
+		return err
+	}
+	want := out[0].Interface().(base.Class)
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("generated %+v, container %+v", got, want)
+	}
+	return nil
+}
+
+var digenPath string
+
+// 编译 digen，只编译一次
+func build() (string, error) {
+	if digenPath != "" {
+		return digenPath, nil
+	}
+	dir, err := os.MkdirTemp("", "digen")
+	if err != nil {
+		return "", err
+	}
+	bin := filepath.Join(dir, "digen")
+	out, err := exec.Command("go", "build", "-o", bin, "code-snippet/code/010/injection/digen").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("build digen: %v\n%s", err, out)
+	}
+	digenPath = bin
+	return bin, nil
+}
+
+// 在临时目录中写入文件并运行 digen，返回目录、生成的代码和 digen 的输出
+func generate(files map[string]string) (dir string, src, output []byte, err error) {
+	bin, err := build()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	dir, err = os.MkdirTemp("", "digen-pkg")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	output, err = exec.Command(bin, "-output", "gen_di.go", dir).CombinedOutput()
+	if err != nil {
+		return dir, nil, output, err
+	}
+	src, err = os.ReadFile(filepath.Join(dir, "gen_di.go"))
+	return dir, src, output, err
+}
+
+// 生成应当失败，并且输出包含所有 want
+func expectFailure(source string, want ...string) error {
+	dir, _, output, err := generate(map[string]string{"main.go": source})
+	if dir != "" {
+		defer os.RemoveAll(dir)
+	}
+	if err == nil {
+		return errors.New("generation succeeded")
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return err
+	}
+	for _, w := range want {
+		if !strings.Contains(string(output), w) {
+			return fmt.Errorf("output %q does not contain %q", output, w)
+		}
+	}
+	return nil
+}
+
+// 提交的生成代码，其他包中的提供函数按导入路径读取，可以复制到临时目录生成
+var generated = []struct {
+	dir, source string
+}{
+	{"code/010/injection/digen/sample", "staff.go"},
+	{"code/008/customer-management-os/wire", "wire.go"},
+	{"code/008/clsfactory/wire", "wire.go"},
+}
+
+func checkUpToDate() error {
+	for _, g := range generated {
+		source, err := os.ReadFile(filepath.Join(g.dir, g.source))
+		if err != nil {
+			return fmt.Errorf("run from the repository root: %v", err)
+		}
+		name := strings.TrimSuffix(g.source, ".go") + "_di.go"
+		committed, err := os.ReadFile(filepath.Join(g.dir, name))
+		if err != nil {
+			return err
+		}
+		dir, src, output, err := generate(map[string]string{g.source: string(source)})
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v: %s", g.dir, err, output)
+		}
+		if !bytes.Equal(src, committed) {
+			return fmt.Errorf("%s is stale, run go generate in %s", name, g.dir)
+		}
+	}
+	return nil
+}
+
+// 在源码中用 ' 代替反引号
+func source(s string) string {
+	return strings.ReplaceAll(s, "'", "`")
+}
+
+const runSource = `package main
+
+import (
+	"code-snippet/code/010/injection/di"
+	"errors"
+	"fmt"
+)
+
+var calls = map[string]int{}
+
+type Config struct{ Fail bool }
+type DB struct{ Config *Config }
+type Cache struct{ DB *DB }
+type App struct {
+	DB    *DB
+	Cache *Cache
+	Port  int
+}
+
+type AppParams struct {
+	di.In
+	DB    *DB    'inject:""'
+	Cache *Cache 'inject:""'
+	Port  int    'inject:"port"'
+}
+
+var fail bool
+
+//di:provide
+func NewConfig() *Config { calls["config"]++; return &Config{Fail: fail} }
+
+//di:provide
+func NewDB(c *Config) (*DB, error) {
+	calls["db"]++
+	if c.Fail {
+		return nil, errors.New("connect failed")
+	}
+	return &DB{Config: c}, nil
+}
+
+//di:provide
+func NewCache(db *DB) *Cache { calls["cache"]++; return &Cache{DB: db} }
+
+//di:provide port
+func Port() int { return 8080 }
+
+//di:provide
+func NewApp(p AppParams) *App { calls["app"]++; return &App{DB: p.DB, Cache: p.Cache, Port: p.Port} }
+
+//di:invoke
+func Run(app *App) {
+	fmt.Println(app.DB == app.Cache.DB, app.Port)
+}
+
+func main() {
+	if err := InvokeRun(); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(calls["config"], calls["db"], calls["cache"], calls["app"])
+	fail = true
+	fmt.Println(InvokeRun())
+}
+`
+
+func checkRun() error {
+	dir, src, output, err := generate(map[string]string{"main.go": source(runSource)})
+	if dir != "" {
+		defer os.RemoveAll(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s\n%s", err, output, src)
+	}
+	// 每个提供函数只调用一次，错误与容器一样带有依赖路径
+	want := "true 8080\n1 1 1 1\ndi: *main.App -> *main.DB: connect failed\n"
+	if string(output) != want {
+		return fmt.Errorf("got %q, want %q", output, want)
+	}
+	return nil
+}
+
+func checkMissing() error {
+	// 所有缺少的提供函数一起报告，带有位置和依赖路径
+	return expectFailure(source(`package main
+
+import "code-snippet/code/010/injection/di"
+
+type DB struct{}
+type Repo struct{ DB *DB }
+
+type Params struct {
+	di.In
+	Repo *Repo 'inject:""'
+	Port int   'inject:"port"'
+}
+
+//di:provide
+func NewRepo(db *DB) *Repo { return &Repo{DB: db} }
+
+//di:provide
+func Port() int { return 80 }
+
+//di:invoke
+func Run(p Params) {}
+
+//di:apply
+type Server struct {
+	Port int 'inject:"port"'
+}
+`),
+		"main.go:21:1: InvokeRun: *main.Repo -> *main.DB: no provider",
+		`main.go:21:1: InvokeRun: int "port": no provider`,
+		`main.go:24:6: ApplyServer: int "port": no provider`,
+	)
+}
+
+func checkAmbiguous() error {
+	if err := expectFailure(`package main
+
+type DB struct{}
+
+//di:provide
+func OpenDB() *DB { return &DB{} }
+
+//di:provide
+func NewDB() (*DB, error) { return &DB{}, nil }
+
+//di:invoke
+func Run(db *DB) {}
+
+//di:invoke
+func Check(db *DB) {}
+`,
+		"*main.DB: ambiguous providers: OpenDB at ", "main.go:6:1, NewDB at ", "main.go:9:1",
+	); err != nil {
+		return err
+	}
+
+	// 其他包中的构造函数返回相同的接口类型，没有用名称区分
+	return expectFailure(`package main
+
+import (
+	"code-snippet/code/008/clsfactory/base"
+	"code-snippet/code/008/clsfactory/cls1"
+	"code-snippet/code/008/clsfactory/cls2"
+)
+
+//di:provide
+func Config() base.Config { return nil }
+
+var (
+	//di:provide
+	_ = cls1.New
+	//di:provide
+	_ = cls2.New
+)
+
+//di:invoke
+func Run(c base.Class) {}
+`,
+		"base.Class: ambiguous providers: cls1.New at ", "main.go:14:2, cls2.New at ", "main.go:16:2",
+	)
+}
+
+func checkCycle() error {
+	return expectFailure(`package main
+
+type A struct{}
+type B struct{}
+type C struct{}
+
+//di:provide
+func NewA(b *B) *A { return &A{} }
+
+//di:provide
+func NewB(c *C) *B { return &B{} }
+
+//di:provide
+func NewC(a *A) *C { return &C{} }
+
+//di:invoke
+func Run(a *A) {}
+`,
+		"InvokeRun: *main.A -> *main.B -> *main.C -> *main.A: dependency cycle",
+	)
+}
+
+func checkUnsupported() error {
+	cases := []struct {
+		source, want string
+	}{
+		{"type DB struct{}\n//di:provide\nfunc NewDB() {}\n", "provider NewDB must return T or (T, error)"},
+		{"type DB struct{}\n//di:provide\nfunc NewDB() (*DB, int) { return nil, 0 }\n", "must return T or (T, error)"},
+		{"type A struct{}\n//di:provide\nfunc NewA(f func() *A) *A { return nil }\n", "lazy dependency func() *A is not supported"},
+		{"//di:invoke\nfunc Run(args ...int) {}\n", "function Run is variadic"},
+		{"//di:inject\nfunc Run() {}\n", "unexpected directive //di:inject"},
+		{"//di:provide a b\nfunc A() int { return 0 }\n", "takes at most one name"},
+		{"//di:apply\ntype N int\n", "needs a struct type"},
+		{"//di:provide\nfunc A() int { return 0 }\n", "no //di:invoke or //di:apply found"},
+		{"//di:provide\nvar _ = 1\n", "needs a function in another package"},
+		{"import \"code-snippet/code/008/clsfactory/base\"\n//di:provide\nvar _ = base.Default\n", "has no exported function Default"},
+		{"import \"code-snippet/code/008/clsfactory/cls1\"\n//di:provide a b\nvar _ = cls1.New\n", "takes at most one name"},
+	}
+	for _, c := range cases {
+		if err := expectFailure("package main\n\n"+c.source, c.want); err != nil {
+			return fmt.Errorf("%q: %v", c.source, err)
+		}
+	}
+	return nil
+}
+
+func main() {
+	failed := 0
+	for _, c := range checks {
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+	if digenPath != "" {
+		os.RemoveAll(filepath.Dir(digenPath))
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", len(checks))
+}
@@ -0,0 +1,898 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// digen 用 go/ast 分析包中的提供函数，生成不使用反射的注入代码，得到的对象与 di 容器相同。
+// 在包中的函数和结构体上用注释标记：
+//
+//	//di:provide         提供函数，返回 T 或 (T, error)，参数是它的依赖
+//	//di:provide age     带名称的提供函数，对应字段标签 inject:"age"
+//	//di:invoke          为函数 F 生成 InvokeF，注入参数后调用 F
+//	//di:apply           为结构体 T 生成 ApplyT，按 inject 标签注入字段
+//
+// 其他包中的构造函数不能加注释，在包级变量上标记，digen 读取导入的包得到函数的参数和返回值：
+//
+//	//di:provide
+//	var _ = service.NewCustomerService
+//
+// 然后在包中添加：
+//
+//	//go:generate go run code-snippet/code/010/injection/digen
+//
+// 在包目录下执行 go generate，生成 <源文件名>_di.go。
+//
+// 缺少提供函数、同一个类型和名称有多个提供函数以及依赖环在生成时报告，全部列出后退出。
+// 每次调用生成的函数都创建一组新的对象，其中每个提供函数最多调用一次，相当于一个只用一次的容器中的单例。
+// 参数和 inject 标签的规则与 di 容器相同，按类型表达式和名称匹配，不支持 As 绑定到接口和延迟获取的函数。
+
+// 运行时容器的包，生成的代码用它的 ResolveError 报告提供函数的错误
+const diPath = "code-snippet/code/010/injection/di"
+
+var output = flag.String("output", "", "输出文件名，默认为 <源文件名>_di.go")
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("digen: ")
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	out := *output
+	if out == "" {
+		// go generate 执行时通过 GOFILE 传入源文件名
+		file := os.Getenv("GOFILE")
+		if file == "" {
+			log.Fatal("-output is required when not run by go generate")
+		}
+		out = strings.TrimSuffix(file, ".go") + "_di.go"
+	}
+
+	g, err := parsePackage(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := g.generate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, out), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 绑定的键，字符串形式与 di 容器中 reflect.Type 的 String 相同
+type key struct {
+	typ  string
+	name string
+}
+
+func (k key) String() string {
+	if k.name == "" {
+		return k.typ
+	}
+	return fmt.Sprintf("%s %q", k.typ, k.name)
+}
+
+// 提供函数
+type provider struct {
+	name   string // 其他包中的函数带有包名
+	path   string // 其他包的导入路径
+	pos    token.Position
+	key    key
+	params []param
+	hasErr bool
+}
+
+// 函数的一个参数，嵌入了 di.In 的结构体按字段展开
+type param struct {
+	key    key
+	in     string // 结构体类型名，普通参数为空
+	fields []field
+}
+
+// 带 inject 标签的字段
+type field struct {
+	name string
+	key  key
+}
+
+// 要生成的函数
+type target struct {
+	kind    string // invoke 或 apply
+	name    string
+	pos     token.Position
+	params  []param    // invoke 的参数
+	fields  []field    // apply 的字段
+	results []ast.Expr // invoke 除 error 以外的返回值
+	hasErr  bool       // invoke 的最后一个返回值是 error
+	imports map[string]string
+}
+
+// 结构体定义和它所在文件导入的包
+type structDef struct {
+	typ     *ast.StructType
+	imports map[string]string
+}
+
+// 代码生成器
+type generator struct {
+	pkg       string
+	dir       string
+	fset      *token.FileSet
+	types     map[string]bool       // 包中定义的类型
+	structs   map[string]*structDef // 包中定义的结构体
+	reserved  map[string]bool       // 包级的名称，生成的变量不能使用
+	funcs     []*ast.FuncDecl       // 带有标记的函数
+	decls     map[string]*ast.FuncDecl
+	vars      []*ast.ValueSpec      // 带有 //di:provide 的包级变量
+	applies   []*ast.TypeSpec       // 带有 //di:apply 的结构体
+	directive map[ast.Node][]string // 标记的参数
+	files     map[ast.Node]map[string]string
+	imported  map[string]*generator // 读取过的其他包
+
+	providers map[key][]*provider
+	targets   []*target
+	errs      []error
+	reported  map[string]bool // 已经报告过的错误，多个函数遇到同一个问题时只报告一次
+	used      map[string]bool // 生成的代码用到的导入路径
+	buf       bytes.Buffer
+}
+
+// 读取目录下的 Go 文件，跳过测试文件和生成的文件
+func parsePackage(dir string) (*generator, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{
+		dir:       dir,
+		fset:      token.NewFileSet(),
+		types:     map[string]bool{},
+		structs:   map[string]*structDef{},
+		reserved:  map[string]bool{},
+		decls:     map[string]*ast.FuncDecl{},
+		directive: map[ast.Node][]string{},
+		files:     map[ast.Node]map[string]string{},
+		imported:  map[string]*generator{},
+		providers: map[key][]*provider{},
+		reported:  map[string]bool{},
+		used:      map[string]bool{},
+	}
+	for _, p := range paths {
+		if strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(g.fset, p, nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		if ast.IsGenerated(file) {
+			continue
+		}
+		if g.pkg != "" && g.pkg != file.Name.Name {
+			return nil, fmt.Errorf("%s: found packages %s and %s", dir, g.pkg, file.Name.Name)
+		}
+		g.pkg = file.Name.Name
+		if err := g.collect(file); err != nil {
+			return nil, err
+		}
+	}
+	if g.pkg == "" {
+		return nil, fmt.Errorf("%s: no Go files", dir)
+	}
+	return g, nil
+}
+
+// 收集类型定义、包级名称和带有标记的声明
+func (g *generator) collect(file *ast.File) error {
+	imports := map[string]string{}
+	for _, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = importPath
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					g.types[spec.Name.Name] = true
+					g.reserved[spec.Name.Name] = true
+					if st, ok := spec.Type.(*ast.StructType); ok && spec.TypeParams == nil {
+						g.structs[spec.Name.Name] = &structDef{typ: st, imports: imports}
+					}
+					// 只有一个类型的声明，注释写在 type 关键字上
+					doc := spec.Doc
+					if doc == nil && len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					ok, args, err := g.parseDirective(doc, "apply")
+					if err != nil {
+						return err
+					}
+					if ok {
+						g.applies = append(g.applies, spec)
+						g.directive[spec] = args
+						g.files[spec] = imports
+					}
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						g.reserved[name.Name] = true
+					}
+					doc := spec.Doc
+					if doc == nil && len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					ok, args, err := g.parseDirective(doc, "provide")
+					if err != nil {
+						return err
+					}
+					if ok {
+						g.vars = append(g.vars, spec)
+						g.directive[spec] = args
+						g.files[spec] = imports
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv != nil {
+				continue
+			}
+			g.reserved[decl.Name.Name] = true
+			g.decls[decl.Name.Name] = decl
+			ok, args, err := g.parseDirective(decl.Doc, "provide", "invoke")
+			if err != nil {
+				return err
+			}
+			if ok {
+				g.funcs = append(g.funcs, decl)
+				g.directive[decl] = args
+				g.files[decl] = imports
+			}
+		}
+	}
+	return nil
+}
+
+// 解析注释中的 //di: 标记，返回标记名和参数。kinds 是这种声明可以使用的标记
+func (g *generator) parseDirective(doc *ast.CommentGroup, kinds ...string) (bool, []string, error) {
+	if doc == nil {
+		return false, nil, nil
+	}
+	var found []string
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, "//di:") {
+			continue
+		}
+		if found != nil {
+			return false, nil, fmt.Errorf("%s: more than one //di: directive", g.fset.Position(c.Pos()))
+		}
+		found = strings.Fields(strings.TrimPrefix(c.Text, "//di:"))
+		if len(found) == 0 || !contains(kinds, found[0]) {
+			return false, nil, fmt.Errorf("%s: unexpected directive %s", g.fset.Position(c.Pos()), c.Text)
+		}
+	}
+	return found != nil, found, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 生成全部函数并格式化
+func (g *generator) generate() ([]byte, error) {
+	for _, fn := range g.funcs {
+		args := g.directive[fn]
+		if args[0] == "provide" {
+			g.addProvider(fn, args[1:])
+		} else {
+			g.addInvoke(fn, args[1:])
+		}
+	}
+	for _, spec := range g.vars {
+		g.addImported(spec, g.directive[spec][1:], g.files[spec])
+	}
+	for _, spec := range g.applies {
+		g.addApply(spec, g.directive[spec][1:])
+	}
+	if len(g.errs) > 0 {
+		return nil, errors.Join(g.errs...)
+	}
+	if len(g.targets) == 0 {
+		return nil, errors.New("no //di:invoke or //di:apply found")
+	}
+
+	for _, t := range g.targets {
+		g.generateTarget(t)
+	}
+	if len(g.errs) > 0 {
+		return nil, errors.Join(g.errs...)
+	}
+	body := g.buf.Bytes()
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "// Code generated by digen; DO NOT EDIT.\n\npackage %s\n", g.pkg)
+	if len(g.used) > 0 {
+		var paths []string
+		for p := range g.used {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		head.WriteString("\nimport (\n")
+		for _, p := range paths {
+			fmt.Fprintf(&head, "\t%q\n", p)
+		}
+		head.WriteString(")\n")
+	}
+	head.Write(body)
+
+	src, err := format.Source(head.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v\n%s", err, head.Bytes())
+	}
+	return src, nil
+}
+
+func (g *generator) errorf(pos token.Pos, format string, args ...interface{}) {
+	g.errs = append(g.errs, fmt.Errorf("%s: %s", g.fset.Position(pos), fmt.Sprintf(format, args...)))
+}
+
+func (g *generator) addProvider(fn *ast.FuncDecl, args []string) {
+	if p := g.provider(fn, args); p != nil {
+		g.providers[p.key] = append(g.providers[p.key], p)
+	}
+}
+
+// 按提供函数的声明创建 provider，出错时返回 nil
+func (g *generator) provider(fn *ast.FuncDecl, args []string) *provider {
+	name := ""
+	switch len(args) {
+	case 0:
+	case 1:
+		name = args[0]
+	default:
+		g.errorf(fn.Pos(), "//di:provide takes at most one name")
+		return nil
+	}
+	if fn.Type.TypeParams != nil {
+		g.errorf(fn.Pos(), "provider %s is generic", fn.Name.Name)
+		return nil
+	}
+	results := fieldTypes(fn.Type.Results)
+	if len(results) == 0 || len(results) > 2 || (len(results) == 2 && !isError(results[1])) {
+		g.errorf(fn.Pos(), "provider %s must return T or (T, error)", fn.Name.Name)
+		return nil
+	}
+	params, ok := g.params(fn)
+	if !ok {
+		return nil
+	}
+	return &provider{
+		name:   fn.Name.Name,
+		pos:    g.fset.Position(fn.Pos()),
+		key:    key{typ: g.typeString(results[0]), name: name},
+		params: params,
+		hasErr: len(results) == 2,
+	}
+}
+
+// 变量的值是其他包中的构造函数，例如 var _ = service.NewCustomerService
+func (g *generator) addImported(spec *ast.ValueSpec, args []string, imports map[string]string) {
+	var sel *ast.SelectorExpr
+	if len(spec.Values) == 1 {
+		sel, _ = spec.Values[0].(*ast.SelectorExpr)
+	}
+	var pkg *ast.Ident
+	if sel != nil {
+		pkg, _ = sel.X.(*ast.Ident)
+	}
+	if pkg == nil || imports[pkg.Name] == "" {
+		g.errorf(spec.Pos(), "//di:provide on a variable needs a function in another package, like var _ = pkg.New")
+		return
+	}
+	if len(args) > 1 {
+		g.errorf(spec.Pos(), "//di:provide takes at most one name")
+		return
+	}
+	importPath := imports[pkg.Name]
+	other, err := g.importPackage(importPath)
+	if err != nil {
+		g.errorf(spec.Pos(), "%v", err)
+		return
+	}
+	fn := other.decls[sel.Sel.Name]
+	if fn == nil || !fn.Name.IsExported() {
+		g.errorf(spec.Pos(), "%s has no exported function %s", importPath, sel.Sel.Name)
+		return
+	}
+	p := other.provider(fn, args)
+	g.errs = append(g.errs, other.errs...)
+	other.errs = nil
+	if p == nil {
+		return
+	}
+
+	// 生成的代码按包名引用其他包中的函数和结构体
+	p.name = other.pkg + "." + p.name
+	p.path = importPath
+	p.pos = g.fset.Position(spec.Pos())
+	for i := range p.params {
+		if p.params[i].in != "" {
+			p.params[i].in = other.pkg + "." + p.params[i].in
+		}
+	}
+	g.reserved[other.pkg] = true
+	g.providers[p.key] = append(g.providers[p.key], p)
+}
+
+// 读取导入的包，每个包只读取一次
+func (g *generator) importPackage(importPath string) (*generator, error) {
+	if other := g.imported[importPath]; other != nil {
+		return other, nil
+	}
+	bp, err := build.Import(importPath, g.dir, build.FindOnly)
+	if err != nil {
+		return nil, err
+	}
+	other, err := parsePackage(bp.Dir)
+	if err != nil {
+		return nil, err
+	}
+	g.imported[importPath] = other
+	return other, nil
+}
+
+func (g *generator) addInvoke(fn *ast.FuncDecl, args []string) {
+	if len(args) > 0 {
+		g.errorf(fn.Pos(), "//di:invoke takes no arguments")
+		return
+	}
+	if fn.Type.TypeParams != nil {
+		g.errorf(fn.Pos(), "function %s is generic", fn.Name.Name)
+		return
+	}
+	params, ok := g.params(fn)
+	if !ok {
+		return
+	}
+	t := &target{
+		kind:    "invoke",
+		name:    fn.Name.Name,
+		pos:     g.fset.Position(fn.Pos()),
+		params:  params,
+		results: fieldTypes(fn.Type.Results),
+		imports: g.files[fn],
+	}
+	if n := len(t.results); n > 0 && isError(t.results[n-1]) {
+		t.results = t.results[:n-1]
+		t.hasErr = true
+	}
+	g.targets = append(g.targets, t)
+}
+
+func (g *generator) addApply(spec *ast.TypeSpec, args []string) {
+	if len(args) > 0 {
+		g.errorf(spec.Pos(), "//di:apply takes no arguments")
+		return
+	}
+	def := g.structs[spec.Name.Name]
+	if def == nil {
+		g.errorf(spec.Pos(), "//di:apply needs a struct type, %s is not", spec.Name.Name)
+		return
+	}
+	fields, ok := g.fields(spec.Name.Name, def)
+	if !ok {
+		return
+	}
+	g.targets = append(g.targets, &target{
+		kind:   "apply",
+		name:   spec.Name.Name,
+		pos:    g.fset.Position(spec.Pos()),
+		fields: fields,
+	})
+}
+
+// 函数参数对应的键，嵌入了 di.In 的结构体展开为各个字段
+func (g *generator) params(fn *ast.FuncDecl) ([]param, bool) {
+	if fn.Type.Params == nil {
+		return nil, true
+	}
+	var params []param
+	for _, f := range fn.Type.Params.List {
+		if _, ok := f.Type.(*ast.Ellipsis); ok {
+			g.errorf(f.Pos(), "function %s is variadic", fn.Name.Name)
+			return nil, false
+		}
+		if _, ok := f.Type.(*ast.FuncType); ok {
+			g.errorf(f.Pos(), "function %s: lazy dependency %s is not supported", fn.Name.Name, types.ExprString(f.Type))
+			return nil, false
+		}
+		p := param{key: key{typ: g.typeString(f.Type)}}
+		if ident, ok := f.Type.(*ast.Ident); ok {
+			if def := g.structs[ident.Name]; def != nil && g.isIn(def) {
+				fields, ok := g.fields(ident.Name, def)
+				if !ok {
+					return nil, false
+				}
+				p = param{in: ident.Name, fields: fields}
+			}
+		}
+		// 没有名称的参数也占一个位置
+		for i := 0; i < max(len(f.Names), 1); i++ {
+			params = append(params, p)
+		}
+	}
+	return params, true
+}
+
+// 带有 inject 标签的字段，规则与 di 容器相同
+func (g *generator) fields(typeName string, def *structDef) ([]field, bool) {
+	var fields []field
+	for _, f := range def.typ.Fields.List {
+		if f.Tag == nil {
+			continue
+		}
+		tag, _ := strconv.Unquote(f.Tag.Value)
+		name, ok := reflect.StructTag(tag).Lookup("inject")
+		if !ok {
+			continue
+		}
+		if len(f.Names) == 0 {
+			g.errorf(f.Pos(), "%s: embedded field %s cannot be injected", typeName, types.ExprString(f.Type))
+			return nil, false
+		}
+		if _, ok := f.Type.(*ast.FuncType); ok {
+			g.errorf(f.Pos(), "%s: lazy dependency %s is not supported", typeName, types.ExprString(f.Type))
+			return nil, false
+		}
+		for _, ident := range f.Names {
+			if !ident.IsExported() {
+				g.errorf(ident.Pos(), "field %s.%s is not exported", typeName, ident.Name)
+				return nil, false
+			}
+			fields = append(fields, field{name: ident.Name, key: key{typ: g.typeString(f.Type), name: name}})
+		}
+	}
+	return fields, true
+}
+
+// 结构体是否嵌入了 di.In
+func (g *generator) isIn(def *structDef) bool {
+	for _, f := range def.typ.Fields.List {
+		if len(f.Names) > 0 {
+			continue
+		}
+		sel, ok := f.Type.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "In" {
+			continue
+		}
+		if pkg, ok := sel.X.(*ast.Ident); ok && def.imports[pkg.Name] == diPath {
+			return true
+		}
+	}
+	return false
+}
+
+// 类型表达式的字符串，包中定义的类型加上包名，与 reflect.Type 的 String 一致
+func (g *generator) typeString(expr ast.Expr) string {
+	return types.ExprString(g.qualify(expr))
+}
+
+func (g *generator) qualify(expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if g.types[e.Name] {
+			return &ast.SelectorExpr{X: ast.NewIdent(g.pkg), Sel: e}
+		}
+	case *ast.StarExpr:
+		return &ast.StarExpr{X: g.qualify(e.X)}
+	case *ast.ArrayType:
+		return &ast.ArrayType{Len: e.Len, Elt: g.qualify(e.Elt)}
+	case *ast.MapType:
+		return &ast.MapType{Key: g.qualify(e.Key), Value: g.qualify(e.Value)}
+	case *ast.ChanType:
+		return &ast.ChanType{Dir: e.Dir, Value: g.qualify(e.Value)}
+	case *ast.ParenExpr:
+		return g.qualify(e.X)
+	}
+	return expr
+}
+
+// 参数或返回值列表展开后的类型
+func fieldTypes(list *ast.FieldList) []ast.Expr {
+	if list == nil {
+		return nil
+	}
+	var result []ast.Expr
+	for _, f := range list.List {
+		for i := 0; i < max(len(f.Names), 1); i++ {
+			result = append(result, f.Type)
+		}
+	}
+	return result
+}
+
+func isError(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "error"
+}
+
+// 一个生成的函数
+type emitter struct {
+	g        *generator
+	t        *target
+	buf      bytes.Buffer
+	vars     map[key]string // 已经创建的对象
+	names    map[string]bool
+	fail     string // 出错时 return 语句中 error 之前的部分
+	building map[key]bool
+}
+
+// 生成一个 Invoke 或 Apply 函数
+func (g *generator) generateTarget(t *target) {
+	e := &emitter{g: g, t: t, vars: map[key]string{}, names: map[string]bool{}, building: map[key]bool{}}
+
+	var signature string
+	var call string
+	var ok bool
+	switch t.kind {
+	case "apply":
+		recv := e.newName(lowerInitial(t.name))
+		signature = fmt.Sprintf("// Apply%s 按 inject 标签注入 %s 的字段\nfunc Apply%s(%s *%s) error {\n", t.name, t.name, t.name, recv, t.name)
+		e.fail = "return "
+		var values []string
+		values, ok = e.fieldValues(t.fields, nil)
+		if !ok {
+			return
+		}
+		var assign strings.Builder
+		for i, f := range t.fields {
+			fmt.Fprintf(&assign, "%s.%s = %s\n", recv, f.name, values[i])
+		}
+		assign.WriteString("return nil\n")
+		call = assign.String()
+	default:
+		// 有 error 以外的返回值时使用命名返回值，出错时返回它们的零值
+		var results, names []string
+		for _, r := range t.results {
+			name := e.newName("result")
+			names = append(names, name)
+			results = append(results, name+" "+g.typeExpr(r, t.imports))
+		}
+		if len(results) > 0 {
+			results = append(results, "err error")
+			signature = fmt.Sprintf("(%s)", strings.Join(results, ", "))
+			e.fail = "return " + strings.Join(names, ", ") + ", "
+		} else {
+			signature = "error"
+			e.fail = "return "
+		}
+		signature = fmt.Sprintf("// Invoke%s 注入参数并调用 %s\nfunc Invoke%s() %s {\n", t.name, t.name, t.name, signature)
+
+		var args []string
+		args, ok = e.args(t.params, nil)
+		if !ok {
+			return
+		}
+		invoke := fmt.Sprintf("%s(%s)", t.name, strings.Join(args, ", "))
+		switch {
+		case t.hasErr:
+			call = "return " + invoke + "\n"
+		case len(names) > 0:
+			call = fmt.Sprintf("%s = %s\nreturn %s, nil\n", strings.Join(names, ", "), invoke, strings.Join(names, ", "))
+		default:
+			call = invoke + "\nreturn nil\n"
+		}
+	}
+
+	g.buf.WriteString("\n")
+	g.buf.WriteString(signature)
+	g.buf.Write(e.buf.Bytes())
+	g.buf.WriteString(call)
+	g.buf.WriteString("}\n")
+}
+
+// 返回值的类型表达式，用到的包加入导入列表
+func (g *generator) typeExpr(expr ast.Expr, imports map[string]string) string {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok && imports[pkg.Name] != "" {
+				g.used[imports[pkg.Name]] = true
+			}
+		}
+		return true
+	})
+	return types.ExprString(expr)
+}
+
+// 按参数获取对象，返回调用时的实参。出错时仍然检查其余的参数，一次报告所有问题
+func (e *emitter) args(params []param, path []key) ([]string, bool) {
+	var args []string
+	all := true
+	for _, p := range params {
+		if p.in == "" {
+			v, ok := e.value(p.key, path)
+			all = all && ok
+			args = append(args, v)
+			continue
+		}
+		values, ok := e.fieldValues(p.fields, path)
+		all = all && ok
+		items := make([]string, len(p.fields))
+		for i, f := range p.fields {
+			items[i] = f.name + ": " + values[i]
+		}
+		args = append(args, fmt.Sprintf("%s{%s}", p.in, strings.Join(items, ", ")))
+	}
+	return args, all
+}
+
+func (e *emitter) fieldValues(fields []field, path []key) ([]string, bool) {
+	values := make([]string, len(fields))
+	all := true
+	for i, f := range fields {
+		v, ok := e.value(f.key, path)
+		all = all && ok
+		values[i] = v
+	}
+	return values, all
+}
+
+// 获取 k 对应的对象，第一次需要时生成调用提供函数的代码，返回保存对象的变量名
+func (e *emitter) value(k key, path []key) (string, bool) {
+	path = append(path[:len(path):len(path)], k)
+	if v, ok := e.vars[k]; ok {
+		return v, true
+	}
+	if e.building[k] {
+		e.report(path, "dependency cycle")
+		return "", false
+	}
+
+	providers := e.g.providers[k]
+	switch len(providers) {
+	case 0:
+		e.report(path, "no provider")
+		return "", false
+	case 1:
+	default:
+		list := make([]string, len(providers))
+		for i, p := range providers {
+			list[i] = fmt.Sprintf("%s at %s", p.name, p.pos)
+		}
+		msg := fmt.Sprintf("%s: ambiguous providers: %s", k, strings.Join(list, ", "))
+		if !e.g.reported[msg] {
+			e.g.reported[msg] = true
+			e.g.errs = append(e.g.errs, errors.New(msg))
+		}
+		return "", false
+	}
+
+	p := providers[0]
+	e.building[k] = true
+	args, ok := e.args(p.params, path)
+	delete(e.building, k)
+	if !ok {
+		return "", false
+	}
+
+	base := k.name
+	if !token.IsIdentifier(base) {
+		base = lowerInitial(lastIdent(k.typ))
+	}
+	v := e.newName(base)
+	call := fmt.Sprintf("%s(%s)", p.name, strings.Join(args, ", "))
+	if p.path != "" {
+		e.g.used[p.path] = true
+	}
+	if !p.hasErr {
+		fmt.Fprintf(&e.buf, "%s := %s\n", v, call)
+	} else {
+		e.g.used[diPath] = true
+		list := make([]string, len(path))
+		for i, pk := range path {
+			list[i] = strconv.Quote(pk.String())
+		}
+		fmt.Fprintf(&e.buf, "%s, err := %s\nif err != nil {\n%s&di.ResolveError{Path: []string{%s}, Err: err}\n}\n",
+			v, call, e.fail, strings.Join(list, ", "))
+	}
+	e.vars[k] = v
+	return v, true
+}
+
+// 报告缺少提供函数或依赖环，错误中带有生成的函数和完整的依赖路径
+func (e *emitter) report(path []key, problem string) {
+	list := make([]string, len(path))
+	for i, k := range path {
+		list[i] = k.String()
+	}
+	kind := "Invoke"
+	if e.t.kind == "apply" {
+		kind = "Apply"
+	}
+	msg := fmt.Sprintf("%s: %s%s: %s: %s", e.t.pos, kind, e.t.name, strings.Join(list, " -> "), problem)
+	e.g.errs = append(e.g.errs, errors.New(msg))
+}
+
+// 分配一个不与包级名称、关键字和预定义标识符冲突的变量名
+func (e *emitter) newName(base string) string {
+	if base == "" || base == "_" {
+		base = "v"
+	}
+	if types.Universe.Lookup(base) != nil {
+		base += "Value"
+	}
+	name := base
+	for i := 2; e.names[name] || e.g.reserved[name] || token.IsKeyword(name) || name == "di" || name == "err"; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	e.names[name] = true
+	return name
+}
+
+// 类型字符串中最后一个标识符，例如 *sample.DB 中的 DB
+func lastIdent(typ string) string {
+	r := []rune(typ)
+	end := len(r)
+	for end > 0 && !isIdentRune(r[end-1]) {
+		end--
+	}
+	start := end
+	for start > 0 && isIdentRune(r[start-1]) {
+		start--
+	}
+	return string(r[start:end])
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// 首字母小写，开头的缩写整体小写，例如 DB -> db，HTTPServer -> httpServer
+func lowerInitial(s string) string {
+	r := []rune(s)
+	n := 0
+	for n < len(r) && unicode.IsUpper(r[n]) {
+		n++
+	}
+	if n > 1 && n < len(r) {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
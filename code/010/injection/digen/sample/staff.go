@@ -0,0 +1,115 @@
+package sample
+
+import (
+	"code-snippet/code/010/injection/di"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 与 container 例子中相同的员工和提供函数，注入代码由 digen 生成
+//go:generate go run code-snippet/code/010/injection/digen
+
+// 员工信息
+//
+//di:apply
+type Staff struct {
+	Name    string `inject:"name"`
+	Company string `inject:"company"`
+	Level   int    `inject:"level"`
+	Age     int    `inject:"age"`
+}
+
+// Format 的参数，嵌入 di.In 后各个字段按标签注入
+type Profile struct {
+	di.In
+	Name    string `inject:"name"`
+	Company string `inject:"company"`
+	Level   int    `inject:"level"`
+	Age     int    `inject:"age"`
+}
+
+// 格式化员工信息
+//
+//di:invoke
+func Format(p Profile) string {
+	return fmt.Sprintf("name: %s, company: %s, level: %d, age: %d", p.Name, p.Company, p.Level, p.Age)
+}
+
+//di:provide name
+func Name() string { return "张三" }
+
+//di:provide company
+func Company() string { return "阿里巴巴" }
+
+//di:provide level
+func Level() int { return 8 }
+
+//di:provide age
+func Age() int { return 18 }
+
+// 数据库地址
+type DSN string
+
+// 从环境变量 STAFF_DSN 读取数据库地址
+//
+//di:provide
+func LoadDSN() DSN {
+	if dsn := os.Getenv("STAFF_DSN"); dsn != "" {
+		return DSN(dsn)
+	}
+	return "mysql://localhost/staff"
+}
+
+// 数据库连接
+type DB struct {
+	DSN DSN
+}
+
+//di:provide
+func NewDB(dsn DSN) (*DB, error) {
+	if !strings.Contains(string(dsn), "://") {
+		return nil, fmt.Errorf("invalid dsn %q", dsn)
+	}
+	return &DB{DSN: dsn}, nil
+}
+
+// 员工仓库
+type Repo struct {
+	DB *DB
+}
+
+//di:provide
+func NewRepo(db *DB) *Repo {
+	return &Repo{DB: db}
+}
+
+// 员工服务
+type Service struct {
+	Repo    *Repo
+	DB      *DB
+	Company string
+}
+
+// NewService 的参数
+type ServiceParams struct {
+	di.In
+	Repo    *Repo  `inject:""`
+	DB      *DB    `inject:""`
+	Company string `inject:"company"`
+}
+
+//di:provide
+func NewService(p ServiceParams) *Service {
+	return &Service{Repo: p.Repo, DB: p.DB, Company: p.Company}
+}
+
+// 服务的说明
+//
+//di:invoke
+func Describe(s *Service) (string, error) {
+	if s.Repo.DB != s.DB {
+		return "", fmt.Errorf("%s: repo and service use different databases", s.Company)
+	}
+	return fmt.Sprintf("%s: %s", s.Company, s.DB.DSN), nil
+}
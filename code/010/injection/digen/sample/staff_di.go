@@ -0,0 +1,43 @@
+// Code generated by digen; DO NOT EDIT.
+
+package sample
+
+import (
+	"code-snippet/code/010/injection/di"
+)
+
+// InvokeFormat 注入参数并调用 Format
+func InvokeFormat() (result string, err error) {
+	name := Name()
+	company := Company()
+	level := Level()
+	age := Age()
+	result = Format(Profile{Name: name, Company: company, Level: level, Age: age})
+	return result, nil
+}
+
+// InvokeDescribe 注入参数并调用 Describe
+func InvokeDescribe() (result string, err error) {
+	dsn := LoadDSN()
+	db, err := NewDB(dsn)
+	if err != nil {
+		return result, &di.ResolveError{Path: []string{"*sample.Service", "*sample.Repo", "*sample.DB"}, Err: err}
+	}
+	repo := NewRepo(db)
+	company := Company()
+	service := NewService(ServiceParams{Repo: repo, DB: db, Company: company})
+	return Describe(service)
+}
+
+// ApplyStaff 按 inject 标签注入 Staff 的字段
+func ApplyStaff(staff *Staff) error {
+	name := Name()
+	company := Company()
+	level := Level()
+	age := Age()
+	staff.Name = name
+	staff.Company = company
+	staff.Level = level
+	staff.Age = age
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"code-snippet/code/011/cookie-setting-and-reading/session"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+var manager *session.Manager
+
+func main() {
+	// 签名密钥和加密密钥，实际使用时应从配置中读取，重启后旧的 Cookie 就会失效
+	codec, err := session.NewCodec(session.GenerateKey(32), session.GenerateKey(32))
+	if err != nil {
+		log.Fatal(err)
+	}
+	manager = session.NewManager(session.NewMemoryStore(), codec)
+
+	http.HandleFunc("/", indexHandler)
+	http.HandleFunc("/login", loginHandler)
+	http.HandleFunc("/logout", logoutHandler)
+
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+// 统计访问次数并显示提示消息
+func indexHandler(writer http.ResponseWriter, request *http.Request) {
+	s, err := manager.Get(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	visits, _ := strconv.Atoi(s.Get("visits"))
+	s.Set("visits", strconv.Itoa(visits+1))
+	flashes := s.Flashes()
+
+	// 写出响应体之前保存会话
+	if err = manager.Save(writer, s); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, flash := range flashes {
+		fmt.Fprintf(writer, "[%s] %s\n", flash.Kind, flash.Message)
+	}
+	if name := s.Get("name"); name != "" {
+		fmt.Fprintf(writer, "hello, %s\n", name)
+	}
+	fmt.Fprintf(writer, "visits: %d\n", visits+1)
+}
+
+// 登录后更换会话ID，防止会话固定攻击
+func loginHandler(writer http.ResponseWriter, request *http.Request) {
+	s, err := manager.Get(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := request.FormValue("name")
+	s.Rotate()
+	s.Set("name", name)
+	s.AddFlash("success", "logged in as "+name)
+
+	if err = manager.Save(writer, s); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(writer, request, "/", http.StatusFound)
+}
+
+// 退出登录，销毁会话
+func logoutHandler(writer http.ResponseWriter, request *http.Request) {
+	s, err := manager.Get(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = manager.Destroy(writer, s); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(writer, request, "/", http.StatusFound)
+}
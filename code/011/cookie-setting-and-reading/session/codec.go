@@ -0,0 +1,123 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// Cookie 值被篡改或者不是由本服务签发
+	ErrInvalidSignature = errors.New("session: invalid cookie signature")
+
+	// Cookie 值已经过期
+	ErrExpired = errors.New("session: cookie expired")
+
+	// Cookie 值格式错误或者无法解密
+	ErrMalformed = errors.New("session: malformed cookie value")
+)
+
+// 签名密钥的最小长度
+const MinHashKeySize = 32
+
+// Cookie 值的编解码器：使用 HMAC-SHA256 签名，设置加密密钥时再使用 AES-GCM 加密。
+// 编码结果为 base64url(负载 | 签名)，负载为 8 字节签发时间加上原始值（或其密文）
+type Codec struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+// 创建编解码器，blockKey 为 nil 时只签名不加密，否则长度必须为 16、24 或 32 字节
+func NewCodec(hashKey, blockKey []byte) (*Codec, error) {
+	if len(hashKey) < MinHashKeySize {
+		return nil, errors.New("session: hash key must be at least 32 bytes")
+	}
+
+	codec := &Codec{hashKey: hashKey}
+	if blockKey != nil {
+		block, err := aes.NewCipher(blockKey)
+		if err != nil {
+			return nil, err
+		}
+		if codec.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return codec, nil
+}
+
+// 生成指定长度的随机密钥
+func GenerateKey(size int) []byte {
+	key := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// 编码 Cookie 值，name 参与签名，防止把一个 Cookie 的值挪给另一个 Cookie 使用
+func (codec *Codec) Encode(name string, value []byte) (string, error) {
+	payload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Unix()))
+	payload = append(payload, value...)
+
+	if codec.aead != nil {
+		nonce := make([]byte, codec.aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+		payload = codec.aead.Seal(nonce, nonce, payload, []byte(name))
+	}
+
+	payload = append(payload, codec.sign(name, payload)...)
+	return base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// 解码 Cookie 值，maxAge 大于 0 时拒绝签发时间早于 maxAge 之前的值
+func (codec *Codec) Decode(name, cookie string, maxAge time.Duration) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil || len(data) < sha256.Size {
+		return nil, ErrMalformed
+	}
+
+	// 先校验签名再解密，签名比较使用常量时间
+	payload, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(mac, codec.sign(name, payload)) {
+		return nil, ErrInvalidSignature
+	}
+
+	if codec.aead != nil {
+		size := codec.aead.NonceSize()
+		if len(payload) < size {
+			return nil, ErrMalformed
+		}
+		if payload, err = codec.aead.Open(nil, payload[:size], payload[size:], []byte(name)); err != nil {
+			return nil, ErrMalformed
+		}
+	}
+
+	if len(payload) < 8 {
+		return nil, ErrMalformed
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(payload[:8])), 0)
+	if maxAge > 0 && time.Since(issued) > maxAge {
+		return nil, ErrExpired
+	}
+	return payload[8:], nil
+}
+
+// 计算签名
+func (codec *Codec) sign(name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, codec.hashKey)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,182 @@
+package session
+
+import (
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// 会话管理器的默认配置
+const (
+	DefaultCookieName = "session"
+	DefaultMaxAge     = 24 * time.Hour
+)
+
+// 会话管理器：Cookie 中只保存签名（可选加密）后的会话ID，会话数据保存在服务器端
+type Manager struct {
+	store Store
+	codec *Codec
+
+	CookieName  string        // Cookie 名称
+	MaxAge      time.Duration // 会话空闲超过该时间后过期，每次保存会话都会延长
+	RotateEvery time.Duration // 会话ID使用超过该时间后自动更换，0 表示不自动更换
+	Secure      bool          // 只通过 HTTPS 发送 Cookie
+}
+
+// 创建会话管理器
+func NewManager(store Store, codec *Codec) *Manager {
+	return &Manager{
+		store:      store,
+		codec:      codec,
+		CookieName: DefaultCookieName,
+		MaxAge:     DefaultMaxAge,
+	}
+}
+
+// 一次请求中的会话
+type Session struct {
+	id     string
+	oldID  string // 更换ID前的会话ID，保存时删除
+	record *Record
+	isNew  bool
+}
+
+// 读取请求携带的会话，Cookie 不存在、签名错误或会话已过期时返回新会话
+func (m *Manager) Get(request *http.Request) (*Session, error) {
+	if cookie, err := request.Cookie(m.CookieName); err == nil {
+		if value, err := m.codec.Decode(m.CookieName, cookie.Value, m.MaxAge); err == nil {
+			id := string(value)
+			record, err := m.store.Load(id)
+			if err == nil {
+				s := &Session{id: id, record: record}
+				if m.RotateEvery > 0 && time.Since(record.Created) > m.RotateEvery {
+					s.Rotate()
+				}
+				return s, nil
+			}
+			if err != ErrNotFound {
+				return nil, err
+			}
+		}
+	}
+
+	return &Session{
+		id:    newID(),
+		isNew: true,
+		record: &Record{
+			Values:  make(map[string]string),
+			Created: time.Now(),
+		},
+	}, nil
+}
+
+// 保存会话并写入 Cookie，必须在写出响应体之前调用
+func (m *Manager) Save(writer http.ResponseWriter, s *Session) error {
+	if s.oldID != "" {
+		if err := m.store.Delete(s.oldID); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+
+	s.record.Expires = time.Now().Add(m.MaxAge)
+	if err := m.store.Save(s.id, s.record); err != nil {
+		return err
+	}
+
+	value, err := m.codec.Encode(m.CookieName, []byte(s.id))
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(m.MaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   m.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	s.isNew = false
+	return nil
+}
+
+// 销毁会话并删除 Cookie，例如用户退出登录时
+func (m *Manager) Destroy(writer http.ResponseWriter, s *Session) error {
+	if err := m.store.Delete(s.id); err != nil {
+		return err
+	}
+	if s.oldID != "" {
+		if err := m.store.Delete(s.oldID); err != nil {
+			return err
+		}
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:     m.CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   m.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// 删除存储中全部过期的会话
+func (m *Manager) Cleanup() error {
+	return m.store.Cleanup()
+}
+
+// 会话ID
+func (s *Session) ID() string {
+	return s.id
+}
+
+// 是否为本次请求新建的会话
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// 读取会话值
+func (s *Session) Get(key string) string {
+	return s.record.Values[key]
+}
+
+// 设置会话值
+func (s *Session) Set(key, value string) {
+	s.record.Values[key] = value
+}
+
+// 删除会话值
+func (s *Session) Delete(key string) {
+	delete(s.record.Values, key)
+}
+
+// 更换会话ID并保留会话数据，登录等权限变化时调用以防止会话固定攻击
+func (s *Session) Rotate() {
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.record.Created = time.Now()
+}
+
+// 添加一条提示消息，在下一次读取提示消息时显示
+func (s *Session) AddFlash(kind, message string) {
+	s.record.Flashes = append(s.record.Flashes, Flash{Kind: kind, Message: message})
+}
+
+// 取出全部提示消息，取出后即被删除，需要保存会话才会生效
+func (s *Session) Flashes() []Flash {
+	flashes := s.record.Flashes
+	s.record.Flashes = nil
+	return flashes
+}
+
+// 生成随机的会话ID
+func newID() string {
+	return hex.EncodeToString(GenerateKey(32))
+}
@@ -0,0 +1,216 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// 会话不存在或已过期
+var ErrNotFound = errors.New("session: not found")
+
+// 合法的会话ID：64 位十六进制
+var idPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// 提示消息，读取一次后即被删除
+type Flash struct {
+	Kind    string `json:"kind"` // 消息类型，例如 success、error
+	Message string `json:"message"`
+}
+
+// 保存在服务器端的会话数据
+type Record struct {
+	Values  map[string]string `json:"values"`
+	Flashes []Flash           `json:"flashes"`
+	Created time.Time         `json:"created"` // 会话ID的生成时间，用于定期轮换
+	Expires time.Time         `json:"expires"`
+}
+
+// 会话存储
+type Store interface {
+	// 读取会话，不存在或已过期时返回 ErrNotFound
+	Load(id string) (*Record, error)
+
+	// 保存会话
+	Save(id string, record *Record) error
+
+	// 删除会话，会话不存在时不返回错误
+	Delete(id string) error
+
+	// 删除全部过期的会话
+	Cleanup() error
+}
+
+// 内存会话存储，进程退出后会话丢失
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Record
+}
+
+// 创建内存会话存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]*Record)}
+}
+
+func (store *MemoryStore) Load(id string) (*Record, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	record, ok := store.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(record.Expires) {
+		delete(store.sessions, id)
+		return nil, ErrNotFound
+	}
+	return copyRecord(record), nil
+}
+
+func (store *MemoryStore) Save(id string, record *Record) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.sessions[id] = copyRecord(record)
+	return nil
+}
+
+func (store *MemoryStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.sessions, id)
+	return nil
+}
+
+func (store *MemoryStore) Cleanup() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	for id, record := range store.sessions {
+		if now.After(record.Expires) {
+			delete(store.sessions, id)
+		}
+	}
+	return nil
+}
+
+// 复制会话数据，调用方修改返回值不会影响存储中的数据
+func copyRecord(record *Record) *Record {
+	c := *record
+	c.Values = make(map[string]string, len(record.Values))
+	for key, value := range record.Values {
+		c.Values[key] = value
+	}
+	c.Flashes = append([]Flash(nil), record.Flashes...)
+	return &c
+}
+
+// 文件会话存储，每个会话保存为目录下的一个 JSON 文件
+type FileStore struct {
+	dir string
+}
+
+// 创建文件会话存储，目录不存在时自动创建
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// 会话文件路径，会话ID必须是合法的十六进制字符串，防止路径穿越
+func (store *FileStore) path(id string) (string, error) {
+	if !idPattern.MatchString(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(store.dir, id+".json"), nil
+}
+
+func (store *FileStore) Load(id string) (*Record, error) {
+	filename, err := store.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := new(Record)
+	if err = json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	if time.Now().After(record.Expires) {
+		os.Remove(filename)
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func (store *FileStore) Save(id string, record *Record) error {
+	filename, err := store.path(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，并发读取不会看到写了一半的文件
+	temp, err := ioutil.TempFile(store.dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(data)
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filename)
+}
+
+func (store *FileStore) Delete(id string) error {
+	filename, err := store.path(id)
+	if err != nil {
+		return nil
+	}
+	if err = os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *FileStore) Cleanup() error {
+	infos, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		// Load 会删除已过期的会话文件
+		if _, err := store.Load(name[:len(name)-len(".json")]); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -19,7 +20,10 @@ func main() {
 
 	var r interface{}
 
-	err := json.Unmarshal(b, &r)
+	// 默认数字都解码为 float64，使用 UseNumber 解码为 json.Number，再区分整数和小数
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err := decoder.Decode(&r)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -32,10 +36,12 @@ func main() {
 				fmt.Printf("【%s】 is string 【%s】\n", k, value)
 			case bool:
 				fmt.Printf("【%s】 is bool 【%t】\n", k, value)
-			case int:
-				fmt.Printf("【%s】 is int 【%d】\n", k, value)
-			case float64:
-				fmt.Printf("【%s】 is float64 【%f】\n", k, value)
+			case json.Number:
+				if i, err := value.Int64(); err == nil {
+					fmt.Printf("【%s】 is int 【%d】\n", k, i)
+				} else if f, err := value.Float64(); err == nil {
+					fmt.Printf("【%s】 is float64 【%f】\n", k, f)
+				}
 			case []interface{}:
 				fmt.Printf("%s is an slice:\n", k)
 				for i, sv := range value {
@@ -1,30 +1,17 @@
+// 类似 jq 的命令行工具，从 stdin 或文件中读取 JSON 值（可以是 NDJSON），
+// 按路径查询、保留或删除其中的值，结果逐行输出到 stdout。
+//
+//	flow -pick Title < books.ndjson
+//	flow '$.store.book[?(@.price < 10)].title' store.json
+//	flow -delete '$..isbn' -pretty . store.json
 package main
 
 import (
-	"encoding/json"
-	"log"
 	"os"
+
+	"code-snippet/code/011/decoding-json-data-with-an-unknown-structure/query"
 )
 
 func main() {
-	decoder := json.NewDecoder(os.Stdin)
-	encoder := json.NewEncoder(os.Stdin)
-
-	for {
-		var v map[string]interface{}
-		if err := decoder.Decode(&v); err != nil {
-			log.Println(err.Error())
-			return
-		}
-
-		for k := range v {
-			if k != "Title" {
-				v[k] = nil
-			}
-		}
-
-		if err := encoder.Encode(&v); err != nil {
-			log.Println(err.Error())
-		}
-	}
+	os.Exit(query.Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
@@ -0,0 +1,75 @@
+// 从 JSON 样本推断结构，生成带有 json 标签的 Go 结构体，或者 JSON Schema 文档。
+// 每个文件（没有文件时为 stdin）中可以有多个 JSON 值，每个值都是一个样本：
+//
+//	infer -name Book -package sample books.ndjson > book.go
+//	infer -schema -name Book books.ndjson
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"code-snippet/code/011/decoding-json-data-with-an-unknown-structure/schema"
+)
+
+var (
+	name       = flag.String("name", "Root", "顶层类型名，生成 JSON Schema 时作为标题")
+	pkg        = flag.String("package", "main", "生成代码的包名")
+	jsonSchema = flag.Bool("schema", false, "生成 JSON Schema 而不是 Go 代码")
+	output     = flag.String("o", "", "输出文件，默认为 stdout")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("infer: ")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: infer [flags] [file ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	s := new(schema.Schema)
+	if flag.NArg() == 0 {
+		addSamples(s, "<stdin>", os.Stdin)
+	}
+	for _, file := range flag.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		addSamples(s, file, f)
+		f.Close()
+	}
+	if s.Count == 0 {
+		log.Fatal("no samples")
+	}
+
+	var out []byte
+	var err error
+	if *jsonSchema {
+		out, err = s.JSONSchema(*name)
+	} else {
+		out, err = s.GoSource(*pkg, *name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	if err := os.WriteFile(*output, out, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 读取一个文件中的样本
+func addSamples(s *schema.Schema, file string, r io.Reader) {
+	if _, err := s.AddSamples(r); err != nil {
+		log.Fatalf("%s: %v", file, err)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"code-snippet/code/011/decoding-json-data-with-an-unknown-structure/query"
+)
+
+// 用 testdata 中的样例文件检查 flow 命令的输出和退出码：
+//
+//	go run ./code/011/decoding-json-data-with-an-unknown-structure/query/check
+
+// 一次命令调用
+type testCase struct {
+	name   string
+	args   []string
+	stdin  string
+	want   string // 期望的 stdout
+	exit   int
+	stderr string // stderr 中应该包含的内容
+}
+
+var cases = []testCase{
+	{
+		name: "pick Title",
+		args: []string{"-pick", "Title", ".", "books.ndjson"},
+		want: `{"Title":"Go语言编程"}
+{"Title":"Go语言实战"}
+{"Title":"Go程序设计语言"}
+`,
+	},
+	{
+		name: "dot path",
+		args: []string{"store.book.0.title", "store.json"},
+		want: `"Sayings of the Century"
+`,
+	},
+	{
+		name: "wildcard",
+		args: []string{"$.store.bicycle.*", "store.json"},
+		want: `"red"
+19.95
+`,
+	},
+	{
+		name: "descendant",
+		args: []string{"$..price", "store.json"},
+		want: `19.95
+8.95
+12.99
+8.99
+22.99
+`,
+	},
+	{
+		name: "negative index",
+		args: []string{"$.store.book[-1].author", "store.json"},
+		want: `"J. R. R. Tolkien"
+`,
+	},
+	{
+		name: "slice with step",
+		args: []string{"$.store.book[0:4:2].title", "store.json"},
+		want: `"Sayings of the Century"
+"Moby Dick"
+`,
+	},
+	{
+		name: "negative step",
+		args: []string{"$.store.book[::-2].price", "store.json"},
+		want: `22.99
+12.99
+`,
+	},
+	{
+		name: "union",
+		args: []string{"$.store.book[3,0]['title','price']", "store.json"},
+		want: `"The Lord of the Rings"
+22.99
+"Sayings of the Century"
+8.95
+`,
+	},
+	{
+		name: "filter compare",
+		args: []string{"$.store.book[?(@.price < 9)].title", "store.json"},
+		want: `"Sayings of the Century"
+"Moby Dick"
+`,
+	},
+	{
+		name: "filter and exists",
+		args: []string{"$.store.book[?@.isbn && @.price > 10].title", "store.json"},
+		want: `"The Lord of the Rings"
+`,
+	},
+	{
+		name: "filter not",
+		args: []string{"-raw", "$.store.book[?(!@.isbn)].title", "store.json"},
+		want: `Sayings of the Century
+Sword of Honour
+`,
+	},
+	{
+		name: "filter regexp",
+		args: []string{"$.store.book[?(@.author =~ '^[HJ]')].author", "store.json"},
+		want: `"Herman Melville"
+"J. R. R. Tolkien"
+`,
+	},
+	{
+		name: "filter root",
+		args: []string{"$.store.book[?(@.price > $.expensive || @.category == \"reference\")].price", "store.json"},
+		want: `8.95
+12.99
+22.99
+`,
+	},
+	{
+		name: "filter on stream",
+		args: []string{"$.user.roles[?(@ == 'ops' || @ == 'admin')]", "events.ndjson"},
+		want: `"admin"
+"ops"
+`,
+	},
+	{
+		name: "nested filter",
+		args: []string{"$[?(@.roles[0] == 'dev')].name", "events.ndjson"},
+		want: `"carol"
+`,
+	},
+	{
+		name: "quoted key and no html escaping",
+		args: []string{"$['first name']", "store.json"},
+		want: `"<Go>"
+`,
+	},
+	{
+		name: "pick keeps positions",
+		args: []string{"-pick", "$.store.book[1].title", "-pick", "expensive", ".", "store.json"},
+		want: `{"expensive":10,"store":{"book":[null,{"title":"Sword of Honour"}]}}
+`,
+	},
+	{
+		name: "delete",
+		args: []string{"-delete", "$..isbn", "-delete", "$.store.book[0:2]", "$.store.book", "store.json"},
+		want: `[{"author":"Herman Melville","category":"fiction","price":8.99,"title":"Moby Dick"},{"author":"J. R. R. Tolkien","category":"fiction","price":22.99,"title":"The Lord of the Rings"}]
+`,
+	},
+	{
+		name:  "delete paths select the original document",
+		args:  []string{"-delete", "$[0]", "-delete", "$[1]", "."},
+		stdin: `[1, 2, 3]`,
+		want: `[3]
+`,
+	},
+	{
+		name: "delete then pick",
+		args: []string{"-delete", "Authors", "-pick", "Authors", "-pick", "Price", ".", "books.ndjson"},
+		want: `{"Price":9.99}
+{"Price":59}
+{"Price":79}
+`,
+	},
+	{
+		name:  "ndjson from stdin",
+		args:  []string{"user.name"},
+		stdin: "{\"user\":{\"name\":\"alice\"}}\n{\"user\":{}}\n  {\"user\":{\"name\":\"bob\"}}",
+		want: `"alice"
+"bob"
+`,
+	},
+	{
+		name: "big numbers",
+		args: []string{"Sales", "books.ndjson"},
+		want: `1000000
+12345678901234567890
+null
+`,
+	},
+	{
+		name:  "root by default",
+		stdin: `[1, {"a": "b"}]`,
+		want: `[1,{"a":"b"}]
+`,
+	},
+	{
+		name:  "pretty",
+		args:  []string{"-pretty"},
+		stdin: `{"a": [1, 2]}`,
+		want: `{
+  "a": [
+    1,
+    2
+  ]
+}
+`,
+	},
+	{
+		name: "exit status true",
+		args: []string{"-e", "IsPublished", "books.ndjson"},
+		want: "true\ntrue\nfalse\n",
+		exit: query.ExitFalse,
+	},
+	{
+		name: "exit status no result",
+		args: []string{"-e", "$.missing", "store.json"},
+		exit: query.ExitFalse,
+	},
+	{
+		name: "exit status ok",
+		args: []string{"-e", "$.expensive", "store.json"},
+		want: "10\n",
+	},
+	{
+		name:   "invalid path",
+		args:   []string{"$.store[", "store.json"},
+		exit:   query.ExitUsage,
+		stderr: "offset 8",
+	},
+	{
+		name:   "invalid filter",
+		args:   []string{"$[?(@.a =~ 'a(')]"},
+		exit:   query.ExitUsage,
+		stderr: "invalid pattern",
+	},
+	{
+		name:   "unknown flag",
+		args:   []string{"-x"},
+		exit:   query.ExitUsage,
+		stderr: "usage: flow",
+	},
+	{
+		name:   "bad input",
+		args:   []string{"id", "bad.ndjson"},
+		want:   "1\n2\n",
+		exit:   query.ExitInput,
+		stderr: "bad.ndjson: jsoncodec: invalid character '}' looking for beginning of object key string at line 3",
+	},
+	{
+		name:   "missing file",
+		args:   []string{"expensive", "missing.json", "store.json"},
+		want:   "10\n",
+		exit:   query.ExitInput,
+		stderr: "missing.json: no such file or directory",
+	},
+}
+
+// 运行命令并比较结果
+func (c *testCase) run() error {
+	var stdout, stderr bytes.Buffer
+	exit := query.Run(c.args, strings.NewReader(c.stdin), &stdout, &stderr)
+
+	if stdout.String() != c.want {
+		return fmt.Errorf("stdout\n%s\nwant\n%s", stdout.String(), c.want)
+	}
+	if exit != c.exit {
+		return fmt.Errorf("exit %d, want %d (stderr: %q)", exit, c.exit, stderr.String())
+	}
+	if c.stderr == "" && stderr.Len() > 0 {
+		return fmt.Errorf("unexpected stderr %q", stderr.String())
+	}
+	if !strings.Contains(stderr.String(), c.stderr) {
+		return fmt.Errorf("stderr %q does not contain %q", stderr.String(), c.stderr)
+	}
+	return nil
+}
+
+func main() {
+	// 样例文件的路径相对于 testdata 目录
+	_, file, _, _ := runtime.Caller(0)
+	if err := os.Chdir(filepath.Join(filepath.Dir(file), "..", "testdata")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	failed := 0
+	for _, c := range cases {
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d cases failed\n", failed, len(cases))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d cases passed\n", len(cases))
+}
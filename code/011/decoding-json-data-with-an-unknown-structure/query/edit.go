@@ -0,0 +1,104 @@
+package query
+
+import "sort"
+
+// 删除节点，返回修改后的文档。会修改 root，删除根节点得到 nil
+func Delete(root interface{}, nodes []Node) interface{} {
+	// 按位置倒序删除：子节点先于父节点，同一数组中下标大的先删，前面的位置不受影响
+	locations := make([][]interface{}, len(nodes))
+	for i, n := range nodes {
+		locations[i] = n.Location
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		return compareLocation(locations[i], locations[j]) > 0
+	})
+
+	for i, location := range locations {
+		if i > 0 && compareLocation(location, locations[i-1]) == 0 {
+			continue
+		}
+		root = deleteAt(root, location)
+	}
+	return root
+}
+
+// 删除 value 中 location 处的值，返回修改后的 value
+func deleteAt(value interface{}, location []interface{}) interface{} {
+	if len(location) == 0 {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		key, ok := location[0].(string)
+		if !ok {
+			break
+		}
+		if len(location) == 1 {
+			delete(v, key)
+		} else if child, ok := v[key]; ok {
+			v[key] = deleteAt(child, location[1:])
+		}
+	case []interface{}:
+		i, ok := location[0].(int)
+		if !ok || i >= len(v) {
+			break
+		}
+		if len(location) == 1 {
+			return append(v[:i], v[i+1:]...)
+		}
+		v[i] = deleteAt(v[i], location[1:])
+	}
+	return value
+}
+
+// 只保留节点，按原来的位置组成新的文档。数组中没有选中的元素为 null
+func Pick(nodes []Node) interface{} {
+	var result interface{}
+	for _, n := range nodes {
+		result = setAt(result, n.Location, n.Value)
+	}
+	return result
+}
+
+// 在 container 的 location 处放入 value，缺少的对象和数组自动创建
+func setAt(container interface{}, location []interface{}, value interface{}) interface{} {
+	if len(location) == 0 {
+		return value
+	}
+
+	switch key := location[0].(type) {
+	case string:
+		m, ok := container.(map[string]interface{})
+		if !ok {
+			m = map[string]interface{}{}
+		}
+		m[key] = setAt(m[key], location[1:], value)
+		return m
+	case int:
+		a, _ := container.([]interface{})
+		for len(a) <= key {
+			a = append(a, nil)
+		}
+		a[key] = setAt(a[key], location[1:], value)
+		return a
+	}
+	return container
+}
+
+// 按元素比较位置，前缀较短的排在前面
+func compareLocation(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch x := a[i].(type) {
+		case string:
+			if y, ok := b[i].(string); ok && x != y {
+				return cmpString(x, y)
+			}
+		case int:
+			if y, ok := b[i].(int); ok && x != y {
+				return x - y
+			}
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,295 @@
+package query
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// 路径选中的值和它在文档中的位置
+type Node struct {
+	Location []interface{} // 从根开始依次为对象的键（string）或数组的下标（int）
+	Value    interface{}
+}
+
+// 在解码得到的 map[string]interface{}、[]interface{} 等值中查找路径选中的值。
+// 对象的键按字符串排序遍历，结果按文档顺序排列
+func (path *Path) Select(root interface{}) []Node {
+	return selectSegments(path.segments, root, Node{Value: root})
+}
+
+// 从 start 开始依次应用各段
+func selectSegments(segments []segment, root interface{}, start Node) []Node {
+	nodes := []Node{start}
+	for _, seg := range segments {
+		var next []Node
+		for _, n := range nodes {
+			if !seg.descendant {
+				next = seg.apply(root, n, next)
+				continue
+			}
+			for _, d := range descendants(n, nil) {
+				next = seg.apply(root, d, next)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// 对节点应用一段中的每个选择器
+func (seg *segment) apply(root interface{}, n Node, out []Node) []Node {
+	for i := range seg.selectors {
+		out = seg.selectors[i].apply(root, n, out)
+	}
+	return out
+}
+
+// 对节点应用选择器，选中的子节点追加到 out
+func (s *selector) apply(root interface{}, n Node, out []Node) []Node {
+	switch s.kind {
+	case selectName:
+		switch v := n.Value.(type) {
+		case map[string]interface{}:
+			if child, ok := v[s.name]; ok {
+				out = append(out, Node{childLocation(n.Location, s.name), child})
+			}
+		case []interface{}:
+			if s.numeric && s.index < len(v) {
+				out = append(out, Node{childLocation(n.Location, s.index), v[s.index]})
+			}
+		}
+	case selectWildcard:
+		out = append(out, children(n)...)
+	case selectIndex:
+		if v, ok := n.Value.([]interface{}); ok {
+			i := s.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				out = append(out, Node{childLocation(n.Location, i), v[i]})
+			}
+		}
+	case selectSlice:
+		if v, ok := n.Value.([]interface{}); ok {
+			for _, i := range sliceIndexes(len(v), s.start, s.end, s.step) {
+				out = append(out, Node{childLocation(n.Location, i), v[i]})
+			}
+		}
+	case selectFilter:
+		for _, child := range children(n) {
+			if s.filter.eval(root, child.Value) {
+				out = append(out, child)
+			}
+		}
+	}
+	return out
+}
+
+// 按 JSONPath 的规则计算切片选中的下标，step 为负数时倒序
+func sliceIndexes(length int, start, end *int, step int) []int {
+	if step == 0 {
+		return nil
+	}
+
+	// 负数从末尾算起，再限制在数组范围内
+	normalize := func(i int) int {
+		if i < 0 {
+			i += length
+		}
+		return i
+	}
+	clamp := func(i, low, high int) int {
+		return max(low, min(i, high))
+	}
+
+	var indexes []int
+	if step > 0 {
+		lower, upper := 0, length
+		if start != nil {
+			lower = clamp(normalize(*start), 0, length)
+		}
+		if end != nil {
+			upper = clamp(normalize(*end), 0, length)
+		}
+		for i := lower; i < upper; i += step {
+			indexes = append(indexes, i)
+		}
+	} else {
+		upper, lower := length-1, -1
+		if start != nil {
+			upper = clamp(normalize(*start), -1, length-1)
+		}
+		if end != nil {
+			lower = clamp(normalize(*end), -1, length-1)
+		}
+		for i := upper; i > lower; i += step {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// 对象的值按键排序，数组的元素按下标
+func children(n Node) []Node {
+	switch v := n.Value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		nodes := make([]Node, len(keys))
+		for i, k := range keys {
+			nodes[i] = Node{childLocation(n.Location, k), v[k]}
+		}
+		return nodes
+	case []interface{}:
+		nodes := make([]Node, len(v))
+		for i, e := range v {
+			nodes[i] = Node{childLocation(n.Location, i), e}
+		}
+		return nodes
+	}
+	return nil
+}
+
+// 节点本身和它的所有后代，先序遍历
+func descendants(n Node, out []Node) []Node {
+	out = append(out, n)
+	for _, child := range children(n) {
+		out = descendants(child, out)
+	}
+	return out
+}
+
+// 子节点的位置，复制一份避免多个子节点共用底层数组
+func childLocation(location []interface{}, key interface{}) []interface{} {
+	child := make([]interface{}, len(location)+1)
+	copy(child, location)
+	child[len(location)] = key
+	return child
+}
+
+// 计算过滤器，current 是 @ 表示的当前元素
+func (e *expr) eval(root, current interface{}) bool {
+	switch e.kind {
+	case exprOr:
+		return e.left.eval(root, current) || e.right.eval(root, current)
+	case exprAnd:
+		return e.left.eval(root, current) && e.right.eval(root, current)
+	case exprNot:
+		return !e.left.eval(root, current)
+	case exprExists:
+		return len(e.a.nodes(root, current)) > 0
+	}
+
+	a, aok := e.a.value(root, current)
+	b, bok := e.b.value(root, current)
+	switch e.op {
+	case "==":
+		return equal(a, aok, b, bok)
+	case "!=":
+		return !equal(a, aok, b, bok)
+	case "=~":
+		s, ok := a.(string)
+		return aok && ok && e.pattern.MatchString(s)
+	}
+	if !aok || !bok {
+		return false
+	}
+
+	// 只有数字和数字、字符串和字符串可以比较大小
+	var c int
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return false
+		}
+		c = cmpFloat(x, y)
+	} else if x, ok := a.(string); ok {
+		y, ok := b.(string)
+		if !ok {
+			return false
+		}
+		c = cmpString(x, y)
+	} else {
+		return false
+	}
+
+	switch e.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// 路径操作数选中的节点
+func (o *operand) nodes(root, current interface{}) []Node {
+	start := root
+	if o.relative {
+		start = current
+	}
+	return selectSegments(o.segments, root, Node{Value: start})
+}
+
+// 操作数的值，路径没有选中或选中多个值时返回 false
+func (o *operand) value(root, current interface{}) (interface{}, bool) {
+	if !o.isPath {
+		return o.literal, true
+	}
+	nodes := o.nodes(root, current)
+	if len(nodes) != 1 {
+		return nil, false
+	}
+	return nodes[0].Value, true
+}
+
+// 两个值是否相等，都不存在时也视为相等，数字按数值比较
+func equal(a interface{}, aok bool, b interface{}, bok bool) bool {
+	if !aok || !bok {
+		return aok == bok
+	}
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// 解码时使用 UseNumber 得到 json.Number，否则为 float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func cmpFloat(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func cmpString(x, y string) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
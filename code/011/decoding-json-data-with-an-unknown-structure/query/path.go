@@ -0,0 +1,496 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 选择器语法兼容 JSONPath 和点号路径：
+//
+//	$.store.book[0].title               JSONPath
+//	store.book.0.title                  点号路径，可以省略开头的 $
+//	$..author                           递归查找所有层级的 author
+//	$.store.* $.store.book[*]           通配符
+//	$.book[-1] $.book[0:2] $.book[0,2]  负数下标、切片和并列选择
+//	$['first name']                     带有特殊字符的键
+//	$.book[?(@.price < 10 && @.isbn)]   过滤器
+//
+// 过滤器支持 == != < <= > >=、正则匹配 =~、逻辑运算 && || ! 和括号，
+// 只写路径时判断路径是否存在，路径以 @ 开头表示当前元素，以 $ 开头表示整个文档
+type Path struct {
+	source   string
+	segments []segment
+}
+
+// 路径中的一段，多个选择器的结果依次合并
+type segment struct {
+	descendant bool // .. 对当前值及其所有后代应用选择器
+	selectors  []selector
+}
+
+type selectorKind int
+
+const (
+	selectName     selectorKind = iota // .name 或 ['name']
+	selectWildcard                     // .* 或 [*]
+	selectIndex                        // [0]
+	selectSlice                        // [start:end:step]
+	selectFilter                       // [?expr]
+)
+
+// 选择器
+type selector struct {
+	kind    selectorKind
+	name    string
+	numeric bool // 点号路径中的数字段，对数组按下标选择
+	index   int
+	start   *int
+	end     *int
+	step    int
+	filter  *expr
+}
+
+// 路径语法错误
+type SyntaxError struct {
+	Path   string
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query: %s at offset %d in path %q", e.Msg, e.Offset, e.Path)
+}
+
+// 解析路径，空字符串、$ 和 . 都表示整个文档
+func Parse(source string) (*Path, error) {
+	p := &parser{src: source}
+	p.skipSpace()
+	if strings.TrimSpace(source) == "." {
+		return &Path{source: source}, nil
+	}
+
+	var segments []segment
+	if !p.consume("$") && p.pos < len(p.src) && !p.peek('.') && !p.peek('[') {
+		// 点号路径省略了开头的 $.
+		first, err := p.parseAfterDot()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, first)
+	}
+
+	rest, err := p.parseSegments()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	return &Path{source: source, segments: append(segments, rest...)}, nil
+}
+
+// 路径的原始文本
+func (path *Path) String() string {
+	return path.source
+}
+
+// 递归下降解析器
+type parser struct {
+	src string
+	pos int
+}
+
+// 解析 $ 或 @ 之后的各段，遇到无法识别的字符时停止
+func (p *parser) parseSegments() ([]segment, error) {
+	var segments []segment
+	for {
+		var seg segment
+		var err error
+		switch {
+		case p.consume(".."):
+			seg, err = p.parseAfterDot()
+			seg.descendant = true
+		case p.consume("."):
+			seg, err = p.parseAfterDot()
+		case p.peek('['):
+			seg, err = p.parseBracket()
+		default:
+			return segments, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+}
+
+// 解析点号之后的名称、通配符或方括号
+func (p *parser) parseAfterDot() (segment, error) {
+	if p.peek('[') {
+		return p.parseBracket()
+	}
+	if p.consume("*") {
+		return segment{selectors: []selector{{kind: selectWildcard}}}, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+			break
+		}
+		p.pos += size
+	}
+	name := p.src[start:p.pos]
+	if name == "" {
+		return segment{}, p.errorf("expected name after '.'")
+	}
+
+	sel := selector{kind: selectName, name: name}
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 {
+		sel.numeric, sel.index = true, n
+	}
+	return segment{selectors: []selector{sel}}, nil
+}
+
+// 解析 [...]，其中可以有逗号分隔的多个选择器
+func (p *parser) parseBracket() (segment, error) {
+	p.pos++ // [
+	var seg segment
+	for {
+		p.skipSpace()
+		sel, err := p.parseSelector()
+		if err != nil {
+			return segment{}, err
+		}
+		seg.selectors = append(seg.selectors, sel)
+
+		p.skipSpace()
+		if p.consume("]") {
+			return seg, nil
+		}
+		if !p.consume(",") {
+			return segment{}, p.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+// 解析方括号中的一个选择器
+func (p *parser) parseSelector() (selector, error) {
+	if p.pos >= len(p.src) {
+		return selector{}, p.errorf("unexpected end of path")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '*':
+		p.pos++
+		return selector{kind: selectWildcard}, nil
+	case c == '\'' || c == '"':
+		name, err := p.parseString()
+		return selector{kind: selectName, name: name}, err
+	case c == '?':
+		p.pos++
+		filter, err := p.parseOr()
+		return selector{kind: selectFilter, filter: filter}, err
+	case c == '-' || c == ':' || c >= '0' && c <= '9':
+		return p.parseIndexOrSlice()
+	}
+	return selector{}, p.errorf("unexpected %q", p.src[p.pos:p.pos+1])
+}
+
+// 解析下标或切片
+func (p *parser) parseIndexOrSlice() (selector, error) {
+	start, err := p.parseOptionalInt()
+	if err != nil {
+		return selector{}, err
+	}
+	p.skipSpace()
+	if !p.consume(":") {
+		if start == nil {
+			return selector{}, p.errorf("expected index")
+		}
+		return selector{kind: selectIndex, index: *start}, nil
+	}
+
+	sel := selector{kind: selectSlice, start: start, step: 1}
+	p.skipSpace()
+	if sel.end, err = p.parseOptionalInt(); err != nil {
+		return selector{}, err
+	}
+	p.skipSpace()
+	if p.consume(":") {
+		p.skipSpace()
+		step, err := p.parseOptionalInt()
+		if err != nil {
+			return selector{}, err
+		}
+		if step != nil {
+			sel.step = *step
+		}
+	}
+	return sel, nil
+}
+
+// 解析可以省略的整数
+func (p *parser) parseOptionalInt() (*int, error) {
+	start := p.pos
+	if p.peek('-') {
+		p.pos++
+	}
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(p.src[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid index %q", p.src[start:p.pos])
+	}
+	return &n, nil
+}
+
+// 解析单引号或双引号括起的字符串，转义规则与 JSON 相同
+func (p *parser) parseString() (string, error) {
+	quote := p.src[p.pos]
+	start := p.pos
+	p.pos++
+
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		case c == '\\':
+			if p.pos+1 >= len(p.src) {
+				return "", p.errorf("unterminated string")
+			}
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case '\'', '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.pos+5 > len(p.src) {
+					return "", p.errorf("invalid escape")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos+1:p.pos+5], 16, 16)
+				if err != nil {
+					return "", p.errorf("invalid escape")
+				}
+				b.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				return "", p.errorf("invalid escape '\\%c'", e)
+			}
+			p.pos++
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+// 过滤器表达式
+type exprKind int
+
+const (
+	exprOr exprKind = iota
+	exprAnd
+	exprNot
+	exprCompare
+	exprExists
+)
+
+type expr struct {
+	kind        exprKind
+	left, right *expr // 逻辑运算的操作数，! 只使用 left
+	op          string
+	a, b        operand
+	pattern     *regexp.Regexp // =~ 右侧的正则表达式
+}
+
+// 比较运算的操作数：字面量或路径
+type operand struct {
+	literal  interface{}
+	segments []segment
+	isPath   bool
+	relative bool // 以 @ 开头
+}
+
+// or := and ('||' and)*
+func (p *parser) parseOr() (*expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpace(); p.consume("||"); p.skipSpace() {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr{kind: exprOr, left: left, right: right}
+	}
+	return left, nil
+}
+
+// and := unary ('&&' unary)*
+func (p *parser) parseAnd() (*expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpace(); p.consume("&&"); p.skipSpace() {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr{kind: exprAnd, left: left, right: right}
+	}
+	return left, nil
+}
+
+// unary := '!' unary | '(' or ')' | operand [op operand]
+func (p *parser) parseUnary() (*expr, error) {
+	p.skipSpace()
+	if p.peek('!') && !strings.HasPrefix(p.src[p.pos:], "!=") {
+		p.pos++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &expr{kind: exprNot, left: e}, nil
+	}
+	if p.consume("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return e, nil
+	}
+
+	a, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	op := ""
+	for _, candidate := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if p.consume(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		if !a.isPath {
+			return nil, p.errorf("expected comparison operator")
+		}
+		return &expr{kind: exprExists, a: a}, nil
+	}
+
+	p.skipSpace()
+	start := p.pos
+	b, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	e := &expr{kind: exprCompare, op: op, a: a, b: b}
+	if op == "=~" {
+		s, ok := b.literal.(string)
+		if !ok || b.isPath {
+			p.pos = start
+			return nil, p.errorf("=~ expects a string pattern")
+		}
+		if e.pattern, err = regexp.Compile(s); err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid pattern: %v", err)
+		}
+	}
+	return e, nil
+}
+
+// 解析路径、字符串、数字、true、false 或 null
+func (p *parser) parseOperand() (operand, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return operand{}, p.errorf("unexpected end of path")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '@' || c == '$':
+		p.pos++
+		segments, err := p.parseSegments()
+		return operand{segments: segments, isPath: true, relative: c == '@'}, err
+	case c == '\'' || c == '"':
+		s, err := p.parseString()
+		return operand{literal: s}, err
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && strings.IndexByte("+-.eE0123456789", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		number := p.src[start:p.pos]
+		if _, err := strconv.ParseFloat(number, 64); err != nil {
+			p.pos = start
+			return operand{}, p.errorf("invalid number %q", number)
+		}
+		return operand{literal: json.Number(number)}, nil
+	}
+
+	for _, keyword := range []struct {
+		text  string
+		value interface{}
+	}{{"true", true}, {"false", false}, {"null", nil}} {
+		if p.consume(keyword.text) {
+			return operand{literal: keyword.value}, nil
+		}
+	}
+	return operand{}, p.errorf("expected value")
+}
+
+func (p *parser) peek(c byte) bool {
+	return p.pos < len(p.src) && p.src[p.pos] == c
+}
+
+// 下面是 s 时跳过并返回 true
+func (p *parser) consume(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Path: p.src, Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
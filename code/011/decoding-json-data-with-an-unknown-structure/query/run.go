@@ -0,0 +1,201 @@
+package query
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"code-snippet/code/006/jsoncodec"
+)
+
+// 命令的退出码
+const (
+	ExitOK    = 0 // 成功
+	ExitFalse = 1 // 使用 -e 时没有结果，或者最后一个结果是 null 或 false
+	ExitUsage = 2 // 参数或路径错误
+	ExitInput = 3 // 输入文件无法打开或不是合法的 JSON
+)
+
+// 可以重复指定的路径参数
+type pathList []*Path
+
+func (l *pathList) String() string {
+	s := make([]string, len(*l))
+	for i, path := range *l {
+		s[i] = path.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *pathList) Set(value string) error {
+	path, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, path)
+	return nil
+}
+
+// 命令的选项
+type options struct {
+	path    *Path
+	picks   pathList
+	deletes pathList
+	pretty  bool
+	raw     bool
+	exit    bool
+}
+
+// 运行命令：flow [选项] [路径] [文件 ...]
+//
+// 依次读取文件（没有文件时读取 stdin）中的每个 JSON 值，
+// 先删除 -delete 选中的值，再用 -pick 选中的值组成新的文档，
+// 最后把路径选中的每个值输出为一行 JSON。返回退出码
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var opts options
+	flags := flag.NewFlagSet("flow", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Var(&opts.picks, "pick", "只保留路径选中的值，可以重复指定")
+	flags.Var(&opts.deletes, "delete", "删除路径选中的值，可以重复指定")
+	flags.BoolVar(&opts.pretty, "pretty", false, "缩进输出")
+	flags.BoolVar(&opts.raw, "raw", false, "字符串结果不加引号直接输出")
+	flags.BoolVar(&opts.exit, "e", false, "没有结果或最后一个结果是 null、false 时退出码为 1")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: flow [flags] [path] [file ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	source, files := "$", flags.Args()
+	if len(files) > 0 {
+		source, files = files[0], files[1:]
+	}
+	path, err := Parse(source)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+	opts.path = path
+
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	out := &output{w: w, opts: &opts}
+
+	code := ExitOK
+	if len(files) == 0 {
+		if err := out.stream(stdin); err != nil {
+			fmt.Fprintf(stderr, "flow: <stdin>: %v\n", err)
+			code = ExitInput
+		}
+	}
+	for _, name := range files {
+		if err := out.file(name); err != nil {
+			fmt.Fprintf(stderr, "flow: %s: %v\n", name, err)
+			code = ExitInput
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		fmt.Fprintf(stderr, "flow: %v\n", err)
+		return ExitInput
+	}
+	if code == ExitOK && opts.exit && !out.truthy {
+		return ExitFalse
+	}
+	return code
+}
+
+// 输出状态
+type output struct {
+	w    *bufio.Writer
+	opts *options
+
+	truthy bool // 最后一个结果不是 null 或 false
+}
+
+// 处理一个文件
+func (out *output) file(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return pathErr.Err
+		}
+		return err
+	}
+	defer f.Close()
+	return out.stream(f)
+}
+
+// 处理输入流中的每个 JSON 值。语法错误之后无法继续读取，直接返回
+func (out *output) stream(r io.Reader) error {
+	decoder := jsoncodec.NewDecoder(r)
+	decoder.UseNumber() // 保留大整数的精度
+	for {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := out.document(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// 处理一个文档
+func (out *output) document(doc interface{}) error {
+	opts := out.opts
+	// 所有 -delete 路径都在原来的文档中选择，再一起删除，与 jq 的 del(a, b) 相同
+	if len(opts.deletes) > 0 {
+		var nodes []Node
+		for _, path := range opts.deletes {
+			nodes = append(nodes, path.Select(doc)...)
+		}
+		doc = Delete(doc, nodes)
+	}
+	if len(opts.picks) > 0 {
+		var nodes []Node
+		for _, path := range opts.picks {
+			nodes = append(nodes, path.Select(doc)...)
+		}
+		doc = Pick(nodes)
+	}
+
+	for _, n := range opts.path.Select(doc) {
+		if err := out.value(n.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 输出一个结果
+func (out *output) value(v interface{}) error {
+	out.truthy = v != nil && v != false
+
+	if s, ok := v.(string); ok && out.opts.raw {
+		out.w.WriteString(s)
+		return out.w.WriteByte('\n')
+	}
+
+	// 每次新建编码器，Encode 会在末尾加上换行
+	encoder := json.NewEncoder(out.w)
+	encoder.SetEscapeHTML(false)
+	if out.opts.pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(v)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"code-snippet/code/011/decoding-json-data-with-an-unknown-structure/schema"
+	"code-snippet/code/011/decoding-json-data-with-an-unknown-structure/schema/sample"
+)
+
+// 检查结构推断、生成的 Go 代码和 JSON Schema：
+//
+//	go run ./code/011/decoding-json-data-with-an-unknown-structure/schema/check
+
+// 一组样本和期望生成的代码
+type testCase struct {
+	name    string
+	samples string
+	goSrc   string // 期望的 Go 代码，省略开头的注释和包名
+	schema  string // 期望的 JSON Schema，为空时不检查
+}
+
+var cases = []testCase{
+	{
+		name:    "int and float",
+		samples: `{"count": 1, "price": 1} {"count": 2, "price": 2.5}`,
+		goSrc: `type Root struct {
+	Count int     ` + "`" + `json:"count"` + "`" + `
+	Price float64 ` + "`" + `json:"price"` + "`" + `
+}
+`,
+	},
+	{
+		name:    "optional and nullable",
+		samples: `{"id": 1, "name": null, "tags": ["a"]} {"id": 2, "name": "b", "nick": "c"}`,
+		goSrc: `type Root struct {
+	ID   int      ` + "`" + `json:"id"` + "`" + `
+	Name *string  ` + "`" + `json:"name"` + "`" + `
+	Tags []string ` + "`" + `json:"tags,omitempty"` + "`" + `
+	Nick *string  ` + "`" + `json:"nick,omitempty"` + "`" + `
+}
+`,
+		schema: `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Root",
+  "type": "object",
+  "properties": {
+    "id": {
+      "type": "integer"
+    },
+    "name": {
+      "type": [
+        "null",
+        "string"
+      ]
+    },
+    "tags": {
+      "type": "array",
+      "items": {
+        "type": "string"
+      }
+    },
+    "nick": {
+      "type": "string"
+    }
+  },
+  "required": [
+    "id",
+    "name"
+  ]
+}
+`,
+	},
+	{
+		name:    "nested objects and arrays of objects",
+		samples: `{"user": {"user_id": 1, "home_url": "x"}, "categories": [{"name": "a"}, {"name": "b", "parent": {"name": "a"}}]}`,
+		goSrc: `type Root struct {
+	User       User       ` + "`" + `json:"user"` + "`" + `
+	Categories []Category ` + "`" + `json:"categories"` + "`" + `
+}
+
+type User struct {
+	UserID  int    ` + "`" + `json:"user_id"` + "`" + `
+	HomeURL string ` + "`" + `json:"home_url"` + "`" + `
+}
+
+type Category struct {
+	Name   string  ` + "`" + `json:"name"` + "`" + `
+	Parent *Parent ` + "`" + `json:"parent,omitempty"` + "`" + `
+}
+
+type Parent struct {
+	Name string ` + "`" + `json:"name"` + "`" + `
+}
+`,
+		schema: `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Root",
+  "type": "object",
+  "properties": {
+    "user": {
+      "type": "object",
+      "properties": {
+        "user_id": {
+          "type": "integer"
+        },
+        "home_url": {
+          "type": "string"
+        }
+      },
+      "required": [
+        "user_id",
+        "home_url"
+      ]
+    },
+    "categories": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string"
+          },
+          "parent": {
+            "type": "object",
+            "properties": {
+              "name": {
+                "type": "string"
+              }
+            },
+            "required": [
+              "name"
+            ]
+          }
+        },
+        "required": [
+          "name"
+        ]
+      }
+    }
+  },
+  "required": [
+    "user",
+    "categories"
+  ]
+}
+`,
+	},
+	{
+		name:    "type name conflicts",
+		samples: `{"root": {"item": {"a": 1}}, "item": {"b": true}}`,
+		goSrc: `type Root struct {
+	Root RootRoot ` + "`" + `json:"root"` + "`" + `
+	Item Item     ` + "`" + `json:"item"` + "`" + `
+}
+
+type RootRoot struct {
+	Item RootRootItem ` + "`" + `json:"item"` + "`" + `
+}
+
+type Item struct {
+	B bool ` + "`" + `json:"b"` + "`" + `
+}
+
+type RootRootItem struct {
+	A int ` + "`" + `json:"a"` + "`" + `
+}
+`,
+	},
+	{
+		name:    "mixed and unknown values",
+		samples: `{"v": 1, "n": null, "e": [], "m": [1, "a"]} {"v": "x", "n": null, "e": []}`,
+		goSrc: `type Root struct {
+	V interface{}   ` + "`" + `json:"v"` + "`" + `
+	N interface{}   ` + "`" + `json:"n"` + "`" + `
+	E []interface{} ` + "`" + `json:"e"` + "`" + `
+	M []interface{} ` + "`" + `json:"m,omitempty"` + "`" + `
+}
+`,
+	},
+	{
+		name:    "large integers",
+		samples: `{"a": 18446744073709551615, "b": -1, "c": 1} {"a": 1, "b": 18446744073709551615, "c": 1e3}`,
+		goSrc: `import "encoding/json"
+
+type Root struct {
+	A uint64      ` + "`" + `json:"a"` + "`" + `
+	B json.Number ` + "`" + `json:"b"` + "`" + `
+	C float64     ` + "`" + `json:"c"` + "`" + `
+}
+`,
+	},
+	{
+		name:    "unusual keys",
+		samples: `{"first name": 1, "-": 2, "2fa": true, "书名": "x", "": 0, "a\"b": 1, "ID": 1, "id": 2}`,
+		goSrc: `type Root struct {
+	FirstName int    ` + "`" + `json:"first name"` + "`" + `
+	X         int    ` + "`" + `json:"-,"` + "`" + `
+	X2fa      bool   ` + "`" + `json:"2fa"` + "`" + `
+	X书名       string ` + "`" + `json:"书名"` + "`" + `
+	// 键 "" 不能用作 json 标签，已忽略
+	// 键 "a\"b" 不能用作 json 标签，已忽略
+	ID  int ` + "`" + `json:"ID"` + "`" + `
+	ID2 int ` + "`" + `json:"id"` + "`" + `
+}
+`,
+	},
+	{
+		name:    "top-level array",
+		samples: `[{"a": 1}, {"a": 2, "b": [[1.5]]}] null`,
+		goSrc: `type Root = []RootItem
+
+type RootItem struct {
+	A int         ` + "`" + `json:"a"` + "`" + `
+	B [][]float64 ` + "`" + `json:"b,omitempty"` + "`" + `
+}
+`,
+	},
+	{
+		name:    "duplicate keys",
+		samples: `{"a": 1, "a": 2} {"b": 1}`,
+		goSrc: `type Root struct {
+	A *int ` + "`" + `json:"a,omitempty"` + "`" + `
+	B *int ` + "`" + `json:"b,omitempty"` + "`" + `
+}
+`,
+	},
+}
+
+// 推断结构并比较生成的代码
+func (c *testCase) run() error {
+	s, err := schema.Infer(strings.NewReader(c.samples))
+	if err != nil {
+		return err
+	}
+
+	src, err := s.GoSource("main", "Root")
+	if err != nil {
+		return err
+	}
+	want := "// Code generated by infer; DO NOT EDIT.\n\npackage main\n\n" + c.goSrc
+	if string(src) != want {
+		return fmt.Errorf("Go source\n%s\nwant\n%s", src, want)
+	}
+
+	if c.schema != "" {
+		doc, err := s.JSONSchema("Root")
+		if err != nil {
+			return err
+		}
+		if string(doc) != c.schema {
+			return fmt.Errorf("JSON Schema\n%s\nwant\n%s", doc, c.schema)
+		}
+	}
+	return nil
+}
+
+// sample 包中的代码与重新生成的相同
+func checkGenerated(file, name, samples string) error {
+	f, err := os.Open(samples)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s, err := schema.Infer(f)
+	if err != nil {
+		return err
+	}
+	src, err := s.GoSource("sample", name)
+	if err != nil {
+		return err
+	}
+	old, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(src, old) {
+		return fmt.Errorf("%s is out of date, run go generate", file)
+	}
+	return nil
+}
+
+// 样本能够严格解码到生成的类型，再编码后内容不变
+func checkRoundTrip(samples string, newValue func() interface{}) error {
+	data, err := os.ReadFile(samples)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	original := json.NewDecoder(bytes.NewReader(data))
+	original.UseNumber()
+	for n := 1; decoder.More(); n++ {
+		v := newValue()
+		if err := decoder.Decode(v); err != nil {
+			return fmt.Errorf("sample %d: %v", n, err)
+		}
+		var want interface{}
+		if err := original.Decode(&want); err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var got interface{}
+		d := json.NewDecoder(bytes.NewReader(encoded))
+		d.UseNumber()
+		if err := d.Decode(&got); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("sample %d: encoded as %s", n, encoded)
+		}
+	}
+	return nil
+}
+
+func main() {
+	_, file, _, _ := runtime.Caller(0)
+	dir := filepath.Join(filepath.Dir(file), "..")
+	testdata := filepath.Join(dir, "..", "query", "testdata")
+
+	checks := []struct {
+		name string
+		run  func() error
+	}{
+		{"generated book.go", func() error {
+			return checkGenerated(filepath.Join(dir, "sample", "book.go"), "Book", filepath.Join(testdata, "books.ndjson"))
+		}},
+		{"generated event.go", func() error {
+			return checkGenerated(filepath.Join(dir, "sample", "event.go"), "Event", filepath.Join(testdata, "events.ndjson"))
+		}},
+		{"round trip books", func() error {
+			return checkRoundTrip(filepath.Join(testdata, "books.ndjson"), func() interface{} { return new(sample.Book) })
+		}},
+		{"round trip events", func() error {
+			return checkRoundTrip(filepath.Join(testdata, "events.ndjson"), func() interface{} { return new(sample.Event) })
+		}},
+	}
+	for i := range cases {
+		c := &cases[i]
+		checks = append(checks, struct {
+			name string
+			run  func() error
+		}{c.name, c.run})
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", len(checks))
+}
@@ -0,0 +1,228 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 生成 Go 类型定义，name 为顶层类型名，嵌套的对象生成单独的结构体。
+// 类型按以下规则确定：
+//
+//   - 只出现过整数时为 int，超出 int64 的非负整数为 uint64，再大则为 json.Number；
+//     同时出现过整数和小数时为 float64
+//   - 出现过 null 或在某些对象中省略的字段使用指针，省略的字段加上 omitempty
+//   - 数组的元素合并后确定类型，对象的数组生成元素的结构体
+//   - 只出现过 null、空数组的元素和类型不一致的值为 interface{}
+func (s *Schema) GoSource(pkg, name string) ([]byte, error) {
+	g := &goGen{names: map[string]bool{}}
+	typeName := g.reserve("", exportedName(name))
+
+	var body bytes.Buffer
+	if s.Kinds&^Null == Object {
+		g.structs = append(g.structs, goStruct{typeName, s})
+	} else {
+		// 使用别名，json.Number 等类型定义新类型后会失去原有的编解码方式
+		fmt.Fprintf(&body, "type %s = %s\n\n", typeName, g.typeOf(s, "", typeName))
+	}
+	// 生成结构体时可能加入新的嵌套结构体
+	for i := 0; i < len(g.structs); i++ {
+		g.writeStruct(&body, g.structs[i])
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by infer; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	if g.jsonNumber {
+		buf.WriteString("import \"encoding/json\"\n\n")
+	}
+	buf.Write(body.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("schema: format generated code: %v\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// 待生成的结构体
+type goStruct struct {
+	name   string
+	schema *Schema
+}
+
+// Go 代码生成器
+type goGen struct {
+	structs    []goStruct
+	names      map[string]bool // 已经使用的类型名
+	jsonNumber bool            // 用到了 json.Number
+}
+
+// 生成结构体定义
+func (g *goGen) writeStruct(buf *bytes.Buffer, st goStruct) {
+	fmt.Fprintf(buf, "type %s struct {\n", st.name)
+	used := map[string]bool{}
+	for _, f := range st.schema.Fields {
+		if !validTag(f.Name) {
+			fmt.Fprintf(buf, "\t// 键 %s 不能用作 json 标签，已忽略\n", strconv.Quote(f.Name))
+			continue
+		}
+
+		fieldName := exportedName(f.Name)
+		for i := 2; used[fieldName]; i++ {
+			fieldName = exportedName(f.Name) + strconv.Itoa(i)
+		}
+		used[fieldName] = true
+
+		optional := st.schema.Optional(f)
+		typ := g.typeOf(f.Schema, st.name, fieldName)
+		if optional && !strings.HasPrefix(typ, "*") && !strings.HasPrefix(typ, "[]") && typ != "interface{}" {
+			typ = "*" + typ
+		}
+
+		tag := f.Name
+		if optional {
+			tag += ",omitempty"
+		} else if tag == "-" {
+			tag = "-,"
+		}
+		fmt.Fprintf(buf, "\t%s %s `json:%s`\n", fieldName, typ, strconv.Quote(tag))
+	}
+	buf.WriteString("}\n\n")
+}
+
+// 值的 Go 类型，需要生成结构体时以 name 为类型名，parent 为所在的结构体
+func (g *goGen) typeOf(s *Schema, parent, name string) string {
+	var typ string
+	switch s.Kinds &^ Null {
+	case 0:
+		return "interface{}"
+	case Bool:
+		typ = "bool"
+	case Int:
+		switch {
+		case !s.overflow:
+			typ = "int"
+		case !s.negative && !s.huge:
+			typ = "uint64"
+		default:
+			g.jsonNumber = true
+			typ = "json.Number"
+		}
+	case Int | Float, Float:
+		typ = "float64"
+	case String:
+		typ = "string"
+	case Array:
+		if s.Items == nil {
+			return "[]interface{}"
+		}
+		// 切片本身可以为 nil，不需要指针
+		return "[]" + g.typeOf(s.Items, parent, singular(name))
+	case Object:
+		typ = g.reserve(parent, name)
+		g.structs = append(g.structs, goStruct{typ, s})
+	default:
+		return "interface{}"
+	}
+
+	if s.Kinds&Null != 0 {
+		typ = "*" + typ
+	}
+	return typ
+}
+
+// 取得不重复的类型名，依次尝试 name、加上所在结构体名作为前缀、加上 Item 后缀，最后加上序号
+func (g *goGen) reserve(parent, name string) string {
+	for _, unique := range []string{name, parent + name, name + "Item"} {
+		if !g.names[unique] {
+			g.names[unique] = true
+			return unique
+		}
+	}
+	unique := name
+	for i := 2; g.names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	g.names[unique] = true
+	return unique
+}
+
+// 常见的缩写整体大写
+var initialisms = map[string]bool{
+	"API": true, "ASCII": true, "CPU": true, "CSS": true, "DNS": true, "HTML": true,
+	"HTTP": true, "HTTPS": true, "ID": true, "IP": true, "JSON": true, "SQL": true,
+	"TCP": true, "TLS": true, "TTL": true, "UDP": true, "UI": true, "URI": true,
+	"URL": true, "UUID": true, "XML": true,
+}
+
+// 把 JSON 的键转换为导出的标识符：按非字母数字字符和小写到大写的边界分词，
+// 每个词首字母大写，缩写整体大写。不以大写字母开头时加上前缀 X
+func exportedName(key string) string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	for i, r := range []rune(key) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 && len(word) > 0 && unicode.IsLower(word[len(word)-1]) {
+			flush()
+		}
+		word = append(word, r)
+	}
+	flush()
+
+	var b strings.Builder
+	for _, w := range words {
+		if upper := strings.ToUpper(w); initialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+
+	name := b.String()
+	if name == "" || !unicode.IsUpper([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// 数组元素的类型名：Books -> Book，Categories -> Category
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "ss"):
+	case strings.HasSuffix(name, "s") && len(name) > 1:
+		return strings.TrimSuffix(name, "s")
+	}
+	return name
+}
+
+// 与 encoding/json 相同：标签中的字段名只能包含字母、数字和部分标点
+func validTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSON Schema 的类型名，整数和小数同时出现时只写 number
+var jsonTypes = []struct {
+	kind Kind
+	name string
+}{
+	{Null, "null"},
+	{Bool, "boolean"},
+	{Int, "integer"},
+	{Float, "number"},
+	{String, "string"},
+	{Array, "array"},
+	{Object, "object"},
+}
+
+// 生成 JSON Schema（draft 2020-12）文档，title 为文档的标题。
+// 每个对象中都出现的字段列入 required，properties 按字段第一次出现的顺序排列
+func (s *Schema) JSONSchema(title string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := &objectWriter{buf: &buf}
+	buf.WriteByte('{')
+	w.key("$schema")
+	writeString(&buf, "https://json-schema.org/draft/2020-12/schema")
+	if title != "" {
+		w.key("title")
+		writeString(&buf, title)
+	}
+	s.writeKeywords(w)
+	buf.WriteByte('}')
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// 逐个写出对象的成员，自动加上逗号
+type objectWriter struct {
+	buf *bytes.Buffer
+	n   int
+}
+
+func (w *objectWriter) key(name string) {
+	if w.n > 0 {
+		w.buf.WriteByte(',')
+	}
+	w.n++
+	writeString(w.buf, name)
+	w.buf.WriteByte(':')
+}
+
+// 把 s 写成一个子模式
+func (s *Schema) writeJSONSchema(buf *bytes.Buffer) {
+	buf.WriteByte('{')
+	s.writeKeywords(&objectWriter{buf: buf})
+	buf.WriteByte('}')
+}
+
+// 写出 s 的各个关键字
+func (s *Schema) writeKeywords(w *objectWriter) {
+	buf := w.buf
+	kinds := s.Kinds
+	if kinds&Float != 0 {
+		kinds &^= Int
+	}
+	var types []string
+	for _, t := range jsonTypes {
+		if kinds&t.kind != 0 {
+			types = append(types, t.name)
+		}
+	}
+	switch len(types) {
+	case 0:
+		// 没有出现过的值，任何类型都可以
+	case 1:
+		w.key("type")
+		writeString(buf, types[0])
+	default:
+		w.key("type")
+		writeStrings(buf, types)
+	}
+
+	if s.Kinds&Array != 0 && s.Items != nil {
+		w.key("items")
+		s.Items.writeJSONSchema(buf)
+	}
+
+	if s.Kinds&Object != 0 {
+		w.key("properties")
+		properties := &objectWriter{buf: buf}
+		var required []string
+		buf.WriteByte('{')
+		for _, f := range s.Fields {
+			properties.key(f.Name)
+			f.writeJSONSchema(buf)
+			if !s.Optional(f) {
+				required = append(required, f.Name)
+			}
+		}
+		buf.WriteByte('}')
+
+		if len(required) > 0 {
+			w.key("required")
+			writeStrings(buf, required)
+		}
+	}
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	buf.Write(data)
+}
+
+func writeStrings(buf *bytes.Buffer, list []string) {
+	buf.WriteByte('[')
+	for i, s := range list {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeString(buf, s)
+	}
+	buf.WriteByte(']')
+}
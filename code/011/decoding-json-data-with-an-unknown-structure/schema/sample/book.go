@@ -0,0 +1,12 @@
+// Code generated by infer; DO NOT EDIT.
+
+package sample
+
+type Book struct {
+	Title       string   `json:"Title"`
+	Authors     []string `json:"Authors"`
+	Publisher   string   `json:"Publisher"`
+	IsPublished bool     `json:"IsPublished"`
+	Price       float64  `json:"Price"`
+	Sales       *uint64  `json:"Sales"`
+}
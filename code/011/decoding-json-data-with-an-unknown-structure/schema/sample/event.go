@@ -0,0 +1,14 @@
+// Code generated by infer; DO NOT EDIT.
+
+package sample
+
+type Event struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	User User   `json:"user"`
+}
+
+type User struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
@@ -0,0 +1,5 @@
+// 由 infer 根据 query/testdata 中的样例文件生成的类型，在本目录下执行 go generate 更新
+package sample
+
+//go:generate go run code-snippet/code/011/decoding-json-data-with-an-unknown-structure/infer -name Book -package sample -o book.go ../../query/testdata/books.ndjson
+//go:generate go run code-snippet/code/011/decoding-json-data-with-an-unknown-structure/infer -name Event -package sample -o event.go ../../query/testdata/events.ndjson
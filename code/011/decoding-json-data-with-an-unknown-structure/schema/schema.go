@@ -0,0 +1,186 @@
+package schema
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"code-snippet/code/006/jsoncodec"
+)
+
+// 值的类型，多个样本中出现过的类型按位合并
+type Kind uint8
+
+const (
+	Null Kind = 1 << iota
+	Bool
+	Int
+	Float
+	String
+	Array
+	Object
+)
+
+var kindNames = []string{"null", "bool", "int", "float", "string", "array", "object"}
+
+func (k Kind) String() string {
+	var names []string
+	for i, name := range kindNames {
+		if k&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// 从样本推断出的结构。同一位置的所有值合并到一个 Schema 中
+type Schema struct {
+	Kinds   Kind
+	Count   int      // 出现的次数，作为对象字段时小于所在对象的次数说明字段可以省略
+	Objects int      // 其中是对象的次数
+	Fields  []*Field // 对象的字段，按第一次出现的顺序排列
+	Items   *Schema  // 数组的元素，所有数组都为空时为 nil
+
+	negative bool // 出现过负整数
+	overflow bool // 出现过超出 int64 的整数
+	huge     bool // 出现过超出 uint64 的整数
+}
+
+// 对象的字段
+type Field struct {
+	Name string
+	*Schema
+}
+
+// 字段是否在某些对象中没有出现
+func (s *Schema) Optional(f *Field) bool {
+	return f.Count < s.Objects
+}
+
+// 查找字段
+func (s *Schema) Field(name string) *Field {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// 读取输入流中的每个 JSON 值作为一个样本，返回样本的数量
+func (s *Schema) AddSamples(r io.Reader) (int, error) {
+	t := jsoncodec.NewTokenizer(r)
+	n := 0
+	for {
+		if _, err := t.PeekKind(); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		if err := s.add(t); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// 从输入流中推断结构
+func Infer(r io.Reader) (*Schema, error) {
+	s := new(Schema)
+	if _, err := s.AddSamples(r); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 读取下一个值并合并到 s
+func (s *Schema) add(t *jsoncodec.Tokenizer) error {
+	s.Count++
+	null, err := jsoncodec.ReadNull(t)
+	if err != nil {
+		return err
+	}
+	if null {
+		s.Kinds |= Null
+		return nil
+	}
+
+	token, err := t.Next()
+	if err != nil {
+		return err
+	}
+	switch token.Kind {
+	case jsoncodec.True, jsoncodec.False:
+		s.Kinds |= Bool
+	case jsoncodec.String:
+		s.Kinds |= String
+	case jsoncodec.Number:
+		s.addNumber(token.Value)
+	case jsoncodec.ArrayStart:
+		s.Kinds |= Array
+		for {
+			more, err := jsoncodec.More(t)
+			if err != nil {
+				return err
+			}
+			if !more {
+				break
+			}
+			if s.Items == nil {
+				s.Items = new(Schema)
+			}
+			if err := s.Items.add(t); err != nil {
+				return err
+			}
+		}
+	case jsoncodec.ObjectStart:
+		s.Kinds |= Object
+		s.Objects++
+		// 同一个对象中重复的键只计一次
+		seen := make(map[string]bool)
+		for {
+			key, ok, err := jsoncodec.ReadKey(t)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			f := s.Field(key)
+			if f == nil {
+				f = &Field{Name: key, Schema: new(Schema)}
+				s.Fields = append(s.Fields, f)
+			}
+			if seen[key] {
+				f.Count--
+			}
+			seen[key] = true
+			if err := f.add(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 记录数字的类型，没有小数点和指数的为整数
+func (s *Schema) addNumber(text string) {
+	if strings.ContainsAny(text, ".eE") {
+		s.Kinds |= Float
+		return
+	}
+	s.Kinds |= Int
+	if strings.HasPrefix(text, "-") {
+		s.negative = true
+	}
+	if _, err := strconv.ParseInt(text, 10, 64); err != nil {
+		s.overflow = true
+		if _, err := strconv.ParseUint(text, 10, 64); err != nil {
+			s.huge = true
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 针对相册服务器的恶意输入测试，需要先启动相册服务器：
+//
+//	go run ./code/011/photos
+//	go run ./code/011/photos/attack -addr http://127.0.0.1:8080
+
+var addr = flag.String("addr", "http://127.0.0.1:8080", "photos server address")
+
+// 不跟随重定向，便于检查上传后跳转的地址
+var client = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// 测试用例
+type testCase struct {
+	name string
+	run  func() error
+}
+
+func main() {
+	flag.Parse()
+
+	before, err := countPhotos()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "photos server is not reachable: %s\n", err)
+		os.Exit(1)
+	}
+
+	cases := []testCase{
+		// 路径穿越
+		{"view ../photos.go", expectStatus("GET", "/view?id="+url.QueryEscape("../photos.go"), http.StatusBadRequest)},
+		{"view ../../../../etc/passwd", expectStatus("GET", "/view?id="+url.QueryEscape("../../../../etc/passwd"), http.StatusBadRequest)},
+		{"view /etc/passwd", expectStatus("GET", "/view?id="+url.QueryEscape("/etc/passwd"), http.StatusBadRequest)},
+		{"view a.jpg/../../photos.go", expectStatus("GET", "/view?id="+url.QueryEscape("a.jpg/../../photos.go"), http.StatusBadRequest)},
+		{"view ..\\photos.go", expectStatus("GET", "/view?id="+url.QueryEscape("..\\photos.go"), http.StatusBadRequest)},
+		{"view double encoded", expectStatus("GET", "/view?id=..%252Fphotos.go", http.StatusBadRequest)},
+		{"view ..", expectStatus("GET", "/view?id=..", http.StatusBadRequest)},
+		{"view empty id", expectStatus("GET", "/view?id=", http.StatusBadRequest)},
+		{"view NUL byte", expectStatus("GET", "/view?id="+url.QueryEscape("a.jpg\x00.png"), http.StatusBadRequest)},
+		{"thumb ../photos.go", expectStatus("GET", "/thumb?id="+url.QueryEscape("../photos.go"), http.StatusBadRequest)},
+		{"thumb ../../meta", expectStatus("GET", "/thumb?id="+url.QueryEscape("../meta/x.jpg"), http.StatusBadRequest)},
+
+		// 非图片内容
+		{"upload HTML named .jpg", expectUpload("evil.jpg", []byte("<html><script>alert(1)</script></html>"), http.StatusUnsupportedMediaType)},
+		{"upload script named .png", expectUpload("evil.png", []byte("#!/bin/sh\nrm -rf /\n"), http.StatusUnsupportedMediaType)},
+		{"upload SVG", expectUpload("evil.svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`), http.StatusUnsupportedMediaType)},
+		{"upload empty file", expectUpload("empty.jpg", nil, http.StatusBadRequest)},
+
+		// 超大请求和解压炸弹
+		{"upload larger than limit", expectUpload("big.png", append(pngImage(), make([]byte, 11<<20)...), http.StatusRequestEntityTooLarge)},
+		{"upload huge dimensions", expectUpload("bomb.png", pngHeader(100000, 100000), http.StatusBadRequest)},
+		{"upload truncated PNG", expectUpload("broken.png", pngImage()[:60], http.StatusBadRequest)},
+
+		// 合法图片使用恶意文件名
+		{"upload PNG named .exe", expectStored("evil.exe", ".png")},
+		{"upload PNG named ../../x.png", expectStored("../../x.png", ".png")},
+		{"upload PNG named .jpg", expectStored("real.jpg", ".png")},
+	}
+
+	failed := 0
+	for _, c := range cases {
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	// 被拒绝的上传不应该留下图片
+	after, err := countPhotos()
+	if err != nil {
+		failed++
+		fmt.Printf("FAIL  count photos: %s\n", err)
+	} else if want := before + 3; after != want {
+		failed++
+		fmt.Printf("FAIL  photo count: got %d, want %d\n", after, want)
+	} else {
+		fmt.Printf("PASS  photo count %d -> %d\n", before, after)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(cases)+1)
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", len(cases)+1)
+}
+
+// 检查请求的响应状态码
+func expectStatus(method, path string, want int) func() error {
+	return func() error {
+		request, err := http.NewRequest(method, *addr+path, nil)
+		if err != nil {
+			return err
+		}
+
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != want {
+			return fmt.Errorf("status %d, want %d", response.StatusCode, want)
+		}
+		return nil
+	}
+}
+
+// 检查上传的响应状态码
+func expectUpload(filename string, data []byte, want int) func() error {
+	return func() error {
+		response, err := upload(filename, data)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != want {
+			return fmt.Errorf("status %d, want %d", response.StatusCode, want)
+		}
+		return nil
+	}
+}
+
+// 检查合法图片上传后保存的ID只由服务器生成，扩展名由内容决定
+func expectStored(filename, ext string) func() error {
+	return func() error {
+		response, err := upload(filename, pngImage())
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusFound {
+			return fmt.Errorf("status %d, want %d", response.StatusCode, http.StatusFound)
+		}
+
+		location, err := url.Parse(response.Header.Get("Location"))
+		if err != nil {
+			return err
+		}
+
+		id := location.Query().Get("id")
+		if strings.ContainsAny(id, "/\\") || strings.Contains(id, "..") || !strings.HasSuffix(id, ext) {
+			return fmt.Errorf("stored as %q", id)
+		}
+		return expectStatus("GET", "/view?id="+url.QueryEscape(id), http.StatusOK)()
+	}
+}
+
+// 以 multipart 表单上传文件
+func upload(filename string, data []byte) (*http.Response, error) {
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+
+	part, err := form.CreateFormFile("image", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(data); err != nil {
+		return nil, err
+	}
+	if err = form.Close(); err != nil {
+		return nil, err
+	}
+
+	return client.Post(*addr+"/upload", form.FormDataContentType(), body)
+}
+
+// 统计列表页显示的图片总数
+func countPhotos() (int, error) {
+	response, err := client.Get(*addr + "/")
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	match := regexp.MustCompile(`\((\d+) photos\)`).FindSubmatch(body)
+	if match == nil {
+		return 0, fmt.Errorf("photo count not found in list page")
+	}
+	return strconv.Atoi(string(match[1]))
+}
+
+// 生成一张合法的小 PNG 图片
+func pngImage() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < 16; i++ {
+		img.Set(i, i, color.RGBA{R: 0xff, A: 0xff})
+	}
+
+	buf := new(bytes.Buffer)
+	png.Encode(buf, img)
+	return buf.Bytes()
+}
+
+// 生成只有文件头的 PNG，声明的宽高可以任意大
+func pngHeader(width, height uint32) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	ihdr[8] = 8 // 位深
+	ihdr[9] = 2 // RGB
+
+	chunk := append([]byte("IHDR"), ihdr...)
+	binary.Write(buf, binary.BigEndian, uint32(len(ihdr)))
+	buf.Write(chunk)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
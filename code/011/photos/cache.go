@@ -0,0 +1,133 @@
+package main
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 变换结果的缓存目录
+	VariantDir = "./code/011/photos/variants"
+
+	// 变换结果缓存的总大小上限
+	MaxVariantCacheSize = 256 << 20
+)
+
+// 缓存项
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// 按最近使用顺序淘汰的磁盘缓存，文件名就是缓存键
+type diskCache struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // 队首为最近使用的缓存项
+	entries map[string]*list.Element
+}
+
+// 创建磁盘缓存，按文件修改时间恢复已有缓存的使用顺序
+func newDiskCache(dir string, max int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	c := &diskCache{
+		dir:     dir,
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	for _, info := range infos {
+		// 跳过写入中断遗留的临时文件
+		if !info.Mode().IsRegular() || info.Name()[0] == '.' {
+			continue
+		}
+		c.entries[info.Name()] = c.order.PushBack(&cacheEntry{key: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+	return c, nil
+}
+
+// 打开缓存文件并标记为最近使用，不存在时返回 false
+func (c *diskCache) Open(key string) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	// 持有锁时打开，避免文件在打开前被淘汰
+	file, err := os.Open(getFilePath(c.dir, key))
+	if err != nil {
+		c.remove(element)
+		return nil, false
+	}
+
+	// 更新修改时间，重启后仍能恢复使用顺序
+	c.order.MoveToFront(element)
+	now := time.Now()
+	os.Chtimes(file.Name(), now, now)
+	return file, true
+}
+
+// 写入缓存，超过大小上限时淘汰最久未使用的缓存项
+func (c *diskCache) Put(key string, data []byte) error {
+	if err := writeFileAtomic(getFilePath(c.dir, key), data); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		c.size += int64(len(data)) - entry.size
+		entry.size = int64(len(data))
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key: key, size: int64(len(data))})
+		c.size += int64(len(data))
+	}
+
+	c.evict()
+	return nil
+}
+
+// 淘汰缓存项直到总大小不超过上限，调用方需持有锁
+func (c *diskCache) evict() {
+	for c.size > c.max && c.order.Len() > 0 {
+		element := c.order.Back()
+		os.Remove(getFilePath(c.dir, element.Value.(*cacheEntry).key))
+		c.remove(element)
+	}
+}
+
+// 移除缓存项，调用方需持有锁
+func (c *diskCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"code-snippet/code/011/cookie-setting-and-reading/session"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// 会话文件目录
+	SessionDir = "./code/011/photos/sessions"
+
+	// Cookie 签名和加密密钥，首次启动时随机生成，重启后已有会话仍然有效
+	SessionKeyFile = "./code/011/photos/session.key"
+
+	// 密钥长度，前 32 字节用于签名，后 32 字节用于加密
+	SessionKeySize = 64
+
+	// 清理过期会话的间隔
+	SessionCleanupInterval = time.Hour
+)
+
+// 会话管理，用于在重定向之后显示一次性提示消息
+var sessions *session.Manager
+
+// 创建会话管理器
+func newSessionManager() (*session.Manager, error) {
+	key, err := ioutil.ReadFile(SessionKeyFile)
+	if os.IsNotExist(err) {
+		key = session.GenerateKey(SessionKeySize)
+		err = ioutil.WriteFile(SessionKeyFile, key, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 密钥文件可能被截断或者手动修改过
+	if len(key) != SessionKeySize {
+		return nil, fmt.Errorf("%s: key is %d bytes, want %d", SessionKeyFile, len(key), SessionKeySize)
+	}
+
+	codec, err := session.NewCodec(key[:32], key[32:])
+	if err != nil {
+		return nil, err
+	}
+	store, err := session.NewFileStore(SessionDir)
+	if err != nil {
+		return nil, err
+	}
+	return session.NewManager(store, codec), nil
+}
+
+// 定期清理过期会话
+func cleanupSessions() {
+	for range time.Tick(SessionCleanupInterval) {
+		if err := sessions.Cleanup(); err != nil {
+			log.Printf("cleanup sessions: %s", err)
+		}
+	}
+}
+
+// 添加提示消息，在下一次显示页面时输出，必须在写出响应之前调用
+func addFlash(writer http.ResponseWriter, request *http.Request, kind, message string) {
+	s, err := sessions.Get(request)
+	check(err)
+	s.AddFlash(kind, message)
+	check(sessions.Save(writer, s))
+}
+
+// 取出待显示的提示消息，没有消息时不创建会话
+func takeFlashes(writer http.ResponseWriter, request *http.Request) []session.Flash {
+	s, err := sessions.Get(request)
+	check(err)
+
+	flashes := s.Flashes()
+	if len(flashes) > 0 {
+		check(sessions.Save(writer, s))
+	}
+	return flashes
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// 说明文字的最大长度（字符）
+	MaxCaptionLength = 500
+
+	// 每张图片最多的标签数
+	MaxTags = 20
+)
+
+var (
+	// 合法的标签：小写字母、数字、中划线和下划线，不超过 32 个字符
+	tagPattern = regexp.MustCompile(`^[\p{Ll}\p{Lo}0-9_-]{1,32}$`)
+
+	// 合法的相册名：不超过 64 个字符，不能包含控制字符
+	albumPattern = regexp.MustCompile(`^[^\p{Cc}]{1,64}$`)
+
+	errInvalidTag     = errors.New("invalid tag: use 1-32 letters, digits, '-' or '_'")
+	errTooManyTags    = errors.New("too many tags")
+	errInvalidAlbum   = errors.New("invalid album name")
+	errCaptionTooLong = errors.New("caption too long")
+)
+
+// 图片详情，包含说明文字、相册、标签的编辑表单和删除按钮
+func photoHandler(writer http.ResponseWriter, request *http.Request) {
+	photo, ok := requestPhoto(writer, request)
+	if !ok {
+		return
+	}
+
+	photos, err := loadPhotos()
+	check(err)
+
+	// 内容相同的其他图片
+	duplicates := make([]*Photo, 0)
+	for _, other := range photos {
+		if other.Hash == photo.Hash && other.ID != photo.ID {
+			duplicates = append(duplicates, other)
+		}
+	}
+
+	err = renderHTML(writer, "photo.html", map[string]interface{}{
+		"photo":      photo,
+		"albums":     collectAlbums(photos),
+		"duplicates": duplicates,
+		"flashes":    takeFlashes(writer, request),
+	})
+	check(err)
+}
+
+// 修改说明文字
+func captionHandler(writer http.ResponseWriter, request *http.Request) {
+	editPhoto(writer, request, func(photo *Photo) error {
+		caption := strings.TrimSpace(request.FormValue("caption"))
+		if utf8.RuneCountInString(caption) > MaxCaptionLength {
+			return errCaptionTooLong
+		}
+		photo.Caption = caption
+		return nil
+	})
+}
+
+// 移动到相册，相册名为空时移出相册
+func albumHandler(writer http.ResponseWriter, request *http.Request) {
+	editPhoto(writer, request, func(photo *Photo) error {
+		album := strings.TrimSpace(request.FormValue("album"))
+		if album != "" && !albumPattern.MatchString(album) {
+			return errInvalidAlbum
+		}
+		photo.Album = album
+		return nil
+	})
+}
+
+// 添加或删除标签，add 和 remove 参数可以同时出现，多个标签以逗号分隔
+func tagsHandler(writer http.ResponseWriter, request *http.Request) {
+	editPhoto(writer, request, func(photo *Photo) error {
+		add, err := parseTags(request.FormValue("add"))
+		if err != nil {
+			return err
+		}
+		remove, err := parseTags(request.FormValue("remove"))
+		if err != nil {
+			return err
+		}
+
+		tags := make(map[string]bool)
+		for _, tag := range photo.Tags {
+			tags[tag] = true
+		}
+		for _, tag := range add {
+			tags[tag] = true
+		}
+		for _, tag := range remove {
+			delete(tags, tag)
+		}
+		if len(tags) > MaxTags {
+			return errTooManyTags
+		}
+
+		photo.Tags = make([]string, 0, len(tags))
+		for tag := range tags {
+			photo.Tags = append(photo.Tags, tag)
+		}
+		sort.Strings(photo.Tags)
+		return nil
+	})
+}
+
+// 删除图片
+func deleteHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	photo, ok := requestPhoto(writer, request)
+	if !ok {
+		return
+	}
+
+	err := deletePhoto(photo.ID)
+	if os.IsNotExist(err) {
+		http.NotFound(writer, request)
+		return
+	}
+	check(err)
+
+	// 重定向到列表
+	addFlash(writer, request, "success", "Photo deleted.")
+	http.Redirect(writer, request, "/", http.StatusFound)
+}
+
+// 相册列表
+func albumsHandler(writer http.ResponseWriter, request *http.Request) {
+	photos, err := loadPhotos()
+	check(err)
+
+	err = renderHTML(writer, "albums.html", map[string]interface{}{
+		"albums": collectAlbums(photos),
+		"tags":   collectTags(photos),
+	})
+	check(err)
+}
+
+// 以 POST 方式修改图片元数据，成功后重定向到图片详情
+func editPhoto(writer http.ResponseWriter, request *http.Request, fn func(photo *Photo) error) {
+	if request.Method != "POST" {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	photo, ok := requestPhoto(writer, request)
+	if !ok {
+		return
+	}
+
+	// 校验失败属于客户端错误，不走 safeHandler 的 500 处理
+	var invalid error
+	_, err := updatePhoto(photo.ID, func(photo *Photo) error {
+		invalid = fn(photo)
+		return invalid
+	})
+	if invalid != nil {
+		http.Error(writer, invalid.Error(), http.StatusBadRequest)
+		return
+	}
+	if os.IsNotExist(err) {
+		http.NotFound(writer, request)
+		return
+	}
+	check(err)
+
+	// 重定向到图片详情
+	addFlash(writer, request, "success", "Photo updated.")
+	http.Redirect(writer, request, "/photo?id="+url.QueryEscape(photo.ID), http.StatusFound)
+}
+
+// 读取请求参数 id 对应的图片元数据，失败时已经写出错误响应
+func requestPhoto(writer http.ResponseWriter, request *http.Request) (*Photo, bool) {
+	// 校验图片ID
+	id := request.FormValue("id")
+	if !isValidPhotoID(id) {
+		http.Error(writer, "invalid photo id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	photo, err := loadPhoto(id)
+	if os.IsNotExist(err) {
+		http.NotFound(writer, request)
+		return nil, false
+	}
+	check(err)
+
+	return photo, true
+}
+
+// 解析逗号分隔的标签，统一转为小写
+func parseTags(value string) ([]string, error) {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if !tagPattern.MatchString(tag) {
+			return nil, errInvalidTag
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 图片元数据，以 JSON 格式保存在 MetaDir 下与图片同名的 .json 文件中
+type Photo struct {
+	ID           string    `json:"id"`            // 图片ID，由服务器生成
+	Hash         string    `json:"hash"`          // 内容的 SHA-256，对应 BlobDir 中的文件
+	OriginalName string    `json:"original_name"` // 上传时客户端提供的文件名
+	Size         int64     `json:"size"`          // 文件大小（字节）
+	Width        int       `json:"width"`         // 图片宽度（像素）
+	Height       int       `json:"height"`        // 图片高度（像素）
+	ContentType  string    `json:"content_type"`  // MIME 类型
+	UploadedAt   time.Time `json:"uploaded_at"`   // 上传时间
+	Caption      string    `json:"caption"`       // 说明文字
+	Album        string    `json:"album"`         // 所属相册，为空表示不属于任何相册
+	Tags         []string  `json:"tags"`          // 标签，按字母顺序排列
+}
+
+// 相册及其图片数量
+type Album struct {
+	Name  string
+	Count int
+	Cover string // 封面图片ID
+}
+
+// 元数据的读取-修改-写入需要串行执行
+var photoLock sync.Mutex
+
+// 是否带有指定标签
+func (photo *Photo) HasTag(tag string) bool {
+	for _, t := range photo.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// 元数据文件路径
+func getMetaPath(id string) string {
+	return getFilePath(MetaDir, id+".json")
+}
+
+// 保存图片元数据
+func savePhoto(photo *Photo) error {
+	data, err := json.MarshalIndent(photo, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(getMetaPath(photo.ID), data)
+}
+
+// 读取图片元数据
+func loadPhoto(id string) (*Photo, error) {
+	data, err := ioutil.ReadFile(getMetaPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	photo := new(Photo)
+	if err = json.Unmarshal(data, photo); err != nil {
+		return nil, err
+	}
+	return photo, nil
+}
+
+// 修改图片元数据，fn 返回错误时不保存
+func updatePhoto(id string, fn func(photo *Photo) error) (*Photo, error) {
+	photoLock.Lock()
+	defer photoLock.Unlock()
+
+	photo, err := loadPhoto(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = fn(photo); err != nil {
+		return nil, err
+	}
+	return photo, savePhoto(photo)
+}
+
+// 删除图片及其缩略图和元数据
+func deletePhoto(id string) error {
+	photoLock.Lock()
+	defer photoLock.Unlock()
+
+	// 先删除元数据，图片立即从列表中消失
+	if err := os.Remove(getMetaPath(id)); err != nil {
+		return err
+	}
+
+	// 内容和缩略图可能被其他图片共用，不再被引用时才删除
+	return releaseBlob(id)
+}
+
+// 读取全部图片元数据
+func loadPhotos() ([]*Photo, error) {
+	infos, err := ioutil.ReadDir(MetaDir)
+	if err != nil {
+		return nil, err
+	}
+
+	photos := make([]*Photo, 0, len(infos))
+	for _, info := range infos {
+		filename := info.Name()
+		if path.Ext(filename) != ".json" {
+			continue
+		}
+
+		photo, err := loadPhoto(strings.TrimSuffix(filename, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, photo)
+	}
+	return photos, nil
+}
+
+// 把旧版本保存在 UploadDir 中的图片复制到内容存储，并补齐元数据和缩略图。
+// UploadDir 中的文件保持不变，迁移过的图片ID记录在索引中，删除后不会再次迁移。
+// 只在启动时调用，此时还没有其他 goroutine 访问索引
+func syncPhotos() error {
+	infos, err := ioutil.ReadDir(UploadDir)
+	if os.IsNotExist(err) {
+		return repairPhotos()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		id := info.Name()
+		if info.IsDir() || !isValidPhotoID(id) || blobIndex.Migrated[id] {
+			continue
+		}
+
+		// 已经登记的图片只需要补上迁移记录
+		if _, ok := blobIndex.Photos[id]; !ok {
+			migrated, err := migratePhoto(id, info)
+			if err != nil {
+				return err
+			}
+			if !migrated {
+				continue
+			}
+		}
+		blobIndex.Migrated[id] = true
+		if err = saveBlobIndex(); err != nil {
+			return err
+		}
+	}
+	return repairPhotos()
+}
+
+// 把 UploadDir 中的一张图片复制到内容存储，无法解码的图片返回 false
+func migratePhoto(id string, info os.FileInfo) (bool, error) {
+	src := getFilePath(UploadDir, id)
+	file, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	temp, hash, err := writeTempBlob(file)
+	file.Close()
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(temp)
+
+	photo, err := loadPhoto(id)
+	if os.IsNotExist(err) {
+		photo, err = processPhoto(id, hash, temp, id, getUploadTime(id, info.ModTime()))
+		if err != nil {
+			// 无法解码的文件不影响其他图片
+			return false, nil
+		}
+	} else if err != nil {
+		return false, err
+	} else {
+		// 已有元数据的图片补上内容哈希，缩略图改为按内容哈希保存
+		photo.Hash = hash
+		if !isExists(getThumbPath(hash)) {
+			if _, _, err = makeThumbnail(temp, getThumbPath(hash)); err != nil {
+				return false, nil
+			}
+		}
+		os.Remove(getThumbPath(id))
+	}
+
+	// 先登记内容再保存元数据，中途退出时由 repairPhotos 补齐元数据
+	if _, err = storeBlob(id, temp, hash); err != nil {
+		return false, err
+	}
+	return true, savePhoto(photo)
+}
+
+// 内容已经登记但没有元数据的图片，根据内容重新生成元数据。
+// 只在启动时调用，此时还没有其他 goroutine 访问索引
+func repairPhotos() error {
+	for id, hash := range blobIndex.Photos {
+		if isExists(getMetaPath(id)) {
+			continue
+		}
+
+		blob := getBlobPath(hash)
+		info, err := os.Stat(blob)
+		if err != nil {
+			return err
+		}
+		photo, err := processPhoto(id, hash, blob, id, getUploadTime(id, info.ModTime()))
+		if err != nil {
+			return err
+		}
+		if err = savePhoto(photo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从 getNewFileNameForUpload 生成的文件名中解析上传时间，解析失败时返回 def
+func getUploadTime(id string, def time.Time) time.Time {
+	name := strings.TrimSuffix(id, path.Ext(id))
+	if len(name) <= len("2006-01-02-15-04-05") {
+		return def
+	}
+
+	nano, err := strconv.ParseInt(name[len("2006-01-02-15-04-05"):], 10, 64)
+	if err != nil {
+		return def
+	}
+	return time.Unix(0, nano)
+}
+
+// 读取图片信息，缩略图不存在时生成缩略图，src 为图片文件路径
+func processPhoto(id, hash, src, originalName string, uploadedAt time.Time) (*Photo, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, err := getFileContentType(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// 相同内容的缩略图已经存在时只需读取宽高
+	var width, height int
+	if thumb := getThumbPath(hash); isExists(thumb) {
+		width, height, err = imageSize(src)
+	} else {
+		width, height, err = makeThumbnail(src, thumb)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Photo{
+		ID:           id,
+		Hash:         hash,
+		OriginalName: originalName,
+		Size:         info.Size(),
+		Width:        width,
+		Height:       height,
+		ContentType:  contentType,
+		UploadedAt:   uploadedAt,
+	}, nil
+}
+
+// 按相册和标签过滤图片，参数为空时不过滤
+func filterPhotos(photos []*Photo, album, tag string) []*Photo {
+	filtered := photos[:0]
+	for _, photo := range photos {
+		if album != "" && photo.Album != album {
+			continue
+		}
+		if tag != "" && !photo.HasTag(tag) {
+			continue
+		}
+		filtered = append(filtered, photo)
+	}
+	return filtered
+}
+
+// 汇总全部相册，按名称排序
+func collectAlbums(photos []*Photo) []*Album {
+	byName := make(map[string]*Album)
+	albums := make([]*Album, 0)
+	for _, photo := range photos {
+		if photo.Album == "" {
+			continue
+		}
+
+		album, ok := byName[photo.Album]
+		if !ok {
+			album = &Album{Name: photo.Album, Cover: photo.ID}
+			byName[photo.Album] = album
+			albums = append(albums, album)
+		}
+		album.Count++
+	}
+
+	sort.Slice(albums, func(i, j int) bool {
+		return albums[i].Name < albums[j].Name
+	})
+	return albums
+}
+
+// 汇总全部标签，按字母顺序排列
+func collectTags(photos []*Photo) []string {
+	seen := make(map[string]bool)
+	tags := make([]string, 0)
+	for _, photo := range photos {
+		for _, tag := range photo.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// 图片排序方式
+var photoSorters = map[string]func(a, b *Photo) bool{
+	"time": func(a, b *Photo) bool { return a.UploadedAt.Before(b.UploadedAt) },
+	"name": func(a, b *Photo) bool { return a.OriginalName < b.OriginalName },
+	"size": func(a, b *Photo) bool { return a.Size < b.Size },
+}
+
+// 按字段排序图片，desc 为 true 时倒序
+func sortPhotos(photos []*Photo, by string, desc bool) {
+	less, ok := photoSorters[by]
+	if !ok {
+		less = photoSorters["time"]
+	}
+
+	sort.SliceStable(photos, func(i, j int) bool {
+		if desc {
+			return less(photos[j], photos[i])
+		}
+		return less(photos[i], photos[j])
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"html/template"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"regexp"
 	"runtime"
 	"runtime/debug"
 	"strconv"
@@ -19,21 +21,68 @@ import (
 )
 
 const (
-	UploadDir   = "./code/011/photos/uploads"
+	UploadDir   = "./code/011/photos/uploads" // 旧版本的上传目录，启动时迁移到 BlobDir
+	ThumbDir    = "./code/011/photos/thumbs"
+	MetaDir     = "./code/011/photos/meta"
 	TemplateDir = "./code/011/photos/views"
 	ListDir     = 0x0001
+
+	// 列表每页默认和最多显示的图片数
+	PageSize    = 12
+	MaxPageSize = 100
+
+	// 上传请求体的最大字节数
+	MaxUploadSize = 10 << 20
 )
 
+// 允许上传的图片类型及对应的扩展名
+var allowedContentTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// 合法的图片ID：字母、数字、下划线和中划线组成的文件名加图片扩展名，不允许出现路径分隔符和 ..
+var photoIDPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z_-]*\.(jpg|jpeg|png|gif)$`)
+
 // 全局变量 templates 用于存放所有模板内容
 var templates = make(map[string]*template.Template)
 
+// 图片变换结果的磁盘缓存
+var variants *diskCache
+
 // 初始化函数完成初始化工作
 func init() {
+	// 创建内容、缩略图和元数据目录
+	for _, dir := range []string{BlobDir, ThumbDir, MetaDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			panic(err)
+		}
+	}
+
+	// 加载内容索引
+	index, err := loadBlobIndex()
+	if err != nil {
+		panic(err)
+	}
+	blobIndex = index
+
+	// 加载变换结果缓存
+	variants, err = newDiskCache(VariantDir, MaxVariantCacheSize)
+	if err != nil {
+		panic(err)
+	}
+
+	// 创建会话管理器
+	sessions, err = newSessionManager()
+	if err != nil {
+		panic(err)
+	}
+
 	// 读取文件夹
 	infos, err := ioutil.ReadDir(TemplateDir)
 	if err != nil {
 		panic(err)
-		return
 	}
 
 	// 遍历文件数组
@@ -87,48 +136,199 @@ func safeHandler(fn http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// 图片列表
+// 图片列表，支持 page、size、sort(time|name|size)、order(asc|desc) 以及 album、tag 过滤参数
 func listHandler(writer http.ResponseWriter, request *http.Request) {
-	// 读取文件夹
-	infos, err := ioutil.ReadDir(UploadDir)
+	// 读取全部图片元数据
+	photos, err := loadPhotos()
 	check(err)
 
-	// 获取图片文件名将其放入到一个切片
-	images := make([]string, 0)
-	for _, info := range infos {
-		images = append(images, info.Name())
+	// 按相册和标签过滤
+	album := request.FormValue("album")
+	tag := request.FormValue("tag")
+	photos = filterPhotos(photos, album, tag)
+
+	// 排序
+	by := request.FormValue("sort")
+	if _, ok := photoSorters[by]; !ok {
+		by = "time"
+	}
+	desc := request.FormValue("order") != "asc"
+	sortPhotos(photos, by, desc)
+
+	// 分页
+	size := formInt(request, "size", PageSize)
+	if size < 1 || size > MaxPageSize {
+		size = PageSize
+	}
+	pages := (len(photos) + size - 1) / size
+	page := formInt(request, "page", 1)
+	if page < 1 {
+		page = 1
+	}
+	if page > pages && pages > 0 {
+		page = pages
+	}
+
+	start := (page - 1) * size
+	end := start + size
+	if end > len(photos) {
+		end = len(photos)
+	}
+
+	order := "desc"
+	if !desc {
+		order = "asc"
 	}
 
 	// 渲染 HTML
-	err = renderHTML(writer, "list.html", map[string]interface{}{"images": images})
+	err = renderHTML(writer, "list.html", map[string]interface{}{
+		"photos":  photos[start:end],
+		"total":   len(photos),
+		"page":    page,
+		"pages":   pages,
+		"size":    size,
+		"sort":    by,
+		"order":   order,
+		"album":   album,
+		"tag":     tag,
+		"prev":    page - 1,
+		"next":    nextPage(page, pages),
+		"flashes": takeFlashes(writer, request),
+	})
 	check(err)
 
 	return
 }
 
+// 下一页页码，没有下一页时返回 0
+func nextPage(page, pages int) int {
+	if page < pages {
+		return page + 1
+	}
+	return 0
+}
+
+// 读取整数参数，缺失或格式错误时返回默认值
+func formInt(request *http.Request, key string, def int) int {
+	value, err := strconv.Atoi(request.FormValue(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// 预览缩略图
+func thumbHandler(writer http.ResponseWriter, request *http.Request) {
+	// 校验图片ID
+	id := request.FormValue("id")
+	if !isValidPhotoID(id) {
+		http.Error(writer, "invalid photo id", http.StatusBadRequest)
+		return
+	}
+
+	// 查找图片内容对应的缩略图，不存在则抛出404
+	hash, ok := getBlobHash(id)
+	if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+
+	// 缩略图内容由图片内容决定，同样可以长期缓存
+	serveImmutable(writer, request, getThumbPath(hash), hash+"-thumb", "image/jpeg")
+}
+
 // 预览图片
 func viewHandler(writer http.ResponseWriter, request *http.Request) {
-	// 目标文件
-	dst := getFilePath(UploadDir, request.FormValue("id"))
+	// 校验图片ID
+	id := request.FormValue("id")
+	if !isValidPhotoID(id) {
+		http.Error(writer, "invalid photo id", http.StatusBadRequest)
+		return
+	}
 
-	// 检测文件是否存在，不存在则抛出404
-	if exists := isExists(dst); !exists {
+	// 查找图片内容，不存在则抛出404
+	hash, ok := getBlobHash(id)
+	if !ok {
 		http.NotFound(writer, request)
 		return
 	}
 
-	// 获取文件类型
-	contentType, err := getFileContentType(dst)
+	// 使用元数据中上传时检测好的文件类型，不再打开图片检测
+	photo, err := loadPhoto(id)
+	if os.IsNotExist(err) {
+		http.NotFound(writer, request)
+		return
+	}
 	check(err)
 
-	// 设置Header头信息
-	writer.Header().Set("Content-Type", contentType)
+	// 带有变换参数时输出变换后的图片
+	transform, err := parseTransform(request.URL.Query(), photo.ContentType)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if transform != nil {
+		serveVariant(writer, request, hash, transform)
+		return
+	}
 
-	// 从服务器读取文件并作为响应数据输出给客户端
-	http.ServeFile(writer, request, dst)
+	serveImmutable(writer, request, getBlobPath(hash), hash, photo.ContentType)
+}
 
-	// 返回停止处理
-	return
+// 输出变换后的图片，变换结果按参数缓存在磁盘上
+func serveVariant(writer http.ResponseWriter, request *http.Request, hash string, transform *Transform) {
+	key := transform.Key(hash)
+
+	// 命中缓存时直接输出
+	if file, ok := variants.Open(key); ok {
+		defer file.Close()
+		info, err := file.Stat()
+		check(err)
+		serveContent(writer, request, file, info.ModTime(), key, transform.ContentType())
+		return
+	}
+
+	data, err := transform.Render(getBlobPath(hash))
+	if err == errCropOutside {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	check(err)
+	check(variants.Put(key, data))
+
+	serveContent(writer, request, bytes.NewReader(data), time.Now(), key, transform.ContentType())
+}
+
+// 输出内容不会改变的文件，ETag 由内容哈希生成
+func serveImmutable(writer http.ResponseWriter, request *http.Request, filename, etag, contentType string) {
+	// 打开文件，不存在则抛出404
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		http.NotFound(writer, request)
+		return
+	}
+	check(err)
+	defer file.Close()
+
+	info, err := file.Stat()
+	check(err)
+
+	serveContent(writer, request, file, info.ModTime(), etag, contentType)
+}
+
+// 设置缓存头后由 http.ServeContent 输出内容，它负责处理 If-None-Match
+// 条件请求（返回 304）和 Range 请求，文件只打开一次
+func serveContent(writer http.ResponseWriter, request *http.Request, content io.ReadSeeker, modtime time.Time, etag, contentType string) {
+	// 设置Header头信息，文件类型为空时由 http.ServeContent 从内容中检测
+	header := writer.Header()
+	header.Set("ETag", `"`+etag+`"`)
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	// 从服务器读取文件并作为响应数据输出给客户端
+	http.ServeContent(writer, request, "", modtime, content)
 }
 
 // 获取文件MIME类型
@@ -143,12 +343,12 @@ func getFileContentType(filepath string) (contentType string, err error) {
 	// 只需要读取文件的前512个字节就够了
 	buffer := make([]byte, 512)
 
-	_, err = file.Read(buffer)
-	if err != nil {
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		return "", err
 	}
 
-	return http.DetectContentType(buffer), nil
+	return http.DetectContentType(buffer[:n]), nil
 }
 
 // 图片上传
@@ -168,39 +368,74 @@ func uploadHandler(writer http.ResponseWriter, request *http.Request) {
 
 	// 上传图片
 	if request.Method == "POST" {
+		// 限制请求体大小
+		request.Body = http.MaxBytesReader(writer, request.Body, MaxUploadSize)
+
 		// 获取上传文件
 		file, header, err := request.FormFile("image")
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(writer, "upload too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(writer, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// 获取上传文件的文件名
-		filename := header.Filename
+		// 获取上传文件的文件名，仅作为元数据保存，不参与生成文件路径
+		filename := path.Base(header.Filename)
 
 		// 延迟关闭文件
 		defer file.Close()
 
-		// 生成新文件名
-		nfn := getNewFileNameForUpload(filename)
-
-		// 创建文件
-		create, err := os.Create(getFilePath(UploadDir, nfn))
+		// 先写入临时文件并计算内容哈希，检查通过后再登记到内容存储
+		temp, hash, err := writeTempBlob(file)
 		if err != nil {
 			http.Error(writer, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer os.Remove(temp)
 
-		// 延迟关闭新创建的文件
-		defer create.Close()
+		// 根据文件内容判断类型，不信任客户端提供的扩展名
+		contentType, err := getFileContentType(temp)
+		if err != nil {
+			http.Error(writer, "empty upload", http.StatusBadRequest)
+			return
+		}
+		ext, ok := allowedContentTypes[contentType]
+		if !ok {
+			http.Error(writer, "unsupported file type "+contentType, http.StatusUnsupportedMediaType)
+			return
+		}
 
-		// 将上传的文件内容拷贝到新创建的文件
-		if _, err = io.Copy(create, file); err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		// 生成图片ID，解码图片并生成缩略图
+		nfn := getNewFileNameForUpload(ext)
+		photo, err := processPhoto(nfn, hash, temp, filename, time.Now())
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// 先登记内容再保存元数据，相同内容只保存一份。
+		// 登记后生成缩略图或者保存元数据失败时解除引用，不再被引用的内容随即删除
+		duplicate, err := storeBlob(nfn, temp, hash)
+		if err != nil {
+			releaseBlob(nfn)
+			panic(err)
+		}
+		if err = savePhoto(photo); err != nil {
+			releaseBlob(nfn)
+			panic(err)
 		}
 
-		// 重定向到预览地址
-		http.Redirect(writer, request, "/view?id="+nfn, http.StatusFound)
+		// 提示上传结果并重定向到图片详情
+		message := "Upload succeeded."
+		if duplicate {
+			message += " This upload is identical to an existing photo and shares its storage."
+		}
+		addFlash(writer, request, "success", message)
+		http.Redirect(writer, request, "/photo?id="+nfn, http.StatusFound)
 
 		// 返回停止处理
 		return
@@ -227,15 +462,36 @@ func getFilePath(dir, filename string) string {
 	return dir + "/" + filename
 }
 
-// 获取新的上传文件名
-func getNewFileNameForUpload(filename string) string {
-	fs := strings.Split(filename, ".")
-	ext := strings.ToLower(fs[len(fs)-1])
+// 获取新的上传文件名，扩展名由文件内容决定
+func getNewFileNameForUpload(ext string) string {
 	t := time.Unix(time.Now().Unix(), 0).Format("2006-01-02-15-04-05")
 	m := strconv.FormatInt(time.Now().UnixNano(), 10)
 	return t + m + "." + ext
 }
 
+// 检查图片ID是否合法
+func isValidPhotoID(id string) bool {
+	return photoIDPattern.MatchString(id) && !strings.Contains(id, "..")
+}
+
+// 先写入同目录下的临时文件再重命名，保证读取方不会看到写了一半的文件
+func writeFileAtomic(filename string, data []byte) error {
+	temp, err := ioutil.TempFile(path.Dir(filename), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(data)
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filename)
+}
+
 // 检测文件是否存在
 func isExists(filepath string) bool {
 	_, err := os.Stat(filepath)
@@ -246,10 +502,38 @@ func isExists(filepath string) bool {
 }
 
 func main() {
+	// 垃圾回收命令：go run ./code/011/photos gc
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		removed, err := collectGarbage()
+		for _, hash := range removed {
+			fmt.Printf("removed %s\n", hash)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d unreferenced blobs removed\n", len(removed))
+		return
+	}
+
+	// 迁移旧版本的图片，补齐元数据和缩略图
+	if err := syncPhotos(); err != nil {
+		log.Fatal(err)
+	}
+
+	go cleanupSessions()
+	go cleanupBlobs()
+
 	mux := http.NewServeMux()
 	staticDirHandler(mux, "/assets/", "./public", 0)
 	mux.HandleFunc("/upload", safeHandler(uploadHandler))
 	mux.HandleFunc("/view", safeHandler(viewHandler))
+	mux.HandleFunc("/thumb", safeHandler(thumbHandler))
+	mux.HandleFunc("/photo", safeHandler(photoHandler))
+	mux.HandleFunc("/photo/caption", safeHandler(captionHandler))
+	mux.HandleFunc("/photo/album", safeHandler(albumHandler))
+	mux.HandleFunc("/photo/tags", safeHandler(tagsHandler))
+	mux.HandleFunc("/photo/delete", safeHandler(deleteHandler))
+	mux.HandleFunc("/albums", safeHandler(albumsHandler))
 	mux.HandleFunc("/", safeHandler(listHandler))
 	err := http.ListenAndServe(":8080", mux)
 	if err != nil {
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 按内容哈希保存图片的目录
+	BlobDir = "./code/011/photos/blobs"
+
+	// 图片ID到内容哈希的引用计数索引
+	IndexFile = "./code/011/photos/blobs.json"
+
+	// 垃圾回收的间隔
+	GCInterval = time.Hour
+
+	// 上传中的临时文件和缩略图在锁外写入，垃圾回收不会删除修改时间在此之内的临时文件和缩略图
+	GCGracePeriod = 10 * time.Minute
+)
+
+// 内容哈希文件名：64 位十六进制的 SHA-256
+var blobPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// 引用计数索引
+type BlobIndex struct {
+	Photos   map[string]string `json:"photos"`   // 图片ID -> 内容哈希
+	Refs     map[string]int    `json:"refs"`     // 内容哈希 -> 引用计数
+	Migrated map[string]bool   `json:"migrated"` // 已经从 UploadDir 迁移的图片ID，包括迁移后删除的
+}
+
+var (
+	// 全局索引，由 storeLock 保护
+	blobIndex *BlobIndex
+
+	// 写入 blob 和修改索引需要串行执行
+	storeLock sync.Mutex
+)
+
+// 内容文件路径
+func getBlobPath(hash string) string {
+	return getFilePath(BlobDir, hash)
+}
+
+// 读取索引文件，文件不存在时返回空索引
+func loadBlobIndex() (*BlobIndex, error) {
+	index := &BlobIndex{
+		Photos:   make(map[string]string),
+		Refs:     make(map[string]int),
+		Migrated: make(map[string]bool),
+	}
+
+	data, err := ioutil.ReadFile(IndexFile)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, index); err != nil {
+		return nil, err
+	}
+	// 旧版本的索引没有 migrated
+	if index.Migrated == nil {
+		index.Migrated = make(map[string]bool)
+	}
+	return index, nil
+}
+
+// 保存索引文件，调用方需持有 storeLock
+func saveBlobIndex() error {
+	data, err := json.MarshalIndent(blobIndex, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(IndexFile, data)
+}
+
+// 查找图片对应的内容哈希
+func getBlobHash(id string) (string, bool) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	hash, ok := blobIndex.Photos[id]
+	return hash, ok
+}
+
+// 把 reader 的内容写入 BlobDir 下的临时文件，同时计算 SHA-256
+func writeTempBlob(reader io.Reader) (temp string, hash string, err error) {
+	file, err := ioutil.TempFile(BlobDir, ".upload-*")
+	if err != nil {
+		return "", "", err
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hasher), reader)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", err
+	}
+	return file.Name(), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// 把文件登记为图片 id 的内容，相同内容已经存在时删除该文件，
+// 返回 duplicate 表示内容与已有图片重复。
+// 调用方应当已经用 processPhoto 生成了缩略图，解码大图不在锁内进行
+func storeBlob(id, src, hash string) (duplicate bool, err error) {
+	duplicate, err = registerBlob(id, src, hash)
+	if err != nil {
+		return duplicate, err
+	}
+
+	// 缩略图在登记之前生成，期间垃圾回收可能删除了它。
+	// 登记后内容已被引用，不会再被回收，在锁外重新生成
+	if thumb := getThumbPath(hash); !isExists(thumb) {
+		if _, _, err = makeThumbnail(getBlobPath(hash), thumb); err != nil {
+			return duplicate, err
+		}
+	}
+	return duplicate, nil
+}
+
+// 在 storeLock 内移动文件并修改索引
+func registerBlob(id, src, hash string) (duplicate bool, err error) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	if blob := getBlobPath(hash); isExists(blob) {
+		duplicate = true
+		os.Remove(src)
+	} else if err = os.Rename(src, blob); err != nil {
+		return false, err
+	}
+
+	blobIndex.Photos[id] = hash
+	blobIndex.Refs[hash]++
+	return duplicate, saveBlobIndex()
+}
+
+// 解除图片对内容的引用，内容不再被引用时同时删除内容和缩略图
+func releaseBlob(id string) error {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	hash, ok := blobIndex.Photos[id]
+	if !ok {
+		return nil
+	}
+
+	delete(blobIndex.Photos, id)
+	if blobIndex.Refs[hash]--; blobIndex.Refs[hash] > 0 {
+		return saveBlobIndex()
+	}
+	delete(blobIndex.Refs, hash)
+	if err := saveBlobIndex(); err != nil {
+		return err
+	}
+	return removeBlob(hash)
+}
+
+// 删除内容和缩略图，调用方需持有 storeLock
+func removeBlob(hash string) error {
+	if err := os.Remove(getBlobPath(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(getThumbPath(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 删除索引保存失败或者进程中途退出时遗留的内容和缩略图，返回被删除的内容哈希。
+// 回收期间持有 storeLock，与 storeBlob 和 releaseBlob 登记内容的部分串行执行
+func collectGarbage() ([]string, error) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	removed, err := collectBlobs()
+	if err != nil {
+		return removed, err
+	}
+	return collectThumbs(removed)
+}
+
+// 删除不再被引用的内容和它的缩略图
+func collectBlobs() ([]string, error) {
+	infos, err := ioutil.ReadDir(BlobDir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0)
+	for _, info := range infos {
+		name := info.Name()
+
+		// 上传中断遗留的临时文件
+		if !blobPattern.MatchString(name) {
+			if info.Mode().IsRegular() && name[0] == '.' && time.Since(info.ModTime()) >= GCGracePeriod {
+				os.Remove(getFilePath(BlobDir, name))
+			}
+			continue
+		}
+
+		if blobIndex.Refs[name] > 0 {
+			continue
+		}
+		if err := removeBlob(name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// 删除内容已经不存在、也不再被引用的缩略图，追加到 removed 中
+func collectThumbs(removed []string) ([]string, error) {
+	infos, err := ioutil.ReadDir(ThumbDir)
+	if err != nil {
+		return removed, err
+	}
+
+	for _, info := range infos {
+		hash := strings.TrimSuffix(info.Name(), ".jpg")
+		if !blobPattern.MatchString(hash) || blobIndex.Refs[hash] > 0 {
+			continue
+		}
+		// 刚生成、还没有登记的缩略图
+		if time.Since(info.ModTime()) < GCGracePeriod {
+			continue
+		}
+		if err := os.Remove(getThumbPath(hash)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, hash)
+	}
+	return removed, nil
+}
+
+// 定期回收不再被引用的内容
+func cleanupBlobs() {
+	for range time.Tick(GCInterval) {
+		removed, err := collectGarbage()
+		if len(removed) > 0 {
+			log.Printf("%d unreferenced blobs removed", len(removed))
+		}
+		if err != nil {
+			log.Printf("collect garbage: %s", err)
+		}
+	}
+}
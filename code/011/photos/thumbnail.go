@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+)
+
+const (
+	// 缩略图的最大宽高
+	ThumbSize = 200
+
+	// 缩略图的 JPEG 质量
+	ThumbQuality = 80
+
+	// 允许解码的最大像素数，防止小文件解码出超大图片耗尽内存
+	MaxPixels = 50 * 1000 * 1000
+)
+
+// 图片尺寸超过限制
+var ErrImageTooLarge = errors.New("image dimensions too large")
+
+// 缩略图路径，相同内容的图片共用一张缩略图，统一保存为 JPEG 格式
+func getThumbPath(hash string) string {
+	return getFilePath(ThumbDir, hash+".jpg")
+}
+
+// 读取图片宽高，超过像素限制时返回错误
+func imageSize(src string) (width, height int, err error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return 0, 0, ErrImageTooLarge
+	}
+	return config.Width, config.Height, nil
+}
+
+// 生成缩略图，返回原图的宽高
+func makeThumbnail(src, dst string) (width, height int, err error) {
+	// 先读取图片尺寸，超过限制时不再解码
+	if _, _, err = imageSize(src); err != nil {
+		return 0, 0, err
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bounds := img.Bounds()
+	thumb := resize(img, fitSize(bounds.Dx(), bounds.Dy(), ThumbSize))
+
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: ThumbQuality}); err != nil {
+		return 0, 0, err
+	}
+	if err = writeFileAtomic(dst, buf.Bytes()); err != nil {
+		return 0, 0, err
+	}
+	return bounds.Dx(), bounds.Dy(), nil
+}
+
+// 按比例缩放到不超过 max 的尺寸，小图保持原尺寸
+func fitSize(width, height, max int) image.Point {
+	if width <= max && height <= max {
+		return image.Pt(width, height)
+	}
+	if width >= height {
+		return image.Pt(max, imax(1, height*max/width))
+	}
+	return image.Pt(imax(1, width*max/height), max)
+}
+
+// 使用区域平均法缩放图片，透明部分以白色填充
+func resize(src image.Image, size image.Point) *image.RGBA {
+	return scale(flatten(src), size)
+}
+
+// 先铺白底再绘制原图，去掉透明通道，返回从 (0,0) 开始的图片
+func flatten(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, bounds.Min, draw.Over)
+	return flat
+}
+
+// 使用区域平均法把从 (0,0) 开始的图片缩放到指定尺寸，保留透明通道
+func scale(src *image.RGBA, size image.Point) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < size.Y; y++ {
+		y0, y1 := y*sh/size.Y, imax((y+1)*sh/size.Y, y*sh/size.Y+1)
+		for x := 0; x < size.X; x++ {
+			x0, x1 := x*sw/size.X, imax((x+1)*sw/size.X, x*sw/size.X+1)
+
+			// 累加源图中对应区域的像素求平均值，RGBA 为预乘透明度的格式，可以直接平均。
+			// 缩放到很小的尺寸时一个像素对应上千万个源像素，uint32 会溢出
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
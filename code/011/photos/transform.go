@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 变换后图片的最大宽高
+	MaxTransformSize = 4096
+
+	// JPEG 默认质量
+	DefaultQuality = 85
+)
+
+// 裁剪区域与图片没有交集
+var errCropOutside = errors.New("crop region is outside the image")
+
+// 变换参数都是 /view 的查询参数
+var transformParams = []string{"w", "h", "fit", "crop", "rotate", "gray", "format", "q"}
+
+// 图片变换，按裁剪、旋转、缩放、灰度的顺序执行
+type Transform struct {
+	Width   int             // 目标宽度，0 表示按比例计算
+	Height  int             // 目标高度，0 表示按比例计算
+	Fill    bool            // true 时居中裁剪后填满目标尺寸，false 时完整放入目标尺寸
+	Crop    image.Rectangle // 裁剪区域，为空表示不裁剪
+	Rotate  int             // 顺时针旋转角度：0、90、180、270
+	Gray    bool            // 转为灰度
+	Format  string          // 输出格式：png、jpeg
+	Quality int             // JPEG 质量 1-100
+}
+
+// 从查询参数解析图片变换，没有任何变换参数时返回 nil。
+// contentType 为原图类型，未指定 format 时 JPEG 原图输出 JPEG，其他输出 PNG
+func parseTransform(values url.Values, contentType string) (*Transform, error) {
+	found := false
+	for _, name := range transformParams {
+		if values.Get(name) != "" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	t := &Transform{Format: "png", Quality: DefaultQuality}
+	if contentType == "image/jpeg" {
+		t.Format = "jpeg"
+	}
+
+	var err error
+	if t.Width, err = paramInt(values, "w", 0, 1, MaxTransformSize); err != nil {
+		return nil, err
+	}
+	if t.Height, err = paramInt(values, "h", 0, 1, MaxTransformSize); err != nil {
+		return nil, err
+	}
+	if t.Quality, err = paramInt(values, "q", DefaultQuality, 1, 100); err != nil {
+		return nil, err
+	}
+
+	switch values.Get("fit") {
+	case "", "fit":
+	case "fill":
+		t.Fill = true
+	default:
+		return nil, errors.New("fit must be fit or fill")
+	}
+	if t.Fill && (t.Width == 0 || t.Height == 0) {
+		return nil, errors.New("fit=fill requires both w and h")
+	}
+
+	if crop := values.Get("crop"); crop != "" {
+		parts := strings.Split(crop, ",")
+		if len(parts) != 4 {
+			return nil, errors.New("crop must be x,y,w,h")
+		}
+		var n [4]int
+		for i, part := range parts {
+			if n[i], err = strconv.Atoi(part); err != nil || n[i] < 0 {
+				return nil, errors.New("crop must be x,y,w,h")
+			}
+		}
+		if n[2] == 0 || n[3] == 0 {
+			return nil, errors.New("crop size must be positive")
+		}
+		t.Crop = image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3])
+	}
+
+	if t.Rotate, err = paramInt(values, "rotate", 0, 0, 270); err != nil || t.Rotate%90 != 0 {
+		return nil, errors.New("rotate must be 0, 90, 180 or 270")
+	}
+
+	switch values.Get("gray") {
+	case "", "0", "false":
+	case "1", "true":
+		t.Gray = true
+	default:
+		return nil, errors.New("gray must be 0 or 1")
+	}
+
+	switch values.Get("format") {
+	case "":
+	case "png":
+		t.Format = "png"
+	case "jpeg", "jpg":
+		t.Format = "jpeg"
+	default:
+		return nil, errors.New("format must be png or jpeg")
+	}
+
+	return t, nil
+}
+
+// 读取整数参数并检查范围，参数为空时返回默认值
+func paramInt(values url.Values, name string, def, min, max int) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// 变换结果的缓存键，由原图内容哈希和规范化后的参数生成
+func (t *Transform) Key(hash string) string {
+	quality := 0
+	if t.Format == "jpeg" {
+		quality = t.Quality
+	}
+
+	canonical := fmt.Sprintf("%s|w=%d|h=%d|fill=%t|crop=%v|rotate=%d|gray=%t|format=%s|q=%d",
+		hash, t.Width, t.Height, t.Fill, t.Crop, t.Rotate, t.Gray, t.Format, quality)
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:]) + "." + t.Format
+}
+
+// 输出的 MIME 类型
+func (t *Transform) ContentType() string {
+	return "image/" + t.Format
+}
+
+// 读取原图，执行变换并编码
+func (t *Transform) Render(src string) ([]byte, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// 统一转为从 (0,0) 开始的 RGBA 图片
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	if !t.Crop.Empty() {
+		if rgba, err = crop(rgba, t.Crop); err != nil {
+			return nil, err
+		}
+	}
+	if t.Rotate != 0 {
+		rgba = rotate(rgba, t.Rotate)
+	}
+	if t.Width != 0 || t.Height != 0 {
+		rgba = t.resize(rgba)
+	}
+	if t.Gray {
+		grayscale(rgba)
+	}
+
+	var buf bytes.Buffer
+	if t.Format == "jpeg" {
+		err = jpeg.Encode(&buf, flatten(rgba), &jpeg.Options{Quality: t.Quality})
+	} else {
+		err = png.Encode(&buf, rgba)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 按 fit 或 fill 方式缩放
+func (t *Transform) resize(src *image.RGBA) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	w, h := t.Width, t.Height
+
+	switch {
+	case w == 0:
+		// 只指定高度时按比例计算宽度
+		w = imin(imax(1, sw*h/sh), MaxTransformSize)
+		return scale(src, image.Pt(w, h))
+	case h == 0:
+		// 只指定宽度时按比例计算高度
+		h = imin(imax(1, sh*w/sw), MaxTransformSize)
+		return scale(src, image.Pt(w, h))
+	case !t.Fill:
+		// 完整放入 w*h，保持比例
+		if sw*h > sh*w {
+			h = imax(1, sh*w/sw)
+		} else {
+			w = imax(1, sw*h/sh)
+		}
+		return scale(src, image.Pt(w, h))
+	}
+
+	// 先从原图中居中裁剪出与目标比例相同的区域，再缩放到 w*h
+	cw, ch := sw, sh
+	if sw*h > sh*w {
+		cw = imax(1, sh*w/h)
+	} else {
+		ch = imax(1, sw*h/w)
+	}
+	x, y := (sw-cw)/2, (sh-ch)/2
+	region, _ := crop(src, image.Rect(x, y, x+cw, y+ch))
+	return scale(region, image.Pt(w, h))
+}
+
+// 裁剪，区域超出图片部分会被截掉
+func crop(src *image.RGBA, rect image.Rectangle) (*image.RGBA, error) {
+	rect = rect.Intersect(src.Bounds())
+	if rect.Empty() {
+		return nil, errCropOutside
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, rect.Min, draw.Src)
+	return dst, nil
+}
+
+// 顺时针旋转 90、180 或 270 度
+func rotate(src *image.RGBA, degrees int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dw, dh := sw, sh
+	if degrees == 90 || degrees == 270 {
+		dw, dh = sh, sw
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			var dx, dy int
+			switch degrees {
+			case 90:
+				dx, dy = sh-1-y, x
+			case 180:
+				dx, dy = sw-1-x, sh-1-y
+			case 270:
+				dx, dy = y, sw-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// 原地转为灰度，保留透明通道
+func grayscale(img *image.RGBA) {
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r, g, b := uint32(img.Pix[i]), uint32(img.Pix[i+1]), uint32(img.Pix[i+2])
+		// 与 color.GrayModel 相同的亮度系数
+		y := uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = y, y, y
+	}
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
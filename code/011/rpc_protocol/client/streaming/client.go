@@ -0,0 +1,84 @@
+package main
+
+import (
+	"code-snippet/code/011/rpc_protocol/service"
+	"code-snippet/code/011/rpc_protocol/stream"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+func main() {
+	conn, err := stream.Dial("tcp", "127.0.0.1:1235")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer conn.Close()
+
+	// 服务器流：请求 100 以内的全部素数
+	primes, err := conn.NewStream(context.Background(), "Ardith.Primes")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if err = primes.Send(&service.Args{A: 100}); err != nil {
+		log.Fatal(err.Error())
+	}
+	primes.CloseSend()
+
+	fmt.Print("ardith: primes <= 100:")
+	for {
+		var prime int
+		err := primes.Recv(&prime)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Printf(" %d", prime)
+	}
+	fmt.Println()
+
+	// 双向流：边发送边接收累加和，与上面的流复用同一个连接
+	sums, err := conn.NewStream(context.Background(), "Ardith.Accumulate")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	for _, n := range []int{1, 2, 3, 4, 5} {
+		if err = sums.Send(n); err != nil {
+			log.Fatal(err.Error())
+		}
+
+		var sum int
+		if err = sums.Recv(&sum); err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Printf("ardith: + %d = %d\n", n, sum)
+	}
+	sums.CloseSend()
+
+	// 取消：只接收一部分素数后取消，服务器端的处理也会停止
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	many, err := conn.NewStream(ctx, "Ardith.Primes")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	many.Send(&service.Args{A: 1 << 30})
+	many.CloseSend()
+
+	count := 0
+	for {
+		var prime int
+		if err := many.Recv(&prime); err != nil {
+			fmt.Printf("ardith: received %d primes before %v\n", count, err)
+			break
+		}
+		count++
+	}
+}
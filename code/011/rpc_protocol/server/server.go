@@ -2,6 +2,7 @@ package main
 
 import (
 	"code-snippet/code/011/rpc_protocol/service"
+	"code-snippet/code/011/rpc_protocol/stream"
 	"log"
 	"net"
 	"net/http"
@@ -24,5 +25,19 @@ func main() {
 
 	go http.Serve(listener, nil)
 
+	// 流式方法使用独立的端口，在一个TCP连接上多路复用多个流
+	streamServer := stream.NewServer()
+	err = streamServer.Register(ardith)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	streamListener, err := net.Listen("tcp", ":1235")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	go streamServer.Serve(streamListener)
+
 	<-exit
 }
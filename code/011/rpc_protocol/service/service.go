@@ -1,7 +1,9 @@
 package service
 
 import (
+	"code-snippet/code/011/rpc_protocol/stream"
 	"errors"
+	"io"
 )
 
 type Args struct {
@@ -28,3 +30,51 @@ func (t *Ardith) Divide(args *Args, quo *Quotient) error {
 	quo.Rem = args.A % args.B
 	return nil
 }
+
+// 服务器流式方法：接收一个 Args，按顺序推送不超过 Args.A 的全部素数
+func (t *Ardith) Primes(s *stream.Stream) error {
+	var args Args
+	if err := s.Recv(&args); err != nil {
+		return err
+	}
+
+	for n := 2; n <= args.A; n++ {
+		if !isPrime(n) {
+			continue
+		}
+		if err := s.Send(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 双向流式方法：每收到一个整数就推送一次当前的累加和
+func (t *Ardith) Accumulate(s *stream.Stream) error {
+	sum := 0
+	for {
+		var n int
+		err := s.Recv(&n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		sum += n
+		if err := s.Send(sum); err != nil {
+			return err
+		}
+	}
+}
+
+// 判断是否为素数
+func isPrime(n int) bool {
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return n >= 2
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"code-snippet/code/011/rpc_protocol/stream"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 检查流量控制、取消和半关闭：
+//
+//	go run -race ./code/011/rpc_protocol/stream/check
+
+var checks = []struct {
+	name string
+	run  func(t *env) error
+}{
+	{"window exhaustion", checkWindow},
+	{"cancel while blocked in Send", checkCancelSend},
+	{"oversized messages keep the window", checkOversized},
+	{"peer half-close", checkHalfClose},
+	{"finished streams are released", checkReleased},
+	{"remote errors", checkRemoteError},
+}
+
+// 每个检查使用的服务器和连接
+type env struct {
+	conn *stream.Conn
+
+	release   chan struct{} // 关闭后 Sink 开始接收
+	received  chan int      // Sink 和 Half 收到的消息数
+	cancelled chan error    // Sink 被取消时的错误
+}
+
+func newEnv() (*env, func(), error) {
+	t := &env{
+		release:   make(chan struct{}),
+		received:  make(chan int, 1),
+		cancelled: make(chan error, 1),
+	}
+	server := stream.NewServer()
+
+	// 等待 release 后接收所有消息；等待期间客户端的发送窗口会用完
+	server.Handle("Sink", func(s *stream.Stream) error {
+		select {
+		case <-t.release:
+		case <-s.Context().Done():
+			t.cancelled <- s.Context().Err()
+			return nil
+		}
+		n := 0
+		for {
+			var v int
+			if err := s.Recv(&v); err != nil {
+				if err != io.EOF {
+					t.cancelled <- err
+					return nil
+				}
+				break
+			}
+			n++
+		}
+		t.received <- n
+		return nil
+	})
+
+	// 先发送三条消息并半关闭，然后接收客户端的消息直到结束
+	server.Handle("Half", func(s *stream.Stream) error {
+		for i := 1; i <= 3; i++ {
+			if err := s.Send(i); err != nil {
+				return err
+			}
+		}
+		if err := s.CloseSend(); err != nil {
+			return err
+		}
+		if err := s.Send(4); !errors.Is(err, stream.ErrSendClosed) {
+			return fmt.Errorf("send after CloseSend: %v", err)
+		}
+		sum := 0
+		for {
+			var v int
+			err := s.Recv(&v)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			sum += v
+		}
+		t.received <- sum
+		return nil
+	})
+
+	// 推送 n 条消息后返回，不读取客户端的半关闭
+	server.Handle("Count", func(s *stream.Stream) error {
+		var n int
+		if err := s.Recv(&n); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := s.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	server.Handle("Fail", func(s *stream.Stream) error {
+		return errors.New("fail: boom")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	go server.Serve(listener)
+	t.conn, err = stream.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		return nil, nil, err
+	}
+	return t, func() {
+		t.conn.Close()
+		listener.Close()
+	}, nil
+}
+
+var errTimeout = errors.New("timed out")
+
+// 在 d 内完成 fn，返回 fn 的错误；超时时返回 errTimeout
+func within(d time.Duration, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(d):
+		return errTimeout
+	}
+}
+
+func checkWindow(t *env) error {
+	s, err := t.conn.NewStream(context.Background(), "Sink")
+	if err != nil {
+		return err
+	}
+	// 服务器还没有接收，初始窗口内的发送不会阻塞
+	for i := 0; i < stream.InitialWindow; i++ {
+		if err := within(time.Second, func() error { return s.Send(i) }); err != nil {
+			return fmt.Errorf("send %d: %v", i, err)
+		}
+	}
+	// 窗口用完，下一次发送阻塞
+	blocked := make(chan error, 1)
+	go func() { blocked <- s.Send(stream.InitialWindow) }()
+	select {
+	case err := <-blocked:
+		return fmt.Errorf("send beyond the window returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 服务器开始接收后归还窗口，阻塞的发送完成，之后还可以继续发送
+	close(t.release)
+	select {
+	case err := <-blocked:
+		if err != nil {
+			return err
+		}
+	case <-time.After(time.Second):
+		return errors.New("send still blocked after the peer consumed messages")
+	}
+	for i := 0; i < 3*stream.InitialWindow; i++ {
+		if err := within(time.Second, func() error { return s.Send(i) }); err != nil {
+			return fmt.Errorf("send %d after release: %v", i, err)
+		}
+	}
+	s.CloseSend()
+	select {
+	case n := <-t.received:
+		if want := 4*stream.InitialWindow + 1; n != want {
+			return fmt.Errorf("server received %d messages, want %d", n, want)
+		}
+	case err := <-t.cancelled:
+		return fmt.Errorf("server: %v", err)
+	case <-time.After(time.Second):
+		return errors.New("server did not finish")
+	}
+	return nil
+}
+
+func checkCancelSend(t *env) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := t.conn.NewStream(ctx, "Sink")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < stream.InitialWindow; i++ {
+		if err := s.Send(i); err != nil {
+			return err
+		}
+	}
+	blocked := make(chan error, 1)
+	go func() { blocked <- s.Send(0) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("blocked send returned %v", err)
+		}
+	case <-time.After(time.Second):
+		return errors.New("send still blocked after cancel")
+	}
+	// 服务器端的处理函数也被取消
+	select {
+	case err := <-t.cancelled:
+		if !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("handler saw %v", err)
+		}
+	case <-time.After(time.Second):
+		return errors.New("handler was not cancelled")
+	}
+	if err := s.Send(0); err == nil {
+		return errors.New("send after cancel succeeded")
+	}
+	return nil
+}
+
+func checkOversized(t *env) error {
+	s, err := t.conn.NewStream(context.Background(), "Sink")
+	if err != nil {
+		return err
+	}
+	defer s.Cancel()
+	big := strings.Repeat("x", stream.MaxPayload)
+	for i := 0; i < 2*stream.InitialWindow; i++ {
+		if err := within(time.Second, func() error { return s.Send(big) }); !errors.Is(err, stream.ErrFrameTooLarge) {
+			return fmt.Errorf("oversized send %d: %v", i, err)
+		}
+	}
+	// 失败的发送没有占用窗口，整个初始窗口仍然可用
+	for i := 0; i < stream.InitialWindow; i++ {
+		if err := within(time.Second, func() error { return s.Send(i) }); err != nil {
+			return fmt.Errorf("send %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func checkHalfClose(t *env) error {
+	s, err := t.conn.NewStream(context.Background(), "Half")
+	if err != nil {
+		return err
+	}
+	// 服务器半关闭后，客户端读完三条消息得到 io.EOF
+	for want := 1; want <= 3; want++ {
+		var v int
+		if err := s.Recv(&v); err != nil || v != want {
+			return fmt.Errorf("recv %d: %d %v", want, v, err)
+		}
+	}
+	var v int
+	if err := s.Recv(&v); err != io.EOF {
+		return fmt.Errorf("recv after peer CloseSend: %v", err)
+	}
+	if err := s.Recv(&v); err != io.EOF {
+		return fmt.Errorf("second recv after peer CloseSend: %v", err)
+	}
+
+	// 另一个方向仍然可以发送
+	for i := 1; i <= 40; i++ {
+		if err := within(time.Second, func() error { return s.Send(i) }); err != nil {
+			return fmt.Errorf("send %d after peer CloseSend: %v", i, err)
+		}
+	}
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	select {
+	case sum := <-t.received:
+		if sum != 820 {
+			return fmt.Errorf("server received sum %d", sum)
+		}
+	case <-time.After(time.Second):
+		return errors.New("server did not see CloseSend")
+	}
+	return nil
+}
+
+// 等待连接上的流全部释放
+func waitReleased(conn *stream.Conn) error {
+	deadline := time.Now().Add(time.Second)
+	for conn.ActiveStreams() != 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d streams still active", conn.ActiveStreams())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func checkReleased(t *env) error {
+	for i := 0; i < 20; i++ {
+		s, err := t.conn.NewStream(context.Background(), "Count")
+		if err != nil {
+			return err
+		}
+		if err := s.Send(5); err != nil {
+			return err
+		}
+		// 服务器推送完就返回，客户端读到 io.EOF，但从不调用 CloseSend
+		n := 0
+		for {
+			var v int
+			if err := s.Recv(&v); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			n++
+		}
+		if n != 5 {
+			return fmt.Errorf("received %d messages", n)
+		}
+		// 服务器已经结束，不能再发送
+		if err := s.Send(1); !errors.Is(err, stream.ErrSendClosed) {
+			return fmt.Errorf("send after the server returned: %v", err)
+		}
+	}
+	if err := waitReleased(t.conn); err != nil {
+		return err
+	}
+
+	// 调用 CloseSend 或者取消的流同样被释放
+	s, err := t.conn.NewStream(context.Background(), "Count")
+	if err != nil {
+		return err
+	}
+	s.Send(1)
+	s.CloseSend()
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := t.conn.NewStream(ctx, "Sink"); err != nil {
+		cancel()
+		return err
+	}
+	cancel()
+	return waitReleased(t.conn)
+}
+
+func checkRemoteError(t *env) error {
+	for method, want := range map[string]string{"Fail": "fail: boom", "Missing": "stream: can't find method Missing"} {
+		s, err := t.conn.NewStream(context.Background(), method)
+		if err != nil {
+			return err
+		}
+		var v int
+		err = s.Recv(&v)
+		var remote stream.RemoteError
+		if !errors.As(err, &remote) || string(remote) != want {
+			return fmt.Errorf("%s: got %v", method, err)
+		}
+	}
+	return waitReleased(t.conn)
+}
+
+func main() {
+	// 服务器在连接断开时打印日志
+	log.SetOutput(io.Discard)
+
+	failed := 0
+	for _, c := range checks {
+		t, cleanup, err := newEnv()
+		if err == nil {
+			err = c.run(t)
+			cleanup()
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("PASS  %s\n", c.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+	fmt.Printf("all %d checks passed\n", len(checks))
+}
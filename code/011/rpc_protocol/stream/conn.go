@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// 连接已关闭
+var ErrConnClosed = errors.New("stream: connection closed")
+
+// 在一个TCP连接上多路复用多个流
+type Conn struct {
+	conn   net.Conn
+	server *Server // 服务器端连接，客户端连接为 nil
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wmu sync.Mutex // 保证帧的写入不会交错
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error
+}
+
+// 连接流服务器
+func Dial(network, address string) (*Conn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
+
+// 在已建立的连接上创建客户端
+func NewConn(conn net.Conn) *Conn {
+	c := newConn(conn, nil)
+	go c.readLoop()
+	return c
+}
+
+func newConn(conn net.Conn, server *Server) *Conn {
+	c := &Conn{
+		conn:    conn,
+		server:  server,
+		streams: make(map[uint32]*Stream),
+		nextID:  1,
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// 打开一个调用指定方法的流，取消 ctx 会同时取消服务器端的处理
+func (c *Conn) NewStream(ctx context.Context, method string) (*Stream, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	id := c.nextID
+	c.nextID += 2
+	s := newStream(ctx, c, id, method)
+	c.streams[id] = s
+	c.mu.Unlock()
+
+	if err := c.write(frame{typ: frameOpen, id: id, payload: []byte(method)}); err != nil {
+		s.onReset(err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// 关闭连接，所有未结束的流都会收到错误
+func (c *Conn) Close() error {
+	err := c.conn.Close()
+	c.fail(ErrConnClosed)
+	return err
+}
+
+// 连接结束的通知通道
+func (c *Conn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// 连接结束的原因，连接正常时返回 nil
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// 写入一帧
+func (c *Conn) write(f frame) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return writeFrame(c.conn, f)
+}
+
+// 连接上还没有结束的流的数量
+func (c *Conn) ActiveStreams() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.streams)
+}
+
+// 释放已结束的流
+func (c *Conn) remove(id uint32) {
+	c.mu.Lock()
+	delete(c.streams, id)
+	c.mu.Unlock()
+}
+
+// 查找流
+func (c *Conn) stream(id uint32) *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams[id]
+}
+
+// 读取帧并分发到对应的流，连接出错时结束所有流
+func (c *Conn) readLoop() {
+	for {
+		f, err := readFrame(c.conn)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		if f.typ == frameOpen {
+			c.accept(f)
+			continue
+		}
+
+		// 已经结束的流可能还会收到对端在途的帧，直接丢弃
+		s := c.stream(f.id)
+		if s == nil {
+			continue
+		}
+
+		switch f.typ {
+		case frameData:
+			s.onData(f.payload)
+		case frameWindow:
+			s.onWindow(f.payload)
+		case frameClose:
+			s.onClose(nil)
+		case frameEnd:
+			s.onEnd(f.payload)
+		case frameReset:
+			s.onReset(context.Canceled)
+		}
+	}
+}
+
+// 服务器端收到打开流的请求
+func (c *Conn) accept(f frame) {
+	method := string(f.payload)
+
+	if c.server == nil {
+		c.write(frame{typ: frameReset, id: f.id})
+		return
+	}
+
+	handler, ok := c.server.handler(method)
+	if !ok {
+		c.write(frame{typ: frameEnd, id: f.id, payload: []byte(fmt.Sprintf("stream: can't find method %s", method))})
+		return
+	}
+
+	c.mu.Lock()
+	if _, ok := c.streams[f.id]; ok || c.err != nil {
+		c.mu.Unlock()
+		c.write(frame{typ: frameReset, id: f.id})
+		return
+	}
+	s := newStream(c.ctx, c, f.id, method)
+	c.streams[f.id] = s
+	c.mu.Unlock()
+
+	go func() {
+		s.end(handler(s))
+	}()
+}
+
+// 连接出错，结束所有流
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	streams := make([]*Stream, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.mu.Unlock()
+
+	for _, s := range streams {
+		s.onReset(err)
+	}
+	c.cancel()
+	c.conn.Close()
+}
@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// 帧类型
+const (
+	frameOpen   byte = iota + 1 // 打开流，负载为方法名
+	frameData                   // 数据，负载为JSON编码的消息
+	frameClose                  // 发送方半关闭，不会再发送数据
+	frameEnd                    // 处理函数返回，流结束，负载为错误信息，为空表示正常结束
+	frameReset                  // 取消流，双方都不再收发
+	frameWindow                 // 流量控制，负载为4字节的窗口增量
+)
+
+// 帧头长度：1字节类型 + 4字节流ID + 4字节负载长度
+const headerSize = 9
+
+// 单帧负载的最大长度
+const MaxPayload = 1 << 20
+
+// 帧负载超过最大长度
+var ErrFrameTooLarge = errors.New("stream: frame too large")
+
+// 传输帧
+type frame struct {
+	typ     byte
+	id      uint32
+	payload []byte
+}
+
+// 写入一帧
+func writeFrame(w io.Writer, f frame) error {
+	if len(f.payload) > MaxPayload {
+		return ErrFrameTooLarge
+	}
+
+	buf := make([]byte, headerSize+len(f.payload))
+	buf[0] = f.typ
+	binary.BigEndian.PutUint32(buf[1:5], f.id)
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(f.payload)))
+	copy(buf[headerSize:], f.payload)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// 读取一帧
+func readFrame(r io.Reader) (frame, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		typ: header[0],
+		id:  binary.BigEndian.Uint32(header[1:5]),
+	}
+
+	size := binary.BigEndian.Uint32(header[5:9])
+	if size > MaxPayload {
+		return frame{}, ErrFrameTooLarge
+	}
+
+	f.payload = make([]byte, size)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+	return f, nil
+}
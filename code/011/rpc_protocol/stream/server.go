@@ -0,0 +1,98 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sync"
+)
+
+// 流处理函数，返回后流结束，返回的错误会传给客户端
+type HandlerFunc func(s *Stream) error
+
+// 流服务器
+type Server struct {
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+// 创建流服务器
+func NewServer() *Server {
+	return &Server{handlers: make(map[string]HandlerFunc)}
+}
+
+// 按名称注册流处理函数
+func (server *Server) Handle(name string, handler HandlerFunc) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if _, ok := server.handlers[name]; ok {
+		return fmt.Errorf("stream: method %s already defined", name)
+	}
+	server.handlers[name] = handler
+	return nil
+}
+
+// 注册对象中形如 func (t *T) Method(s *stream.Stream) error 的方法，
+// 与 net/rpc 一样以 "类型名.方法名" 作为方法名称
+func (server *Server) Register(rcvr interface{}) error {
+	value := reflect.ValueOf(rcvr)
+	name := reflect.Indirect(value).Type().Name()
+	if name == "" {
+		return errors.New("stream: no service name for type " + value.Type().String())
+	}
+
+	typeOfStream := reflect.TypeOf((*Stream)(nil))
+	typeOfError := reflect.TypeOf((*error)(nil)).Elem()
+
+	registered := 0
+	for i := 0; i < value.NumMethod(); i++ {
+		method := value.Type().Method(i)
+		mtype := method.Type
+		if mtype.NumIn() != 2 || mtype.In(1) != typeOfStream {
+			continue
+		}
+		if mtype.NumOut() != 1 || mtype.Out(0) != typeOfError {
+			continue
+		}
+
+		fn := value.Method(i).Interface().(func(*Stream) error)
+		if err := server.Handle(name+"."+method.Name, fn); err != nil {
+			return err
+		}
+		registered++
+	}
+
+	if registered == 0 {
+		return errors.New("stream: type " + name + " has no streaming methods")
+	}
+	return nil
+}
+
+// 接受连接并为每个连接处理流请求
+func (server *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// 处理单个连接，连接断开后返回
+func (server *Server) ServeConn(conn net.Conn) {
+	c := newConn(conn, server)
+	c.readLoop()
+	log.Printf("stream: connection %s closed: %v\n", conn.RemoteAddr(), c.Err())
+}
+
+// 查找流处理函数
+func (server *Server) handler(name string) (HandlerFunc, bool) {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	handler, ok := server.handlers[name]
+	return handler, ok
+}
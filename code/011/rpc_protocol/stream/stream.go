@@ -0,0 +1,332 @@
+package stream
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+// 每个流初始的发送窗口（消息条数）
+const InitialWindow = 16
+
+var (
+	// 本端已经半关闭，不能再发送
+	ErrSendClosed = errors.New("stream: send on closed stream")
+
+	// 对端发送的消息超过了窗口
+	ErrFlowControl = errors.New("stream: flow control violation")
+)
+
+// 远端处理函数返回的错误
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return string(e)
+}
+
+// 连接上的一个双向流
+type Stream struct {
+	id     uint32
+	method string
+	conn   *Conn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   func() bool
+
+	// 接收队列，容量等于窗口，读协程写入时不会阻塞
+	in chan []byte
+
+	mu         sync.Mutex
+	window     int           // 剩余发送窗口
+	windowed   chan struct{} // 窗口增加的通知
+	consumed   int           // 已消费但尚未归还窗口的消息数
+	inClosed   bool          // 对端已结束发送
+	sendClosed bool          // 本端已结束发送
+	finished   bool          // 流已结束，取消时不再发送 Reset
+	err        error         // 对端结束时携带的错误
+}
+
+// 创建流，取消 ctx 会向对端发送 Reset
+func newStream(ctx context.Context, conn *Conn, id uint32, method string) *Stream {
+	s := &Stream{
+		id:       id,
+		method:   method,
+		conn:     conn,
+		in:       make(chan []byte, InitialWindow),
+		window:   InitialWindow,
+		windowed: make(chan struct{}, 1),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.stop = context.AfterFunc(s.ctx, s.reset)
+	return s
+}
+
+// 流的方法名
+func (s *Stream) Method() string {
+	return s.method
+}
+
+// 流的上下文，流被任意一端取消时结束
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// 发送一条消息，发送窗口用完时阻塞直到对端归还窗口
+func (s *Stream) Send(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// 在占用窗口之前检查，过大的消息不消耗窗口
+	if len(payload) > MaxPayload {
+		return ErrFrameTooLarge
+	}
+
+	for {
+		s.mu.Lock()
+		if s.sendClosed || s.finished {
+			s.mu.Unlock()
+			return ErrSendClosed
+		}
+		if s.window > 0 {
+			s.window--
+			s.mu.Unlock()
+			break
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.windowed:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+
+	if err := s.conn.write(frame{typ: frameData, id: s.id, payload: payload}); err != nil {
+		// 消息没有发出，归还占用的窗口
+		s.addWindow(1)
+		return err
+	}
+	return nil
+}
+
+// 接收一条消息，对端正常结束时返回 io.EOF
+func (s *Stream) Recv(v interface{}) error {
+	// 优先取出已经到达的消息，即使流已经结束
+	select {
+	case payload, ok := <-s.in:
+		return s.received(payload, ok, v)
+	default:
+	}
+
+	select {
+	case payload, ok := <-s.in:
+		return s.received(payload, ok, v)
+	case <-s.ctx.Done():
+		s.mu.Lock()
+		closed := s.inClosed
+		s.mu.Unlock()
+
+		// 对端已经结束，接收队列关闭后不会阻塞
+		if closed {
+			payload, ok := <-s.in
+			return s.received(payload, ok, v)
+		}
+		return s.ctx.Err()
+	}
+}
+
+// 处理从接收队列取出的消息
+func (s *Stream) received(payload []byte, ok bool, v interface{}) error {
+	if !ok {
+		return s.recvErr()
+	}
+	s.release()
+	return json.Unmarshal(payload, v)
+}
+
+// 结束本端发送，对端的 Recv 将返回 io.EOF
+func (s *Stream) CloseSend() error {
+	s.mu.Lock()
+	if s.sendClosed || s.finished {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	done := s.inClosed
+	s.mu.Unlock()
+
+	err := s.conn.write(frame{typ: frameClose, id: s.id})
+	if done {
+		s.finish()
+	}
+	return err
+}
+
+// 取消流，双方都会停止收发
+func (s *Stream) Cancel() {
+	s.cancel()
+}
+
+// 对端结束后 Recv 返回的错误
+func (s *Stream) recvErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return io.EOF
+}
+
+// 消费一条消息，累计到半个窗口时归还给对端
+func (s *Stream) release() {
+	s.mu.Lock()
+	s.consumed++
+	n := s.consumed
+	if n < InitialWindow/2 || s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.consumed = 0
+	s.mu.Unlock()
+
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(n))
+	s.conn.write(frame{typ: frameWindow, id: s.id, payload: payload})
+}
+
+// 读协程收到数据帧
+func (s *Stream) onData(payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inClosed {
+		return
+	}
+
+	select {
+	case s.in <- payload:
+	default:
+		// 对端没有遵守窗口，结束流
+		s.closeIn(ErrFlowControl)
+		go s.cancel()
+	}
+}
+
+// 读协程收到窗口增量
+func (s *Stream) onWindow(payload []byte) {
+	if len(payload) != 4 {
+		return
+	}
+
+	s.addWindow(int(binary.BigEndian.Uint32(payload)))
+}
+
+// 增加发送窗口，唤醒等待窗口的 Send
+func (s *Stream) addWindow(n int) {
+	s.mu.Lock()
+	s.window += n
+	s.mu.Unlock()
+
+	select {
+	case s.windowed <- struct{}{}:
+	default:
+	}
+}
+
+// 读协程收到对端结束，err 为 nil 表示正常结束
+func (s *Stream) onClose(err error) {
+	s.mu.Lock()
+	s.closeIn(err)
+	done := s.sendClosed
+	s.mu.Unlock()
+
+	if done {
+		s.finish()
+	}
+}
+
+// 读协程收到服务器处理函数返回。服务器不会再接收数据，本端即使没有调用 CloseSend，
+// 两个方向也都已经结束，释放流
+func (s *Stream) onEnd(payload []byte) {
+	var err error
+	if len(payload) > 0 {
+		err = RemoteError(payload)
+	}
+
+	s.mu.Lock()
+	s.closeIn(err)
+	s.sendClosed = true
+	s.mu.Unlock()
+
+	s.finish()
+}
+
+// 读协程收到对端取消或连接断开
+func (s *Stream) onReset(err error) {
+	s.mu.Lock()
+	s.closeIn(err)
+	s.finished = true
+	s.mu.Unlock()
+
+	s.cancel()
+	s.conn.remove(s.id)
+}
+
+// 关闭接收队列，调用方需持有锁
+func (s *Stream) closeIn(err error) {
+	if s.inClosed {
+		return
+	}
+	s.inClosed = true
+	s.err = err
+	close(s.in)
+}
+
+// 服务器处理函数返回后结束流。即使处理函数已经调用过 CloseSend 也发送 End 帧，
+// 客户端据此知道服务器不再接收数据，err 不为 nil 时通知对端出错
+func (s *Stream) end(err error) {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.sendClosed = true
+	s.mu.Unlock()
+
+	var payload []byte
+	if err != nil {
+		payload = []byte(err.Error())
+	}
+	s.conn.write(frame{typ: frameEnd, id: s.id, payload: payload})
+	s.finish()
+}
+
+// 双方都结束发送后释放流
+func (s *Stream) finish() {
+	s.mu.Lock()
+	s.finished = true
+	s.mu.Unlock()
+
+	s.stop()
+	s.cancel()
+	s.conn.remove(s.id)
+}
+
+// 本端取消时通知对端
+func (s *Stream) reset() {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.finished = true
+	s.closeIn(s.ctx.Err())
+	s.mu.Unlock()
+
+	s.conn.write(frame{typ: frameReset, id: s.id})
+	s.conn.remove(s.id)
+}